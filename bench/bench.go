@@ -0,0 +1,112 @@
+package bench
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/eugenmayer/nexus-cli/registry"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// Image is the image name synthetic blobs are uploaded for. The blobs are never referenced by a manifest,
+// a compact blobstore task removes them
+const Image = "nexus-cli-bench"
+
+// Result holds the timings of one measured operation
+type Result struct {
+	Name      string
+	Durations []time.Duration
+	// Bytes is the amount of data transferred per run, 0 for latency only measurements
+	Bytes int64
+}
+
+// Percentile returns the duration below which p percent (0-100) of the runs finished
+func (r Result) Percentile(p float64) time.Duration {
+	if len(r.Durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), r.Durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(float64(len(sorted)-1) * p / 100)
+	return sorted[index]
+}
+
+// Throughput returns the median transfer rate in bytes per second
+func (r Result) Throughput() float64 {
+	median := r.Percentile(50)
+	if r.Bytes == 0 || median == 0 {
+		return 0
+	}
+	return float64(r.Bytes) / median.Seconds()
+}
+
+// Catalog measures listing the catalog
+func Catalog(r registry.Registry, iterations int) (Result, error) {
+	return measure("catalog", iterations, 0, func() error {
+		_, err := r.ListImages()
+		return err
+	})
+}
+
+// Manifest measures fetching the manifest of a tag
+func Manifest(r registry.Registry, image string, tag string, iterations int) (Result, error) {
+	return measure("manifest GET", iterations, 0, func() error {
+		_, _, _, err := r.RawManifest(image, tag)
+		return err
+	})
+}
+
+// Blobs uploads and downloads random blobs of the given size, returning the upload and the download measurements.
+// Use a scratch repository, the blobs stay in the blobstore until it is compacted
+func Blobs(r registry.Registry, size int64, iterations int) (Result, Result, error) {
+	upload := Result{Name: "blob upload", Bytes: size}
+	download := Result{Name: "blob download", Bytes: size}
+
+	for i := 0; i < iterations; i++ {
+		content := make([]byte, size)
+		if _, err := rand.Read(content); err != nil {
+			return upload, download, err
+		}
+		sum := sha256.Sum256(content)
+		digest := "sha256:" + hex.EncodeToString(sum[:])
+
+		start := time.Now()
+		if err := r.UploadBlob(Image, digest, size, bytes.NewReader(content)); err != nil {
+			return upload, download, err
+		}
+		upload.Durations = append(upload.Durations, time.Since(start))
+
+		start = time.Now()
+		blob, _, err := r.GetBlob(Image, digest)
+		if err != nil {
+			return upload, download, err
+		}
+		n, err := io.Copy(ioutil.Discard, blob)
+		blob.Close()
+		if err != nil {
+			return upload, download, err
+		}
+		if n != size {
+			return upload, download, errors.New("downloaded blob is truncated")
+		}
+		download.Durations = append(download.Durations, time.Since(start))
+	}
+	return upload, download, nil
+}
+
+func measure(name string, iterations int, bytes int64, operation func() error) (Result, error) {
+	result := Result{Name: name, Bytes: bytes}
+	for i := 0; i < iterations; i++ {
+		start := time.Now()
+		if err := operation(); err != nil {
+			return result, err
+		}
+		result.Durations = append(result.Durations, time.Since(start))
+	}
+	return result, nil
+}
@@ -0,0 +1,241 @@
+// Package checkpoint records the progress of long running jobs, so an interrupted cleanup, sync or index can resume
+// where it stopped instead of crawling the whole registry again
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Checkpoint is a journal of finished units of work (images, tags) and of work decided on but not finished yet,
+// like the tags a policy chose to delete. Every change is appended to the file as one JSON line, so recording
+// progress stays cheap for huge registries and a crash loses at most the line being written.
+//
+// A nil Checkpoint records nothing and has nothing done, dry runs use it
+type Checkpoint struct {
+	Path string
+	// Job identifies the work the checkpoint belongs to, e.g. the repository and a digest of the policy
+	Job string
+
+	mu      sync.Mutex
+	file    *os.File
+	done    map[string]bool
+	pending map[string][]string
+	results map[string]json.RawMessage
+	resumed int
+}
+
+// entry is a line of the journal. The first line only holds the job
+type entry struct {
+	Job     string   `json:"job,omitempty"`
+	Done    string   `json:"done,omitempty"`
+	Pending string   `json:"pending,omitempty"`
+	Items   []string `json:"items,omitempty"`
+	// Result is what a finished unit produced, for crawls keeping what they fetched
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Open starts recording the job at path. With resume the progress recorded by an interrupted run of the same job
+// is loaded first, without it (or if there is none) the job starts from scratch
+func Open(path string, job string, resume bool) (*Checkpoint, error) {
+	c := &Checkpoint{Path: path, Job: job, done: map[string]bool{}, pending: map[string][]string{}, results: map[string]json.RawMessage{}}
+	if resume {
+		found, err := c.load()
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, err
+			}
+			c.file = f
+			c.resumed = len(c.done)
+			return c, nil
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c.file = f
+	if err := c.append(entry{Job: job}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Exists tells if an interrupted run left a checkpoint at path
+func Exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (c *Checkpoint) load() (bool, error) {
+	f, err := os.Open(c.Path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	first := true
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// the line being written when the job was killed
+			break
+		}
+		if first {
+			if e.Job != c.Job {
+				return false, errors.New(fmt.Sprintf("The checkpoint %s belongs to another job (%s), start over without --resume", c.Path, e.Job))
+			}
+			first = false
+			continue
+		}
+		switch {
+		case e.Done != "":
+			c.done[e.Done] = true
+			delete(c.pending, e.Done)
+			if e.Result != nil {
+				c.results[e.Done] = e.Result
+			}
+		case e.Pending != "":
+			c.pending[e.Pending] = e.Items
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return !first, nil
+}
+
+func (c *Checkpoint) append(e entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = c.file.Write(append(line, '\n'))
+	return err
+}
+
+// Resumed returns the number of units an interrupted run had finished
+func (c *Checkpoint) Resumed() int {
+	if c == nil {
+		return 0
+	}
+	return c.resumed
+}
+
+// Unfinished returns the number of units with work recorded but not finished yet
+func (c *Checkpoint) Unfinished() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// IsDone tells if a unit was finished, by this or the interrupted run
+func (c *Checkpoint) IsDone(unit string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[unit]
+}
+
+// MarkDone records a unit as finished
+func (c *Checkpoint) MarkDone(unit string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done[unit] = true
+	delete(c.pending, unit)
+	return c.append(entry{Done: unit})
+}
+
+// MarkDoneWith records a unit as finished with what it produced, a resumed run takes the result instead of doing
+// the unit again
+func (c *Checkpoint) MarkDoneWith(unit string, result interface{}) error {
+	if c == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done[unit] = true
+	delete(c.pending, unit)
+	c.results[unit] = encoded
+	return c.append(entry{Done: unit, Result: encoded})
+}
+
+// Result decodes the result recorded for a finished unit into v, ok is false if there is none
+func (c *Checkpoint) Result(unit string, v interface{}) (bool, error) {
+	if c == nil {
+		return false, nil
+	}
+	c.mu.Lock()
+	result, ok := c.results[unit]
+	c.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(result, v)
+}
+
+// SetPending records the work decided on for a unit, a resumed run finishes it instead of deciding again
+func (c *Checkpoint) SetPending(unit string, items []string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[unit] = items
+	return c.append(entry{Pending: unit, Items: items})
+}
+
+// Pending returns the work recorded for a unit not finished yet, ok is false if there is none
+func (c *Checkpoint) Pending(unit string) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	items, ok := c.pending[unit]
+	return items, ok
+}
+
+// Close stops recording, the file is kept for a later resume
+func (c *Checkpoint) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// Remove stops recording and deletes the file, the job is complete
+func (c *Checkpoint) Remove() error {
+	if c == nil {
+		return nil
+	}
+	c.file.Close()
+	return os.Remove(c.Path)
+}
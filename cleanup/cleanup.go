@@ -0,0 +1,149 @@
+// Package cleanup applies retention policies to a docker repository. It is the engine of the cleanup command, for Go
+// programs embedding it, e.g. operators enforcing retention without running nexus-cli:
+//
+//	p, err := policy.Parse(spec)
+//	...
+//	r := registry.New("https://nexus.example.com", registry.WithBasicAuth("ci", password), registry.WithRepository("docker-hosted"))
+//	rep, err := cleanup.Run(ctx, r, p, cleanup.Options{DryRun: true})
+//
+// Tags are deleted like by the command: locked tags are skipped and tags sharing their manifest with tags which are
+// kept are left alone, see registry.TagDeleter. The images and tags excluded by the ignore rules of the client
+// (registry.WithIgnoreRules) are left alone as well. What the command adds around it, the cleanup lock, confirmation,
+// checkpoints and retries, is up to the program
+package cleanup
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/lock"
+	"github.com/eugenmayer/nexus-cli/policy"
+	"github.com/eugenmayer/nexus-cli/registry"
+	"github.com/eugenmayer/nexus-cli/report"
+)
+
+// Report is the outcome of a run, it renders as HTML or Markdown with Write
+type Report = report.Report
+
+// Options tune a run
+type Options struct {
+	// DryRun only reports the tags the policy selects, and those the run would skip or fail to delete
+	DryRun bool
+	// Images are the images the policy is applied to, all images of the repository by default but the ones nexus-cli
+	// keeps its own state in
+	Images []string
+	// Force and Untag decide about tags sharing their manifest with tags which are kept, see registry.TagDeleter
+	Force bool
+	Untag bool
+	// Quarantine is the repository the tags are copied to before they are deleted, empty to delete them for good
+	Quarantine string
+	// FailFast stops the run at the first tag failing to delete, the others are tried nonetheless by default
+	FailFast bool
+	// Measure adds the size of the tags to the report, which takes a request per tag
+	Measure bool
+	// Stats records the outcome of the deletions as well, nil for none
+	Stats *registry.RunStats
+}
+
+// Internal tells if an image holds the state of nexus-cli (locks, protections, the quarantine index) rather than
+// content, policies are not applied to those
+func Internal(image string) bool {
+	return image == lock.RepositoryImage || image == registry.ProtectionImage || image == registry.QuarantineImage
+}
+
+// Run applies the policy to the images of the repository of client. The report lists the tags deleted (or selected
+// in a dry run), skipped and failing to delete. An error is returned when the run stopped: the policy could not be
+// evaluated, ctx is done, the repository refuses deletes or a tag failed with FailFast. The report then tells how
+// far it got
+func Run(ctx context.Context, client registry.Registry, p policy.Policy, options Options) (Report, error) {
+	rep := Report{Title: "Cleanup of " + client.Repository, Host: client.Host, Started: time.Now(), DryRun: options.DryRun}
+	err := run(ctx, client, p, options, &rep)
+	rep.Finished = time.Now()
+	return rep, err
+}
+
+func run(ctx context.Context, client registry.Registry, p policy.Policy, options Options, rep *Report) error {
+	registry.WithRunStats(options.Stats)(&client)
+	images := options.Images
+	if len(images) == 0 {
+		all, err := client.ListImages()
+		if err != nil {
+			return err
+		}
+		for _, image := range all {
+			if !Internal(image) {
+				images = append(images, image)
+			}
+		}
+	}
+	ignore := client.IgnoreRules()
+	for _, image := range ignore.Images(images) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tags, err := p.Evaluate(client, image)
+		if err != nil {
+			rep.Failures = append(rep.Failures, report.Failure{Image: image, Error: firstLine(err)})
+			return err
+		}
+		if err := deleteTags(ctx, client, image, ignore.Tags(image, tags), options, rep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteTags deletes the tags of image the policy selected
+func deleteTags(ctx context.Context, client registry.Registry, image string, tags []string, options Options, rep *Report) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	d, err := client.NewTagDeleter(image, tags)
+	if err != nil {
+		rep.Failures = append(rep.Failures, report.Failure{Image: image, Error: firstLine(err)})
+		return err
+	}
+	// a dry run reports the tags a run would skip or fail to delete as well
+	d.Force, d.Untag, d.DryRun = options.Force, options.Untag, options.DryRun
+	if options.Quarantine != "" {
+		q, err := client.Quarantine(options.Quarantine)
+		if err != nil {
+			return err
+		}
+		d.Quarantine = &q
+	}
+	for _, tag := range tags {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// the size is gone once the tag is
+		tagSize := size(client, image, tag, options)
+		err := d.Delete(tag)
+		switch {
+		case registry.IsProtected(err):
+			rep.Skipped = append(rep.Skipped, report.Tag{Image: image, Tag: tag, Reason: firstLine(err)})
+		case err != nil:
+			rep.Failures = append(rep.Failures, report.Failure{Image: image, Tag: tag, Error: firstLine(err)})
+			// the next tags would fail the same way
+			if options.FailFast || registry.IsDeleteDisabled(err) {
+				return err
+			}
+		default:
+			rep.Deleted = append(rep.Deleted, report.Tag{Image: image, Tag: tag, Size: tagSize})
+		}
+	}
+	return nil
+}
+
+func size(client registry.Registry, image string, tag string, options Options) int64 {
+	if !options.Measure {
+		return 0
+	}
+	size, _ := client.ImageSize(image, tag)
+	return size
+}
+
+func firstLine(err error) string {
+	return strings.SplitN(err.Error(), "\n", 2)[0]
+}
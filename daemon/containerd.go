@@ -0,0 +1,32 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// LoadContainerd imports an archive as written by `docker save` into a containerd namespace. containerd has no
+// HTTP API, the import is done by the ctr client which has to be installed. address is the containerd socket, empty
+// for the default of ctr
+func LoadContainerd(namespace string, address string, archive io.Reader, out io.Writer) error {
+	ctr, err := exec.LookPath("ctr")
+	if err != nil {
+		return errors.New("loading into containerd needs the ctr client in PATH")
+	}
+	args := []string{"--namespace", namespace}
+	if address != "" {
+		args = append(args, "--address", address)
+	}
+	args = append(args, "images", "import", "-")
+
+	cmd := exec.Command(ctr, args...)
+	cmd.Stdin = archive
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return errors.New(fmt.Sprintf("ctr images import failed: %s", err))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Package daemon loads images into local container runtimes
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultDockerHost is where the Docker daemon listens unless DOCKER_HOST says otherwise
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// loadMessage is a line of the JSON stream the Docker daemon answers image loads with
+type loadMessage struct {
+	Stream      string `json:"stream"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// LoadDocker streams an archive as written by `docker save` into the Docker daemon at host, given in DOCKER_HOST
+// syntax (unix:///path or tcp://host:port). The messages of the daemon are written to out
+func LoadDocker(host string, archive io.Reader, out io.Writer) error {
+	if host == "" {
+		host = DefaultDockerHost
+	}
+	client, base, err := dockerClient(host)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", base+"/images/load?quiet=1", archive)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Docker daemon at %s not reachable: %s", host, err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		var message struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&message)
+		return errors.New(fmt.Sprintf("Docker daemon refused the image, HTTP Code: %d %s", resp.StatusCode, message.Message))
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var m loadMessage
+		if err := decoder.Decode(&m); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if m.Error != "" {
+			return errors.New(fmt.Sprintf("Docker daemon failed to load the image: %s", m.Error))
+		}
+		if m.Stream != "" {
+			fmt.Fprint(out, m.Stream)
+		}
+	}
+}
+
+// dockerClient returns a client talking to the daemon and the base URL of the API
+func dockerClient(host string) (*http.Client, string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, "", err
+	}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &http.Client{Transport: transport}, "http://docker", nil
+	case "tcp", "http":
+		return &http.Client{}, "http://" + u.Host, nil
+	case "https":
+		return &http.Client{}, "https://" + u.Host, nil
+	}
+	return nil, "", errors.New(fmt.Sprintf("unsupported Docker host %s, use unix:// or tcp://", strings.TrimSpace(host)))
+}
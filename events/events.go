@@ -0,0 +1,112 @@
+// Package events writes the stream of --progress-json: one JSON object per line (NDJSON) telling what a long
+// running job does, so GUIs and CI wrappers can follow it without parsing the output meant for humans. Nothing is
+// written until Configure is called, the functions may be called from several goroutines
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Types of events
+const (
+	// Started begins an operation, a command of nexus-cli
+	Started = "started"
+	// Finished ends it, with the error it failed with if it did
+	Finished = "finished"
+	// Item tells an item (image, tag, blob) is done, with how many are done out of how many known so far
+	Item = "item"
+	// Bytes tells a blob was transferred
+	Bytes = "bytes"
+	// Error tells an item failed, the operation goes on
+	Error = "error"
+)
+
+// Event is a line of the stream
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Operation string    `json:"operation,omitempty"`
+	// Stage is the part of the operation the item belongs to, e.g. Measuring or delete
+	Stage string `json:"stage,omitempty"`
+	Item  string `json:"item,omitempty"`
+	Done  int    `json:"done,omitempty"`
+	Total int    `json:"total,omitempty"`
+	// Bytes and Direction (download or upload) are those of Bytes events
+	Bytes     int64  `json:"bytes,omitempty"`
+	Direction string `json:"direction,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// ExitCode is the one nexus-cli exits with, for Finished events
+	ExitCode int `json:"exit_code,omitempty"`
+}
+
+var (
+	mu        sync.Mutex
+	encoder   *json.Encoder
+	operation string
+)
+
+// Configure writes the events to w from now on
+func Configure(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	encoder = json.NewEncoder(w)
+}
+
+// Enabled tells if events are written, to skip the work of producing them if not
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return encoder != nil
+}
+
+// Emit writes an event, stamped with the time and the operation running. Write errors are ignored: a GUI going
+// away must not fail the job it watched
+func Emit(e Event) {
+	mu.Lock()
+	defer mu.Unlock()
+	if encoder == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.Operation == "" {
+		e.Operation = operation
+	}
+	encoder.Encode(e)
+}
+
+// Start emits the Started event of an operation, the events following belong to it
+func Start(name string) {
+	mu.Lock()
+	operation = name
+	mu.Unlock()
+	Emit(Event{Type: Started})
+}
+
+// Finish emits the Finished event of the operation running, err is nil if it succeeded
+func Finish(err error, exitCode int) {
+	e := Event{Type: Finished, ExitCode: exitCode}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	Emit(e)
+}
+
+// Done emits an Item event
+func Done(stage string, item string, done int, total int) {
+	Emit(Event{Type: Item, Stage: stage, Item: item, Done: done, Total: total})
+}
+
+// Failed emits an Error event
+func Failed(stage string, item string, err error) {
+	Emit(Event{Type: Error, Stage: stage, Item: item, Error: err.Error()})
+}
+
+// Transferred emits a Bytes event, direction is download or upload
+func Transferred(item string, n int64, direction string) {
+	Emit(Event{Type: Bytes, Item: item, Bytes: n, Direction: direction})
+}
@@ -5,7 +5,9 @@ go 1.12
 require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/blang/semver v3.5.1+incompatible
+	github.com/klauspost/compress v1.11.13
 	github.com/urfave/cli v1.20.0
-	golang.org/x/crypto v0.0.0-20181112202954-3d3f9f413869
-	golang.org/x/sys v0.0.0-20181116161606-93218def8b18
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	gopkg.in/yaml.v2 v2.4.0
+	modernc.org/sqlite v1.10.0
 )
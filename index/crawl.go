@@ -0,0 +1,160 @@
+package index
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/registry"
+)
+
+// Stats tells what Build indexed
+type Stats struct {
+	Images int
+	Tags   int
+	// Skipped maps image:tag to the reason it could not be indexed, e.g. a broken manifest
+	Skipped map[string]error
+	Crawl   registry.CrawlStats
+}
+
+// Build crawls the given images of the repository (all of them if none are given) and replaces what the index
+// holds about them. Tags are fetched by up to workers in parallel, as many as the registry copes with (see
+// registry.Crawler). Tags failing to index are skipped and reported in the stats, a crawl of a huge registry is not
+// thrown away for one broken manifest. With a Checkpoint the tags crawled are recorded as they come, a build
+// interrupted before storing them resumes with the tags left
+func (ix *Index) Build(r registry.Registry, images []string, workers int) (Stats, error) {
+	stats := Stats{Skipped: map[string]error{}}
+	started := time.Now()
+	catalog, err := r.ListImages()
+	if err != nil {
+		return stats, err
+	}
+	all := len(images) == 0
+
+	var present []string
+	if all {
+		present, images = catalog, catalog
+	} else {
+		inCatalog := map[string]bool{}
+		for _, image := range catalog {
+			inCatalog[image] = true
+		}
+		// given images missing in the repository are removed from the index
+		for _, image := range images {
+			if inCatalog[image] {
+				present = append(present, image)
+			}
+		}
+	}
+	// listing the tags and crawling them are throttled together, the registry is the same
+	crawler := registry.NewCrawler(workers)
+	if ix.Progress != nil {
+		crawler.Observe(ix.Progress)
+	}
+	imageTags := make([][]string, len(present))
+	err = crawler.RunLabeled(len(present), func(i int) string { return present[i] }, func(i int) error {
+		var err error
+		imageTags[i], err = r.ListTagsByImage(present[i])
+		return err
+	})
+	if err != nil {
+		return stats, err
+	}
+	type job struct{ image, tag string }
+	var jobs []job
+	for i, image := range present {
+		for _, tag := range imageTags[i] {
+			jobs = append(jobs, job{image, tag})
+		}
+	}
+
+	var (
+		mu   sync.Mutex
+		tags []Tag
+	)
+	err = crawler.RunLabeled(len(jobs), func(i int) string { return jobs[i].image + ":" + jobs[i].tag }, func(i int) error {
+		unit := jobs[i].image + ":" + jobs[i].tag
+		// tags an interrupted build crawled are taken as they were then
+		var t Tag
+		found, err := ix.Checkpoint.Result(unit, &t)
+		if err != nil {
+			return err
+		}
+		if !found {
+			t, err = crawlTag(r, jobs[i].image, jobs[i].tag)
+			// a busy registry is asked again by the crawler, it only gives up (and fails the crawl) if it stays busy
+			if registry.IsThrottling(err) {
+				return err
+			}
+			if err == nil {
+				if err := ix.Checkpoint.MarkDoneWith(unit, t); err != nil {
+					return err
+				}
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			stats.Skipped[unit] = err
+		} else {
+			tags = append(tags, t)
+		}
+		return nil
+	})
+	stats.Crawl = crawler.Stats()
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Images, stats.Tags = len(present), len(tags)
+	// the index is as old as the start of the crawl, changes made meanwhile may be missing
+	return stats, ix.store(images, present, tags, all, started)
+}
+
+// crawlTag fetches the digest, layers, size and configuration of a tag. The layers of multi-arch images are
+// recorded per platform
+func crawlTag(r registry.Registry, image string, tag string) (Tag, error) {
+	t := Tag{Image: image, Tag: tag}
+	body, _, digest, err := r.RawManifest(image, tag)
+	if err != nil {
+		return t, err
+	}
+	t.Digest = digest
+
+	var manifest registry.ImageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return t, err
+	}
+	if manifest.IsIndex() {
+		for _, m := range manifest.Manifests {
+			child, err := r.ImageManifest(image, m.Digest)
+			if err != nil {
+				return t, err
+			}
+			platform := m.Digest
+			if m.Platform != nil {
+				platform = m.Platform.OS + "/" + m.Platform.Architecture
+				if m.Platform.Variant != "" {
+					platform += "/" + m.Platform.Variant
+				}
+			}
+			for _, layer := range child.Layers {
+				t.Layers = append(t.Layers, Layer{Platform: platform, Digest: layer.Digest, Size: layer.Size})
+			}
+		}
+	} else {
+		for _, layer := range manifest.Layers {
+			t.Layers = append(t.Layers, Layer{Digest: layer.Digest, Size: layer.Size})
+		}
+	}
+
+	if t.Size, err = r.ImageSize(image, tag); err != nil {
+		return t, err
+	}
+	config, err := r.ImageConfig(image, tag)
+	if err != nil {
+		return t, err
+	}
+	t.Created, t.Labels = config.Created, config.Config.Labels
+	return t, nil
+}
@@ -0,0 +1,120 @@
+package index
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/registry"
+)
+
+// Snapshot tells what the repository held when it was indexed. Every Build takes one, indexing from a cron job keeps
+// the history of how the repository grows. The images and the digests of the tags are kept with it, see Inventory
+type Snapshot struct {
+	Taken  time.Time `json:"taken"`
+	Images int       `json:"images"`
+	Tags   int       `json:"tags"`
+	// Bytes is the size of the distinct layers, layers shared by several images and tags are counted once
+	Bytes int64 `json:"bytes"`
+}
+
+// snapshot records the totals of the index as written by the transaction
+func (ix *Index) snapshot(tx *sql.Tx, taken time.Time) error {
+	s := Snapshot{Taken: taken}
+	err := tx.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM images WHERE host = ?1 AND repository = ?2),
+		(SELECT COUNT(*) FROM tags WHERE host = ?1 AND repository = ?2),
+		(SELECT COALESCE(SUM(size), 0) FROM (SELECT DISTINCT digest, size FROM layers WHERE host = ?1 AND repository = ?2))`,
+		ix.Host, ix.Repository).Scan(&s.Images, &s.Tags, &s.Bytes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO snapshots VALUES (?, ?, ?, ?, ?, ?)", ix.Host, ix.Repository, taken.Unix(), s.Images, s.Tags, s.Bytes)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"snapshot_images", "snapshot_tags"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE host = ? AND repository = ? AND taken = ?", ix.Host, ix.Repository, taken.Unix()); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("INSERT INTO snapshot_images SELECT host, repository, ?, image FROM images WHERE host = ? AND repository = ?",
+		taken.Unix(), ix.Host, ix.Repository)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO snapshot_tags SELECT host, repository, ?, image, tag, digest FROM tags WHERE host = ? AND repository = ?",
+		taken.Unix(), ix.Host, ix.Repository)
+	return err
+}
+
+// SnapshotAt returns the last snapshot taken at or before the given time, ok is false if there is none
+func (ix *Index) SnapshotAt(at time.Time) (Snapshot, bool, error) {
+	var s Snapshot
+	var taken int64
+	err := ix.db.QueryRow("SELECT taken, images, tags, bytes FROM snapshots WHERE host = ? AND repository = ? AND taken <= ? ORDER BY taken DESC LIMIT 1",
+		ix.Host, ix.Repository, at.Unix()).Scan(&taken, &s.Images, &s.Tags, &s.Bytes)
+	if err == sql.ErrNoRows {
+		return s, false, nil
+	}
+	if err != nil {
+		return s, false, err
+	}
+	s.Taken = time.Unix(taken, 0).UTC()
+	return s, true, nil
+}
+
+// Inventory returns the images of a snapshot with the digests of their tags. Snapshots taken by older versions only
+// have the totals, they fail
+func (ix *Index) Inventory(s Snapshot) (registry.Inventory, error) {
+	inventory := registry.Inventory{}
+	images, err := ix.strings("SELECT image FROM snapshot_images WHERE host = ? AND repository = ? AND taken = ?", ix.Host, ix.Repository, s.Taken.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 && s.Images > 0 {
+		return nil, errors.New(fmt.Sprintf("The snapshot of %s has no images and tags, only their totals. Index the repository again to take one with them", s.Taken.Format(time.RFC3339)))
+	}
+	for _, image := range images {
+		inventory[image] = map[string]string{}
+	}
+
+	rows, err := ix.db.Query("SELECT image, tag, digest FROM snapshot_tags WHERE host = ? AND repository = ? AND taken = ?", ix.Host, ix.Repository, s.Taken.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var image, tag, digest string
+		if err := rows.Scan(&image, &tag, &digest); err != nil {
+			return nil, err
+		}
+		if inventory[image] != nil {
+			inventory[image][tag] = digest
+		}
+	}
+	return inventory, rows.Err()
+}
+
+// History returns the snapshots taken since the given time, oldest first
+func (ix *Index) History(since time.Time) ([]Snapshot, error) {
+	rows, err := ix.db.Query("SELECT taken, images, tags, bytes FROM snapshots WHERE host = ? AND repository = ? AND taken >= ? ORDER BY taken",
+		ix.Host, ix.Repository, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []Snapshot
+	for rows.Next() {
+		var s Snapshot
+		var taken int64
+		if err := rows.Scan(&taken, &s.Images, &s.Tags, &s.Bytes); err != nil {
+			return nil, err
+		}
+		s.Taken = time.Unix(taken, 0).UTC()
+		history = append(history, s)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,328 @@
+// Package index keeps a local SQLite copy of the images and tags of a repository with their digests, sizes, labels,
+// layers and build times, so listing and searching huge registries does not have to crawl them every time
+package index
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/checkpoint"
+	"github.com/eugenmayer/nexus-cli/registry"
+	// registers the pure Go "sqlite" driver, release builds are done without cgo
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS repositories (
+	host TEXT NOT NULL, repository TEXT NOT NULL, indexed INTEGER NOT NULL,
+	PRIMARY KEY (host, repository));
+CREATE TABLE IF NOT EXISTS images (
+	host TEXT NOT NULL, repository TEXT NOT NULL, image TEXT NOT NULL,
+	PRIMARY KEY (host, repository, image));
+CREATE TABLE IF NOT EXISTS tags (
+	host TEXT NOT NULL, repository TEXT NOT NULL, image TEXT NOT NULL, tag TEXT NOT NULL,
+	digest TEXT NOT NULL, size INTEGER NOT NULL, created INTEGER NOT NULL,
+	PRIMARY KEY (host, repository, image, tag));
+CREATE TABLE IF NOT EXISTS labels (
+	host TEXT NOT NULL, repository TEXT NOT NULL, image TEXT NOT NULL, tag TEXT NOT NULL,
+	key TEXT NOT NULL, value TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS layers (
+	host TEXT NOT NULL, repository TEXT NOT NULL, image TEXT NOT NULL, tag TEXT NOT NULL,
+	platform TEXT NOT NULL, digest TEXT NOT NULL, size INTEGER NOT NULL);
+CREATE TABLE IF NOT EXISTS snapshots (
+	host TEXT NOT NULL, repository TEXT NOT NULL, taken INTEGER NOT NULL,
+	images INTEGER NOT NULL, tags INTEGER NOT NULL, bytes INTEGER NOT NULL,
+	PRIMARY KEY (host, repository, taken));
+CREATE TABLE IF NOT EXISTS snapshot_images (
+	host TEXT NOT NULL, repository TEXT NOT NULL, taken INTEGER NOT NULL, image TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS snapshot_tags (
+	host TEXT NOT NULL, repository TEXT NOT NULL, taken INTEGER NOT NULL, image TEXT NOT NULL, tag TEXT NOT NULL,
+	digest TEXT NOT NULL);
+CREATE INDEX IF NOT EXISTS snapshot_images_taken ON snapshot_images (host, repository, taken);
+CREATE INDEX IF NOT EXISTS snapshot_tags_taken ON snapshot_tags (host, repository, taken);
+CREATE INDEX IF NOT EXISTS labels_tag ON labels (host, repository, image, tag);
+CREATE INDEX IF NOT EXISTS layers_tag ON layers (host, repository, image, tag);
+CREATE INDEX IF NOT EXISTS layers_digest ON layers (digest);
+`
+
+// Index is the index of one repository in a database file. A file can hold the indexes of several repositories
+type Index struct {
+	Host       string
+	Repository string
+	// Progress is told about the images and tags Build crawls, if set
+	Progress registry.Progress
+	// Checkpoint records the tags Build crawled, so an interrupted build resumes without fetching them again. Nil
+	// records nothing
+	Checkpoint *checkpoint.Checkpoint
+
+	db *sql.DB
+}
+
+// Tag is an indexed tag
+type Tag struct {
+	Image   string            `json:"image"`
+	Tag     string            `json:"tag"`
+	Digest  string            `json:"digest"`
+	Size    int64             `json:"size"`
+	Created time.Time         `json:"created"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Layers  []Layer           `json:"layers"`
+}
+
+// Layer is a layer of an indexed tag. Platform is set for multi-arch images
+type Layer struct {
+	Platform string `json:"platform,omitempty"`
+	Digest   string `json:"digest"`
+	Size     int64  `json:"size"`
+}
+
+// Open opens (or creates) the database file and selects the index of the repository in it
+func Open(path string, host string, repository string) (*Index, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, errors.New(fmt.Sprintf("%s is not a nexus-cli index: %s", path, err))
+	}
+	return &Index{Host: host, Repository: repository, db: db}, nil
+}
+
+// Close closes the database file
+func (ix *Index) Close() error {
+	return ix.db.Close()
+}
+
+// Indexed returns when the repository was indexed last, ok is false if it never was
+func (ix *Index) Indexed() (time.Time, bool, error) {
+	var indexed int64
+	err := ix.db.QueryRow("SELECT indexed FROM repositories WHERE host = ? AND repository = ?", ix.Host, ix.Repository).Scan(&indexed)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	return time.Unix(indexed, 0), err == nil, err
+}
+
+// Images lists the indexed images, sorted by name
+func (ix *Index) Images() ([]string, error) {
+	return ix.strings("SELECT image FROM images WHERE host = ? AND repository = ? ORDER BY image", ix.Host, ix.Repository)
+}
+
+// Tags lists the indexed tags of an image, sorted by name
+func (ix *Index) Tags(image string) ([]string, error) {
+	return ix.strings("SELECT tag FROM tags WHERE host = ? AND repository = ? AND image = ? ORDER BY tag", ix.Host, ix.Repository, image)
+}
+
+// Usage returns the indexed tags of the images with their digests and the layers of each image with their sizes,
+// as registry.Inventory and registry.ImageBlobs do. Manifests and configs are not indexed, they are not counted
+func (ix *Index) Usage() (registry.Inventory, map[string]map[string]int64, error) {
+	inventory := registry.Inventory{}
+	blobs := map[string]map[string]int64{}
+	images, err := ix.Images()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, image := range images {
+		inventory[image] = map[string]string{}
+		blobs[image] = map[string]int64{}
+	}
+
+	rows, err := ix.db.Query("SELECT image, tag, digest FROM tags WHERE host = ? AND repository = ?", ix.Host, ix.Repository)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var image, tag, digest string
+		if err := rows.Scan(&image, &tag, &digest); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if inventory[image] != nil {
+			inventory[image][tag] = digest
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = ix.db.Query("SELECT image, digest, size FROM layers WHERE host = ? AND repository = ?", ix.Host, ix.Repository)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var image, digest string
+		var size int64
+		if err := rows.Scan(&image, &digest, &size); err != nil {
+			return nil, nil, err
+		}
+		if blobs[image] != nil {
+			blobs[image][digest] = size
+		}
+	}
+	return inventory, blobs, rows.Err()
+}
+
+func (ix *Index) strings(query string, args ...interface{}) ([]string, error) {
+	rows, err := ix.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// Tag returns an indexed tag with its labels and layers, ok is false if it is not in the index
+func (ix *Index) Tag(image string, tag string) (Tag, bool, error) {
+	t := Tag{Image: image, Tag: tag}
+	var created int64
+	err := ix.db.QueryRow("SELECT digest, size, created FROM tags WHERE host = ? AND repository = ? AND image = ? AND tag = ?",
+		ix.Host, ix.Repository, image, tag).Scan(&t.Digest, &t.Size, &created)
+	if err == sql.ErrNoRows {
+		return t, false, nil
+	}
+	if err != nil {
+		return t, false, err
+	}
+	t.Created = time.Unix(created, 0).UTC()
+
+	rows, err := ix.db.Query("SELECT key, value FROM labels WHERE host = ? AND repository = ? AND image = ? AND tag = ?",
+		ix.Host, ix.Repository, image, tag)
+	if err != nil {
+		return t, false, err
+	}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return t, false, err
+		}
+		if t.Labels == nil {
+			t.Labels = map[string]string{}
+		}
+		t.Labels[key] = value
+	}
+	rows.Close()
+
+	rows, err = ix.db.Query("SELECT platform, digest, size FROM layers WHERE host = ? AND repository = ? AND image = ? AND tag = ? ORDER BY rowid",
+		ix.Host, ix.Repository, image, tag)
+	if err != nil {
+		return t, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var layer Layer
+		if err := rows.Scan(&layer.Platform, &layer.Digest, &layer.Size); err != nil {
+			return t, false, err
+		}
+		t.Layers = append(t.Layers, layer)
+	}
+	return t, true, rows.Err()
+}
+
+// FindLayer is registry.FindLayer answered from the index
+func (ix *Index) FindLayer(layer string, images []string) ([]registry.LayerMatch, error) {
+	rows, err := ix.db.Query(`SELECT DISTINCT l.image, l.tag, l.platform, t.digest FROM layers l
+		JOIN tags t ON t.host = l.host AND t.repository = l.repository AND t.image = l.image AND t.tag = l.tag
+		WHERE l.host = ? AND l.repository = ? AND l.digest = ? ORDER BY l.image, l.tag, l.platform`,
+		ix.Host, ix.Repository, layer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wanted := map[string]bool{}
+	for _, image := range images {
+		wanted[image] = true
+	}
+	var matches []registry.LayerMatch
+	for rows.Next() {
+		var m registry.LayerMatch
+		if err := rows.Scan(&m.Image, &m.Tag, &m.Platform, &m.Digest); err != nil {
+			return nil, err
+		}
+		if len(wanted) == 0 || wanted[m.Image] {
+			matches = append(matches, m)
+		}
+	}
+	return matches, rows.Err()
+}
+
+// store replaces what the index holds about the crawled images (the whole repository with all) by the present images
+// and their tags, in one transaction so queries never see a half written index
+func (ix *Index) store(crawled []string, present []string, tags []Tag, all bool, indexed time.Time) error {
+	tx, err := ix.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := ix.replace(tx, crawled, present, tags, all, indexed); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ix *Index) replace(tx *sql.Tx, crawled []string, present []string, tags []Tag, all bool, indexed time.Time) error {
+	tables := []string{"images", "tags", "labels", "layers"}
+	for _, table := range tables {
+		if all {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE host = ? AND repository = ?", ix.Host, ix.Repository); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, image := range crawled {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE host = ? AND repository = ? AND image = ?", ix.Host, ix.Repository, image); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, image := range present {
+		if _, err := tx.Exec("INSERT INTO images VALUES (?, ?, ?)", ix.Host, ix.Repository, image); err != nil {
+			return err
+		}
+	}
+	for _, t := range tags {
+		if _, err := tx.Exec("INSERT INTO tags VALUES (?, ?, ?, ?, ?, ?, ?)",
+			ix.Host, ix.Repository, t.Image, t.Tag, t.Digest, t.Size, t.Created.Unix()); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(t.Labels))
+		for key := range t.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if _, err := tx.Exec("INSERT INTO labels VALUES (?, ?, ?, ?, ?, ?)",
+				ix.Host, ix.Repository, t.Image, t.Tag, key, t.Labels[key]); err != nil {
+				return err
+			}
+		}
+		for _, layer := range t.Layers {
+			if _, err := tx.Exec("INSERT INTO layers VALUES (?, ?, ?, ?, ?, ?, ?)",
+				ix.Host, ix.Repository, t.Image, t.Tag, layer.Platform, layer.Digest, layer.Size); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec("INSERT OR REPLACE INTO repositories VALUES (?, ?, ?)", ix.Host, ix.Repository, indexed.Unix()); err != nil {
+		return err
+	}
+	return ix.snapshot(tx, indexed)
+}
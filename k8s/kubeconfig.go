@@ -0,0 +1,274 @@
+// Package k8s finds the images deployed to Kubernetes: those the workloads of a cluster run, talking to the API server
+// with the credentials of a kubeconfig or, inside the cluster, of the service account, and those deployment
+// manifests declare
+package k8s
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/utils"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceAccount is where pods find the credentials of their service account
+const serviceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeconfig is the part of a kubeconfig file needed to reach the API server
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			Username              string `yaml:"username"`
+			Password              string `yaml:"password"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Exec                  *struct {
+				Command string   `yaml:"command"`
+				Args    []string `yaml:"args"`
+				Env     []struct {
+					Name  string `yaml:"name"`
+					Value string `yaml:"value"`
+				} `yaml:"env"`
+			} `yaml:"exec"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// Client talks to the API server of a cluster
+type Client struct {
+	// Context is the kubeconfig context used, "in-cluster" for the service account
+	Context string
+	Server  string
+
+	http     *http.Client
+	token    string
+	username string
+	password string
+}
+
+// DefaultKubeconfig is the kubeconfig kubectl uses: the first file of KUBECONFIG or ~/.kube/config
+func DefaultKubeconfig() string {
+	if paths := filepath.SplitList(os.Getenv("KUBECONFIG")); len(paths) > 0 && paths[0] != "" {
+		return paths[0]
+	}
+	return utils.ExpandTildeInPath("~/.kube/config")
+}
+
+// NewClient connects with the context of the kubeconfig at path, its current context if context is empty. Without
+// a kubeconfig, inside a pod, the service account is used
+func NewClient(path string, context string) (*Client, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && context == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return inCluster()
+	} else if err != nil {
+		return nil, err
+	}
+	var config kubeconfig
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, errors.New(fmt.Sprintf("%s is no kubeconfig: %s", path, err))
+	}
+	if context == "" {
+		context = config.CurrentContext
+	}
+	if context == "" {
+		return nil, errors.New(fmt.Sprintf("%s has no current context, give one with --context", path))
+	}
+
+	var clusterName, userName string
+	found := false
+	for _, c := range config.Contexts {
+		if c.Name == context {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+		}
+	}
+	if !found {
+		return nil, errors.New(fmt.Sprintf("%s has no context %s", path, context))
+	}
+	client := &Client{Context: context}
+	tlsConfig := &tls.Config{}
+	found = false
+	for _, c := range config.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		client.Server = strings.TrimRight(c.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		ca, err := fileOrData(path, c.Cluster.CertificateAuthority, c.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, err
+		}
+		if ca != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, errors.New(fmt.Sprintf("The certificate authority of cluster %s is no PEM certificate", clusterName))
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if !found || client.Server == "" {
+		return nil, errors.New(fmt.Sprintf("%s has no server for cluster %s", path, clusterName))
+	}
+
+	for _, u := range config.Users {
+		if u.Name != userName {
+			continue
+		}
+		user := u.User
+		client.token, client.username, client.password = user.Token, user.Username, user.Password
+		if user.TokenFile != "" {
+			token, err := ioutil.ReadFile(user.TokenFile)
+			if err != nil {
+				return nil, err
+			}
+			client.token = strings.TrimSpace(string(token))
+		}
+		cert, err := fileOrData(path, user.ClientCertificate, user.ClientCertificateData)
+		if err != nil {
+			return nil, err
+		}
+		key, err := fileOrData(path, user.ClientKey, user.ClientKeyData)
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil && key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("The client certificate of user %s is broken: %s", userName, err))
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		if user.Exec != nil {
+			env := os.Environ()
+			for _, e := range user.Exec.Env {
+				env = append(env, e.Name+"="+e.Value)
+			}
+			if client.token, err = execToken(user.Exec.Command, user.Exec.Args, env); err != nil {
+				return nil, err
+			}
+		}
+	}
+	client.http = &http.Client{Timeout: time.Minute, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}}
+	return client, nil
+}
+
+// inCluster connects with the service account of the pod
+func inCluster() (*Client, error) {
+	token, err := ioutil.ReadFile(serviceAccount + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(serviceAccount + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	server := "https://" + os.Getenv("KUBERNETES_SERVICE_HOST") + ":" + os.Getenv("KUBERNETES_SERVICE_PORT")
+	return &Client{
+		Context: "in-cluster",
+		Server:  server,
+		http:    &http.Client{Timeout: time.Minute, Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+		token:   strings.TrimSpace(string(token)),
+	}, nil
+}
+
+// fileOrData returns the inline base64 data of a certificate or key, or reads the file, relative to the kubeconfig.
+// nil if neither is given
+func fileOrData(kubeconfig string, file string, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(kubeconfig), file)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// execToken runs a credential plugin, like aws eks get-token or gke-gcloud-auth-plugin, and returns the token of the
+// ExecCredential it prints
+func execToken(command string, args []string, env []string) (string, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Env = env
+	cmd.Stderr = os.Stderr
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", errors.New(fmt.Sprintf("The credential plugin %s failed: %s", command, err))
+	}
+	var credential struct {
+		Status struct {
+			Token string `json:"token"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &credential); err != nil || credential.Status.Token == "" {
+		return "", errors.New(fmt.Sprintf("The credential plugin %s printed no token", command))
+	}
+	return credential.Status.Token, nil
+}
+
+// get decodes the answer of the API server to a GET of path into v
+func (c *Client) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", c.Server+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		if status.Message != "" {
+			return errors.New(fmt.Sprintf("GET %s: %s: %s", path, resp.Status, status.Message))
+		}
+		return errors.New(fmt.Sprintf("GET %s: %s", path, resp.Status))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,113 @@
+package k8s
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Declared is an image reference found in a deployment manifest
+type Declared struct {
+	// File is the manifest declaring the image
+	File  string
+	Image string
+}
+
+// ManifestImages finds the images declared by the Kubernetes manifests, Compose files and Helm values under root, a
+// file or a directory of them like the checkout of a GitOps repository. The values of every image key of the YAML
+// and JSON files are taken: the containers of pod specs, the services of Compose files and the image.repository and
+// image.tag of Helm values. Files which are no YAML, e.g. Helm templates, are skipped with a warning on stderr
+func ManifestImages(root string) ([]Declared, error) {
+	var declared []Declared
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// .git and the like hold no manifests
+			if path != root && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		images, err := manifestImages(content)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s skipped, it is no YAML: %s\n", path, err)
+			return nil
+		}
+		for _, image := range images {
+			declared = append(declared, Declared{File: path, Image: image})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(declared, func(i, j int) bool { return declared[i].Image < declared[j].Image })
+	return declared, nil
+}
+
+// manifestImages returns the images of the documents of a YAML (or JSON) file
+func manifestImages(content []byte) ([]string, error) {
+	var images []string
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var document interface{}
+		if err := decoder.Decode(&document); err == io.EOF {
+			return images, nil
+		} else if err != nil {
+			return nil, err
+		}
+		images = collectImages(document, images)
+	}
+}
+
+func collectImages(node interface{}, images []string) []string {
+	switch n := node.(type) {
+	case map[interface{}]interface{}:
+		for key, value := range n {
+			if key != "image" {
+				images = collectImages(value, images)
+				continue
+			}
+			switch v := value.(type) {
+			case string:
+				images = append(images, v)
+			case map[interface{}]interface{}:
+				// Helm values: image: {registry: .., repository: .., tag: ..}
+				repository, _ := v["repository"].(string)
+				if repository == "" {
+					images = collectImages(v, images)
+					continue
+				}
+				if registry, ok := v["registry"].(string); ok && registry != "" {
+					repository = registry + "/" + repository
+				}
+				if tag := fmt.Sprint(v["tag"]); v["tag"] != nil && tag != "" {
+					repository += ":" + tag
+				}
+				images = append(images, repository)
+			}
+		}
+	case []interface{}:
+		for _, value := range n {
+			images = collectImages(value, images)
+		}
+	}
+	return images
+}
@@ -0,0 +1,198 @@
+package k8s
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Container is a container of a workload and the image it runs
+type Container struct {
+	Namespace string
+	// Workload is Kind/name, the controller of a pod if it has one, e.g. ReplicaSet/web-5d8f7c or CronJob/backup
+	Workload string
+	Image    string
+	// Digest is the manifest digest the container runs, known for running pods only
+	Digest string
+}
+
+type podSpec struct {
+	InitContainers []specContainer `json:"initContainers"`
+	Containers     []specContainer `json:"containers"`
+}
+
+type specContainer struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+type metadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	OwnerReferences []struct {
+		Kind       string `json:"kind"`
+		Name       string `json:"name"`
+		Controller bool   `json:"controller"`
+	} `json:"ownerReferences"`
+}
+
+type containerStatus struct {
+	Name    string `json:"name"`
+	ImageID string `json:"imageID"`
+}
+
+// templates are the kinds of workloads whose pod template names images, pods or not
+var templates = []struct {
+	kind string
+	path string
+}{
+	{"Deployment", "/apis/apps/v1/deployments"},
+	{"StatefulSet", "/apis/apps/v1/statefulsets"},
+	{"DaemonSet", "/apis/apps/v1/daemonsets"},
+	{"Job", "/apis/batch/v1/jobs"},
+	{"CronJob", "/apis/batch/v1/cronjobs"},
+}
+
+// Containers lists the containers, init containers included, of the pods which did not finish and of the pod
+// templates of deployments, stateful sets, daemon sets, jobs and cron jobs: a deployment scaled to 0 or a cron job
+// between two runs pulls its image again later. An empty namespace lists all of them
+func (c *Client) Containers(namespace string) ([]Container, error) {
+	var containers []Container
+	err := c.list("/api/v1/pods", namespace, func(item json.RawMessage) error {
+		var pod struct {
+			Metadata metadata `json:"metadata"`
+			Spec     podSpec  `json:"spec"`
+			Status   struct {
+				Phase                 string            `json:"phase"`
+				InitContainerStatuses []containerStatus `json:"initContainerStatuses"`
+				ContainerStatuses     []containerStatus `json:"containerStatuses"`
+			} `json:"status"`
+		}
+		if err := json.Unmarshal(item, &pod); err != nil {
+			return err
+		}
+		if pod.Status.Phase == "Succeeded" || pod.Status.Phase == "Failed" {
+			return nil
+		}
+		workload := "Pod/" + pod.Metadata.Name
+		for _, owner := range pod.Metadata.OwnerReferences {
+			if owner.Controller {
+				workload = owner.Kind + "/" + owner.Name
+			}
+		}
+		digests := map[string]string{}
+		for _, s := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			// docker-pullable://nexus.example.com/app@sha256:..., the image id of other runtimes has no repository
+			if i := strings.LastIndex(s.ImageID, "@"); i >= 0 {
+				digests[s.Name] = s.ImageID[i+1:]
+			}
+		}
+		for _, sc := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+			containers = append(containers, Container{Namespace: pod.Metadata.Namespace, Workload: workload, Image: sc.Image, Digest: digests[sc.Name]})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range templates {
+		kind := t.kind
+		err := c.list(t.path, namespace, func(item json.RawMessage) error {
+			var workload struct {
+				Metadata metadata `json:"metadata"`
+				Spec     struct {
+					Template struct {
+						Spec podSpec `json:"spec"`
+					} `json:"template"`
+					JobTemplate struct {
+						Spec struct {
+							Template struct {
+								Spec podSpec `json:"spec"`
+							} `json:"template"`
+						} `json:"spec"`
+					} `json:"jobTemplate"`
+				} `json:"spec"`
+			}
+			if err := json.Unmarshal(item, &workload); err != nil {
+				return err
+			}
+			spec := workload.Spec.Template.Spec
+			if kind == "CronJob" {
+				spec = workload.Spec.JobTemplate.Spec.Template.Spec
+			}
+			for _, sc := range append(spec.InitContainers, spec.Containers...) {
+				containers = append(containers, Container{Namespace: workload.Metadata.Namespace, Workload: kind + "/" + workload.Metadata.Name, Image: sc.Image})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(containers, func(i, j int) bool {
+		if containers[i].Namespace != containers[j].Namespace {
+			return containers[i].Namespace < containers[j].Namespace
+		}
+		return containers[i].Workload < containers[j].Workload
+	})
+	return containers, nil
+}
+
+// list pages through the objects of a collection, in namespace if it is not empty, calling each for every item
+func (c *Client) list(collection string, namespace string, each func(item json.RawMessage) error) error {
+	path := collection
+	if namespace != "" {
+		// /api/v1/pods becomes /api/v1/namespaces/<namespace>/pods
+		i := strings.LastIndex(collection, "/")
+		path = collection[:i] + "/namespaces/" + url.PathEscape(namespace) + collection[i:]
+	}
+	next := ""
+	for {
+		query := url.Values{"limit": {"500"}}
+		if next != "" {
+			query.Set("continue", next)
+		}
+		var page struct {
+			Metadata struct {
+				Continue string `json:"continue"`
+			} `json:"metadata"`
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := c.get(path+"?"+query.Encode(), &page); err != nil {
+			return err
+		}
+		for _, item := range page.Items {
+			if err := each(item); err != nil {
+				return err
+			}
+		}
+		if page.Metadata.Continue == "" {
+			return nil
+		}
+		next = page.Metadata.Continue
+	}
+}
+
+// SplitImage splits an image reference of a pod spec, e.g. nexus.example.com:8082/team/app:1.0@sha256:..., into the
+// registry host, the image name, the tag and the digest. The host is empty for images of Docker Hub, tag and digest
+// if the reference has none
+func SplitImage(image string) (host string, name string, tag string, digest string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image, digest = image[:i], image[i+1:]
+	}
+	// the tag follows the last colon after the last slash, a colon before it separates the port
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image, tag = image[:i], image[i+1:]
+	}
+	name = image
+	if i := strings.Index(image, "/"); i >= 0 {
+		first := image[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			host, name = first, image[i+1:]
+		}
+	}
+	return host, name, tag, digest
+}
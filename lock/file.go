@@ -0,0 +1,170 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileLock is a lock file on the local host, for cleanup jobs scheduled on a single machine
+type FileLock struct {
+	Path  string
+	Stale time.Duration
+
+	mu      sync.Mutex
+	holder  *Holder
+	refresh *refresher
+}
+
+// NewFileLock creates a lock using the file at path
+func NewFileLock(path string, stale time.Duration) *FileLock {
+	return &FileLock{Path: path, Stale: stale}
+}
+
+func (l *FileLock) Acquire() error {
+	now := time.Now()
+	holder := Holder{Owner: owner(), Acquired: now, Refreshed: now}
+	content, err := json.Marshal(holder)
+	if err != nil {
+		return err
+	}
+
+	// two attempts: the second one after moving a stale lock aside
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.Write(content)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(l.Path)
+				return err
+			}
+			l.mu.Lock()
+			l.holder = &holder
+			l.mu.Unlock()
+			l.refresh = startRefresh(l.Stale, l.touch)
+			return nil
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		existing, err := l.read()
+		if os.IsNotExist(err) {
+			// released in the meantime
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !existing.isStale(l.Stale) {
+			return &HeldError{Holder: existing, Stale: l.Stale}
+		}
+		if err := l.takeOver(existing); err != nil {
+			return err
+		}
+	}
+	existing, err := l.read()
+	if err != nil {
+		return err
+	}
+	return &HeldError{Holder: existing, Stale: l.Stale}
+}
+
+func (l *FileLock) Release() error {
+	l.refresh.halt()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == nil {
+		return errNotHeld
+	}
+	holder := *l.holder
+	l.holder = nil
+	current, err := l.read()
+	if err != nil {
+		return err
+	}
+	if !current.same(holder) {
+		// the lock file is the one of whoever took the lock over
+		return takenOver(current)
+	}
+	return os.Remove(l.Path)
+}
+
+// takeOver moves the stale lock file of stale out of the way. Renaming is atomic: of several processes taking the
+// same stale lock over, only one moves it aside. The others move the lock file of the winner, which is put back
+func (l *FileLock) takeOver(stale Holder) error {
+	aside := fmt.Sprintf("%s.%d.stale", l.Path, os.Getpid())
+	if err := os.Rename(l.Path, aside); os.IsNotExist(err) {
+		// released or taken over in the meantime
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer os.Remove(aside)
+	moved, err := readHolder(aside)
+	if err != nil {
+		return err
+	}
+	if moved.same(stale) {
+		return nil
+	}
+	// linking fails instead of replacing a lock file created in the meantime
+	if err := os.Link(aside, l.Path); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return &HeldError{Holder: moved, Stale: l.Stale}
+}
+
+// touch updates the refresh time of the lock file
+func (l *FileLock) touch() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == nil {
+		return errNotHeld
+	}
+	current, err := l.read()
+	if err != nil {
+		return err
+	}
+	if !current.same(*l.holder) {
+		return takenOver(current)
+	}
+	l.holder.Refreshed = time.Now()
+	content, err := json.Marshal(l.holder)
+	if err != nil {
+		return err
+	}
+	// written aside and renamed, a process reading the lock meanwhile must not see it empty and take it over
+	tmp := fmt.Sprintf("%s.%d.tmp", l.Path, os.Getpid())
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.Path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (l *FileLock) read() (Holder, error) {
+	return readHolder(l.Path)
+}
+
+// readHolder reads the lock file at path
+func readHolder(path string) (Holder, error) {
+	var h Holder
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return h, err
+	}
+	// an unreadable lock file is treated as stale
+	if err := json.Unmarshal(content, &h); err != nil {
+		return Holder{}, nil
+	}
+	return h, nil
+}
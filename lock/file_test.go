@@ -0,0 +1,92 @@
+package lock
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeHolder(t *testing.T, path string, h Holder) {
+	content, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileLockTakeOver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cleanup.lock")
+	crashed := Holder{Owner: "crashed:1", Acquired: time.Now().Add(-2 * time.Hour), Refreshed: time.Now().Add(-2 * time.Hour)}
+	fresh := Holder{Owner: "other:2", Acquired: time.Now(), Refreshed: time.Now()}
+
+	// a fresh lock is left alone
+	writeHolder(t, path, fresh)
+	l := NewFileLock(path, time.Hour)
+	if err := l.Acquire(); err == nil {
+		t.Fatal("acquired a lock held by a live process")
+	} else if held, ok := err.(*HeldError); !ok || held.Holder.Owner != fresh.Owner {
+		t.Fatalf("Acquire: %v, want it held by %s", err, fresh.Owner)
+	}
+
+	// a stale one is taken over
+	writeHolder(t, path, crashed)
+	if err := l.Acquire(); err != nil {
+		t.Fatalf("taking a stale lock over: %s", err)
+	}
+	if h, err := l.read(); err != nil || h.Owner != owner() {
+		t.Errorf("lock file holds %+v, %v after the take over, want %s", h, err, owner())
+	}
+	if err := l.touch(); err != nil {
+		t.Errorf("refreshing the lock: %s", err)
+	}
+
+	// releasing a lock taken over by someone else leaves their lock file
+	writeHolder(t, path, fresh)
+	if err := l.touch(); err == nil || !strings.Contains(err.Error(), "taken over by other:2") {
+		t.Errorf("refreshing a lock taken over: %v", err)
+	}
+	if err := l.Release(); err == nil || !strings.Contains(err.Error(), "taken over by other:2") {
+		t.Errorf("releasing a lock taken over: %v", err)
+	}
+	if h, err := l.read(); err != nil || !h.same(fresh) {
+		t.Errorf("lock file holds %+v, %v after the release, want the one of %s", h, err, fresh.Owner)
+	}
+}
+
+// TestFileLockTakeOverRace checks a process which saw a stale lock but lost the race to take it over puts the lock
+// file of the winner back
+func TestFileLockTakeOverRace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cleanup.lock")
+	crashed := Holder{Owner: "crashed:1", Acquired: time.Now().Add(-2 * time.Hour), Refreshed: time.Now().Add(-2 * time.Hour)}
+	winner := Holder{Owner: "winner:2", Acquired: time.Now(), Refreshed: time.Now()}
+	writeHolder(t, path, winner)
+
+	l := NewFileLock(path, time.Hour)
+	if err := l.takeOver(crashed); err == nil {
+		t.Fatal("took over the lock of the winner")
+	} else if held, ok := err.(*HeldError); !ok || held.Holder.Owner != winner.Owner {
+		t.Fatalf("takeOver: %v, want it held by %s", err, winner.Owner)
+	}
+	if h, err := l.read(); err != nil || !h.same(winner) {
+		t.Errorf("lock file holds %+v, %v, want the one of %s", h, err, winner.Owner)
+	}
+	if files, _ := filepath.Glob(path + ".*"); len(files) > 0 {
+		t.Errorf("left behind %q", files)
+	}
+}
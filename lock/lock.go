@@ -0,0 +1,105 @@
+// Package lock provides advisory locks keeping concurrent cleanup runs from racing on the same tags
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/output"
+	"os"
+	"sync"
+	"time"
+)
+
+// Lock is an advisory lock. A lock not refreshed for longer than its stale timeout is taken over by the next
+// process trying to acquire it, so a crashed run does not block all later ones
+type Lock interface {
+	// Acquire takes the lock or fails if another live process holds it
+	Acquire() error
+	// Release gives the lock up
+	Release() error
+}
+
+// Holder identifies who holds a lock
+type Holder struct {
+	Owner    string    `json:"owner"`
+	Acquired time.Time `json:"acquired"`
+	// Refreshed is updated while the lock is held, it tells live holders from crashed ones
+	Refreshed time.Time `json:"refreshed"`
+}
+
+// HeldError is returned by Acquire if another process holds the lock
+type HeldError struct {
+	Holder Holder
+	Stale  time.Duration
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("locked by %s since %s, it is taken over if not refreshed for %s",
+		e.Holder.Owner, e.Holder.Acquired.Format(time.RFC3339), e.Stale)
+}
+
+func (h Holder) isStale(stale time.Duration) bool {
+	return time.Since(h.Refreshed) > stale
+}
+
+// same tells if o is the lock h acquired, refreshed or not
+func (h Holder) same(o Holder) bool {
+	return h.Owner == o.Owner && h.Acquired.Equal(o.Acquired)
+}
+
+// owner names this process, host and pid
+func owner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// refresher keeps a held lock fresh until stopped
+type refresher struct {
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// startRefresh calls refresh regularly, well within the stale timeout
+func startRefresh(stale time.Duration, refresh func() error) *refresher {
+	r := &refresher{stop: make(chan struct{})}
+	interval := stale / 3
+	if interval < time.Second {
+		interval = time.Second
+	}
+	r.done.Add(1)
+	go func() {
+		defer r.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				if err := refresh(); err != nil {
+					output.Warnf("Refreshing the lock failed: %s", err)
+				}
+			}
+		}
+	}()
+	return r
+}
+
+func (r *refresher) halt() {
+	if r == nil {
+		return
+	}
+	close(r.stop)
+	r.done.Wait()
+}
+
+var errNotHeld = errors.New("lock is not held")
+
+// takenOver is the error of refreshing or releasing a lock another process took over, e.g. after it was not
+// refreshed in time
+func takenOver(current Holder) error {
+	return errors.New(fmt.Sprintf("the lock was taken over by %s at %s", current.Owner, current.Acquired.Format(time.RFC3339)))
+}
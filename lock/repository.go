@@ -0,0 +1,178 @@
+package lock
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/output"
+	"github.com/eugenmayer/nexus-cli/registry"
+	"sync"
+	"time"
+)
+
+// RepositoryImage is the image holding repository locks, cleanups have to leave it alone
+const RepositoryImage = "nexus-cli-lock"
+
+// ArtifactType marks lock manifests
+const ArtifactType = "application/vnd.nexus-cli.lock.v1"
+
+const annotationHolder = "io.github.nexus-cli.lock.holder"
+
+// the empty JSON object serves as config and layer of lock manifests
+var emptyBlob = []byte("{}")
+
+// RepositoryLock is a lock stored as a tag of RepositoryImage in the repository itself, so cleanups running on
+// different hosts see each other. The registry offers no atomic create, so after writing the lock it is read back:
+// of two racing processes only the one whose write came last goes on
+type RepositoryLock struct {
+	Registry registry.Registry
+	Name     string
+	Stale    time.Duration
+
+	mu      sync.Mutex
+	holder  *Holder
+	digest  string
+	refresh *refresher
+}
+
+// NewRepositoryLock creates a lock named name, stored as RepositoryImage:name in the repository of r
+func NewRepositoryLock(r registry.Registry, name string, stale time.Duration) *RepositoryLock {
+	return &RepositoryLock{Registry: r, Name: name, Stale: stale}
+}
+
+func (l *RepositoryLock) Acquire() error {
+	existing, previous, found, err := l.read()
+	if err != nil {
+		return err
+	}
+	if found && !existing.isStale(l.Stale) {
+		return &HeldError{Holder: existing, Stale: l.Stale}
+	}
+
+	now := time.Now()
+	holder := Holder{Owner: owner(), Acquired: now, Refreshed: now}
+	digest, err := l.write(holder)
+	if err != nil {
+		return err
+	}
+
+	// give a racing writer the time to overwrite us, then check who won
+	time.Sleep(time.Second)
+	current, currentDigest, found, err := l.read()
+	if err != nil {
+		return err
+	}
+	if !found || currentDigest != digest {
+		return &HeldError{Holder: current, Stale: l.Stale}
+	}
+
+	l.mu.Lock()
+	l.holder = &holder
+	l.digest = digest
+	l.mu.Unlock()
+	l.refresh = startRefresh(l.Stale, l.touch)
+	if found {
+		// the tag moved on, the manifest of the stale lock would stay behind untagged
+		if err := l.Registry.DeleteManifest(RepositoryImage, previous); err != nil && !registry.IsNotFound(err) {
+			output.Warnf("Removing the stale lock of %s failed: %s", existing.Owner, err)
+		}
+	}
+	return nil
+}
+
+func (l *RepositoryLock) Release() error {
+	l.refresh.halt()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == nil {
+		return errNotHeld
+	}
+	l.holder = nil
+	current, digest, found, err := l.read()
+	if err != nil {
+		return err
+	}
+	if found && digest != l.digest {
+		// whoever took the lock over removed our manifest
+		return takenOver(current)
+	}
+	return l.Registry.DeleteManifest(RepositoryImage, l.digest)
+}
+
+// touch rewrites the lock with a new refresh time and removes the former manifest
+func (l *RepositoryLock) touch() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == nil {
+		return errNotHeld
+	}
+	current, digest, found, err := l.read()
+	if err != nil {
+		return err
+	}
+	if found && digest != l.digest {
+		return takenOver(current)
+	}
+	l.holder.Refreshed = time.Now()
+	digest, err = l.write(*l.holder)
+	if err != nil {
+		return err
+	}
+	previous := l.digest
+	l.digest = digest
+	return l.Registry.DeleteManifest(RepositoryImage, previous)
+}
+
+func (l *RepositoryLock) read() (Holder, string, bool, error) {
+	var h Holder
+	body, _, digest, err := l.Registry.RawManifest(RepositoryImage, l.Name)
+	if registry.IsNotFound(err) {
+		return h, "", false, nil
+	} else if err != nil {
+		return h, "", false, err
+	}
+	var manifest registry.ImageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return h, "", false, err
+	}
+	if manifest.ArtifactType != ArtifactType {
+		return h, "", false, errors.New(fmt.Sprintf("%s:%s is not a lock of nexus-cli", RepositoryImage, l.Name))
+	}
+	// an unreadable holder is treated as stale
+	json.Unmarshal([]byte(manifest.Annotations[annotationHolder]), &h)
+	return h, digest, true, nil
+}
+
+func (l *RepositoryLock) write(holder Holder) (string, error) {
+	content, err := json.Marshal(holder)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(emptyBlob)
+	empty := registry.LayerInfo{MediaType: "application/vnd.oci.empty.v1+json", Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(emptyBlob))}
+	exists, err := l.Registry.BlobExists(RepositoryImage, empty.Digest)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := l.Registry.UploadBlob(RepositoryImage, empty.Digest, empty.Size, bytes.NewReader(emptyBlob)); err != nil {
+			return "", err
+		}
+	}
+
+	manifest, err := json.Marshal(registry.ImageManifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		ArtifactType:  ArtifactType,
+		Config:        empty,
+		Layers:        []registry.LayerInfo{empty},
+		Annotations:   map[string]string{annotationHolder: string(content)},
+	})
+	if err != nil {
+		return "", err
+	}
+	return l.Registry.PutManifest(RepositoryImage, l.Name, registry.MediaTypeOCIManifest, manifest)
+}
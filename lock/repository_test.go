@@ -0,0 +1,47 @@
+package lock
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/registry"
+	"github.com/eugenmayer/nexus-cli/registrytest"
+)
+
+func TestRepositoryLockTakeOver(t *testing.T) {
+	srv := registrytest.NewServer()
+	defer srv.Close()
+	r := srv.Registry("docker-hosted")
+	crashed := Holder{Owner: "crashed:1", Acquired: time.Now().Add(-2 * time.Hour), Refreshed: time.Now().Add(-2 * time.Hour)}
+	previous, err := NewRepositoryLock(r, "cleanup", time.Hour).write(crashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewRepositoryLock(r, "cleanup", time.Hour)
+	if err := l.Acquire(); err != nil {
+		t.Fatalf("taking a stale lock over: %s", err)
+	}
+	if _, _, _, err := r.RawManifest(RepositoryImage, previous); !registry.IsNotFound(err) {
+		t.Errorf("the manifest of the stale lock is left: %v", err)
+	}
+	if err := l.touch(); err != nil {
+		t.Errorf("refreshing the lock: %s", err)
+	}
+
+	// refreshing or releasing a lock taken over by someone else leaves their lock
+	fresh := Holder{Owner: "other:2", Acquired: time.Now(), Refreshed: time.Now()}
+	if _, err := NewRepositoryLock(r, "cleanup", time.Hour).write(fresh); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.touch(); err == nil || !strings.Contains(err.Error(), "taken over by other:2") {
+		t.Errorf("refreshing a lock taken over: %v", err)
+	}
+	if err := l.Release(); err == nil || !strings.Contains(err.Error(), "taken over by other:2") {
+		t.Errorf("releasing a lock taken over: %v", err)
+	}
+	if h, _, found, err := l.read(); err != nil || !found || !h.same(fresh) {
+		t.Errorf("lock holds %+v (found %t, %v) after the release, want the one of %s", h, found, err, fresh.Owner)
+	}
+}
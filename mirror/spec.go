@@ -0,0 +1,171 @@
+// Package mirror runs declarative sync jobs copying images between Nexus repositories
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/registry"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Spec is a sync specification in the spirit of `skopeo sync`, usually loaded from a YAML file:
+//
+//	destination:
+//	  repository: docker-mirror
+//	sources:
+//	  - profile: staging
+//	    images:
+//	      team/app: ["1.0.0", "2.0.0"]
+//	      library/alpine: []
+//	    images-by-tag-regex:
+//	      web: '^1\.'
+//
+// An empty tag list copies all tags of an image
+type Spec struct {
+	Destination Endpoint `yaml:"destination"`
+	Sources     []Source `yaml:"sources"`
+	// Ignore are rules of images and tags which are not copied, nil for none. They are not part of the file
+	Ignore *registry.IgnoreRules `yaml:"-"`
+	// Throttle paces the blobs copied into the destination, nil for no limit. It is not part of the file either
+	Throttle *registry.Throttle `yaml:"-"`
+}
+
+// Endpoint is a Nexus repository. It is based on a profile (the active one if not given) whose settings can be
+// overridden. The password can be taken from an environment variable
+type Endpoint struct {
+	Profile     string `yaml:"profile"`
+	Host        string `yaml:"host"`
+	Repository  string `yaml:"repository"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	PasswordEnv string `yaml:"password-env"`
+}
+
+// Source lists the images to copy from one repository
+type Source struct {
+	Endpoint         `yaml:",inline"`
+	Images           map[string][]string `yaml:"images"`
+	ImagesByTagRegex map[string]string   `yaml:"images-by-tag-regex"`
+	// Prefix is put in front of the image names in the destination
+	Prefix        string `yaml:"prefix"`
+	SkipReferrers bool   `yaml:"skip-referrers"`
+
+	tagRegexps map[string]*regexp.Regexp
+}
+
+// Load reads and validates a sync specification
+func Load(path string) (Spec, error) {
+	var s Spec
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := yaml.UnmarshalStrict(content, &s); err != nil {
+		return s, errors.New(fmt.Sprintf("Invalid sync specification %s: %s", path, err))
+	}
+	if err := s.compile(); err != nil {
+		return s, errors.New(fmt.Sprintf("Invalid sync specification %s: %s", path, err))
+	}
+	return s, nil
+}
+
+func (s *Spec) compile() error {
+	if len(s.Sources) == 0 {
+		return errors.New("no sources defined")
+	}
+	for i := range s.Sources {
+		source := &s.Sources[i]
+		if len(source.Images) == 0 && len(source.ImagesByTagRegex) == 0 {
+			return errors.New(fmt.Sprintf("source %d: no images given", i+1))
+		}
+		source.tagRegexps = map[string]*regexp.Regexp{}
+		for image, pattern := range source.ImagesByTagRegex {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return errors.New(fmt.Sprintf("source %d: tag regex of %s: %s", i+1, image, err))
+			}
+			source.tagRegexps[image] = re
+		}
+	}
+	return nil
+}
+
+// Registry resolves the endpoint to the registry it points to
+func (e Endpoint) Registry() (registry.Registry, error) {
+	r, _, err := registry.NewRegistryFromProfile(e.Profile)
+	if err != nil && (e.Profile != "" || e.Host == "") {
+		return r, err
+	}
+	if e.Host != "" {
+		r.Host = strings.TrimRight(e.Host, "/")
+	}
+	if e.Repository != "" {
+		r.Repository = e.Repository
+	}
+	if e.Username != "" {
+		r.Username = e.Username
+	}
+	if e.Password != "" {
+		r.Password = e.Password
+	}
+	if e.PasswordEnv != "" {
+		r.Password = os.Getenv(e.PasswordEnv)
+	}
+	return r, nil
+}
+
+// imageNames returns the images of the source in alphabetical order
+func (s Source) imageNames() []string {
+	seen := map[string]bool{}
+	var names []string
+	for image := range s.Images {
+		seen[image] = true
+		names = append(names, image)
+	}
+	for image := range s.ImagesByTagRegex {
+		if !seen[image] {
+			names = append(names, image)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// tags resolves the tags of an image to copy. Explicitly listed tags and tags matching the regex are combined
+func (s Source) tags(r registry.Registry, image string) ([]string, error) {
+	explicit, listed := s.Images[image]
+	re := s.tagRegexps[image]
+	if listed && len(explicit) > 0 && re == nil {
+		return explicit, nil
+	}
+
+	all, err := r.ListTagsByImage(image)
+	if err != nil {
+		return nil, err
+	}
+	if listed && len(explicit) == 0 {
+		return all, nil
+	}
+
+	var tags []string
+	for _, tag := range all {
+		if re.MatchString(tag) || containsTag(explicit, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package mirror
+
+import (
+	"github.com/eugenmayer/nexus-cli/checkpoint"
+	"github.com/eugenmayer/nexus-cli/registry"
+	"strconv"
+)
+
+// Actions reported for synced tags
+const (
+	ActionCopied   = "copied"
+	ActionUpToDate = "up-to-date"
+	ActionFailed   = "failed"
+)
+
+// Result is the outcome of syncing one tag
+type Result struct {
+	Source string
+	Image  string
+	Tag    string
+	// Target is the image name in the destination
+	Target string
+	Action string
+	Digest string
+	Err    error
+}
+
+// Run executes the specification. Tags whose digest is the same in the destination are skipped, as are the images
+// and tags of the Ignore rules. report is called
+// for every tag, failures do not stop the sync. With dryRun nothing is copied, tags are reported as they would be.
+// Synced tags are recorded in cp (may be nil), tags it has done already are skipped without being reported, as are
+// the uploads of big blobs so a resumed run continues them. The outcome of the tags and the bytes copied are
+// recorded on stats (may be nil), up to date tags as skipped. A Throttle stopping outside of its windows ends the
+// sync with its *registry.OutsideWindowError, the tag being copied is not reported
+func (s Spec) Run(dryRun bool, cp *checkpoint.Checkpoint, stats *registry.RunStats, report func(Result)) error {
+	dst, err := s.Destination.Registry()
+	if err != nil {
+		return err
+	}
+	registry.WithRunStats(stats)(&dst)
+	registry.WithThrottle(s.Throttle)(&dst)
+	if cp != nil {
+		registry.WithUploadJournal(uploads{cp: cp, dst: dst}, 0)(&dst)
+	}
+	report = recording(stats, report)
+
+	for _, source := range s.Sources {
+		src, err := source.Registry()
+		if err != nil {
+			return err
+		}
+		for _, image := range s.Ignore.Images(source.imageNames()) {
+			tags, err := source.tags(src, image)
+			if err != nil {
+				report(Result{Source: src.Repository, Image: image, Action: ActionFailed, Err: err})
+				continue
+			}
+			for _, tag := range s.Ignore.Tags(image, tags) {
+				unit := src.Host + "/" + src.Repository + "/" + image + ":" + tag
+				if cp.IsDone(unit) {
+					continue
+				}
+				result := syncTag(src, dst, source, image, tag, dryRun)
+				if registry.IsOutsideWindow(result.Err) {
+					return result.Err
+				}
+				if result.Action != ActionFailed {
+					if err := cp.MarkDone(unit); err != nil {
+						return err
+					}
+				}
+				report(result)
+			}
+		}
+	}
+	return nil
+}
+
+// uploads keeps the uploads of big blobs in progress in the checkpoint of the sync
+type uploads struct {
+	cp  *checkpoint.Checkpoint
+	dst registry.Registry
+}
+
+func (u uploads) unit(image string, digest string) string {
+	return "upload " + u.dst.Host + "/" + u.dst.Repository + "/" + image + "@" + digest
+}
+
+func (u uploads) Upload(image string, digest string) (string, int64, bool) {
+	items, ok := u.cp.Pending(u.unit(image, digest))
+	if !ok || len(items) != 2 {
+		return "", 0, false
+	}
+	offset, err := strconv.ParseInt(items[1], 10, 64)
+	return items[0], offset, err == nil
+}
+
+func (u uploads) SaveUpload(image string, digest string, location string, offset int64) error {
+	if location == "" {
+		return u.cp.MarkDone(u.unit(image, digest))
+	}
+	return u.cp.SetPending(u.unit(image, digest), []string{location, strconv.FormatInt(offset, 10)})
+}
+
+// recording records the results on stats before reporting them
+func recording(stats *registry.RunStats, report func(Result)) func(Result) {
+	return func(result Result) {
+		switch result.Action {
+		case ActionCopied:
+			stats.Succeeded()
+		case ActionUpToDate:
+			stats.Skipped()
+		case ActionFailed:
+			stats.Failed()
+		}
+		report(result)
+	}
+}
+
+func syncTag(src registry.Registry, dst registry.Registry, source Source, image string, tag string, dryRun bool) Result {
+	result := Result{Source: src.Repository, Image: image, Tag: tag, Target: source.Prefix + image}
+
+	digest, err := src.ImageDigest(image, tag)
+	if err != nil {
+		result.Action, result.Err = ActionFailed, err
+		return result
+	}
+	result.Digest = digest
+
+	existing, err := dst.ImageDigest(result.Target, tag)
+	if err != nil && !registry.IsNotFound(err) {
+		result.Action, result.Err = ActionFailed, err
+		return result
+	}
+	if existing == digest {
+		result.Action = ActionUpToDate
+		return result
+	}
+
+	result.Action = ActionCopied
+	if !dryRun {
+		if _, err := registry.CopyImage(src, image, tag, dst, result.Target, tag, !source.SkipReferrers); err != nil {
+			result.Action, result.Err = ActionFailed, err
+		}
+	}
+	return result
+}
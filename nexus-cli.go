@@ -1,25 +1,56 @@
 package main
 
 import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/blang/semver"
+	"github.com/eugenmayer/nexus-cli/bench"
+	"github.com/eugenmayer/nexus-cli/checkpoint"
+	"github.com/eugenmayer/nexus-cli/cleanup"
+	"github.com/eugenmayer/nexus-cli/daemon"
+	"github.com/eugenmayer/nexus-cli/events"
+	"github.com/eugenmayer/nexus-cli/index"
+	"github.com/eugenmayer/nexus-cli/k8s"
+	"github.com/eugenmayer/nexus-cli/lock"
+	"github.com/eugenmayer/nexus-cli/mirror"
+	"github.com/eugenmayer/nexus-cli/output"
+	"github.com/eugenmayer/nexus-cli/plan"
+	"github.com/eugenmayer/nexus-cli/policy"
+	"github.com/eugenmayer/nexus-cli/quota"
 	"github.com/eugenmayer/nexus-cli/registry"
+	"github.com/eugenmayer/nexus-cli/report"
+	"github.com/eugenmayer/nexus-cli/retry"
+	"github.com/eugenmayer/nexus-cli/sbom"
+	"github.com/eugenmayer/nexus-cli/scan"
+	"github.com/eugenmayer/nexus-cli/server"
+	"github.com/eugenmayer/nexus-cli/signing"
+	"github.com/eugenmayer/nexus-cli/tracing"
 	"github.com/eugenmayer/nexus-cli/utils"
+	"github.com/eugenmayer/nexus-cli/webhook"
 	"github.com/urfave/cli"
 	"golang.org/x/crypto/ssh/terminal"
-	"html/template"
+	"io"
+	"io/ioutil"
 	"log"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
-)
-
-const (
-	CredentialTemplate = `# Nexus Credentials
-nexus_host = "{{ .Host }}"
-nexus_username = "{{ .Username }}"
-nexus_password = "{{ .Password }}"
-nexus_repository = "{{ .Repository }}"`
+	"time"
 )
 
 func main() {
@@ -33,14 +64,152 @@ func main() {
 			Email: "-",
 		},
 	}
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "profile",
+			Usage:  "Profile to use instead of the active one",
+			EnvVar: "NEXUS_CLI_PROFILE",
+		},
+		cli.BoolFlag{
+			Name:  "no-color",
+			Usage: "Do not color the output, also disabled by setting NO_COLOR or when not writing to a terminal",
+		},
+		cli.StringFlag{
+			Name:   "cache-dir",
+			Usage:  "Keep catalogs, tag lists and manifests in this directory and only fetch them again when they changed",
+			EnvVar: "NEXUS_CLI_CACHE_DIR",
+		},
+		cli.StringFlag{
+			Name:   "blob-cache-dir",
+			Usage:  "Keep the blobs read (layers copied, scanned or extracted) in this directory by digest, so they are downloaded once",
+			EnvVar: "NEXUS_CLI_BLOB_CACHE_DIR",
+		},
+		cli.StringFlag{
+			Name:   "blob-cache-size",
+			Value:  "10GiB",
+			Usage:  "Remove the least recently used blobs once the blob cache holds more, 0 for no limit",
+			EnvVar: "NEXUS_CLI_BLOB_CACHE_SIZE",
+		},
+		cli.StringFlag{
+			Name:   "http-protocol",
+			Usage:  "Speak HTTP/2 with servers offering it (auto) or always HTTP/1.1 (http1), overriding http_protocol of the profile",
+			EnvVar: "NEXUS_CLI_HTTP_PROTOCOL",
+		},
+		cli.BoolFlag{
+			Name:  "verbose",
+			Usage: "Log every request to stderr with its status, the protocol negotiated and the time it took",
+		},
+		cli.StringFlag{
+			Name:   "ignore-file",
+			Usage:  "Rules file of the images and tags to leave out of listings, reports, cleanups and mirroring and to never delete, " + registry.IgnoreFile + " of the working directory by default",
+			EnvVar: "NEXUS_CLI_IGNORE_FILE",
+		},
+		cli.BoolFlag{
+			Name:  "no-ignore",
+			Usage: "Do not apply the rules of --ignore-file or " + registry.IgnoreFile,
+		},
+		cli.StringFlag{
+			Name:   "progress-json",
+			Usage:  "Write progress events as JSON lines to this file or named pipe, - for stdout (the usual output goes to stderr then)",
+			EnvVar: "NEXUS_CLI_PROGRESS_JSON",
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		output.Configure(c.GlobalBool("no-color"))
+		registry.ConfigureCache(c.GlobalString("cache-dir"))
+		maxSize, err := utils.ParseBytes(c.GlobalString("blob-cache-size"))
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Invalid --blob-cache-size: %s", err), 1)
+		}
+		registry.ConfigureBlobCache(c.GlobalString("blob-cache-dir"), maxSize)
+		registry.ConfigureProtocol(c.GlobalString("http-protocol"))
+		registry.ConfigureVerbose(c.GlobalBool("verbose"))
+		if err := tracing.Configure(c.App.Version); err != nil {
+			return cli.NewExitError(fmt.Sprintf("Configuring tracing: %s", err), 1)
+		}
+		if err := loadIgnoreRules(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if target := c.GlobalString("progress-json"); target == "-" {
+			events.Configure(os.Stdout)
+			// stdout belongs to the events now
+			os.Stdout = os.Stderr
+			c.App.Writer = os.Stderr
+		} else if target != "" {
+			// opening a named pipe waits for its reader
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("Opening --progress-json: %s", err), 1)
+			}
+			events.Configure(f)
+		}
+		return nil
+	}
 	app.Commands = []cli.Command{
 		{
 			Name:  "configure",
 			Usage: "Configure Nexus Credentials",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "profile, p",
+					Usage: "Store the credentials as a named profile instead of the default one",
+				},
+				cli.StringFlag{
+					Name:  "password-command",
+					Usage: "Command printing the password, e.g. 'pass show nexus/ci', run whenever it is needed instead of storing the password. Replaces the vault_path of the profile",
+				},
+				cli.StringFlag{
+					Name:  "nuget-api-key",
+					Usage: "NuGet API key of the user, used to push and delete NuGet packages. Kept from the existing profile if not given",
+				},
+				cli.StringFlag{
+					Name:  "environment",
+					Usage: "Name of the environment shown when confirming deletions, e.g. PROD. Defaults to the profile name, kept from the existing profile if not given",
+				},
+				cli.IntFlag{
+					Name:  "confirm-threshold",
+					Usage: "Deleting more tags than this at once asks for the repository name, kept from the existing profile if not given",
+				},
+			},
 			Action: func(c *cli.Context) error {
 				return setNexusCredentials(c)
 			},
 		},
+		{
+			Name:  "profile",
+			Usage: "Switch between configured registries",
+			Subcommands: []cli.Command{
+				{
+					Name:      "use",
+					Usage:     "Make a profile the active one",
+					ArgsUsage: "<profile>",
+					Action: func(c *cli.Context) error {
+						return useProfile(c)
+					},
+				},
+				{
+					Name:  "current",
+					Usage: "Show the active profile",
+					Action: func(c *cli.Context) error {
+						return showCurrentProfile(c)
+					},
+				},
+				{
+					Name:  "ls",
+					Usage: "List all profiles",
+					Action: func(c *cli.Context) error {
+						return listProfiles(c)
+					},
+				},
+			},
+		},
+		{
+			Name:  "version",
+			Usage: "Show the version of nexus-cli and of the Nexus server, with the features it supports",
+			Action: func(c *cli.Context) error {
+				return showVersion(c)
+			},
+		},
 		{
 			Name:  "image",
 			Usage: "Manage Docker Images",
@@ -48,14 +217,30 @@ func main() {
 				{
 					Name:  "ls",
 					Usage: "List all images in repository",
+					Flags: append(append([]cli.Flag{
+						cli.StringFlag{
+							Name:  "filter",
+							Usage: "Only list the images matching name=~<regular expression> or name=<glob>, e.g. name=~^team-x/. Matches are printed as the catalog is listed",
+						},
+						namespaceFlag,
+						cli.BoolFlag{
+							Name:  "tree",
+							Usage: "Show the images as a tree of their namespaces, with the number of images and tags and the size of each",
+						},
+						cli.IntFlag{
+							Name:  "depth",
+							Usage: "Only show this many levels of the --tree, the levels below count for the last one shown. 0 shows all",
+						},
+						concurrencyFlag,
+					}, offlineFlags...), append(repositoryFlags, outputFlags...)...),
 					Action: func(c *cli.Context) error {
-						return listImages(c)
+						return sinkOutput(c, listImages)
 					},
 				},
 				{
 					Name:  "tags",
 					Usage: "Display all image tags",
-					Flags: []cli.Flag{
+					Flags: append([]cli.Flag{
 						cli.StringFlag{
 							Name:  "name, n",
 							Usage: "List tags by image name",
@@ -64,14 +249,29 @@ func main() {
 							Name:  "sort, s",
 							Usage: "Default is semver (not other implemented yet), sort tags by semantic version, assuming all tags are semver except latest.",
 						},
-					},
+					}, append(offlineFlags, outputFlags...)...),
 					Action: func(c *cli.Context) error {
-						return listTagsByImage(c)
+						return sinkOutput(c, listTagsByImage)
 					},
 				},
 				{
 					Name:  "info",
 					Usage: "Show image details",
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name: "tag, t",
+						},
+					}, append(append(imageGroupFlags, offlineFlags...), summaryFlag)...),
+					Action: func(c *cli.Context) error {
+						return showImageInfo(c)
+					},
+				},
+				{
+					Name:  "digest",
+					Usage: "Print the manifest digest of a tag, to pin it in deployments",
 					Flags: []cli.Flag{
 						cli.StringFlag{
 							Name: "name, n",
@@ -79,15 +279,93 @@ func main() {
 						cli.StringFlag{
 							Name: "tag, t",
 						},
+						cli.StringFlag{
+							Name:  "format",
+							Value: "plain",
+							Usage: "plain prints the digests, reference image references with the digest (<nexus host>/<name>@sha256:...), json all of it",
+						},
+						cli.BoolFlag{
+							Name:  "config",
+							Usage: "Also print the digest of the image config, for indexes the one of each platform",
+						},
+						cli.BoolFlag{
+							Name:  "platforms",
+							Usage: "Also print the digests of the platform manifests of an index",
+						},
+						cli.StringFlag{
+							Name:  "docker-reference",
+							Usage: "Image reference to print the digests with, defaults to <nexus host>/<name>",
+						},
 					},
 					Action: func(c *cli.Context) error {
-						return showImageInfo(c)
+						return showImageDigest(c)
+					},
+				},
+				{
+					Name:  "annotate",
+					Usage: "Add annotations to the manifest of an image tag and push it under the same tag",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name: "tag, t",
+						},
+						cli.StringSliceFlag{
+							Name:  "annotation, a",
+							Usage: "Annotation as key=value, can be given several times",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return annotateImage(c)
+					},
+				},
+				{
+					Name:  "manifest-put",
+					Usage: "Push a manifest or index verbatim, e.g. after editing it by hand. The blobs and manifests it references have to exist",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name:  "tag, t",
+							Usage: "Tag (or digest) to push the manifest as",
+						},
+						cli.StringFlag{
+							Name:  "file, f",
+							Usage: "The manifest, - for stdin",
+						},
+						cli.StringFlag{
+							Name:  "media-type",
+							Usage: "Media type of the manifest, defaults to its mediaType field",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return putManifest(c)
+					},
+				},
+				{
+					Name:  "fsck",
+					Usage: "Check that the manifests and blobs the tags reference are there, exits with 1 if any is missing or has the wrong size",
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name:  "name, n",
+							Usage: "Only check this image, all images are checked by default",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print the damages as JSON",
+						},
+						concurrencyFlag,
+					}, outputFlags...),
+					Action: func(c *cli.Context) error {
+						return sinkOutput(c, fsckImages)
 					},
 				},
 				{
 					Name:  "delete",
 					Usage: "Delete an image",
-					Flags: []cli.Flag{
+					Flags: append([]cli.Flag{
 						cli.StringFlag{
 							Name: "name, n",
 						},
@@ -95,6 +373,10 @@ func main() {
 							Name: "tag, t",
 							Usage: "Give one or more comma-separated tags to delete",
 						},
+						cli.StringFlag{
+							Name:  "digest",
+							Usage: "Delete the manifest with this digest instead of tags, e.g. an untagged one fsck reported. Tags pointing to it are only deleted along with --force",
+						},
 						cli.StringFlag{
 							Name: "keep, k",
 						},
@@ -105,258 +387,7235 @@ func main() {
 						cli.BoolFlag{
 							Name: "dry-run, d",
 						},
-					},
+						cli.BoolFlag{
+							Name:  "with-referrers, r",
+							Usage: "Also delete signatures, attestations and SBOMs attached to the deleted tags",
+						},
+						cli.BoolFlag{
+							Name:  "estimate, e",
+							Usage: "Before deleting, estimate the space reclaimed after the next blobstore compaction, accounting for layers shared with remaining images. Combine with --dry-run to only estimate",
+						},
+						cli.BoolFlag{
+							Name:  "fail-fast",
+							Usage: "Stop at the first tag which cannot be deleted instead of reporting all failures at the end",
+						},
+						quarantineFlag,
+					}, append(append(append(append(sharedDigestFlags, confirmFlags...), failureFlags...), manifestsFlags...), summaryFlag)...),
 					Action: func(c *cli.Context) error {
 						return deleteImage(c)
 					},
 				},
-			},
-		},
-	}
-	app.CommandNotFound = func(c *cli.Context, command string) {
-		_, err := fmt.Fprintf(c.App.Writer, "Wrong command %q !", command)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-	err := app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func setNexusCredentials(_ *cli.Context) error {
-	var hostname, repository, username, password string
-	fmt.Print("Enter Nexus Host: ")
-
-	if _, err := fmt.Scan(&hostname); err != nil {
-		return err
-	}
-	fmt.Print("Enter Nexus Repository Name: ")
-	if _, err := fmt.Scan(&repository); err != nil {
-		return err
-	}
-	fmt.Print("Enter Nexus Username: ")
-	if _, err := fmt.Scan(&username); err != nil {
-		return err
-	}
-	fmt.Print("Enter Nexus Password: ")
-	bytePw, err := terminal.ReadPassword(int(syscall.Stdin))
-	if err != nil {
-		return err
-	}
-
-	password = string(bytePw)
-	// The password will be read by a toml parser (registry.go)
-	// This parser only allows certain escape character sequences and will therefore
-	// throw exceptions when your pw contains backslahes in certain cases.
-	// Hence we escape all backslash chars again here.
-	password = strings.Replace(password, "\\", "\\\\", -1)
-
-	// we need to remove trailing slashes
-	hostname = strings.TrimRight(hostname, "/")
-	fmt.Printf("Removed potential trailing slash on Nexus Host URL, now: %s\n", hostname)
-
-	data := struct {
-		Host       string
-		Username   string
-		Password   string
-		Repository string
-	}{
-		hostname,
-		username,
-		password,
-		repository,
-	}
-
-	tmpl, err := template.New(".credentials").Parse(CredentialTemplate)
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-
-	configurationPath := utils.ExpandTildeInPath("~/.nexus-cli")
-	f, err := os.Create(configurationPath)
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-
-	err = tmpl.Execute(f, data)
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-
-	fmt.Printf("Configuration saved to succesfully to: %s\n", configurationPath)
-	return nil
-}
-
-func listImages(_ *cli.Context) error {
-	r, err := registry.NewRegistry()
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-	images, err := r.ListImages()
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-	for _, image := range images {
-		fmt.Println(image)
-	}
-	fmt.Printf("Total images: %d\n", len(images))
-	return nil
-}
-
-func listTagsByImage(c *cli.Context) error {
-	var imgName = c.String("name")
-	var sort = c.String("sort")
-	if sort != "semver" {
-		sort = "default"
-	}
-
-	r, err := registry.NewRegistry()
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-	if imgName == "" {
-		if err = cli.ShowSubcommandHelp(c); err != nil {
-			return cli.NewExitError(err.Error(), 1)
-		}
-	}
-	tags, err := r.ListTagsByImage(imgName)
-
-	compareStringNumber := getSortComparisonStrategy(sort)
-	utils.Compare(compareStringNumber).Sort(tags)
-
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-	for _, tag := range tags {
-		fmt.Println(tag)
-	}
-	fmt.Printf("There are %d images for %s\n", len(tags), imgName)
-	return nil
-}
-
-func showImageInfo(c *cli.Context) error {
-	var imgName = c.String("name")
-	var tag = c.String("tag")
-	r, err := registry.NewRegistry()
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-	if imgName == "" || tag == "" {
-		err = cli.ShowSubcommandHelp(c)
-		if err != nil {
-			return cli.NewExitError(err.Error(), 1)
-		}
-	}
-	manifest, err := r.ImageManifest(imgName, tag)
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-	fmt.Printf("Image: %s:%s\n", imgName, tag)
-	fmt.Printf("Size: %d\n", manifest.Config.Size)
-	fmt.Println("Layers:")
-	for _, layer := range manifest.Layers {
-		fmt.Printf("\t%s\t%d\n", layer.Digest, layer.Size)
-	}
-	return nil
-}
-
-func deleteImage(c *cli.Context) error {
-	var imgName = c.String("name")
-	var tag = c.String("tag")
-	var keep = c.Int("keep")
-	var dryRun = c.Bool("dry-run")
-	var sort = c.String("sort")
-	if sort != "semver" {
-		sort = "default"
-	}
-
-	if imgName == "" {
-		if _,err := fmt.Fprintf(c.App.Writer, "You should specify the image name\n"); err != nil {
-			return cli.NewExitError(err.Error(), 1)
-		}
-
-		if err := cli.ShowSubcommandHelp(c); err != nil {
-			return cli.NewExitError(err.Error(), 1)
+				{
+					Name:  "lock",
+					Usage: "Protect tags against deletion by every command of nexus-cli",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name:  "tag, t",
+							Usage: "Give one or more comma-separated tags to lock",
+						},
+						cli.StringFlag{
+							Name:  "reason",
+							Usage: "Why the tags are locked, shown when deleting them is refused",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return lockImage(c, true)
+					},
+				},
+				{
+					Name:  "unlock",
+					Usage: "Allow deleting locked tags again",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name:  "tag, t",
+							Usage: "Give one or more comma-separated tags to unlock",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return lockImage(c, false)
+					},
+				},
+				{
+					Name:  "locks",
+					Usage: "List the locked tags",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "name, n",
+							Usage: "Only list the locked tags of this image",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return listLocks(c)
+					},
+				},
+				{
+					Name:  "watch",
+					Usage: "Poll tags and digests and print added, removed and re-pushed tags",
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "name, n",
+							Usage: "Image to watch, can be given several times. Defaults to all images",
+						},
+						cli.DurationFlag{
+							Name:  "interval, i",
+							Value: 30 * time.Second,
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print one JSON object per change",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return watchImages(c)
+					},
+				},
+				{
+					Name:  "referrers",
+					Usage: "List artifacts (signatures, attestations, SBOMs) attached to an image",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name: "tag, t",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return listReferrers(c)
+					},
+				},
+				{
+					Name:      "provenance",
+					Usage:     "Show how and from what an image was built: created time, OCI labels, VCS revision, base layers and attestations",
+					ArgsUsage: "<image>:<tag>",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "platform",
+							Usage: "Platform to describe of multi-arch images, e.g. linux/arm64. Defaults to linux/amd64",
+						},
+						cli.StringFlag{
+							Name:  "base",
+							Usage: "Base image <image>:<tag> of the repository, the leading layers the image shares with it are marked",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print the provenance as JSON",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return showProvenance(c)
+					},
+				},
+				{
+					Name:  "sign",
+					Usage: "Sign an image tag with a cosign key and push the signature to the repository",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name: "tag, t",
+						},
+						cli.StringFlag{
+							Name:  "key, k",
+							Usage: "Path to the private key, a cosign key or an unencrypted ECDSA PEM key. The password is read from COSIGN_PASSWORD or prompted",
+						},
+						cli.StringFlag{
+							Name:  "docker-reference",
+							Usage: "Image reference verifiers pull the image with, defaults to <nexus host>/<name>",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return signImage(c)
+					},
+				},
+				{
+					Name:  "copy",
+					Usage: "Copy an image tag to another repository, image name or tag",
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name: "tag, t",
+						},
+						cli.StringFlag{
+							Name:  "to-repository",
+							Usage: "Target repository, defaults to the configured one",
+						},
+						cli.StringFlag{
+							Name:  "to-name",
+							Usage: "Target image name, defaults to the source name",
+						},
+						cli.StringFlag{
+							Name:  "to-tag",
+							Usage: "Target tag, defaults to the source tag",
+						},
+						cli.BoolFlag{
+							Name:  "skip-referrers",
+							Usage: "Do not copy signatures, attestations and SBOMs attached to the image",
+						},
+						cli.BoolFlag{
+							Name:  "deny-overwrite",
+							Usage: "Refuse to replace an existing tag pointing to another digest",
+						},
+					}, append(imageGroupFlags, summaryFlag)...),
+					Action: func(c *cli.Context) error {
+						return copyImage(c)
+					},
+				},
+				{
+					Name:  "retag-bulk",
+					Usage: "Copy every tag matching a regular expression to the tag a template makes of it, e.g. release candidates to their release. Only a dry run without --apply",
+					Flags: append(append([]cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name:  "match",
+							Usage: "Regular expression the whole tag has to match, e.g. '(.+)-rc(\\d+)'",
+						},
+						cli.StringFlag{
+							Name:  "to",
+							Usage: "Template of the new tag, $1 or ${name} stand for the groups of the match, e.g. '$1'",
+						},
+						cli.BoolFlag{
+							Name:  "apply",
+							Usage: "Copy the tags, without only those which would be copied are listed",
+						},
+						cli.StringFlag{
+							Name:  "to-repository",
+							Usage: "Target repository, defaults to the configured one",
+						},
+						cli.BoolFlag{
+							Name:  "skip-referrers",
+							Usage: "Do not copy signatures, attestations and SBOMs attached to the images",
+						},
+						cli.BoolFlag{
+							Name:  "deny-overwrite",
+							Usage: "Refuse to move existing tags to another digest",
+						},
+					}, imageGroupFlags...), summaryFlag),
+					Action: func(c *cli.Context) error {
+						return retagBulk(c)
+					},
+				},
+				{
+					Name:      "assert-immutable",
+					Usage:     "Record the digests of tags and fail if a later run finds one changed",
+					ArgsUsage: "<image>:<tag> [<image>:<tag> ...]",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "record",
+							Value: "digests.json",
+							Usage: "File holding the recorded digests, tags not in it yet are added",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return assertImmutable(c)
+					},
+				},
+				{
+					Name:      "pull",
+					Usage:     "Load an image into the local Docker daemon or containerd, using the credentials of nexus-cli",
+					ArgsUsage: "[<image>:<tag>]",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name: "tag, t",
+						},
+						cli.StringFlag{
+							Name:  "format",
+							Value: "daemon",
+							Usage: "daemon loads the image into Docker or containerd, oci-layout writes it to an OCI image layout directory",
+						},
+						cli.StringFlag{
+							Name:  "output, o",
+							Usage: "Directory of the OCI image layout, created if needed. Existing layouts are added to",
+						},
+						cli.StringFlag{
+							Name:  "to",
+							Value: "docker",
+							Usage: "Where to load the image, docker or containerd",
+						},
+						cli.StringFlag{
+							Name:  "as",
+							Usage: "Name to load the image as, defaults to <image>:<tag>",
+						},
+						cli.StringFlag{
+							Name:  "platform",
+							Usage: "Platform to pick from multi-arch images, e.g. linux/arm64. Defaults to linux/amd64",
+						},
+						cli.StringFlag{
+							Name:   "docker-host",
+							Usage:  "Docker daemon to load into",
+							Value:  daemon.DefaultDockerHost,
+							EnvVar: "DOCKER_HOST",
+						},
+						cli.StringFlag{
+							Name:  "namespace",
+							Value: "default",
+							Usage: "containerd namespace to load into, k8s.io for images used by Kubernetes",
+						},
+						cli.StringFlag{
+							Name:  "containerd-address",
+							Usage: "containerd socket, defaults to the one of ctr",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return pullImage(c)
+					},
+				},
+				{
+					Name:      "share",
+					Usage:     "Share an image with someone without credentials: a script downloading it from presigned URLs, or a file to load",
+					ArgsUsage: "[<image>:<tag>]",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name: "tag, t",
+						},
+						cli.DurationFlag{
+							Name:  "ttl",
+							Value: 24 * time.Hour,
+							Usage: "How long the share has to work, a bundle is written if the presigned URLs of the blob store expire sooner",
+						},
+						cli.StringFlag{
+							Name:  "output, o",
+							Usage: "File to write the download script to, defaults to stdout",
+						},
+						cli.StringFlag{
+							Name:  "bundle",
+							Usage: "File to write the image to if it can't be shared by URLs, as docker save does. Defaults to <image>-<tag>.tar",
+						},
+						cli.BoolFlag{
+							Name:  "bundle-only",
+							Usage: "Write the bundle without trying presigned URLs",
+						},
+						cli.StringFlag{
+							Name:  "as",
+							Usage: "Name the image is loaded as, defaults to <image>:<tag>",
+						},
+						cli.StringFlag{
+							Name:  "platform",
+							Usage: "Platform to pick from multi-arch images, e.g. linux/arm64. Defaults to linux/amd64",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return shareImage(c)
+					},
+				},
+				{
+					Name:      "scan",
+					Usage:     "Scan an image for vulnerabilities with Trivy and print how many of each severity it has",
+					ArgsUsage: "[<image>:<tag>]",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name: "tag, t",
+						},
+						cli.StringFlag{
+							Name:  "exit-on",
+							Usage: "Exit with 1 if there are vulnerabilities of this severity or a higher one, e.g. CRITICAL or HIGH",
+						},
+						cli.StringFlag{
+							Name:  "platform",
+							Usage: "Platform to scan of multi-arch images, e.g. linux/arm64",
+						},
+						cli.StringFlag{
+							Name:   "trivy",
+							Value:  "trivy",
+							Usage:  "The trivy binary to run",
+							EnvVar: "NEXUS_CLI_TRIVY",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print the report of trivy instead of the summary",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return scanImage(c)
+					},
+				},
+				{
+					Name:      "evaluate",
+					Usage:     "Evaluate the SBOM attached to an image against the policies of a Sonatype IQ server, exits with 1 if a policy fails",
+					ArgsUsage: "[<image>:<tag>]",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name: "tag, t",
+						},
+						cli.StringFlag{
+							Name:  "iq-app",
+							Usage: "Public id of the IQ application to evaluate for",
+						},
+						cli.StringFlag{
+							Name:  "stage",
+							Value: "build",
+							Usage: "Stage to evaluate at, e.g. build, stage-release or release",
+						},
+						cli.StringFlag{
+							Name:   "iq-url",
+							Usage:  "The IQ server, e.g. https://iq.example.com",
+							EnvVar: "NEXUS_IQ_URL",
+						},
+						cli.StringFlag{
+							Name:   "iq-username",
+							Usage:  "IQ user, defaults to the one of the profile",
+							EnvVar: "NEXUS_IQ_USERNAME",
+						},
+						cli.StringFlag{
+							Name:   "iq-password",
+							Usage:  "Password of the IQ user, defaults to the one of the profile",
+							EnvVar: "NEXUS_IQ_PASSWORD",
+						},
+						cli.DurationFlag{
+							Name:  "timeout",
+							Value: 5 * time.Minute,
+							Usage: "How long to wait for the evaluation",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return evaluateImage(c)
+					},
+				},
+				{
+					Name:      "fs-diff",
+					Usage:     "List the files added, removed or changed between two images",
+					ArgsUsage: "<image>:<tag> <image>:<tag>",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "platform",
+							Usage: "Platform to compare of multi-arch images, e.g. linux/arm64. Defaults to linux/amd64",
+						},
+						cli.BoolFlag{
+							Name:  "ignore-mtime",
+							Usage: "Do not report files whose modification time is all that changed",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print the changes as JSON",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return diffImageFiles(c)
+					},
+				},
+				{
+					Name:      "files",
+					Usage:     "List the files of an image with their modes and sizes, straight from the registry",
+					ArgsUsage: "[<image>:<tag>]",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name: "tag, t",
+						},
+						cli.StringFlag{
+							Name:  "glob, g",
+							Usage: "Only list the paths matching this pattern, ** matches across directories, e.g. etc/**",
+						},
+						cli.IntFlag{
+							Name:  "layer",
+							Value: -1,
+							Usage: "List the entries of this layer as they are, whiteouts included, counting from 0 for the base layer. By default the files of the final filesystem are listed",
+						},
+						cli.StringFlag{
+							Name:  "platform",
+							Usage: "Platform to list of multi-arch images, e.g. linux/arm64. Defaults to linux/amd64",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return listImageFiles(c)
+					},
+				},
+				{
+					Name:      "cat",
+					Usage:     "Print a file of an image, as the final filesystem has it",
+					ArgsUsage: "<image>:<tag> <path>",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "platform",
+							Usage: "Platform to read of multi-arch images, e.g. linux/arm64. Defaults to linux/amd64",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return catImageFile(c)
+					},
+				},
+				{
+					Name:      "extract",
+					Usage:     "Write a file or directory of an image to a local directory, as the final filesystem has it",
+					ArgsUsage: "<image>:<tag> <path>",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "output, o",
+							Usage: "Directory to write to, it gets the content of the directory extracted, or the file",
+						},
+						cli.StringFlag{
+							Name:  "platform",
+							Usage: "Platform to read of multi-arch images, e.g. linux/arm64. Defaults to linux/amd64",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return extractImageFiles(c)
+					},
+				},
+				{
+					Name:  "push",
+					Usage: "Push an image from an OCI image layout directory",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name: "name, n",
+						},
+						cli.StringFlag{
+							Name:  "tag, t",
+							Usage: "Tag to push as, defaults to the ref name in the layout",
+						},
+						cli.StringFlag{
+							Name:  "from-oci-layout",
+							Usage: "OCI image layout directory, as written by oras, skopeo or buildkit",
+						},
+						cli.StringFlag{
+							Name:  "ref",
+							Usage: "Ref name of the manifest to push if the layout holds several",
+						},
+						cli.BoolFlag{
+							Name:  "deny-overwrite",
+							Usage: "Refuse to replace an existing tag pointing to another digest",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return pushImage(c)
+					},
+				},
+			},
+		},
+		{
+			Name:  "repo",
+			Usage: "Work with whole repositories",
+			Subcommands: []cli.Command{
+				{
+					Name:      "diff",
+					Usage:     "List images and tags present in only one of two repositories, and tags whose digests differ",
+					ArgsUsage: "<repository> <other repository>",
+					Flags: append([]cli.Flag{
+						concurrencyFlag,
+						cli.StringFlag{
+							Name:  "other-profile",
+							Usage: "Profile of the other repository, e.g. another Nexus, defaults to the one in use",
+						},
+						cli.StringSliceFlag{
+							Name:  "name, n",
+							Usage: "Only compare this image, can be given several times. Defaults to all images of both repositories",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print the differences as JSON",
+						},
+					}, outputFlags...),
+					Action: func(c *cli.Context) error {
+						return sinkOutput(c, diffRepositories)
+					},
+				},
+				{
+					Name:  "snapshot",
+					Usage: "Save every image, tag and digest of the repository to a JSON file",
+					Flags: append([]cli.Flag{
+						concurrencyFlag,
+						cli.StringFlag{
+							Name:  "output, o",
+							Usage: "File to write the snapshot to",
+						},
+					}, reportFlags...),
+					Action: func(c *cli.Context) error {
+						return snapshotRepository(c)
+					},
+				},
+				{
+					Name:      "verify",
+					Usage:     "Check the repository against a snapshot and report drift, exits with 1 if there is any",
+					ArgsUsage: "<snapshot file>",
+					Flags: []cli.Flag{
+						concurrencyFlag,
+						cli.StringFlag{
+							Name:  "repository",
+							Usage: "Repository to verify, defaults to the configured one. Useful after migrating content to a new repository",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print the drift as JSON",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return verifySnapshot(c)
+					},
+				},
+				{
+					Name:  "quota",
+					Usage: "Attribute the storage of the repository to teams by image name prefix",
+					Subcommands: []cli.Command{
+						{
+							Name:  "report",
+							Usage: "Show the storage used by each team, and whether it is within its budget",
+							Flags: append([]cli.Flag{
+								cli.StringFlag{
+									Name:  "file, f",
+									Value: "quotas.yaml",
+									Usage: "YAML file mapping image name prefixes to teams and their budgets",
+								},
+								cli.BoolFlag{
+									Name:  "enforce",
+									Usage: "Exit with 1 if a team uses more than its budget, e.g. to block pushes in CI",
+								},
+								cli.BoolFlag{
+									Name:  "json",
+									Usage: "Print the report as JSON",
+								},
+								concurrencyFlag,
+							}, append(repositoryFlags, outputFlags...)...),
+							Action: func(c *cli.Context) error {
+								return sinkOutput(c, quotaReport)
+							},
+						},
+					},
+				},
+				{
+					Name:  "gc-plan",
+					Usage: "Write the tags a retention policy deletes to a plan file, to be reviewed and applied with gc-apply",
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name:  "policy, p",
+							Usage: "Path to the YAML policy file",
+						},
+						cli.StringSliceFlag{
+							Name:  "image, i",
+							Usage: "Only plan the deletions of this image, can be given several times. Defaults to all images",
+						},
+						cli.StringFlag{
+							Name:  "output, o",
+							Usage: "File to write the plan to",
+						},
+					}, imageGroupFlags...),
+					Action: func(c *cli.Context) error {
+						return planCleanup(c)
+					},
+				},
+				{
+					Name:      "gc-approve",
+					Usage:     "Sign a plan written by gc-plan after reviewing it, gc-apply then only applies it unchanged",
+					ArgsUsage: "<plan.json>",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "key",
+							Usage: "Private key of the approver (cosign key or PEM encoded ECDSA key)",
+						},
+						cli.StringFlag{
+							Name:  "output, o",
+							Usage: "File to write the signature to, defaults to the plan file with .sig appended",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return approvePlan(c)
+					},
+				},
+				{
+					Name:      "gc-apply",
+					Usage:     "Delete exactly the tags of a plan written by gc-plan, skipping those pushed again since",
+					ArgsUsage: "<plan.json>",
+					Flags: append([]cli.Flag{
+						cli.BoolFlag{
+							Name: "dry-run, d",
+						},
+						cli.StringSliceFlag{
+							Name:   "key",
+							Usage:  "Public key of an approver, can be given several times, in addition to the plan_keys of the profile. The plan is only applied if one of them signed it",
+							EnvVar: "NEXUS_CLI_PLAN_KEY",
+						},
+						cli.StringFlag{
+							Name:  "signature",
+							Usage: "Signature of the plan, defaults to the plan file with .sig appended",
+						},
+						cli.BoolFlag{
+							Name:  "insecure-unsigned",
+							Usage: "Apply the plan without checking that it was approved",
+						},
+						cli.BoolFlag{
+							Name:  "fail-fast",
+							Usage: "Stop at the first tag which cannot be deleted instead of reporting all failures at the end",
+						},
+						quarantineFlag,
+					}, append(append(append(append(sharedDigestFlags, confirmFlags...), failureFlags...), manifestsFlags...), summaryFlag)...),
+					Action: func(c *cli.Context) error {
+						return applyPlan(c)
+					},
+				},
+				{
+					Name:      "find-layer",
+					Usage:     "List every image:tag containing a layer, for example a vulnerable base layer",
+					ArgsUsage: "<sha256:digest>",
+					Flags: append([]cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "name, n",
+							Usage: "Only search this image, can be given several times. Defaults to all images",
+						},
+						cli.IntFlag{
+							Name:  "concurrency",
+							Value: 8,
+							Usage: "Number of manifests fetched in parallel",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print the matches as JSON",
+						},
+					}, append(offlineFlags, outputFlags...)...),
+					Action: func(c *cli.Context) error {
+						return sinkOutput(c, findLayer)
+					},
+				},
+				{
+					Name:  "index",
+					Usage: "Store the images and tags with their digests, sizes, labels, layers and build times in a local SQLite database, for --offline queries",
+					Flags: []cli.Flag{
+						indexFlag,
+						cli.StringSliceFlag{
+							Name:  "name, n",
+							Usage: "Only index this image, can be given several times. Defaults to all images",
+						},
+						concurrencyFlag,
+						resumeFlag,
+						checkpointFlag,
+					},
+					Action: func(c *cli.Context) error {
+						return indexRepository(c)
+					},
+				},
+				{
+					Name:  "stats",
+					Usage: "Show how the image count, tag count and stored bytes grew, from the snapshots every 'repo index' takes",
+					Flags: append([]cli.Flag{
+						indexFlag,
+						cli.StringFlag{
+							Name:  "since",
+							Value: "90d",
+							Usage: "How far back to look, e.g. 90d, 12w or 48h",
+						},
+						cli.BoolFlag{
+							Name:  "csv",
+							Usage: "Print the snapshots as CSV instead",
+						},
+					}, outputFlags...),
+					Action: func(c *cli.Context) error {
+						return sinkOutput(c, repositoryStats)
+					},
+				},
+				{
+					Name:  "changes",
+					Usage: "Compare the repository with a snapshot 'repo index' took: new and removed images, tags whose digest changed",
+					Flags: append([]cli.Flag{
+						indexFlag,
+						concurrencyFlag,
+						cli.StringFlag{
+							Name:  "since",
+							Value: "last",
+							Usage: "The snapshot to compare with: last, the last one taken that long ago (e.g. 7d, 48h) or on a date (2026-01-31)",
+						},
+						cli.BoolFlag{
+							Name:  "fail-on-deletions",
+							Usage: "Exit with 1 if tags were deleted which --expected-deletions does not allow",
+						},
+						cli.StringSliceFlag{
+							Name:  "expected-deletions",
+							Usage: "Pattern of the images and tags which may be deleted, in the syntax of .nexusignore, e.g. '**:pr-*'. Can be repeated",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print the changes as JSON",
+						},
+					}, outputFlags...),
+					Action: func(c *cli.Context) error {
+						return sinkOutput(c, repositoryChanges)
+					},
+				},
+				{
+					Name:  "base-images",
+					Usage: "Report which base image every image is built on, and which are on outdated bases",
+					Flags: append([]cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "base, b",
+							Usage: "Base image as <image>:<current tag>, can be given several times. All its other tags count as outdated versions. The tag defaults to latest",
+						},
+						cli.StringFlag{
+							Name:  "base-repository",
+							Usage: "Repository holding the base images, defaults to the configured one",
+						},
+						cli.StringFlag{
+							Name:  "upstream",
+							Usage: "Repository (e.g. a Docker Hub proxy) to resolve the current base tags from, flagging images on bases that moved on upstream as stale",
+						},
+						cli.StringFlag{
+							Name:  "upstream-profile",
+							Usage: "Profile of the upstream repository, defaults to the one in use",
+						},
+						cli.StringSliceFlag{
+							Name:  "name, n",
+							Usage: "Only report this image, can be given several times. Defaults to all images but the bases",
+						},
+						cli.StringFlag{
+							Name:  "platform",
+							Usage: "Platform compared for multi-arch images as os/arch[/variant], defaults to linux/amd64 or the first one",
+						},
+						cli.BoolFlag{
+							Name:  "outdated",
+							Usage: "Only list images on outdated or stale bases",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print the report as JSON",
+						},
+					}, outputFlags...),
+					Action: func(c *cli.Context) error {
+						return sinkOutput(c, baseImageReport)
+					},
+				},
+				{
+					Name:  "export-sboms",
+					Usage: "Upload the CycloneDX SBOMs attached to images to Dependency-Track, or write them to one consolidated file",
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "name, n",
+							Usage: "Only export this image, can be given several times. Defaults to all images",
+						},
+						cli.StringFlag{
+							Name:   "dependency-track",
+							EnvVar: "DTRACK_URL",
+							Usage:  "Url of the Dependency-Track server, for example https://dtrack.example.com",
+						},
+						cli.StringFlag{
+							Name:   "api-key",
+							EnvVar: "DTRACK_API_KEY",
+							Usage:  "Dependency-Track API key",
+						},
+						cli.StringFlag{
+							Name:  "project-prefix",
+							Usage: "Put in front of the image name to build the Dependency-Track project name",
+						},
+						cli.StringFlag{
+							Name:  "output, o",
+							Usage: "Write a consolidated CycloneDX file with one component per image",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return exportSBOMs(c)
+					},
+				},
+				{
+					Name:      "invalidate-cache",
+					Usage:     "Evict stale upstream content cached by a proxy repository",
+					ArgsUsage: "<repository>",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "path, p",
+							Usage: "Only evict the cached assets matching this pattern, ** matches across directories, e.g. org/acme/**/*.pom",
+						},
+						cli.BoolFlag{
+							Name:  "rebuild-index",
+							Usage: "Rebuild the search index of the repository afterwards, for formats keeping one (e.g. maven, npm)",
+						},
+						cli.BoolFlag{
+							Name:  "apply",
+							Usage: "Invalidate the cache, without only the cached assets which would be evicted are listed",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return invalidateCache(c)
+					},
+				},
+			},
+		},
+		{
+			Name:  "serve",
+			Usage: "Expose registry operations as a REST API",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "listen, l",
+					Value: ":8088",
+					Usage: "Address to listen on",
+				},
+				cli.StringFlag{
+					Name:  "policy, p",
+					Usage: "Policy file applied by POST /cleanup, cleanups are disabled without it",
+				},
+				cli.StringFlag{
+					Name:   "token",
+					EnvVar: "NEXUS_CLI_SERVE_TOKEN",
+					Usage:  "Require this bearer token on every request, POST /cleanup only deletes with one",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return serve(c)
+			},
+		},
+		{
+			Name:  "bench",
+			Usage: "Measure catalog and manifest latency and blob throughput of the registry",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "iterations, i",
+					Value: 20,
+				},
+				cli.StringFlag{
+					Name:  "name, n",
+					Usage: "Image to fetch manifests of, defaults to the first image of the catalog",
+				},
+				cli.StringFlag{
+					Name:  "tag, t",
+					Usage: "Tag to fetch the manifest of, defaults to the first tag of the image",
+				},
+				cli.StringFlag{
+					Name:  "scratch-repository",
+					Usage: "Repository to upload synthetic blobs to, the blob benchmark is skipped without it",
+				},
+				cli.Int64Flag{
+					Name:  "blob-size",
+					Value: 8 << 20,
+					Usage: "Size of the synthetic blobs in bytes",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return benchmark(c)
+			},
+		},
+		{
+			Name:  "cleanup",
+			Usage: "Apply a retention policy file to the images of the repository",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "policy, p",
+					Usage: "Path to the YAML policy file",
+				},
+				cli.StringSliceFlag{
+					Name:  "image, i",
+					Usage: "Only apply the policy to this image, can be given several times. Defaults to all images",
+				},
+				cli.BoolFlag{
+					Name: "dry-run, d",
+				},
+				cli.BoolFlag{
+					Name:  "fail-fast",
+					Usage: "Stop at the first tag which cannot be deleted instead of reporting all failures at the end",
+				},
+				cli.StringFlag{
+					Name:  "lock",
+					Value: "file",
+					Usage: "Keep concurrent cleanups apart with a local lock file (file), a lock in the repository for jobs on several hosts (repository) or not at all (none)",
+				},
+				cli.StringFlag{
+					Name:  "lock-file",
+					Usage: "Path of the lock file, defaults to one per host and repository in the temp directory",
+				},
+				cli.DurationFlag{
+					Name:  "lock-timeout",
+					Value: time.Hour,
+					Usage: "Take over locks not refreshed for this long, their holder is assumed to have crashed",
+				},
+				resumeFlag,
+				checkpointFlag,
+				quarantineFlag,
+				cli.StringFlag{
+					Name:  "state",
+					Usage: "Keep the tags the run selected and those locks protected in this file, and tell what changed since the previous run",
+				},
+			}, append(append(append(append(append(append(append(imageGroupFlags, sharedDigestFlags...), confirmFlags...), reportFlags...), repositoryFlags...), failureFlags...), manifestsFlags...), summaryFlag)...),
+			Action: func(c *cli.Context) error {
+				return runCleanup(c)
+			},
+		},
+		cleanupPolicyCommand(),
+		scriptCommand(),
+		{
+			Name:  "sync",
+			Usage: "Copy the images listed in a sync specification, skipping tags which are up to date",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "file, f",
+					Usage: "Path to the YAML sync specification",
+				},
+				cli.BoolFlag{
+					Name: "dry-run, d",
+				},
+				resumeFlag,
+				checkpointFlag,
+				summaryFlag,
+				cli.StringFlag{
+					Name:  "limit-rate",
+					Usage: "Copy blobs at most this fast per second, e.g. 20MiB",
+				},
+				cli.StringSliceFlag{
+					Name:  "window",
+					Usage: "Copy blobs only at these times, e.g. 'Mon-Fri 19:00-07:00' or 'Mon-Fri 07:00-19:00 5MiB' with a rate of its own. Can be repeated",
+				},
+				cli.StringFlag{
+					Name:  "outside-window",
+					Value: "wait",
+					Usage: "What to do outside of the windows: wait for the next one, or stop keeping the checkpoint to --resume later",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return syncImages(c)
+			},
+		},
+		{
+			Name:  "listen",
+			Usage: "Receive Nexus webhooks and react on pushed images",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "bind, b",
+					Value: ":8080",
+					Usage: "Address to listen on",
+				},
+				cli.StringFlag{
+					Name:   "secret",
+					EnvVar: "NEXUS_WEBHOOK_SECRET",
+					Usage:  "Secret key of the webhook capability, deliveries with an invalid signature are rejected",
+				},
+				cli.StringFlag{
+					Name:  "on-push",
+					Usage: "Policy file to apply to an image whenever a tag is pushed",
+				},
+				cli.StringFlag{
+					Name:  "mirror-to",
+					Usage: "Repository to copy pushed tags to",
+				},
+				cli.BoolFlag{
+					Name:  "mirror-deletes",
+					Usage: "Delete tags in the --mirror-to repository when they are deleted in the source repository",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run, d",
+					Usage: "Only log what the actions would do",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return listen(c)
+			},
+		},
+		{
+			Name:  "component",
+			Usage: "Work with components of hosted repositories of any format",
+			Subcommands: []cli.Command{
+				{
+					Name:      "upload",
+					Usage:     "Upload files as components, with the coordinates the format of the repository takes",
+					ArgsUsage: "<file>...",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "repository, r",
+							Usage: "The hosted repository, defaults to the configured one. Its format tells which attributes it takes",
+						},
+						uploadAttrFlag,
+					},
+					Action: func(c *cli.Context) error {
+						return uploadPackages(c, "")
+					},
+				},
+			},
+		},
+		packageCommand("apt", "Manage .deb packages in apt hosted repositories"),
+		packageCommand("yum", "Manage .rpm packages in yum hosted repositories", cli.StringFlag{
+			Name:  "directory",
+			Usage: "Directory of the repository to upload to, e.g. el8/x86_64",
+		}),
+		packageCommand("pypi", "Manage wheels and sdists of projects in pypi hosted repositories"),
+		nugetCommand(),
+		goCommand(),
+		blobCommand(),
+		cacheCommand(),
+		quarantineCommand(),
+		k8sCommand(),
+	}
+	app.CommandNotFound = func(c *cli.Context, command string) {
+		_, err := fmt.Fprintf(c.App.Writer, "Wrong command %q !", command)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	for i := range app.Commands {
+		if app.Commands[i].Name == "image" {
+			app.Commands[i].Subcommands = referenceCommands(app.Commands[i].Subcommands)
+		}
+	}
+	app.Commands = observeCommands(app.Commands, "")
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// observeCommands makes the commands emit the Started and Finished events of --progress-json and their tracing spans
+func observeCommands(commands []cli.Command, parent string) []cli.Command {
+	for i := range commands {
+		name := strings.TrimSpace(parent + " " + commands[i].Name)
+		commands[i].Subcommands = observeCommands(commands[i].Subcommands, name)
+		action, ok := commands[i].Action.(func(*cli.Context) error)
+		if !ok {
+			continue
+		}
+		commands[i].Action = func(c *cli.Context) error {
+			events.Start(name)
+			tracing.Start(name)
+			err := action(c)
+			exitCode := 0
+			if coder, ok := err.(cli.ExitCoder); ok {
+				exitCode = coder.ExitCode()
+			} else if err != nil {
+				exitCode = 1
+			}
+			registry.RunPostRunHooks(name, err, exitCode)
+			events.Finish(err, exitCode)
+			tracing.Finish(err, exitCode)
+			return err
+		}
+	}
+	return commands
+}
+
+// referenced is where the references given to an image command point to, loadRegistry applies it
+var referenced struct {
+	repository string
+	// args are the references among the arguments, for splitImageRef
+	args map[string]registry.Reference
+	// pins are the references with a tag and a digest, the tag has to point to the digest still
+	pins []registry.Reference
+}
+
+// referenceCommands lets the image commands take full references for --name and their <image>:<tag> arguments, e.g.
+// nexus.example.com/docker-hosted/team/app:1.2@sha256:..., like other container tools
+func referenceCommands(commands []cli.Command) []cli.Command {
+	for i := range commands {
+		commands[i].Subcommands = referenceCommands(commands[i].Subcommands)
+		action, ok := commands[i].Action.(func(*cli.Context) error)
+		if !ok {
+			continue
+		}
+		argsUsage := commands[i].ArgsUsage
+		commands[i].Action = func(c *cli.Context) error {
+			if err := resolveReferences(c, argsUsage); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			return action(c)
+		}
+	}
+	return commands
+}
+
+// resolveReferences splits the references given to a command. The host selects the profile on that server unless
+// --profile is given, then it has to be on it. Hosts no profile is on are taken as part of the image name, as they
+// were before references were understood. The repository replaces the one of the profile, the tag and the digest
+// pin the version
+func resolveReferences(c *cli.Context, argsUsage string) error {
+	var raws []string
+	if name := c.String("name"); name != "" {
+		raws = append(raws, name)
+	}
+	raws = append(raws, imageArgs(c.Args(), argsUsage)...)
+	if len(raws) == 0 {
+		return nil
+	}
+	config, err := registry.LoadConfig()
+	if err != nil {
+		return err
+	}
+	profile := c.GlobalString("profile")
+	if profile == "" {
+		profile = config.Current()
+	}
+	current, _ := config.Profile(profile)
+	var repository string
+	referenced.args = map[string]registry.Reference{}
+	for i, raw := range raws {
+		ref, err := registry.ParseReference(raw)
+		if err != nil {
+			return err
+		}
+		if ref.Host != "" && !registry.SameHost(current.Host, ref.Host) {
+			name, ok := config.ProfileForHost(ref.Host)
+			switch {
+			case !ok:
+				ref.Image = strings.TrimSuffix(strings.SplitN(raw, "@", 2)[0], ":"+ref.Tag)
+				ref.Host, ref.Repository = "", ""
+			case c.GlobalString("profile") != "" || profile != config.Current():
+				return errors.New(fmt.Sprintf("%s is on %s, not on the server of profile %s", raw, ref.Host, profile))
+			default:
+				profile = name
+				current, _ = config.Profile(profile)
+			}
+		}
+		if ref.Repository != "" {
+			if repository != "" && repository != ref.Repository {
+				return errors.New(fmt.Sprintf("The references are on the repositories %s and %s, give references on one repository", repository, ref.Repository))
+			}
+			if flag := strings.TrimSpace(c.String("repository")); flag != "" && flag != ref.Repository {
+				return errors.New(fmt.Sprintf("%s is on the repository %s, not on --repository %s", raw, ref.Repository, flag))
+			}
+			repository = ref.Repository
+		}
+		tag := ref.Tag
+		if i == 0 && raw == c.String("name") {
+			if err := c.Set("name", ref.Image); err != nil {
+				return err
+			}
+			if ref.Tag != "" {
+				if flag := c.String("tag"); flag != "" && flag != ref.Tag {
+					return errors.New(fmt.Sprintf("%s has the tag %s, not --tag %s", raw, ref.Tag, flag))
+				}
+				if err := c.Set("tag", ref.Tag); err != nil {
+					return errors.New(fmt.Sprintf("%s has a tag, the command works on all tags of an image", raw))
+				}
+			}
+			tag = c.String("tag")
+		} else {
+			referenced.args[raw] = ref
+		}
+		if ref.Digest != "" {
+			if tag == "" {
+				return errors.New(fmt.Sprintf("%s has no tag, the command works on tags. Give the tag the digest is pinned to with it", raw))
+			}
+			ref.Tag = tag
+			referenced.pins = append(referenced.pins, ref)
+		}
+	}
+	referenced.repository = repository
+	if profile != config.Current() && c.GlobalString("profile") == "" {
+		return c.GlobalSet("profile", profile)
+	}
+	return nil
+}
+
+// imageArgs returns the arguments which are <image>:<tag> according to the ArgsUsage of the command, the last one
+// is repeated
+func imageArgs(args []string, argsUsage string) []string {
+	fields := strings.Fields(strings.Replace(argsUsage, " ...", "", -1))
+	if len(fields) == 0 {
+		return nil
+	}
+	var images []string
+	for i, arg := range args {
+		field := fields[len(fields)-1]
+		if i < len(fields) {
+			field = fields[i]
+		}
+		if strings.Contains(field, "<image>:<tag>") {
+			images = append(images, arg)
+		}
+	}
+	return images
+}
+
+// applyReferences moves the registry to the repository the references of the command are on and checks that their
+// tags point to the digests they are pinned to
+func applyReferences(r *registry.Registry) error {
+	if referenced.repository != "" {
+		r.Repository = referenced.repository
+	}
+	for _, pin := range referenced.pins {
+		digest, err := r.ImageDigest(pin.Image, pin.Tag)
+		if err != nil {
+			return err
+		}
+		if digest != pin.Digest {
+			return errors.New(fmt.Sprintf("%s:%s points to %s, not to %s as referenced", pin.Image, pin.Tag, digest, pin.Digest))
+		}
+	}
+	return nil
+}
+
+func setNexusCredentials(c *cli.Context) error {
+	var profile = c.String("profile")
+	if profile == "" {
+		profile = registry.DefaultProfile
+	}
+
+	// keep the other profiles when adding one, and the settings configure does not ask for when changing one
+	var config registry.Config
+	if _, err := os.Stat(registry.ConfigurationPath()); err == nil {
+		if config, err = registry.ReadConfig(); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	existing, err := config.Profile(profile)
+	reconfigure := err == nil
+	// the password of a profile reading it from Vault stays there, unless a password command replaces it
+	fromVault := reconfigure && existing.VaultPath != "" && c.String("password-command") == ""
+
+	var hostname, repository, username, password string
+	fmt.Print("Enter Nexus Host: ")
+
+	if _, err := fmt.Scan(&hostname); err != nil {
+		return err
+	}
+	fmt.Print("Enter Nexus Repository Name: ")
+	if _, err := fmt.Scan(&repository); err != nil {
+		return err
+	}
+	fmt.Print("Enter Nexus Username: ")
+	if _, err := fmt.Scan(&username); err != nil {
+		return err
+	}
+	if fromVault {
+		fmt.Printf("The password is read from Vault (%s)\n", existing.VaultPath)
+	} else if c.String("password-command") == "" {
+		fmt.Print("Enter Nexus Password: ")
+		bytePw, err := terminal.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			return err
+		}
+		fmt.Println()
+		password = string(bytePw)
+	}
+
+	// we need to remove trailing slashes
+	hostname = strings.TrimRight(hostname, "/")
+	fmt.Printf("Removed potential trailing slash on Nexus Host URL, now: %s\n", hostname)
+
+	r := registry.Registry{Host: hostname, Username: username, Password: password, Repository: repository, PasswordCommand: c.String("password-command"),
+		NuGetAPIKey: c.String("nuget-api-key"), Environment: c.String("environment"), ConfirmThreshold: c.Int("confirm-threshold")}
+	if reconfigure {
+		if r.NuGetAPIKey == "" {
+			r.NuGetAPIKey = existing.NuGetAPIKey
+		}
+		if r.Environment == "" {
+			r.Environment = existing.Environment
+		}
+		if !c.IsSet("confirm-threshold") {
+			r.ConfirmThreshold = existing.ConfirmThreshold
+		}
+		r.HTTPProtocol = existing.HTTPProtocol
+		r.PreDeleteHook, r.PostDeleteHook, r.PostRunHook = existing.PreDeleteHook, existing.PostDeleteHook, existing.PostRunHook
+		r.PlanKeys = existing.PlanKeys
+		if fromVault {
+			r.VaultPath, r.VaultAddress, r.VaultAuth, r.VaultRoleID = existing.VaultPath, existing.VaultAddress, existing.VaultAuth, existing.VaultRoleID
+		}
+	}
+	config.SetProfile(profile, r)
+	if err := config.Save(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	fmt.Printf("Configuration of profile %s saved succesfully to: %s\n", profile, registry.ConfigurationPath())
+	return nil
+}
+
+func useProfile(c *cli.Context) error {
+	var profile = c.Args().First()
+	if profile == "" {
+		fmt.Fprintf(c.App.Writer, "You must provide the profile to use\n")
+		cli.ShowSubcommandHelp(c)
+		return cli.NewExitError("", 1)
+	}
+	config, err := registry.LoadConfig()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	r, err := config.Profile(profile)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	config.ActiveProfile = profile
+	if profile == registry.DefaultProfile {
+		config.ActiveProfile = ""
+	}
+	if err := config.Save(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("Switched to profile %s (%s, repository %s)\n", profile, r.Host, r.Repository)
+	return nil
+}
+
+func showCurrentProfile(c *cli.Context) error {
+	config, err := registry.LoadConfig()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	profile := c.GlobalString("profile")
+	if profile == "" {
+		profile = config.Current()
+	}
+	r, err := config.Profile(profile)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%s\t%s\t%s\n", profile, r.Host, r.Repository)
+	return nil
+}
+
+func listProfiles(_ *cli.Context) error {
+	config, err := registry.LoadConfig()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	t := output.NewTable("CURRENT", "NAME", "HOST", "REPOSITORY")
+	for _, name := range config.ProfileNames() {
+		r, _ := config.Profile(name)
+		if name == config.Current() {
+			t.Row(output.Green("*"), output.Green(name), r.Host, r.Repository)
+		} else {
+			t.Row("", name, r.Host, r.Repository)
+		}
+	}
+	return t.Render(os.Stdout)
+}
+
+func showVersion(c *cli.Context) error {
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("nexus-cli: %s\n", c.App.Version)
+	version, known, err := r.ServerVersion()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if !known {
+		fmt.Println("Nexus: unknown, the server does not send its version. All features are assumed to be available")
+		return nil
+	}
+	fmt.Printf("Nexus: %s %s\n", version, version.Edition)
+
+	t := output.NewTable("FEATURE", "REQUIRES", "SUPPORTED")
+	for _, feature := range registry.Features {
+		supported := output.Green("yes")
+		if !version.AtLeast(feature.Since) {
+			supported = output.Red("no")
+		}
+		t.Row(feature.Name, ">= "+feature.Since.String(), supported)
+	}
+	return t.Render(os.Stdout)
+}
+
+// loadRegistry loads the registry of the selected profile and tells on stderr which one it is, so nobody deletes
+// from the wrong registry by accident
+func loadRegistry(c *cli.Context) (registry.Registry, error) {
+	if !registry.ValidProtocol(c.GlobalString("http-protocol")) {
+		return registry.Registry{}, errors.New(fmt.Sprintf("Invalid --http-protocol %s, use %s or %s", c.GlobalString("http-protocol"), registry.ProtocolAuto, registry.ProtocolHTTP1))
+	}
+	r, profile, err := registry.NewRegistryFromProfile(c.GlobalString("profile"))
+	if err != nil {
+		return r, err
+	}
+	if err := applyReferences(&r); err != nil {
+		return r, err
+	}
+	registry.WithIgnoreRules(ignoreRules)(&r)
+	fmt.Fprintf(os.Stderr, "Using profile %s: %s, repository %s\n", profile, r.Host, r.Repository)
+	if c.GlobalBool("verbose") {
+		fmt.Fprintf(os.Stderr, "HTTP protocol %s\n", r.Protocol())
+	}
+	return r, nil
+}
+
+// repositoryFlags let commands work on other repositories than the configured one, several at once
+var repositoryFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "repository",
+		Usage: "Repositories to work on instead of the configured one, comma-separated. Several are worked on in parallel, each line of their output prefixed with [repository]",
+	},
+	cli.BoolFlag{
+		Name:  "all-docker-repos",
+		Usage: "Work on every docker repository of the server, as listed by the repositories API",
+	},
+	cli.IntFlag{
+		Name:  "parallel-repos",
+		Value: 4,
+		Usage: "Maximum number of repositories worked on in parallel",
+	},
+}
+
+// fanOutRepository names the repository a run started by fanOut works on
+const fanOutRepository = "NEXUS_CLI_FAN_OUT_REPOSITORY"
+
+// ignoreRules are the rules of --ignore-file, nil if there are none
+var ignoreRules *registry.IgnoreRules
+
+// loadIgnoreRules reads --ignore-file, or the rules file of the working directory if there is one
+func loadIgnoreRules(c *cli.Context) error {
+	file := c.GlobalString("ignore-file")
+	if c.GlobalBool("no-ignore") {
+		return nil
+	}
+	if file == "" {
+		if _, err := os.Stat(registry.IgnoreFile); err != nil {
+			return nil
+		}
+		file = registry.IgnoreFile
+	}
+	rules, err := registry.LoadIgnoreRules(file)
+	if err != nil {
+		return err
+	}
+	ignoreRules = rules
+	if c.GlobalBool("verbose") {
+		fmt.Fprintf(os.Stderr, "Applying the rules of %s\n", file)
+	}
+	return nil
+}
+
+// loadRepositoryRegistry is loadRegistry for the commands with repositoryFlags, on the repository they select
+func loadRepositoryRegistry(c *cli.Context) (registry.Registry, error) {
+	r, profile, err := registry.NewRegistryFromProfile(c.GlobalString("profile"))
+	if err != nil {
+		return r, err
+	}
+	if err := applyReferences(&r); err != nil {
+		return r, err
+	}
+	registry.WithIgnoreRules(ignoreRules)(&r)
+	if repository := os.Getenv(fanOutRepository); repository != "" {
+		r.Repository = repository
+	} else if repository := strings.TrimSpace(c.String("repository")); repository != "" {
+		r.Repository = repository
+	}
+	if !registry.ValidProtocol(c.GlobalString("http-protocol")) {
+		return r, errors.New(fmt.Sprintf("Invalid --http-protocol %s, use %s or %s", c.GlobalString("http-protocol"), registry.ProtocolAuto, registry.ProtocolHTTP1))
+	}
+	fmt.Fprintf(os.Stderr, "Using profile %s: %s, repository %s\n", profile, r.Host, r.Repository)
+	if c.GlobalBool("verbose") {
+		fmt.Fprintf(os.Stderr, "HTTP protocol %s\n", r.Protocol())
+	}
+	return r, nil
+}
+
+// fanOut runs the command once per repository when repositoryFlags select several, returning true. The runs are
+// nexus-cli itself started again with the same arguments, so their output is kept apart and prefixed line by line
+// however deep in the registry package it is printed. It returns false if the command is to run as usual, on one
+// repository
+func fanOut(c *cli.Context) (bool, error) {
+	if os.Getenv(fanOutRepository) != "" {
+		return false, nil
+	}
+	var repositories []string
+	if c.Bool("all-docker-repos") {
+		r, _, err := registry.NewRegistryFromProfile(c.GlobalString("profile"))
+		if err != nil {
+			return true, err
+		}
+		infos, err := r.Repositories()
+		if err != nil {
+			return true, err
+		}
+		for _, info := range infos {
+			if info.Format == "docker" {
+				repositories = append(repositories, info.Name)
+			}
+		}
+		if len(repositories) == 0 {
+			return true, errors.New(fmt.Sprintf("There are no docker repositories on %s", r.Host))
+		}
+	} else {
+		for _, repository := range strings.Split(c.String("repository"), ",") {
+			if repository = strings.TrimSpace(repository); repository != "" {
+				repositories = append(repositories, repository)
+			}
+		}
+		if len(repositories) < 2 {
+			return false, nil
+		}
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return true, err
+	}
+	parallel := c.Int("parallel-repos")
+	if parallel < 1 {
+		parallel = 1
+	}
+	fmt.Fprintf(os.Stderr, "Running on %d repositories: %s\n", len(repositories), strings.Join(repositories, ", "))
+
+	var outMu, errMu, failedMu sync.Mutex
+	var failed []string
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+	for _, repository := range repositories {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(repository string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			prefix := fmt.Sprintf("[%s] ", repository)
+			stdout := output.NewPrefixWriter(os.Stdout, prefix, &outMu)
+			stderr := output.NewPrefixWriter(os.Stderr, prefix, &errMu)
+			cmd := exec.Command(executable, os.Args[1:]...)
+			cmd.Env = append(append(os.Environ(), tracing.Environ()...), fanOutRepository+"="+repository)
+			cmd.Stdout, cmd.Stderr = stdout, stderr
+			err := cmd.Run()
+			stdout.Flush()
+			stderr.Flush()
+			if err != nil {
+				failedMu.Lock()
+				failed = append(failed, repository)
+				failedMu.Unlock()
+			}
+		}(repository)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return true, errors.New(fmt.Sprintf("%d of %d repositories failed: %s", len(failed), len(repositories), strings.Join(failed, ", ")))
+	}
+	return true, nil
+}
+
+// perRepository makes a path given for a run of fanOut its own, report.html becomes report-<repository>.html, so
+// the parallel runs do not overwrite each other's files
+func perRepository(path string) string {
+	repository := os.Getenv(fanOutRepository)
+	if path == "" || repository == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + strings.Replace(repository, string(filepath.Separator), "_", -1) + ext
+}
+
+// indexFlag names the database of 'repo index' and the --offline queries
+var indexFlag = cli.StringFlag{
+	Name:   "db",
+	Value:  "nexus.db",
+	Usage:  "SQLite database holding the index",
+	EnvVar: "NEXUS_CLI_INDEX",
+}
+
+// offlineFlags are the flags of the commands which can answer from the index instead of the server
+var offlineFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "offline",
+		Usage: "Answer from the index built by 'repo index' instead of asking the server",
+	},
+	indexFlag,
+}
+
+// openIndex opens the index of the repository of the selected profile for an --offline query, telling on stderr
+// how old it is
+func openIndex(c *cli.Context) (*index.Index, error) {
+	r, profile, err := registry.NewRegistryFromProfile(c.GlobalString("profile"))
+	if err != nil {
+		return nil, err
+	}
+	path := c.String("db")
+	if _, err := os.Stat(path); err != nil {
+		return nil, errors.New(fmt.Sprintf("There is no index %s, build it with 'nexus-cli repo index --db %s'", path, path))
+	}
+	ix, err := index.Open(path, r.Host, r.Repository)
+	if err != nil {
+		return nil, err
+	}
+	indexed, ok, err := ix.Indexed()
+	if err == nil && !ok {
+		err = errors.New(fmt.Sprintf("Repository %s of %s is not in the index %s, build it with 'nexus-cli repo index --db %s'", r.Repository, r.Host, path, path))
+	}
+	if err != nil {
+		ix.Close()
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Using index %s of profile %s: %s, repository %s, indexed %s\n", path, profile, r.Host, r.Repository, indexed.Format(time.RFC3339))
+	return ix, nil
+}
+
+// concurrencyFlag caps the requests of crawls in parallel, they use fewer while the registry is slow or throttles
+var concurrencyFlag = cli.IntFlag{
+	Name:  "concurrency",
+	Value: 16,
+	Usage: "Maximum number of requests in parallel, fewer are sent while Nexus answers slowly or with 429 / 503",
+}
+
+// crawlerFor sends the crawls of r through a crawler obeying --concurrency, printCrawlStats tells how they went
+func crawlerFor(c *cli.Context, r *registry.Registry) *registry.Crawler {
+	crawler := registry.NewCrawler(c.Int("concurrency"))
+	registry.WithCrawler(crawler)(r)
+	return crawler
+}
+
+// crawlStatus shows what the crawls of crawler work on while they run, see output.Status. Stop it before printing
+func crawlStatus(crawler *registry.Crawler, title string) *output.Status {
+	status := output.NewStatus(title)
+	crawler.Observe(status)
+	return status
+}
+
+func printCrawlStats(crawler *registry.Crawler) {
+	fmt.Fprintf(os.Stderr, "Crawled with %s\n", crawler.Stats())
+}
+
+// summaryFlag chooses how the bulk commands print the summary of their run, see printSummary
+var summaryFlag = cli.StringFlag{
+	Name:  "summary",
+	Value: "text",
+	Usage: "Print the summary of the run on stderr as a line of text, as a JSON object (json) or not at all (none)",
+}
+
+// runStatsFor records the outcome of the bulk operations of r for printSummary
+func runStatsFor(r *registry.Registry) *registry.RunStats {
+	stats := registry.NewRunStats()
+	registry.WithRunStats(stats)(r)
+	return stats
+}
+
+// printSummary prints what the bulk command did on repository, as --summary asks
+func printSummary(c *cli.Context, repository string, stats *registry.RunStats) {
+	summary := stats.Summary(c.Command.FullName(), repository)
+	switch c.String("summary") {
+	case "none":
+	case "json":
+		json.NewEncoder(os.Stderr).Encode(summary)
+	default:
+		fmt.Fprintln(os.Stderr, summary)
+	}
+}
+
+// outputFlags let the inventory and report commands publish what they print, e.g. for dashboards reading the result
+// of scheduled jobs
+var outputFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "output-file",
+		Usage: "Write the output to this file instead of stdout, replacing it once complete",
+	},
+	cli.StringFlag{
+		Name:  "output-s3",
+		Usage: "Put the output to this s3://bucket/key, with the credentials, region and endpoint of the AWS_ environment variables",
+	},
+	cli.StringFlag{
+		Name:  "output-url",
+		Usage: "POST the output to this URL",
+	},
+	cli.StringSliceFlag{
+		Name:  "output-header",
+		Usage: "Header of the --output-url request as \"Name: value\", e.g. an Authorization header, can be repeated",
+	},
+}
+
+// sinkOutput runs a command with outputFlags, what it prints to stdout goes to the sinks they give instead. The
+// runs started by fanOut print as usual, their parent collects the output
+func sinkOutput(c *cli.Context, action func(*cli.Context) error) error {
+	var sinks []output.Sink
+	if os.Getenv(fanOutRepository) == "" {
+		if uri := c.String("output-s3"); uri != "" {
+			sink, err := output.NewS3Sink(uri)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			sinks = append(sinks, sink)
+		}
+		if target := c.String("output-url"); target != "" {
+			sink, err := output.NewHTTPSink(target, c.StringSlice("output-header"))
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			sinks = append(sinks, sink)
+		}
+		// last, it creates its temporary file right away
+		if path := c.String("output-file"); path != "" {
+			sink, err := output.NewFileSink(path)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			sinks = append(sinks, sink)
+		}
+	}
+	if len(sinks) == 0 {
+		return action(c)
+	}
+	release, err := output.Capture(sinks...)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	// a failed run publishes what it printed as well, e.g. the damages fsck found
+	err = action(c)
+	if releaseErr := release(); releaseErr != nil {
+		return cli.NewExitError(releaseErr.Error(), 1)
+	}
+	for _, sink := range sinks {
+		fmt.Fprintf(os.Stderr, "Output written to %s\n", sink)
+	}
+	return err
+}
+
+func quotaReport(c *cli.Context) error {
+	config, err := quota.Load(c.String("file"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if handled, err := fanOut(c); handled {
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	r, err := loadRepositoryRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	crawler := crawlerFor(c, &r)
+	status := crawlStatus(crawler, "Measuring")
+	defer status.Stop()
+	inventory, err := r.Inventory(nil)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	delete(inventory, lock.RepositoryImage)
+	delete(inventory, registry.ProtectionImage)
+	delete(inventory, registry.QuarantineImage)
+	ignoreRules.Inventory(inventory)
+	blobs, err := r.ImageBlobs(inventory)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	status.Stop()
+	printCrawlStats(crawler)
+	report := config.Report(inventory, blobs)
+
+	var over []string
+	for _, u := range report {
+		if u.Over() {
+			over = append(over, u.Team)
+		}
+	}
+	if c.Bool("json") {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	} else {
+		t := output.NewTable("TEAM", "IMAGES", "TAGS", "STORAGE", "EXCLUSIVE", "BUDGET", "USED")
+		for _, u := range report {
+			budget, used := "-", "-"
+			if u.Budget > 0 {
+				budget = utils.HumanBytes(u.Budget)
+				used = fmt.Sprintf("%.0f%%", float64(u.Bytes)*100/float64(u.Budget))
+				if u.Over() {
+					used = output.Red(used)
+				}
+			}
+			t.Row(u.Team, strconv.Itoa(u.Images), strconv.Itoa(u.Tags), utils.HumanBytes(u.Bytes), utils.HumanBytes(u.Exclusive), budget, used)
+		}
+		if err := t.Render(os.Stdout); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println(output.Faint("Layers shared between teams count for each of them, EXCLUSIVE is what only the team uses"))
+	}
+	if len(over) > 0 {
+		message := fmt.Sprintf("%s over budget", strings.Join(over, ", "))
+		if c.Bool("enforce") {
+			return cli.NewExitError(output.Red(message), 1)
+		}
+		fmt.Fprintln(os.Stderr, output.Yellow(message))
+	}
+	return nil
+}
+
+func indexRepository(c *cli.Context) error {
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	ix, err := index.Open(c.String("db"), r.Host, r.Repository)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer ix.Close()
+	// other images or another database are another job
+	names := append([]string{}, c.StringSlice("name")...)
+	sort.Strings(names)
+	job := fmt.Sprintf("index %s/%s into %s images %s", r.Host, r.Repository, c.String("db"), strings.Join(names, ","))
+	cp, err := openCheckpoint(c, "index", job, job)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer cp.Close()
+
+	started := time.Now()
+	status := output.NewStatus("Indexing")
+	ix.Progress = status
+	ix.Checkpoint = cp
+	stats, err := ix.Build(r, c.StringSlice("name"), c.Int("concurrency"))
+	status.Stop()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if err := cp.Remove(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	skipped := make([]string, 0, len(stats.Skipped))
+	for tag := range stats.Skipped {
+		skipped = append(skipped, tag)
+	}
+	sort.Strings(skipped)
+	for _, tag := range skipped {
+		fmt.Fprintln(os.Stderr, output.Yellow(fmt.Sprintf("Skipped %s: %s", tag, stats.Skipped[tag])))
+	}
+	fmt.Printf("Indexed %d tags of %d images into %s in %s\n", stats.Tags, stats.Images, c.String("db"), time.Since(started).Round(time.Second))
+	fmt.Fprintf(os.Stderr, "Crawled with %s\n", stats.Crawl)
+	return nil
+}
+
+// snapshotSince returns the snapshot of the index --since selects
+func snapshotSince(ix *index.Index, since string) (index.Snapshot, error) {
+	at := time.Now()
+	if since != "last" {
+		if date, err := time.ParseInLocation("2006-01-02", since, time.Local); err == nil {
+			// the last snapshot of that day
+			at = date.AddDate(0, 0, 1).Add(-time.Second)
+		} else if age, err := utils.ParseDuration(since); err == nil {
+			at = at.Add(-age)
+		} else {
+			return index.Snapshot{}, errors.New(fmt.Sprintf("Invalid --since %q, use last, an age like 7d or a date like 2026-01-31", since))
+		}
+	}
+	s, ok, err := ix.SnapshotAt(at)
+	if err == nil && !ok {
+		err = errors.New(fmt.Sprintf("There is no snapshot taken before %s, 'repo index' takes one", at.Format(time.RFC3339)))
+	}
+	return s, err
+}
+
+// repositoryChanges compares a crawl of the repository with a snapshot of the index. With --fail-on-deletions tags
+// deleted since, which --expected-deletions does not match, fail it
+func repositoryChanges(c *cli.Context) error {
+	expected, err := registry.ParseIgnoreRules([]byte(strings.Join(c.StringSlice("expected-deletions"), "\n")))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Invalid --expected-deletions: %s", err), 1)
+	}
+	ix, err := openIndex(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer ix.Close()
+	snapshot, err := snapshotSince(ix, c.String("since"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	before, err := ix.Inventory(snapshot)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	crawler := crawlerFor(c, &r)
+	status := crawlStatus(crawler, "Crawling")
+	defer status.Stop()
+	live, err := r.Inventory(nil)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	status.Stop()
+	printCrawlStats(crawler)
+	ignoreRules.Inventory(before)
+	ignoreRules.Inventory(live)
+
+	added, removed := registry.DiffImages(before, live)
+	changed := []registry.Change{}
+	var addedTags, removedTags int
+	unexpected := []string{}
+	for _, change := range registry.DiffInventory(before, live) {
+		switch change.Kind {
+		case registry.ChangeAdded:
+			addedTags++
+		case registry.ChangeRemoved:
+			removedTags++
+			if !expected.Tag(change.Image, change.Tag) {
+				unexpected = append(unexpected, change.Image+":"+change.Tag)
+			}
+		case registry.ChangeDigest:
+			changed = append(changed, change)
+		}
+	}
+
+	if c.Bool("json") {
+		report := struct {
+			Snapshot            time.Time         `json:"snapshot"`
+			NewImages           []string          `json:"new_images"`
+			RemovedImages       []string          `json:"removed_images"`
+			ChangedDigests      []registry.Change `json:"changed_digests"`
+			AddedTags           int               `json:"added_tags"`
+			RemovedTags         int               `json:"removed_tags"`
+			UnexpectedDeletions []string          `json:"unexpected_deletions"`
+		}{snapshot.Taken, added, removed, changed, addedTags, removedTags, unexpected}
+		if report.NewImages == nil {
+			report.NewImages = []string{}
+		}
+		if report.RemovedImages == nil {
+			report.RemovedImages = []string{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	} else {
+		fmt.Printf("Changes of %s since the snapshot of %s:\n", r.Repository, snapshot.Taken.Local().Format(time.RFC3339))
+		fmt.Println("New images:")
+		for _, image := range added {
+			fmt.Println(output.Green("\t" + image))
+		}
+		fmt.Println("Removed images:")
+		for _, image := range removed {
+			fmt.Println(output.Red("\t" + image))
+		}
+		fmt.Println("Changed digests:")
+		for _, change := range changed {
+			fmt.Printf("\t%s:%s\t%s\t%s\n", change.Image, change.Tag, change.PreviousDigest, change.Digest)
+		}
+		fmt.Printf("%d new and %d removed images, %d tags added, %d removed and %d changed\n",
+			len(added), len(removed), addedTags, removedTags, len(changed))
+	}
+
+	if c.Bool("fail-on-deletions") && len(unexpected) > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d tags were deleted unexpectedly: %s", len(unexpected), strings.Join(unexpected, ", ")), 1)
+	}
+	return nil
+}
+
+// trendPoints is how many snapshots the sparklines of repo stats draw at most, frequent indexing is thinned out
+const trendPoints = 60
+
+// repositoryStats prints the growth of the repository between the first and the last snapshot of the index since
+// --since with a sparkline per figure, or the snapshots as CSV
+func repositoryStats(c *cli.Context) error {
+	since, err := utils.ParseDuration(c.String("since"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	ix, err := openIndex(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer ix.Close()
+	history, err := ix.History(time.Now().Add(-since))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if c.Bool("csv") {
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"taken", "images", "tags", "bytes"})
+		for _, s := range history {
+			w.Write([]string{s.Taken.Format(time.RFC3339), strconv.Itoa(s.Images), strconv.Itoa(s.Tags), strconv.FormatInt(s.Bytes, 10)})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	if len(history) < 2 {
+		fmt.Printf("%d snapshots in the last %s, index the repository regularly (e.g. daily from cron) to see it grow\n", len(history), c.String("since"))
+		return nil
+	}
+
+	// evenly spaced snapshots, the last one always among them
+	points := history
+	if len(history) > trendPoints {
+		points = nil
+		for i := 0; i < trendPoints; i++ {
+			points = append(points, history[(len(history)-1)*i/(trendPoints-1)])
+		}
+	}
+	first, last := history[0], history[len(history)-1]
+	growth := func(from float64, to float64, format func(float64) string) string {
+		sign := "+"
+		if to < from {
+			sign = "-"
+		}
+		text := sign + format(math.Abs(to-from))
+		if from > 0 {
+			text += fmt.Sprintf(" (%s%.1f%%)", sign, math.Abs(to-from)/from*100)
+		}
+		return text
+	}
+	count := func(v float64) string { return strconv.FormatInt(int64(v), 10) }
+	bytes := func(v float64) string { return utils.HumanBytes(int64(v)) }
+	figures := []struct {
+		name   string
+		value  func(s index.Snapshot) float64
+		format func(float64) string
+	}{
+		{"images", func(s index.Snapshot) float64 { return float64(s.Images) }, count},
+		{"tags", func(s index.Snapshot) float64 { return float64(s.Tags) }, count},
+		{"bytes", func(s index.Snapshot) float64 { return float64(s.Bytes) }, bytes},
+	}
+
+	fmt.Printf("%d snapshots from %s to %s\n", len(history), first.Taken.Format(time.RFC3339), last.Taken.Format(time.RFC3339))
+	t := output.NewTable("", "FIRST", "LAST", "GROWTH", "TREND")
+	for _, f := range figures {
+		var values []float64
+		for _, s := range points {
+			values = append(values, f.value(s))
+		}
+		t.Row(f.name, f.format(f.value(first)), f.format(f.value(last)), growth(f.value(first), f.value(last), f.format), output.Sparkline(values))
+	}
+	if err := t.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	span := last.Taken.Sub(first.Taken)
+	if span > 0 {
+		perMonth := float64(last.Bytes-first.Bytes) / span.Hours() * 30 * 24
+		if perMonth >= 0 {
+			fmt.Printf("Growing by %s per 30 days\n", utils.HumanBytes(int64(perMonth)))
+		} else {
+			fmt.Printf("Shrinking by %s per 30 days\n", utils.HumanBytes(int64(-perMonth)))
+		}
+	}
+	return nil
+}
+
+func listImages(c *cli.Context) error {
+	var images []string
+	var filter *registry.NameFilter
+	if expr := c.String("filter"); expr != "" || c.String("namespace") != "" {
+		f := registry.NameFilter{}
+		if expr != "" {
+			var err error
+			if f, err = registry.ParseNameFilter(expr); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+		f, err := namespaceFilter(c, f)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		filter = &f
+	}
+	if c.Bool("tree") {
+		return listImageTree(c, filter)
+	}
+	if c.Bool("offline") {
+		ix, err := openIndex(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		defer ix.Close()
+		if images, err = ix.Images(); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	} else {
+		if handled, err := fanOut(c); handled {
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			return nil
+		}
+		r, err := loadRepositoryRegistry(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if filter != nil {
+			matched := 0
+			err := r.EachImageMatching(context.Background(), *filter, func(image string) error {
+				if !ignoreRules.Image(image) {
+					fmt.Println(image)
+					matched++
+				}
+				return nil
+			})
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			fmt.Printf("Total images: %d\n", matched)
+			return nil
+		}
+		if images, err = r.ListImages(); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	total := 0
+	for _, image := range ignoreRules.Images(images) {
+		if filter == nil || filter.Match(image) {
+			fmt.Println(image)
+			total++
+		}
+	}
+	fmt.Printf("Total images: %d\n", total)
+	return nil
+}
+
+// listImageTree shows the images matching filter (all if nil) as a tree of namespaces, see registry.NamespaceTree
+func listImageTree(c *cli.Context, filter *registry.NameFilter) error {
+	var repository string
+	var inventory registry.Inventory
+	var blobs map[string]map[string]int64
+	if c.Bool("offline") {
+		ix, err := openIndex(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		defer ix.Close()
+		if inventory, blobs, err = ix.Usage(); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for image := range inventory {
+			if filter != nil && !filter.Match(image) {
+				delete(inventory, image)
+			}
+		}
+		ignoreRules.Inventory(inventory)
+		repository = ix.Repository
+	} else {
+		if handled, err := fanOut(c); handled {
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			return nil
+		}
+		r, err := loadRepositoryRegistry(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		repository = r.Repository
+		crawler := crawlerFor(c, &r)
+		status := crawlStatus(crawler, "Measuring")
+		defer status.Stop()
+		var images []string
+		if filter != nil {
+			err := r.EachImageMatching(context.Background(), *filter, func(image string) error {
+				images = append(images, image)
+				return nil
+			})
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+		inventory = registry.Inventory{}
+		// no images given means all of them
+		if filter == nil || len(images) > 0 {
+			if inventory, err = r.Inventory(images); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+		ignoreRules.Inventory(inventory)
+		if blobs, err = r.ImageBlobs(inventory); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		status.Stop()
+		printCrawlStats(crawler)
+	}
+
+	tree := registry.NamespaceTree(inventory, blobs)
+	t := output.NewTable("NAME", "IMAGES", "TAGS", "SIZE")
+	t.Row(repository, strconv.Itoa(tree.Images), strconv.Itoa(tree.Tags), utils.HumanBytes(tree.Bytes))
+	namespaceRows(t, tree, "", 1, c.Int("depth"))
+	if err := t.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Println(output.Faint("Layers shared between images count once for the namespace holding them"))
+	return nil
+}
+
+// namespaceRows adds the children of node to the tree t, down to maxDepth (all if 0). Namespaces end with a slash,
+// those whose levels are not shown with an ellipsis
+func namespaceRows(t *output.Table, node *registry.NamespaceNode, indent string, depth int, maxDepth int) {
+	for i, child := range node.Children {
+		branch, next := "├── ", "│   "
+		if i == len(node.Children)-1 {
+			branch, next = "└── ", "    "
+		}
+		name := child.Name
+		collapsed := maxDepth > 0 && depth >= maxDepth
+		if len(child.Children) > 0 {
+			name += "/"
+			if collapsed {
+				name += output.Faint("…")
+			}
+		}
+		t.Row(indent+branch+name, strconv.Itoa(child.Images), strconv.Itoa(child.Tags), utils.HumanBytes(child.Bytes))
+		if !collapsed {
+			namespaceRows(t, child, indent+next, depth+1, maxDepth)
+		}
+	}
+}
+
+func listTagsByImage(c *cli.Context) error {
+	var imgName = c.String("name")
+	var sort = c.String("sort")
+	if sort != "semver" {
+		sort = "default"
+	}
+
+	if imgName == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	var tags []string
+	if c.Bool("offline") {
+		ix, err := openIndex(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		defer ix.Close()
+		if tags, err = ix.Tags(imgName); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	} else {
+		r, err := loadRegistry(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if tags, err = r.ListTagsByImage(imgName); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	tags = ignoreRules.Tags(imgName, tags)
+	compareStringNumber := utils.GetSortComparisonStrategy(sort)
+	utils.Compare(compareStringNumber).Sort(tags)
+
+	for _, tag := range tags {
+		fmt.Println(tag)
+	}
+	fmt.Printf("There are %d images for %s\n", len(tags), imgName)
+	return nil
+}
+
+func showImageInfo(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	if c.Bool("offline") {
+		return showIndexedImageInfo(c)
+	}
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	images, grouped, err := groupImages(c, r)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if grouped {
+		if tag == "" {
+			return cli.NewExitError("Give the tag to show for every image with -tag", 1)
+		}
+		var total int64
+		g := runImageGroup(images, registry.NewRunStats(), func(image string) error {
+			if err := printImageInfo(r, image, tag); err != nil {
+				return err
+			}
+			size, err := r.ImageSize(image, tag)
+			total += size
+			return err
+		})
+		fmt.Printf("Total size of %s in %d images: %s\n", tag, g.done, utils.HumanBytes(total))
+		return g.summary(c, r.Repository)
+	}
+	if imgName == "" || tag == "" {
+		err = cli.ShowSubcommandHelp(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	if err := printImageInfo(r, imgName, tag); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+func printImageInfo(r registry.Registry, imgName string, tag string) error {
+	manifest, err := r.ImageManifest(imgName, tag)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Image: %s:%s\n", imgName, tag)
+	if manifest.IsIndex() {
+		fmt.Println("Manifests:")
+		for _, m := range manifest.Manifests {
+			platform := "unknown"
+			if m.Platform != nil {
+				platform = m.Platform.OS + "/" + m.Platform.Architecture
+				if m.Platform.Variant != "" {
+					platform += "/" + m.Platform.Variant
+				}
+			}
+			fmt.Printf("\t%s\t%s\t%d\n", m.Digest, platform, m.Size)
+		}
+	} else {
+		fmt.Printf("Size: %d\n", manifest.Config.Size)
+		fmt.Println("Layers:")
+		for _, layer := range manifest.Layers {
+			fmt.Printf("\t%s\t%d\n", layer.Digest, layer.Size)
+		}
+	}
+	if len(manifest.Annotations) > 0 {
+		fmt.Println("Annotations:")
+		printAnnotations(manifest.Annotations)
+	}
+	return nil
+}
+
+// showIndexedImageInfo is image info with --offline, it shows what the index has about the tag
+func showIndexedImageInfo(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	if imgName == "" || tag == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	ix, err := openIndex(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer ix.Close()
+	t, ok, err := ix.Tag(imgName, tag)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if !ok {
+		return cli.NewExitError(fmt.Sprintf("%s:%s is not in the index", imgName, tag), 1)
+	}
+
+	fmt.Printf("Image: %s:%s\n", imgName, tag)
+	fmt.Printf("Digest: %s\n", t.Digest)
+	fmt.Printf("Size: %d\n", t.Size)
+	fmt.Printf("Created: %s\n", t.Created.Format(time.RFC3339))
+	fmt.Println("Layers:")
+	for _, layer := range t.Layers {
+		if layer.Platform != "" {
+			fmt.Printf("\t%s\t%s\t%d\n", layer.Digest, layer.Platform, layer.Size)
+		} else {
+			fmt.Printf("\t%s\t%d\n", layer.Digest, layer.Size)
+		}
+	}
+	if len(t.Labels) > 0 {
+		fmt.Println("Labels:")
+		printAnnotations(t.Labels)
+	}
+	return nil
+}
+
+func annotateImage(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	var pairs = c.StringSlice("annotation")
+	if imgName == "" || tag == "" || len(pairs) == 0 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	annotations := make(map[string]string)
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return cli.NewExitError(fmt.Sprintf("Annotation %q is not in the form key=value", pair), 1)
+		}
+		annotations[kv[0]] = kv[1]
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	digest, err := r.AnnotateImage(imgName, tag, annotations)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%s:%s has been annotated, new digest: %s\n", imgName, tag, digest)
+	printAnnotations(annotations)
+	return nil
+}
+
+func putManifest(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	var path = c.String("file")
+	if imgName == "" || tag == "" || path == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	var body []byte
+	var err error
+	if path == "-" {
+		body, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		body, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	var manifest registry.ImageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s is not a manifest: %s", path, err), 1)
+	}
+	mediaType := c.String("media-type")
+	switch {
+	case mediaType == "" && manifest.MediaType == "":
+		return cli.NewExitError(fmt.Sprintf("%s has no mediaType field, give it with --media-type", path), 1)
+	case mediaType == "":
+		mediaType = manifest.MediaType
+	case manifest.MediaType != "" && manifest.MediaType != mediaType:
+		return cli.NewExitError(fmt.Sprintf("--media-type %s does not match the mediaType %s of %s", mediaType, manifest.MediaType, path), 1)
+	}
+	// the references are checked by the media type the registry is told, the field may be missing in OCI manifests
+	manifest.MediaType = mediaType
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	missing, err := r.MissingReferences(imgName, manifest)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if len(missing) > 0 {
+		return cli.NewExitError(fmt.Sprintf("%s references what %s does not have:\n\t%s", path, imgName, strings.Join(missing, "\n\t")), 1)
+	}
+	digest, pushed, err := r.PushManifest(imgName, tag, mediaType, body)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if !pushed {
+		fmt.Printf("%s:%s is up to date, digest: %s\n", imgName, tag, digest)
+		return nil
+	}
+	fmt.Printf("%s:%s has been pushed, digest: %s\n", imgName, tag, digest)
+	return nil
+}
+
+func fsckImages(c *cli.Context) error {
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	crawler := crawlerFor(c, &r)
+	status := crawlStatus(crawler, "Checking")
+	defer status.Stop()
+	images := []string{c.String("name")}
+	if images[0] == "" {
+		catalog, err := r.ListImages()
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		images = nil
+		for _, image := range catalog {
+			if !internalImage(image) {
+				images = append(images, image)
+			}
+		}
+	}
+
+	var damages []registry.Damage
+	for i, image := range images {
+		status.Printf("[%d/%d] %s\n", i+1, len(images), image)
+		found, err := r.Fsck(image)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Checking %s failed: %s", image, err), 1)
+		}
+		damages = append(damages, found...)
+	}
+	status.Stop()
+	printCrawlStats(crawler)
+
+	if c.Bool("json") {
+		if damages == nil {
+			damages = []registry.Damage{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(damages); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	} else if len(damages) > 0 {
+		t := output.NewTable("IMAGE", "TAG", "KIND", "DIGEST", "PROBLEM")
+		for _, d := range damages {
+			t.Row(d.Image, d.Tag, d.Kind, d.Digest, d.Problem)
+		}
+		if err := t.Render(os.Stdout); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	if len(damages) > 0 {
+		return cli.NewExitError(output.Red(fmt.Sprintf("%d damaged references in %d images, pulling the tags listed fails", len(damages), len(images))), 1)
+	}
+	if !c.Bool("json") {
+		fmt.Println(output.Green(fmt.Sprintf("%d images checked, nothing is missing", len(images))))
+	}
+	return nil
+}
+
+func printAnnotations(annotations map[string]string) {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("\t%s=%s\n", key, annotations[key])
+	}
+}
+
+func deleteImage(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	var keep = c.Int("keep")
+	var dryRun = c.Bool("dry-run")
+	var withReferrers = c.Bool("with-referrers")
+	var estimate = c.Bool("estimate")
+	var bulk = newBulkDelete(c)
+	var sort = c.String("sort")
+	if sort != "semver" {
+		sort = "default"
+	}
+
+	if imgName == "" {
+		if _,err := fmt.Fprintf(c.App.Writer, "You should specify the image name\n"); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	} else {
+		r, err := loadRegistry(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		retried, err := bulk.retrying(c, r, "image delete", imgName)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if retried != nil {
+			if tag != "" || keep != 0 {
+				return cli.NewExitError("Give either --retry-from or -tag / -keep", 1)
+			}
+			_, failed := retried.Images()
+			if len(failed[imgName]) == 0 {
+				fmt.Println("Nothing failed, there is nothing to retry")
+				return nil
+			}
+			tag = strings.Join(failed[imgName], ",")
+		}
+		if digest := c.String("digest"); digest != "" {
+			return deleteImageDigest(c, r, bulk, imgName, digest, retried != nil)
+		}
+		// the tags deleted together are selected first, so they may share manifests among each other
+		var deleter *registry.TagDeleter
+		selectTags := func(tags []string) error {
+			var err error
+			if deleter, err = bulk.tagDeleter(r, imgName, tags); err != nil {
+				return err
+			}
+			deleter.WithReferrers = withReferrers
+			if withReferrers && dryRun {
+				bulk.referrers = func(tag string) ([]registry.Referrer, error) {
+					digest, ok := deleter.Digest(tag)
+					if !ok {
+						return nil, nil
+					}
+					return r.ReferrerTree(imgName, digest)
+				}
+			}
+			return nil
+		}
+		deleteTag := func(tag string) error {
+			return deleter.Delete(tag)
+		}
+		printEstimate := func(tags []string) error {
+			if !estimate {
+				return nil
+			}
+			e, err := r.EstimateReclaimable(imgName, tags)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Deleting %d tags removes %d manifests, estimated space reclaimed after compaction: %s (%d blobs)\n",
+				len(tags), e.Manifests, utils.HumanBytes(e.Bytes), e.Blobs)
+			fmt.Printf("%d blobs (%s) remain in use by other images\n", e.SharedBlobs, utils.HumanBytes(e.SharedBytes))
+			return nil
+		}
+		if tag == "" {
+			if keep == 0 {
+				if _,err := fmt.Fprintf(c.App.Writer, "You should either specify the tag or how many images you want to keep\n"); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				if err := cli.ShowSubcommandHelp(c); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+			} else {
+				tags, err := r.ListTagsByImage(imgName)
+
+				compareStringNumber := utils.GetSortComparisonStrategy(sort)
+				utils.Compare(compareStringNumber).Sort(tags)
+
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				if len(tags) >= keep {
+					if err := printEstimate(tags[:len(tags)-keep]); err != nil {
+						return cli.NewExitError(err.Error(), 1)
+					}
+					if !dryRun {
+						if err := confirmDeletion(c, r, len(tags)-keep); err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+					}
+					if err := selectTags(tags[:len(tags)-keep]); err != nil {
+						return cli.NewExitError(err.Error(), 1)
+					}
+					for _, tag := range tags[:len(tags)-keep] {
+						if !dryRun {
+							fmt.Println(output.Red(fmt.Sprintf("%s:%s image will be deleted ...", imgName, tag)))
+						}
+						if err := bulk.delete(imgName, tag, deleteTag); err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+					}
+					return bulk.summary(c, r.Repository)
+				} else {
+					fmt.Printf("Only %d images are available\n", len(tags))
+				}
+			}
+		} else if strings.Contains(tag, ",") || retried != nil { // credits to https://github.com/mlabouardy/nexus-cli/pull/28
+			tags := strings.Split(tag, ",")
+			if err := printEstimate(tags); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			if !dryRun {
+				if err := confirmDeletion(c, r, len(tags)); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+			}
+			if err := selectTags(tags); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			for _, value := range tags {
+				if err := bulk.delete(imgName, value, deleteTag); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+			}
+			return bulk.summary(c, r.Repository)
+		} else {
+			if err := printEstimate([]string{tag}); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			if !dryRun {
+				if err := confirmDeletion(c, r, 1); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+			}
+			if err := selectTags([]string{tag}); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			if dryRun {
+				return bulk.wouldDelete(imgName, tag, deleteTag)
+			}
+			err = deleteTag(tag)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+	}
+	return nil
+}
+
+// deleteImageDigest deletes a manifest of image by its digest, without a tag resolving to it. The tags still pointing
+// to it are guarded like those shared by a deleted tag
+func deleteImageDigest(c *cli.Context, r registry.Registry, bulk *bulkDelete, image string, digest string, retried bool) error {
+	if c.String("tag") != "" || c.Int("keep") != 0 || retried {
+		return cli.NewExitError("Give either --digest or -tag / -keep / --retry-from", 1)
+	}
+	if c.Bool("untag") || c.Bool("estimate") {
+		return cli.NewExitError("--untag and --estimate work on tags, not with --digest", 1)
+	}
+	if !registry.IsDigest(digest) {
+		return cli.NewExitError(fmt.Sprintf("Invalid digest %q, expected sha256:<hex>", digest), 1)
+	}
+	deleter, err := bulk.tagDeleter(r, image, nil)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	deleter.WithReferrers = c.Bool("with-referrers")
+	tags := deleter.Tags(digest)
+	if bulk.dryRun {
+		if err := deleter.DeleteDigest(digest); err != nil {
+			fmt.Println(output.Yellow("Would not be deleted (Dry Run): " + strings.SplitN(err.Error(), "\n", 2)[0]))
+			return nil
+		}
+		msg := fmt.Sprintf("%s@%s would be deleted (Dry Run) ...", image, digest)
+		if len(tags) > 0 {
+			msg = fmt.Sprintf("%s@%s would be deleted with %s (Dry Run) ...", image, digest, strings.Join(tags, ", "))
+		}
+		fmt.Println(output.Yellow(msg))
+		if deleter.WithReferrers {
+			referrers, err := r.ReferrerTree(image, digest)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			printWouldDeleteReferrers(image, referrers)
+		}
+		return nil
+	}
+	// an untagged manifest is confirmed like a tag
+	count := len(tags)
+	if count == 0 {
+		count = 1
+	}
+	if err := confirmDeletion(c, r, count); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if err := deleter.DeleteDigest(digest); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+// lockImage locks the given tags, or with lock false unlocks them
+func lockImage(c *cli.Context, lock bool) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	if imgName == "" || tag == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	tags := strings.Split(tag, ",")
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if !lock {
+		if err := r.Unprotect(imgName, tags); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for _, tag := range tags {
+			fmt.Printf("%s:%s has been unlocked\n", imgName, tag)
+		}
+		return nil
+	}
+
+	// locking a tag which does not exist is most probably a typo
+	existing, err := r.ListTagsByImage(imgName)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, tag := range existing {
+		exists[tag] = true
+	}
+	for _, tag := range tags {
+		if !exists[tag] {
+			return cli.NewExitError(fmt.Sprintf("%s:%s does not exist", imgName, tag), 1)
+		}
+	}
+	if err := r.Protect(imgName, tags, c.String("reason")); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	for _, tag := range tags {
+		fmt.Printf("%s:%s has been locked\n", imgName, tag)
+	}
+	return nil
+}
+
+func listLocks(c *cli.Context) error {
+	var imgName = c.String("name")
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	protections, err := r.Protections()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	t := output.NewTable("IMAGE", "TAG", "LOCKED", "REASON")
+	count := 0
+	for _, p := range protections {
+		if imgName != "" && p.Image != imgName {
+			continue
+		}
+		count++
+		t.Row(p.Image, p.Tag, p.Created.Format(time.RFC3339), p.Reason)
+	}
+	if err := t.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("There are %d locked tags\n", count)
+	return nil
+}
+
+func watchImages(c *cli.Context) error {
+	var images = c.StringSlice("name")
+	var interval = c.Duration("interval")
+	var asJSON = c.Bool("json")
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	previous, err := r.Inventory(images)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Fprintf(os.Stderr, "Watching %d images every %s\n", len(previous), interval)
+
+	encoder := json.NewEncoder(os.Stdout)
+	for range time.Tick(interval) {
+		current, err := r.Inventory(images)
+		if err != nil {
+			// the registry may be unavailable for a moment, try again on the next tick
+			output.Warnf("Polling failed: %s", err)
+			continue
+		}
+		for _, change := range registry.DiffInventory(previous, current) {
+			if asJSON {
+				event := struct {
+					Time string `json:"time"`
+					registry.Change
+				}{time.Now().UTC().Format(time.RFC3339), change}
+				if err := encoder.Encode(event); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				continue
+			}
+			switch change.Kind {
+			case registry.ChangeAdded:
+				fmt.Printf("+ %s:%s %s\n", change.Image, change.Tag, change.Digest)
+			case registry.ChangeRemoved:
+				fmt.Printf("- %s:%s %s\n", change.Image, change.Tag, change.PreviousDigest)
+			case registry.ChangeDigest:
+				fmt.Printf("~ %s:%s %s -> %s\n", change.Image, change.Tag, change.PreviousDigest, change.Digest)
+			}
+		}
+		previous = current
+	}
+	return nil
+}
+
+func listReferrers(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	if imgName == "" || tag == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	digest, err := r.ImageDigest(imgName, tag)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	referrers, err := r.Referrers(imgName, digest)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	for _, referrer := range referrers {
+		fmt.Printf("%s\t%s\t%d", referrer.Digest, referrer.ArtifactType, referrer.Size)
+		if referrer.Tag != "" {
+			fmt.Printf("\t(tag %s)", referrer.Tag)
+		}
+		fmt.Println()
+		printAnnotations(referrer.Annotations)
+	}
+	fmt.Printf("There are %d referrers for %s:%s (%s)\n", len(referrers), imgName, tag, digest)
+	return nil
+}
+
+func showProvenance(c *cli.Context) error {
+	if c.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	image, tag := splitImageRef(c.Args().First())
+	var base *registry.BaseRef
+	if c.String("base") != "" {
+		baseImage, baseTag := splitImageRef(c.String("base"))
+		base = &registry.BaseRef{Image: baseImage, Tag: baseTag}
+	}
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	p, err := r.ImageProvenance(image, tag, c.String("platform"), base)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if c.Bool("json") {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(p); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	// what an audit looks for is shown even if the image does not tell
+	unknown := output.Yellow("unknown")
+	or := func(value string) string {
+		if value == "" {
+			return unknown
+		}
+		return value
+	}
+	created := unknown
+	if !p.Created.IsZero() {
+		created = p.Created.Format(time.RFC3339)
+	}
+	fmt.Printf("Image: %s:%s\n", p.Image, p.Tag)
+	fmt.Printf("Digest: %s\n", p.Digest)
+	fmt.Printf("Platform: %s\n", or(p.Platform))
+	fmt.Printf("Created: %s\n", created)
+	if p.Author != "" {
+		fmt.Printf("Author: %s\n", p.Author)
+	}
+	fmt.Printf("Source: %s\n", or(p.Source))
+	fmt.Printf("Revision: %s\n", or(p.Revision))
+	baseName := or(p.BaseName)
+	if p.BaseDigest != "" {
+		baseName += " (" + p.BaseDigest + ")"
+	}
+	fmt.Printf("Base: %s\n", baseName)
+	if len(p.OCI) > 0 {
+		fmt.Println("Labels:")
+		printAnnotations(p.OCI)
+	}
+	fmt.Println("Layers:")
+	t := output.NewTable("DIGEST", "SIZE", "BASE", "CREATED BY")
+	for _, layer := range p.Layers {
+		fromBase := ""
+		if layer.Base {
+			fromBase = "yes"
+		}
+		createdBy := layer.CreatedBy
+		if len(createdBy) > 80 {
+			createdBy = createdBy[:77] + "..."
+		}
+		t.Row(layer.Digest, utils.HumanBytes(layer.Size), fromBase, createdBy)
+	}
+	if err := t.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if len(p.Attestations) == 0 {
+		fmt.Printf("Attestations: %s\n", output.Yellow("none"))
+		return nil
+	}
+	fmt.Println("Attestations:")
+	for _, a := range p.Attestations {
+		fmt.Printf("\t%s\t%s", a.ArtifactType, a.Digest)
+		if a.Tag != "" {
+			fmt.Printf("\t(tag %s)", a.Tag)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func signImage(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	var keyPath = c.String("key")
+	if imgName == "" || tag == "" || keyPath == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	key, err := signing.LoadPrivateKey(keyPath, readKeyPassword)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	dockerReference := imageReference(c, r, imgName)
+	digest, err := signing.SignImage(r, imgName, tag, dockerReference, key)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%s:%s (%s) has been signed as %s\n", imgName, tag, digest, dockerReference)
+	return nil
+}
+
+// imageReference returns the reference images are pulled with, --docker-reference or <nexus host>/<name>
+func imageReference(c *cli.Context, r registry.Registry, imgName string) string {
+	if reference := c.String("docker-reference"); reference != "" {
+		return reference
+	}
+	host := r.Host
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	return host + "/" + imgName
+}
+
+func showImageDigest(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	if imgName == "" || tag == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	format := c.String("format")
+	if format != "plain" && format != "reference" && format != "json" {
+		return cli.NewExitError(fmt.Sprintf("Unknown format %s, use plain, reference or json", format), 1)
+	}
+	if format == "reference" && c.Bool("config") {
+		return cli.NewExitError("Image configs are not pulled by reference, leave out --config or use --format plain", 1)
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	d, err := r.PinDigests(imgName, tag, c.Bool("config"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	reference := imageReference(c, r, imgName)
+
+	if format == "json" {
+		out := struct {
+			Image     string `json:"image"`
+			Tag       string `json:"tag"`
+			Reference string `json:"reference"`
+			registry.Digests
+		}{imgName, tag, reference + "@" + d.Digest, d}
+		if !c.Bool("config") {
+			out.Config = ""
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(out); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	// the first line is the digest of the tag alone, so $(nexus-cli image digest ...) pins it
+	pin := func(digest string) string {
+		if format == "reference" {
+			return reference + "@" + digest
+		}
+		return digest
+	}
+	fmt.Println(pin(d.Digest))
+	if c.Bool("config") && d.Config != "" {
+		fmt.Printf("config\t%s\n", d.Config)
+	}
+	if c.Bool("platforms") || c.Bool("config") {
+		for _, p := range d.Platforms {
+			fmt.Printf("%s\t%s\n", p.Platform, pin(p.Digest))
+			if c.Bool("config") {
+				fmt.Printf("%s config\t%s\n", p.Platform, p.Config)
+			}
+		}
+	}
+	return nil
+}
+
+// readKeyPassword follows cosign and takes the key password from COSIGN_PASSWORD, prompting for it otherwise
+func readKeyPassword() ([]byte, error) {
+	if password, ok := os.LookupEnv("COSIGN_PASSWORD"); ok {
+		return []byte(password), nil
+	}
+	fmt.Fprint(os.Stderr, "Enter password for private key: ")
+	password, err := terminal.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(os.Stderr)
+	return password, err
+}
+
+func copyImage(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	grouped := c.Bool("all-images") || c.String("image-regex") != ""
+	if (imgName == "" && !grouped) || tag == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	src, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	dst := src
+	if repository := c.String("to-repository"); repository != "" {
+		dst.Repository = repository
+	}
+	if grouped {
+		if c.String("to-name") != "" {
+			return cli.NewExitError("--to-name can't be combined with --all-images, --image-regex or --namespace, the images keep their names", 1)
+		}
+		images, _, err := groupImages(c, src)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		// the bytes are counted by the uploads to dst
+		stats := runStatsFor(&dst)
+		return runImageGroup(images, stats, func(image string) error {
+			return copyTag(c, src, image, tag, dst, image)
+		}).summary(c, dst.Repository)
+	}
+	dstName := imgName
+	if name := c.String("to-name"); name != "" {
+		dstName = name
+	}
+	if err := copyTag(c, src, imgName, tag, dst, dstName); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+// copyTag copies a tag of imgName to dstName in dst, --to-tag renaming it
+func copyTag(c *cli.Context, src registry.Registry, imgName string, tag string, dst registry.Registry, dstName string) error {
+	dstTag := tag
+	if t := c.String("to-tag"); t != "" {
+		dstTag = t
+	}
+	if dst.Repository == src.Repository && dstName == imgName && dstTag == tag {
+		return errors.New("Source and target are the same, give at least one of --to-repository, --to-name or --to-tag")
+	}
+
+	if c.Bool("deny-overwrite") {
+		digest, err := src.ImageDigest(imgName, tag)
+		if err != nil {
+			return err
+		}
+		if err := dst.EnsureNotOverwritten(dstName, dstTag, digest); err != nil {
+			return err
+		}
+	}
+
+	digest, err := registry.CopyImage(src, imgName, tag, dst, dstName, dstTag, !c.Bool("skip-referrers"))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s/%s:%s has been copied to %s/%s:%s (%s)\n", src.Repository, imgName, tag, dst.Repository, dstName, dstTag, digest)
+	return nil
+}
+
+// retagBulk copies the tags --match matches to the tags --to makes of them, of the image given with -name or of
+// several, see groupImages. When tags map to the same new tag the highest of them is copied, see registry.PlanRetags
+func retagBulk(c *cli.Context) error {
+	var imgName = c.String("name")
+	if c.String("match") == "" || c.String("to") == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	expr, err := regexp.Compile("^(?:" + c.String("match") + ")$")
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Invalid --match: %s", err), 1)
+	}
+	dryRun := !c.Bool("apply")
+
+	src, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	dst := src
+	if repository := c.String("to-repository"); repository != "" {
+		dst.Repository = repository
+	}
+	images, grouped, err := groupImages(c, src)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if !grouped {
+		if imgName == "" {
+			return cli.NewExitError("Give the image with -name, or several with --all-images, --image-regex or --namespace", 1)
+		}
+		images = []string{imgName}
+	}
+
+	// the bytes are counted by the uploads to dst
+	stats := runStatsFor(&dst)
+	defer printSummary(c, dst.Repository, stats)
+	copied, upToDate, superseded, failed := 0, 0, 0, 0
+	for _, image := range images {
+		tags, err := src.TagDigests(image)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		existing := tags
+		if dst.Repository != src.Repository {
+			if existing, err = dst.TagDigests(image); registry.IsNotFound(err) {
+				existing = map[string]string{}
+			} else if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+		names := make([]string, 0, len(tags))
+		for tag := range tags {
+			names = append(names, tag)
+		}
+		sort.Strings(names)
+
+		for _, retag := range registry.PlanRetags(names, expr, c.String("to")) {
+			from, to := image+":"+retag.From, image+":"+retag.To
+			digest, current := tags[retag.From], existing[retag.To]
+			switch {
+			case retag.SupersededBy != "":
+				fmt.Println(output.Faint(fmt.Sprintf("%s skipped, %s:%s becomes %s", from, image, retag.SupersededBy, retag.To)))
+				superseded++
+				stats.Skipped()
+			case !registry.IsValidTag(retag.To):
+				fmt.Println(output.Red(fmt.Sprintf("%s failed: '%s' is no valid tag", from, retag.To)))
+				failed++
+				stats.Failed()
+			case current == digest:
+				fmt.Println(output.Faint(fmt.Sprintf("%s is %s already", to, from)))
+				upToDate++
+				stats.Skipped()
+			case current != "" && c.Bool("deny-overwrite"):
+				fmt.Println(output.Red(fmt.Sprintf("%s failed: %s points to %s, not moved with --deny-overwrite", from, to, current)))
+				failed++
+				stats.Failed()
+			case dryRun:
+				fmt.Println(output.Yellow(fmt.Sprintf("%s would be copied to %s/%s (Dry Run)", from, dst.Repository, to)))
+				copied++
+			default:
+				if _, err := registry.CopyImage(src, image, retag.From, dst, image, retag.To, !c.Bool("skip-referrers")); err != nil {
+					fmt.Println(output.Red(fmt.Sprintf("%s failed: %s", from, err)))
+					failed++
+					stats.Failed()
+					continue
+				}
+				fmt.Println(output.Green(fmt.Sprintf("%s has been copied to %s/%s (%s)", from, dst.Repository, to, digest)))
+				copied++
+				stats.Succeeded()
+			}
+		}
+	}
+
+	verb := "copied"
+	if dryRun {
+		verb = "would be copied, give --apply to copy them"
+	}
+	fmt.Printf("\n%d tags %s, %d up to date, %d superseded, %d failed\n", copied, verb, upToDate, superseded, failed)
+	if failed > 0 {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
+
+func assertImmutable(c *cli.Context) error {
+	var path = c.String("record")
+	if c.NArg() == 0 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	// the record maps <image>:<tag> to the digest first seen
+	recorded := map[string]string{}
+	if content, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(content, &recorded); err != nil {
+			return cli.NewExitError(fmt.Sprintf("Invalid record %s: %s", path, err), 1)
+		}
+	} else if !os.IsNotExist(err) {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	changed, added := 0, 0
+	for _, ref := range c.Args() {
+		i := strings.LastIndex(ref, ":")
+		if i <= 0 {
+			return cli.NewExitError(fmt.Sprintf("%s is not <image>:<tag>", ref), 1)
+		}
+		digest, err := r.ImageDigest(ref[:i], ref[i+1:])
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		switch previous, ok := recorded[ref]; {
+		case !ok:
+			recorded[ref] = digest
+			added++
+			fmt.Printf("%s recorded as %s\n", ref, digest)
+		case previous != digest:
+			changed++
+			fmt.Println(output.Red(fmt.Sprintf("%s CHANGED from %s to %s", ref, previous, digest)))
+		default:
+			fmt.Printf("%s unchanged (%s)\n", ref, digest)
+		}
+	}
+
+	if added > 0 {
+		content, err := json.MarshalIndent(recorded, "", "  ")
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if err := ioutil.WriteFile(path, append(content, '\n'), 0644); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	if changed > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d tags have been overwritten since they were recorded in %s", changed, path), 1)
+	}
+	return nil
+}
+
+func scanImage(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	if ref := c.Args().First(); ref != "" && imgName == "" {
+		imgName, tag = splitImageRef(ref)
+	}
+	if imgName == "" || tag == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	exitOn := strings.ToUpper(c.String("exit-on"))
+	if exitOn != "" && scan.SeverityRank(exitOn) < 0 {
+		return cli.NewExitError(fmt.Sprintf("Unknown severity %s, use one of %s", exitOn, strings.Join(scan.Severities, ", ")), 1)
+	}
+
+	trivy, err := scan.LookTrivy(c.String("trivy"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	// trivy reads the image from a layout on disk, it is removed after the scan
+	dir, err := ioutil.TempDir("", "nexus-cli-scan")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer os.RemoveAll(dir)
+	digest, err := r.ExportOCILayout(dir, imgName, tag, tag)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Fprintf(os.Stderr, "Scanning %s:%s (%s)\n", imgName, tag, digest)
+	report, err := scan.Trivy(trivy, dir, c.String("platform"), os.Stderr)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if c.Bool("json") {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	} else {
+		counts := report.Counts()
+		t := output.NewTable("SEVERITY", "VULNERABILITIES")
+		for i := len(scan.Severities) - 1; i >= 0; i-- {
+			severity := scan.Severities[i]
+			t.Row(severity, strconv.Itoa(counts[severity]))
+		}
+		if err := t.Render(os.Stdout); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	if exitOn != "" {
+		if n := report.AtLeast(exitOn); n > 0 {
+			return cli.NewExitError(output.Red(fmt.Sprintf("%s:%s has %d vulnerabilities of severity %s or higher", imgName, tag, n, exitOn)), 1)
+		}
+	}
+	return nil
+}
+
+func evaluateImage(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	if ref := c.Args().First(); ref != "" && imgName == "" {
+		imgName, tag = splitImageRef(ref)
+	}
+	if imgName == "" || tag == "" || c.String("iq-app") == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	if c.String("iq-url") == "" {
+		return cli.NewExitError("Evaluating needs the IQ server, give --iq-url or NEXUS_IQ_URL", 1)
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	iq := sbom.IQ{URL: c.String("iq-url"), Username: c.String("iq-username"), Password: c.String("iq-password"), Timeout: c.Duration("timeout")}
+	if iq.Username == "" {
+		iq.Username, iq.Password = r.Username, r.Password
+	}
+	s, ok, err := sbom.Find(r, imgName, tag)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if !ok {
+		return cli.NewExitError(fmt.Sprintf("%s:%s has no CycloneDX SBOM attached, attach one with e.g. cosign attach sbom", imgName, tag), 1)
+	}
+	fmt.Fprintf(os.Stderr, "Evaluating the SBOM of %s:%s (%s) for %s at stage %s\n", imgName, tag, s.Referrer, c.String("iq-app"), c.String("stage"))
+	evaluation, err := iq.Evaluate(s, c.String("iq-app"), c.String("stage"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	t := output.NewTable("THREAT LEVEL", "OPEN VIOLATIONS", "COMPONENTS AFFECTED")
+	t.Row("critical", strconv.Itoa(evaluation.OpenPolicyViolations.Critical), strconv.Itoa(evaluation.ComponentsAffected.Critical))
+	t.Row("severe", strconv.Itoa(evaluation.OpenPolicyViolations.Severe), strconv.Itoa(evaluation.ComponentsAffected.Severe))
+	t.Row("moderate", strconv.Itoa(evaluation.OpenPolicyViolations.Moderate), strconv.Itoa(evaluation.ComponentsAffected.Moderate))
+	if err := t.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if evaluation.GrandfatheredPolicyViolations > 0 {
+		fmt.Println(output.Faint(fmt.Sprintf("%d violations are grandfathered", evaluation.GrandfatheredPolicyViolations)))
+	}
+	if evaluation.ReportHTMLURL != "" {
+		fmt.Printf("Report: %s\n", evaluation.ReportHTMLURL)
+	}
+	if evaluation.Failed() {
+		return cli.NewExitError(output.Red(fmt.Sprintf("%s:%s fails the policies of %s at stage %s", imgName, tag, c.String("iq-app"), c.String("stage"))), 1)
+	}
+	fmt.Printf("Policy action: %s\n", evaluation.PolicyAction)
+	return nil
+}
+
+// splitImageRef splits <image>:<tag> or a reference resolveReferences has understood, the tag is latest if there is none
+func splitImageRef(ref string) (string, string) {
+	if parsed, ok := referenced.args[ref]; ok && parsed.Tag != "" {
+		return parsed.Image, parsed.Tag
+	} else if ok {
+		return parsed.Image, "latest"
+	}
+	if i := strings.LastIndex(ref, ":"); i > 0 && !strings.Contains(ref[i:], "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+func diffImageFiles(c *cli.Context) error {
+	if c.NArg() != 2 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	image, tag := splitImageRef(c.Args().Get(0))
+	otherImage, otherTag := splitImageRef(c.Args().Get(1))
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	changes, err := r.DiffImageFiles(image, tag, otherImage, otherTag, c.String("platform"), c.Bool("ignore-mtime"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if c.Bool("json") {
+		if changes == nil {
+			changes = []registry.FileChange{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(changes); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	for _, change := range changes {
+		switch change.Kind {
+		case registry.FileAdded:
+			fmt.Println(output.Green(fmt.Sprintf("+ /%s", change.Path)))
+		case registry.FileRemoved:
+			fmt.Println(output.Red(fmt.Sprintf("- /%s", change.Path)))
+		default:
+			fmt.Println(output.Yellow(fmt.Sprintf("~ /%s (%s)", change.Path, strings.Join(change.Changes, ", "))))
+		}
+	}
+	fmt.Printf("%d files differ between %s:%s and %s:%s\n", len(changes), image, tag, otherImage, otherTag)
+	return nil
+}
+
+func listImageFiles(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	var layer = c.Int("layer")
+	if ref := c.Args().First(); ref != "" && imgName == "" {
+		imgName, tag = splitImageRef(ref)
+	}
+	if imgName == "" || tag == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	var matcher *regexp.Regexp
+	if pattern := c.String("glob"); pattern != "" {
+		var err error
+		if matcher, err = utils.GlobToRegexp(strings.TrimPrefix(pattern, "/")); err != nil {
+			return cli.NewExitError(fmt.Sprintf("Invalid --glob: %s", err), 1)
+		}
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	var files []registry.File
+	if layer < 0 {
+		fs, _, err := r.ImageFilesystem(imgName, tag, c.String("platform"), false)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for _, p := range fs.Paths() {
+			files = append(files, fs[p])
+		}
+	} else {
+		manifest, err := r.PlatformManifest(imgName, tag, c.String("platform"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if layer >= len(manifest.Layers) {
+			return cli.NewExitError(fmt.Sprintf("%s:%s has %d layers, --layer counts from 0", imgName, tag, len(manifest.Layers)), 1)
+		}
+		if files, err = r.LayerFiles(imgName, manifest.Layers[layer], layer, false); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	listed := 0
+	for _, f := range files {
+		if matcher != nil && !matcher.MatchString(f.Path) {
+			continue
+		}
+		listed++
+		line := fmt.Sprintf("%s %5d:%-5d %10d /%s", f.Mode, f.UID, f.GID, f.Size, f.Path)
+		switch {
+		case f.Type == tar.TypeSymlink:
+			line += " -> " + f.Linkname
+		case f.Type == tar.TypeLink:
+			line += " link to /" + strings.TrimPrefix(f.Linkname, "/")
+		}
+		fmt.Println(line)
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d files listed\n", listed, len(files))
+	return nil
+}
+
+func catImageFile(c *cli.Context) error {
+	if c.NArg() != 2 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	imgName, tag := splitImageRef(c.Args().Get(0))
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if err := r.CatFile(imgName, tag, c.String("platform"), c.Args().Get(1), os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+func extractImageFiles(c *cli.Context) error {
+	var dir = c.String("output")
+	if c.NArg() != 2 || dir == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	imgName, tag := splitImageRef(c.Args().Get(0))
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	stats, err := r.ExtractFiles(imgName, tag, c.String("platform"), c.Args().Get(1), dir)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%s of %s:%s has been extracted to %s: %d files, %d directories, %d links\n", c.Args().Get(1), imgName, tag, dir, stats.Files, stats.Directories, stats.Links)
+	if stats.Skipped > 0 {
+		fmt.Println(output.Yellow(fmt.Sprintf("%d devices and fifos have been skipped", stats.Skipped)))
+	}
+	return nil
+}
+
+func pullImage(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	var target = c.String("to")
+	if ref := c.Args().First(); ref != "" && imgName == "" {
+		imgName, tag = splitImageRef(ref)
+	}
+	if imgName == "" || tag == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	if target != "docker" && target != "containerd" {
+		return cli.NewExitError(fmt.Sprintf("Unknown target %s, use docker or containerd", target), 1)
+	}
+	name := c.String("as")
+	if name == "" {
+		name = imgName + ":" + tag
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	switch c.String("format") {
+	case "daemon":
+	case "oci-layout":
+		dir := c.String("output")
+		if dir == "" {
+			return cli.NewExitError("Writing an OCI layout needs the directory, give --output", 1)
+		}
+		// the ref name is the tag, unless --as names the image otherwise
+		refName := tag
+		if c.String("as") != "" {
+			refName = name
+		}
+		digest, err := r.ExportOCILayout(dir, imgName, tag, refName)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Printf("%s:%s has been written to %s as %s (%s)\n", imgName, tag, dir, refName, digest)
+		return nil
+	default:
+		return cli.NewExitError(fmt.Sprintf("Unknown format %s, use daemon or oci-layout", c.String("format")), 1)
+	}
+
+	// the archive is streamed, nothing is buffered on disk
+	archive, writer := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := r.WriteDockerArchive(writer, imgName, tag, c.String("platform"), []string{name})
+		writer.CloseWithError(err)
+		written <- err
+	}()
+
+	if target == "docker" {
+		err = daemon.LoadDocker(c.String("docker-host"), archive, os.Stdout)
+	} else {
+		err = daemon.LoadContainerd(c.String("namespace"), c.String("containerd-address"), archive, os.Stdout)
+	}
+	archive.Close()
+	// a failing download makes the daemon fail as well, its error is the one telling why. The other way round
+	// writing fails on the closed pipe
+	if writeErr := <-written; writeErr != nil && writeErr != io.ErrClosedPipe {
+		return cli.NewExitError(writeErr.Error(), 1)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%s:%s has been loaded into %s as %s\n", imgName, tag, target, name)
+	return nil
+}
+
+// shareImage writes a script downloading an image from the presigned URLs the blob store redirects to, which needs no
+// credentials. Blob stores without them, or with URLs expiring before --ttl, get the image written to a bundle instead
+func shareImage(c *cli.Context) error {
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	if ref := c.Args().First(); ref != "" && imgName == "" {
+		imgName, tag = splitImageRef(ref)
+	}
+	if imgName == "" || tag == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	name := c.String("as")
+	if name == "" {
+		name = imgName + ":" + tag
+	}
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if !c.Bool("bundle-only") {
+		share, err := r.ShareImage(imgName, tag, c.String("platform"))
+		needed := time.Now().Add(c.Duration("ttl"))
+		switch expires := share.Expires(); {
+		case registry.IsNotPresigned(err):
+			fmt.Fprintln(os.Stderr, output.Yellow(strings.SplitN(err.Error(), "\n", 2)[0]+", writing a bundle instead"))
+		case err != nil:
+			return cli.NewExitError(err.Error(), 1)
+		case !expires.IsZero() && expires.Before(needed):
+			fmt.Fprintln(os.Stderr, output.Yellow(fmt.Sprintf("The presigned URLs of the blob store expire at %s, before the --ttl of %s, writing a bundle instead",
+				expires.Format(time.RFC3339), c.Duration("ttl"))))
+		default:
+			if expires.IsZero() {
+				output.Warnf("The presigned URLs tell no expiry, the blob store decides how long they work")
+			}
+			w := os.Stdout
+			if path := c.String("output"); path != "" {
+				f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				defer f.Close()
+				w = f
+			}
+			if err := share.WriteScript(w, name); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			if w != os.Stdout {
+				fmt.Printf("%s:%s is shared by %s, running it loads the image into Docker as %s\n", imgName, tag, c.String("output"), name)
+			}
+			return nil
+		}
+	}
+
+	path := c.String("bundle")
+	if path == "" {
+		path = strings.Replace(imgName, "/", "_", -1) + "-" + tag + ".tar"
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer f.Close()
+	if err := r.WriteDockerArchive(f, imgName, tag, c.String("platform"), []string{name}); err != nil {
+		os.Remove(path)
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%s:%s has been written to %s, load it with: docker load -i %s\n", imgName, tag, path, filepath.Base(path))
+	return nil
+}
+
+func pushImage(c *cli.Context) error {
+	var imgName = c.String("name")
+	var dir = c.String("from-oci-layout")
+	if imgName == "" || dir == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	digest, tag, err := r.ImportOCILayout(dir, c.String("ref"), imgName, c.String("tag"), c.Bool("deny-overwrite"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%s has been pushed as %s:%s (%s)\n", dir, imgName, tag, digest)
+	return nil
+}
+
+func diffRepositories(c *cli.Context) error {
+	var images = c.StringSlice("name")
+	if c.NArg() != 2 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	left, right := r, r
+	leftName, rightName := c.Args().Get(0), c.Args().Get(1)
+	crawlers := []*registry.Crawler{crawlerFor(c, &left)}
+	if profile := c.String("other-profile"); profile != "" {
+		if right, _, err = registry.NewRegistryFromProfile(profile); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		registry.WithIgnoreRules(ignoreRules)(&right)
+		// another server copes with its own number of requests
+		crawlers = append(crawlers, crawlerFor(c, &right))
+		leftName = r.Host + "/" + leftName
+		rightName = right.Host + "/" + rightName
+	} else {
+		right = left
+	}
+	left.Repository = c.Args().Get(0)
+	right.Repository = c.Args().Get(1)
+	status := output.NewStatus("Comparing")
+	for _, crawler := range crawlers {
+		crawler.Observe(status)
+	}
+	defer status.Stop()
+
+	leftInventory, err := left.Inventory(images)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s: %s", leftName, err), 1)
+	}
+	rightInventory, err := right.Inventory(images)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s: %s", rightName, err), 1)
+	}
+	changes := registry.DiffInventory(leftInventory, rightInventory)
+	status.Stop()
+
+	if err := printChanges(leftName, rightName, changes, c.Bool("json")); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	for _, crawler := range crawlers {
+		printCrawlStats(crawler)
+	}
+	return nil
+}
+
+func snapshotRepository(c *cli.Context) error {
+	var output = c.String("output")
+	if output == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	crawler := crawlerFor(c, &r)
+	status := crawlStatus(crawler, "Crawling")
+	defer status.Stop()
+	snapshot, err := r.Snapshot()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	status.Stop()
+	printCrawlStats(crawler)
+	if err := snapshot.Save(output); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	tags := 0
+	for _, image := range snapshot.Images {
+		tags += len(image)
+	}
+	fmt.Printf("Snapshot of %d images and %d tags in %s saved to %s\n", len(snapshot.Images), tags, r.Repository, output)
+
+	if reporting(c) {
+		rep := &report.Report{Title: "Inventory of " + r.Repository, Host: r.Host, Started: snapshot.Created}
+		blobs, err := r.ImageBlobs(snapshot.Images)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for image, imageTags := range snapshot.Images {
+			var size int64
+			for _, s := range blobs[image] {
+				size += s
+			}
+			rep.Images = append(rep.Images, report.Image{Name: image, Tags: len(imageTags), Size: size})
+		}
+		if err := deliverReport(c, rep, nil); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	return nil
+}
+
+func verifySnapshot(c *cli.Context) error {
+	if c.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	snapshot, err := registry.LoadSnapshot(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if repository := c.String("repository"); repository != "" {
+		r.Repository = repository
+	}
+	crawler := crawlerFor(c, &r)
+	status := crawlStatus(crawler, "Crawling")
+	defer status.Stop()
+	live, err := r.Inventory(nil)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	status.Stop()
+	printCrawlStats(crawler)
+
+	changes := registry.DiffInventory(snapshot.Images, live)
+	name := fmt.Sprintf("snapshot of %s (%s)", snapshot.Repository, snapshot.Created.Format(time.RFC3339))
+	if err := printChanges(name, r.Repository, changes, c.Bool("json")); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if len(changes) > 0 {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
+
+func findLayer(c *cli.Context) error {
+	if c.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	layer := c.Args().First()
+	if !strings.Contains(layer, ":") {
+		layer = "sha256:" + layer
+	}
+
+	var matches []registry.LayerMatch
+	var repository string
+	if c.Bool("offline") {
+		ix, err := openIndex(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		defer ix.Close()
+		repository = ix.Repository
+		if matches, err = ix.FindLayer(layer, c.StringSlice("name")); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	} else {
+		r, err := loadRegistry(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		repository = r.Repository
+		if matches, err = r.FindLayer(layer, c.StringSlice("name"), c.Int("concurrency")); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	if c.Bool("json") {
+		if matches == nil {
+			matches = []registry.LayerMatch{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(matches); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	if len(matches) == 0 {
+		fmt.Printf("No image in %s contains %s\n", repository, layer)
+		return nil
+	}
+	table := output.NewTable("IMAGE", "TAG", "PLATFORM", "DIGEST")
+	for _, m := range matches {
+		table.Row(m.Image, m.Tag, m.Platform, m.Digest)
+	}
+	if err := table.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%d tags contain %s\n", len(matches), layer)
+	return nil
+}
+
+func baseImageReport(c *cli.Context) error {
+	if len(c.StringSlice("base")) == 0 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	var refs []registry.BaseRef
+	for _, base := range c.StringSlice("base") {
+		ref := registry.BaseRef{Image: base, Tag: "latest"}
+		if i := strings.LastIndex(base, ":"); i > 0 && !strings.Contains(base[i:], "/") {
+			ref = registry.BaseRef{Image: base[:i], Tag: base[i+1:]}
+		}
+		refs = append(refs, ref)
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	bases := r
+	if repository := c.String("base-repository"); repository != "" {
+		bases.Repository = repository
+	}
+	var upstream *registry.Registry
+	if c.String("upstream") != "" || c.String("upstream-profile") != "" {
+		profile := c.String("upstream-profile")
+		if profile == "" {
+			profile = c.GlobalString("profile")
+		}
+		u, _, err := registry.NewRegistryFromProfile(profile)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if repository := c.String("upstream"); repository != "" {
+			u.Repository = repository
+		}
+		upstream = &u
+	}
+	report, err := r.BaseImageReport(bases, upstream, refs, c.StringSlice("name"), c.String("platform"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	counts := map[string]int{}
+	var listed []registry.BaseUsage
+	for _, usage := range report {
+		counts[usage.Status]++
+		if !c.Bool("outdated") || usage.Status == registry.BaseOutdated || usage.Status == registry.BaseStale {
+			listed = append(listed, usage)
+		}
+	}
+
+	if c.Bool("json") {
+		if listed == nil {
+			listed = []registry.BaseUsage{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(listed); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	table := output.NewTable("IMAGE", "TAG", "BASE", "STATUS")
+	for _, usage := range listed {
+		status := usage.Status
+		switch status {
+		case registry.BaseCurrent:
+			status = output.Green(status)
+		case registry.BaseOutdated:
+			status = output.Yellow(status + ", current is " + usage.Current)
+		case registry.BaseStale:
+			status = output.Red(status + ", upstream " + usage.Current + " is " + usage.UpstreamDigest)
+		default:
+			status = output.Faint(status)
+		}
+		table.Row(usage.Image, usage.Tag, usage.Base, status)
+	}
+	if err := table.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%d images on current bases, %d on outdated bases, %d on stale bases, %d on unknown bases\n",
+		counts[registry.BaseCurrent], counts[registry.BaseOutdated], counts[registry.BaseStale], counts[registry.BaseUnknown])
+	return nil
+}
+
+func exportSBOMs(c *cli.Context) error {
+	var server = c.String("dependency-track")
+	var path = c.String("output")
+	if server == "" && path == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	if server != "" && c.String("api-key") == "" {
+		return cli.NewExitError("Uploading to Dependency-Track needs an --api-key", 1)
+	}
+	dtrack := sbom.DependencyTrack{URL: server, APIKey: c.String("api-key"), ProjectPrefix: c.String("project-prefix")}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	images := c.StringSlice("name")
+	if len(images) == 0 {
+		if images, err = r.ListImages(); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	var found []sbom.SBOM
+	failed := 0
+	for _, image := range images {
+		tags, err := r.ListTagsByImage(image)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for _, tag := range tags {
+			if registry.IsReferrerTag(tag) {
+				continue
+			}
+			s, ok, err := sbom.Find(r, image, tag)
+			if err != nil {
+				failed++
+				fmt.Println(output.Red(fmt.Sprintf("%s:%s: %s", image, tag, err)))
+				continue
+			}
+			if !ok {
+				fmt.Println(output.Faint(fmt.Sprintf("%s:%s has no CycloneDX SBOM", image, tag)))
+				continue
+			}
+			found = append(found, s)
+			if server == "" {
+				fmt.Printf("%s:%s SBOM found (%s)\n", image, tag, s.Referrer)
+				continue
+			}
+			token, err := dtrack.Upload(s)
+			if err != nil {
+				failed++
+				fmt.Println(output.Red(fmt.Sprintf("%s:%s: %s", image, tag, err)))
+				continue
+			}
+			fmt.Printf("%s:%s SBOM uploaded to project %s%s version %s (token %s)\n", image, tag, dtrack.ProjectPrefix, image, tag, token)
+		}
+	}
+
+	if path != "" {
+		content, err := sbom.Consolidate(r.Host+"/repository/"+r.Repository, found)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if err := ioutil.WriteFile(path, append(content, '\n'), 0644); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Printf("SBOMs of %d tags written to %s\n", len(found), path)
+	}
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d tags failed", failed), 1)
+	}
+	return nil
+}
+
+func invalidateCache(c *cli.Context) error {
+	var pattern = c.String("path")
+	var dryRun = !c.Bool("apply")
+	if c.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	r.Repository = c.Args().First()
+	if err := r.CheckProxy(r.Repository); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if pattern == "" && !dryRun {
+		if err := r.InvalidateCache(); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Printf("The cache of %s has been invalidated\n", r.Repository)
+	} else {
+		// Nexus invalidates whole repositories only, single entries are evicted by deleting them
+		matcher, err := utils.GlobToRegexp(strings.TrimPrefix(pattern, "/"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		assets, err := r.SearchAssets(url.Values{})
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		evicted := 0
+		for _, asset := range assets {
+			if pattern != "" && !matcher.MatchString(strings.TrimPrefix(asset.Path, "/")) {
+				continue
+			}
+			evicted++
+			if dryRun {
+				fmt.Println(output.Yellow(asset.Path + " would be evicted"))
+				continue
+			}
+			if err := r.DeleteAsset(asset.ID); err != nil {
+				return cli.NewExitError(fmt.Sprintf("%s: %s", asset.Path, err), 1)
+			}
+			fmt.Println(asset.Path + " has been evicted")
+		}
+		if pattern == "" {
+			fmt.Printf("%d cached assets in %s would be evicted, run with --apply to invalidate the cache\n", evicted, r.Repository)
+		} else if dryRun {
+			fmt.Printf("%d of %d cached assets in %s match %s, run with --apply to evict them\n", evicted, len(assets), r.Repository, pattern)
+		} else {
+			fmt.Printf("%d of %d cached assets in %s match %s\n", evicted, len(assets), r.Repository, pattern)
+		}
+	}
+
+	if c.Bool("rebuild-index") && !dryRun {
+		if err := r.RebuildIndex(); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Printf("The index of %s is being rebuilt\n", r.Repository)
+	}
+	return nil
+}
+
+// printChanges reports the differences between two inventories, left being the baseline
+func printChanges(left string, right string, changes []registry.Change, asJSON bool) error {
+	if asJSON {
+		report := struct {
+			Left    string            `json:"left"`
+			Right   string            `json:"right"`
+			Changes []registry.Change `json:"changes"`
+		}{left, right, changes}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	sections := []struct {
+		title string
+		kind  string
+	}{
+		{"Only in " + left, registry.ChangeRemoved},
+		{"Only in " + right, registry.ChangeAdded},
+		{"Different digests", registry.ChangeDigest},
+	}
+	for _, section := range sections {
+		fmt.Printf("%s:\n", section.title)
+		for _, change := range changes {
+			if change.Kind != section.kind {
+				continue
+			}
+			switch change.Kind {
+			case registry.ChangeRemoved:
+				fmt.Printf("\t%s:%s\t%s\n", change.Image, change.Tag, change.PreviousDigest)
+			case registry.ChangeAdded:
+				fmt.Printf("\t%s:%s\t%s\n", change.Image, change.Tag, change.Digest)
+			case registry.ChangeDigest:
+				fmt.Printf("\t%s:%s\t%s\t%s\n", change.Image, change.Tag, change.PreviousDigest, change.Digest)
+			}
+		}
+	}
+	fmt.Printf("There are %d differences between %s and %s\n", len(changes), left, right)
+	return nil
+}
+
+func benchmark(c *cli.Context) error {
+	var iterations = c.Int("iterations")
+	var imgName = c.String("name")
+	var tag = c.String("tag")
+	var scratch = c.String("scratch-repository")
+	if iterations < 1 {
+		return cli.NewExitError("--iterations must be at least 1", 1)
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	var results []bench.Result
+	catalog, err := bench.Catalog(r, iterations)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	results = append(results, catalog)
+
+	if imgName == "" {
+		images, err := r.ListImages()
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if len(images) > 0 {
+			imgName = images[0]
+		}
+	}
+	if imgName != "" && tag == "" {
+		tags, err := r.ListTagsByImage(imgName)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if len(tags) > 0 {
+			tag = tags[0]
+		}
+	}
+	if tag != "" {
+		manifest, err := bench.Manifest(r, imgName, tag, iterations)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		results = append(results, manifest)
+	}
+
+	if scratch != "" {
+		s := r
+		s.Repository = scratch
+		upload, download, err := bench.Blobs(s, c.Int64("blob-size"), iterations)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		results = append(results, upload, download)
+	}
+
+	t := output.NewTable("OPERATION", "RUNS", "MIN", "P50", "P90", "P99", "MAX", "THROUGHPUT")
+	for _, result := range results {
+		throughput := "-"
+		if result.Bytes > 0 {
+			throughput = utils.HumanBytes(int64(result.Throughput())) + "/s"
+		}
+		t.Row(result.Name, strconv.Itoa(len(result.Durations)), result.Percentile(0).String(), result.Percentile(50).String(),
+			result.Percentile(90).String(), result.Percentile(99).String(), result.Percentile(100).String(), throughput)
+	}
+	if err := t.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if tag == "" {
+		output.Warnf("No image found, manifest latency was not measured")
+	}
+	return nil
+}
+
+func serve(c *cli.Context) error {
+	var listen = c.String("listen")
+	var policyPath = c.String("policy")
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	s := &server.Server{Registry: r, Token: c.String("token")}
+	if policyPath != "" {
+		p, err := policy.Load(policyPath)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		s.Policy = &p
+	}
+	if s.Token == "" {
+		output.Warnf("No --token given, the API is not authenticated and POST /cleanup only makes dry runs")
+	}
+
+	log.Printf("Serving the API for %s on %s", r.Repository, listen)
+	// POST /cleanup answers once the run is done
+	if err := httpServer(listen, s, 30*time.Minute).ListenAndServe(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+// httpServer returns a server for handler on addr, whose clients can't hold connections open by being slow.
+// writeTimeout is how long a response may take
+func httpServer(addr string, handler http.Handler, writeTimeout time.Duration) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
+
+func runCleanup(c *cli.Context) error {
+	var policyPath = c.String("policy")
+	var images = c.StringSlice("image")
+	var dryRun = c.Bool("dry-run")
+	if policyPath == "" {
+		if err := cli.ShowCommandHelp(c, c.Command.Name); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	p, err := policy.Load(policyPath)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	content, err := ioutil.ReadFile(policyPath)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	policyDigest := fmt.Sprintf("%x", sha256.Sum256(content))
+	if handled, err := fanOut(c); handled {
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	r, err := loadRepositoryRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	bulk := newBulkDelete(c)
+	// the tags failing in a run of the same policy are deleted again without evaluating it
+	retried, err := bulk.retrying(c, r, "cleanup", "policy "+policyDigest)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	group, grouped, err := groupImages(c, r)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	var retriedTags map[string][]string
+	if retried != nil {
+		if grouped || len(images) > 0 {
+			return cli.NewExitError("Give either --retry-from or -image / --all-images / --image-regex / --namespace", 1)
+		}
+		images, retriedTags = retried.Images()
+	} else if grouped {
+		if len(images) > 0 {
+			return cli.NewExitError("Give either -image or --all-images / --image-regex / --namespace", 1)
+		}
+		images = group
+	} else if len(images) == 0 {
+		all, err := r.ListImages()
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for _, image := range all {
+			if !internalImage(image) {
+				images = append(images, image)
+			}
+		}
+	}
+	images = ignoreRules.Images(images)
+
+	var cp *checkpoint.Checkpoint
+	if !dryRun {
+		l, err := cleanupLock(c, r)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if l != nil {
+			if err := l.Acquire(); err != nil {
+				return cli.NewExitError(fmt.Sprintf("Another cleanup is running: %s", err), 1)
+			}
+			defer l.Release()
+			// an interrupted run gives the lock up instead of blocking others until it is stale
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-signals
+				l.Release()
+				os.Exit(1)
+			}()
+		}
+
+		// a changed policy may select other tags, it must not resume the deletions of the old one
+		job := fmt.Sprintf("cleanup %s/%s policy %s", r.Host, r.Repository, policyDigest)
+		if cp, err = openCheckpoint(c, "cleanup", r.Host+"/"+r.Repository, job); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		defer cp.Close()
+	}
+
+	statePath := perRepository(c.String("state"))
+	if reporting(c) || statePath != "" {
+		bulk.report = &report.Report{Title: "Cleanup of " + r.Repository, Host: r.Host, Started: time.Now(), DryRun: dryRun}
+	}
+	if reporting(c) {
+		bulk.measure = func(image string, tag string) int64 {
+			size, _ := r.ImageSize(image, tag)
+			return size
+		}
+	}
+	// the tags are selected up front, so what is about to be deleted can be confirmed
+	type selection struct {
+		tags    []string
+		resumed bool
+	}
+	selected := map[string]selection{}
+	for image, tags := range retriedTags {
+		// tags deleted meanwhile are no failure, images failing as a whole are evaluated again
+		if len(tags) > 0 {
+			selected[image] = selection{tags: tags, resumed: true}
+		}
+	}
+	if !dryRun {
+		status := output.NewStatus("Evaluating")
+		status.Add(len(images))
+		count := 0
+		for _, image := range images {
+			if cp.IsDone(image) {
+				continue
+			}
+			if s, ok := selected[image]; ok {
+				for _, tag := range s.tags {
+					if !cp.IsDone(image + ":" + tag) {
+						count++
+					}
+				}
+				continue
+			}
+			done := status.Start(image)
+			tags, resumed, err := policyTags(r, p, image, cp)
+			done()
+			if err != nil {
+				status.Stop()
+				return cli.NewExitError(err.Error(), 1)
+			}
+			selected[image] = selection{tags: tags, resumed: resumed}
+			for _, tag := range tags {
+				if !cp.IsDone(image + ":" + tag) {
+					count++
+				}
+			}
+		}
+		status.Stop()
+		// a repository refusing deletes would fail every tag, better once before the first
+		for _, image := range images {
+			if len(selected[image].tags) > 0 {
+				err = r.CheckDeletes(image)
+				break
+			}
+		}
+		if err == nil {
+			err = confirmDeletion(c, r, count)
+		}
+		if err != nil {
+			// there is nothing to resume, unless this was a resumed run already
+			if cp.Resumed() == 0 {
+				cp.Remove()
+			}
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	for i, image := range images {
+		if cp.IsDone(image) {
+			continue
+		}
+		if grouped {
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", i+1, len(images), image)
+		}
+		var err error
+		if s, ok := selected[image]; ok {
+			err = deletePolicyTags(r, image, s.tags, s.resumed, dryRun, bulk, cp)
+		} else {
+			err = applyPolicy(r, p, image, dryRun, bulk, cp)
+		}
+		if err != nil {
+			// the run stops, the report tells how far it got
+			bulk.failures = append(bulk.failures, deleteFailure{image: image, err: err})
+			if reportErr := deliverReport(c, bulk.report, bulk.failures); reportErr != nil {
+				fmt.Fprintln(os.Stderr, output.Red(reportErr.Error()))
+			}
+			// the images not reached are left to retry as well
+			for _, rest := range images[i+1:] {
+				if !cp.IsDone(rest) {
+					bulk.failures = append(bulk.failures, deleteFailure{image: rest, err: errors.New("Not reached, the run stopped at " + image)})
+				}
+			}
+			if saveErr := bulk.saveFailures(); saveErr != nil {
+				fmt.Fprintln(os.Stderr, output.Red(saveErr.Error()))
+			}
+			printSummary(c, r.Repository, bulk.stats)
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	if err := cp.Remove(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if grouped && !dryRun {
+		fmt.Printf("\n%d images, %d tags deleted\n", len(images), bulk.deleted)
+	}
+	if statePath != "" {
+		if err := trackChanges(statePath, bulk.report, bulk.failures, policyDigest); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	if err := deliverReport(c, bulk.report, bulk.failures); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return bulk.summary(c, r.Repository)
+}
+
+// reportFlags are the flags of the runs which can write a report of what they did and mail it
+var reportFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "report",
+		Usage: "Write a report of the run to this file, as HTML (.html) or Markdown (.md)",
+	},
+	cli.StringSliceFlag{
+		Name:  "mail-to",
+		Usage: "Mail the report to this address, can be given several times",
+	},
+	cli.StringFlag{
+		Name:   "mail-from",
+		Usage:  "Sender of the report mails",
+		EnvVar: "NEXUS_CLI_MAIL_FROM",
+	},
+	cli.StringFlag{
+		Name:   "smtp",
+		Usage:  "SMTP server to mail the report through, host:port",
+		EnvVar: "NEXUS_CLI_SMTP",
+	},
+	cli.StringFlag{
+		Name:   "smtp-username",
+		EnvVar: "NEXUS_CLI_SMTP_USERNAME",
+	},
+	cli.StringFlag{
+		Name:   "smtp-password",
+		EnvVar: "NEXUS_CLI_SMTP_PASSWORD",
+	},
+}
+
+// reporting tells if a report was asked for with reportFlags
+func reporting(c *cli.Context) bool {
+	return c.String("report") != "" || len(c.StringSlice("mail-to")) > 0
+}
+
+// deliverReport completes a report with the failures of the run, writes it and mails it as asked with reportFlags.
+// A nil report is none asked for
+func deliverReport(c *cli.Context, rep *report.Report, failures []deleteFailure) error {
+	if rep == nil {
+		return nil
+	}
+	rep.Finished = time.Now()
+	rep.Failures = append(rep.Failures, reportFailures(failures)...)
+	if path := perRepository(c.String("report")); path != "" {
+		if err := rep.Write(path); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Report written to %s\n", path)
+	}
+	if to := c.StringSlice("mail-to"); len(to) > 0 {
+		if c.String("smtp") == "" {
+			return errors.New("Mailing the report needs the SMTP server, give --smtp or NEXUS_CLI_SMTP")
+		}
+		mailer := report.SMTP{Addr: c.String("smtp"), Username: c.String("smtp-username"), Password: c.String("smtp-password"), From: c.String("mail-from"), To: to}
+		if err := mailer.Send(*rep); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Report mailed to %s\n", strings.Join(to, ", "))
+	}
+	return nil
+}
+
+// reportFailures converts the failures of a bulk delete for a report, with the first line of their error
+func reportFailures(failures []deleteFailure) []report.Failure {
+	var converted []report.Failure
+	for _, f := range failures {
+		converted = append(converted, report.Failure{Image: f.image, Tag: f.tag, Error: strings.SplitN(f.err.Error(), "\n", 2)[0]})
+	}
+	return converted
+}
+
+// trackChanges prints what the cleanup selected differently than the run which saved the state file, adds it to the
+// report and saves the result set of this run in its place. policy identifies the policy applied
+func trackChanges(path string, rep *report.Report, failures []deleteFailure, policy string) error {
+	run := *rep
+	run.Failures = append(run.Failures, reportFailures(failures)...)
+	current := run.Run(policy)
+	previous, err := report.LoadRun(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Reading the previous run from %s failed: %s", path, err))
+	}
+	if previous != nil {
+		changes := report.Compare(*previous, current)
+		rep.Changes = &changes
+		fmt.Printf("\nSince the run of %s: %d newly eligible, %d no longer eligible, %d newly protected, %d no longer protected\n",
+			previous.Started.Format(time.RFC3339), len(changes.NewlyEligible), len(changes.NoLongerEligible),
+			len(changes.NewlyProtected), len(changes.NoLongerProtected))
+		if changes.PolicyChanged {
+			fmt.Println(output.Yellow("The policy changed since"))
+		}
+		if !changes.Empty() {
+			t := output.NewTable("CHANGE", "IMAGE", "TAG", "REASON")
+			for _, tag := range changes.NewlyEligible {
+				t.Row(output.Red("newly eligible"), tag.Image, tag.Tag, "")
+			}
+			for _, tag := range changes.NoLongerEligible {
+				t.Row(output.Green("no longer eligible"), tag.Image, tag.Tag, "")
+			}
+			for _, tag := range changes.NewlyProtected {
+				t.Row(output.Yellow("newly protected"), tag.Image, tag.Tag, tag.Reason)
+			}
+			for _, tag := range changes.NoLongerProtected {
+				t.Row("no longer protected", tag.Image, tag.Tag, "")
+			}
+			if err := t.Render(os.Stdout); err != nil {
+				return err
+			}
+		}
+	}
+	return current.Save(path)
+}
+
+// resumeFlag and checkpointFlag are the flags of the long running jobs recording their progress
+var resumeFlag = cli.BoolFlag{
+	Name:  "resume",
+	Usage: "Continue the interrupted run recorded in the checkpoint instead of starting over",
+}
+
+var checkpointFlag = cli.StringFlag{
+	Name:  "checkpoint",
+	Usage: "File recording the progress, defaults to one per job in the temp directory",
+}
+
+// openCheckpoint opens the checkpoint recording a job. key picks the default file, job identifies the work done so
+// a changed job does not resume from the progress of another one
+func openCheckpoint(c *cli.Context, kind string, key string, job string) (*checkpoint.Checkpoint, error) {
+	path := perRepository(c.String("checkpoint"))
+	if path == "" {
+		sum := sha256.Sum256([]byte(key))
+		path = filepath.Join(os.TempDir(), fmt.Sprintf("nexus-cli-%s-%x.checkpoint", kind, sum[:8]))
+	}
+	resume := c.Bool("resume")
+	if !resume && checkpoint.Exists(path) {
+		fmt.Fprintln(os.Stderr, output.Yellow(fmt.Sprintf("An interrupted run left the checkpoint %s, starting over. Give --resume to continue it", path)))
+	}
+	cp, err := checkpoint.Open(path, job, resume)
+	if err != nil {
+		return nil, err
+	}
+	if resume {
+		if cp.Resumed() > 0 || cp.Unfinished() > 0 {
+			fmt.Fprintf(os.Stderr, "Resuming the interrupted run recorded in %s (done: %d)\n", path, cp.Resumed())
+		} else {
+			fmt.Fprintf(os.Stderr, "No interrupted run is recorded in %s, starting from scratch\n", path)
+		}
+	}
+	return cp, nil
+}
+
+// cleanupLock returns the lock chosen with --lock, nil for none
+func cleanupLock(c *cli.Context, r registry.Registry) (lock.Lock, error) {
+	var stale = c.Duration("lock-timeout")
+	switch c.String("lock") {
+	case "none":
+		return nil, nil
+	case "file":
+		path := perRepository(c.String("lock-file"))
+		if path == "" {
+			sum := sha256.Sum256([]byte(r.Host + "/" + r.Repository))
+			path = filepath.Join(os.TempDir(), fmt.Sprintf("nexus-cli-cleanup-%x.lock", sum[:8]))
+		}
+		return lock.NewFileLock(path, stale), nil
+	case "repository":
+		return lock.NewRepositoryLock(r, "cleanup", stale), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown lock %s, use file, repository or none", c.String("lock")))
+}
+
+func syncImages(c *cli.Context) error {
+	var path = c.String("file")
+	var dryRun = c.Bool("dry-run")
+	if path == "" {
+		if err := cli.ShowCommandHelp(c, c.Command.Name); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	spec, err := mirror.Load(path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	// endpoints without a profile use the one given on the command line
+	if profile := c.GlobalString("profile"); profile != "" {
+		if spec.Destination.Profile == "" {
+			spec.Destination.Profile = profile
+		}
+		for i := range spec.Sources {
+			if spec.Sources[i].Profile == "" {
+				spec.Sources[i].Profile = profile
+			}
+		}
+	}
+
+	if spec.Throttle, err = syncThrottle(c); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	var cp *checkpoint.Checkpoint
+	if !dryRun {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		key, err := filepath.Abs(path)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if cp, err = openCheckpoint(c, "sync", key, fmt.Sprintf("sync %x", sha256.Sum256(content))); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		defer cp.Close()
+	}
+
+	counts := map[string]int{}
+	stats := registry.NewRunStats()
+	defer printSummary(c, spec.Destination.Repository, stats)
+	spec.Ignore = ignoreRules
+	err = spec.Run(dryRun, cp, stats, func(result mirror.Result) {
+		counts[result.Action]++
+		source := result.Source + "/" + result.Image
+		if result.Tag != "" {
+			source += ":" + result.Tag
+		}
+		switch {
+		case result.Err != nil:
+			fmt.Println(output.Red(fmt.Sprintf("%s failed: %s", source, result.Err)))
+		case result.Action == mirror.ActionUpToDate:
+			fmt.Println(output.Faint(fmt.Sprintf("%s is up to date", source)))
+		case dryRun:
+			fmt.Println(output.Yellow(fmt.Sprintf("%s would be copied to %s:%s (Dry Run) ...", source, result.Target, result.Tag)))
+		default:
+			fmt.Println(output.Green(fmt.Sprintf("%s has been copied to %s:%s (%s)", source, result.Target, result.Tag, result.Digest)))
+		}
+	})
+	if registry.IsOutsideWindow(err) {
+		// not a failure, the next run in a window continues
+		fmt.Printf("%d copied, %d up to date, %d failed\n", counts[mirror.ActionCopied], counts[mirror.ActionUpToDate], counts[mirror.ActionFailed])
+		fmt.Fprintln(os.Stderr, output.Yellow(fmt.Sprintf("%s. Stopped, run the sync again with --resume to continue", err)))
+		return nil
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if err := cp.Remove(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%d copied, %d up to date, %d failed\n", counts[mirror.ActionCopied], counts[mirror.ActionUpToDate], counts[mirror.ActionFailed])
+	if counts[mirror.ActionFailed] > 0 {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
+
+// syncThrottle returns the throttle of --limit-rate and --window, nil if there is none
+func syncThrottle(c *cli.Context) (*registry.Throttle, error) {
+	t := &registry.Throttle{}
+	if value := c.String("limit-rate"); value != "" {
+		rate, err := utils.ParseBytes(value)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid --limit-rate: %s", err))
+		}
+		t.Rate = rate
+	}
+	for _, value := range c.StringSlice("window") {
+		w, err := registry.ParseWindow(value)
+		if err != nil {
+			return nil, err
+		}
+		t.Windows = append(t.Windows, w)
+	}
+	switch c.String("outside-window") {
+	case "wait":
+	case "stop":
+		t.Stop = true
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown --outside-window %s, use wait or stop", c.String("outside-window")))
+	}
+	if t.Rate == 0 && len(t.Windows) == 0 {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// applyPolicy deletes the tags of image the policy selects, or only prints them on a dry run. The selected tags are
+// recorded in cp before deleting: a resumed run finishes deleting them instead of evaluating the policy again, which
+// would select further tags once some are gone (e.g. with keep)
+func applyPolicy(r registry.Registry, p policy.Policy, image string, dryRun bool, bulk *bulkDelete, cp *checkpoint.Checkpoint) error {
+	tags, resumed, err := policyTags(r, p, image, cp)
+	if err != nil {
+		return err
+	}
+	return deletePolicyTags(r, image, tags, resumed, dryRun, bulk, cp)
+}
+
+// policyTags returns the tags of image the policy deletes, resumed tells they were recorded by an interrupted run
+func policyTags(r registry.Registry, p policy.Policy, image string, cp *checkpoint.Checkpoint) ([]string, bool, error) {
+	if tags, ok := cp.Pending(image); ok {
+		return tags, true, nil
+	}
+	tags, err := p.Evaluate(r, image)
+	if err != nil {
+		return nil, false, err
+	}
+	// the tags the rules exclude are kept, whatever the policy says
+	tags = r.IgnoreRules().Tags(image, tags)
+	return tags, false, cp.SetPending(image, tags)
+}
+
+// deletePolicyTags deletes the tags policyTags selected
+func deletePolicyTags(r registry.Registry, image string, tags []string, resumed bool, dryRun bool, bulk *bulkDelete, cp *checkpoint.Checkpoint) error {
+	var deleter *registry.TagDeleter
+	if len(tags) > 0 {
+		var err error
+		if deleter, err = bulk.tagDeleter(r, image, tags); err != nil {
+			return err
+		}
+	}
+	for _, tag := range tags {
+		if dryRun {
+			if err := bulk.wouldDelete(image, tag, deleter.Delete); err != nil {
+				return err
+			}
+			continue
+		}
+		if cp.IsDone(image + ":" + tag) {
+			continue
+		}
+		fmt.Println(output.Red(fmt.Sprintf("%s:%s image will be deleted ...", image, tag)))
+		err := bulk.delete(image, tag, func(tag string) error {
+			// the interrupted run may have deleted it without recording so
+			if err := deleter.Delete(tag); err != nil && !(resumed && registry.IsNotFound(err)) {
+				return err
+			}
+			return cp.MarkDone(image + ":" + tag)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return cp.MarkDone(image)
+}
+
+func planCleanup(c *cli.Context) error {
+	var policyPath = c.String("policy")
+	var path = c.String("output")
+	if policyPath == "" || path == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	content, err := ioutil.ReadFile(policyPath)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	p, err := policy.Load(policyPath)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	images := c.StringSlice("image")
+	group, grouped, err := groupImages(c, r)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if grouped {
+		if len(images) > 0 {
+			return cli.NewExitError("Give either -image or --all-images / --image-regex / --namespace", 1)
+		}
+		images = group
+	} else if len(images) == 0 {
+		all, err := r.ListImages()
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for _, image := range all {
+			if !internalImage(image) {
+				images = append(images, image)
+			}
+		}
+	}
+
+	gc := plan.Plan{
+		Version:      plan.Version,
+		Host:         r.Host,
+		Repository:   r.Repository,
+		Created:      time.Now().UTC(),
+		Policy:       policyPath,
+		PolicyDigest: fmt.Sprintf("sha256:%x", sha256.Sum256(content)),
+		Deletions:    []plan.Deletion{},
+	}
+	for i, image := range images {
+		fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", i+1, len(images), image)
+		tags, err := p.Evaluate(r, image)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if len(tags) == 0 {
+			continue
+		}
+		digests, err := r.TagDigests(image)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for _, tag := range tags {
+			size, err := r.ImageSize(image, tag)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			gc.Deletions = append(gc.Deletions, plan.Deletion{Image: image, Tag: tag, Digest: digests[tag], Size: size})
+		}
+	}
+
+	if len(gc.Deletions) > 0 {
+		t := output.NewTable("IMAGE", "TAG", "DIGEST", "SIZE")
+		for _, d := range gc.Deletions {
+			t.Row(d.Image, d.Tag, d.Digest, utils.HumanBytes(d.Size))
+		}
+		if err := t.Render(os.Stdout); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println()
+	}
+	if err := gc.Save(path); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("Plan of %d deletions (%s) written to %s, apply it with: nexus-cli repo gc-apply %s\n", len(gc.Deletions), utils.HumanBytes(gc.Size()), path, path)
+	return nil
+}
+
+func approvePlan(c *cli.Context) error {
+	var keyPath = c.String("key")
+	if c.NArg() != 1 || keyPath == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	path := c.Args().First()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	// what is signed must be a plan gc-apply accepts
+	gc, err := plan.Parse(path, content)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	key, err := signing.LoadPrivateKey(keyPath, readKeyPassword)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	signature, err := signing.SignBlob(content, key)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	output := c.String("output")
+	if output == "" {
+		output = path + ".sig"
+	}
+	if err := ioutil.WriteFile(output, []byte(signature+"\n"), 0644); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("Plan of %d deletions (%s) in %s on %s approved, signature written to %s\n", len(gc.Deletions), utils.HumanBytes(gc.Size()), gc.Repository, gc.Host, output)
+	return nil
+}
+
+func applyPlan(c *cli.Context) error {
+	var dryRun = c.Bool("dry-run")
+	if c.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	path := c.Args().First()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if c.Bool("insecure-unsigned") {
+		fmt.Fprintln(os.Stderr, output.Yellow(fmt.Sprintf("Applying %s without checking that it was approved (--insecure-unsigned)", path)))
+	} else {
+		approver, err := plan.Verify(path, content, c.String("signature"), append(c.StringSlice("key"), r.PlanKeys...))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Fprintf(os.Stderr, "Plan approved by %s\n", approver)
+	}
+	gc, err := plan.Parse(path, content)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if gc.Host != r.Host || gc.Repository != r.Repository {
+		return cli.NewExitError(fmt.Sprintf("The plan is for %s on %s, the profile uses %s on %s", gc.Repository, gc.Host, r.Repository, r.Host), 1)
+	}
+	fmt.Fprintf(os.Stderr, "Plan made %s with %s (%s), %d deletions\n", gc.Created.Format(time.RFC3339), gc.Policy, gc.PolicyDigest, len(gc.Deletions))
+	bulk := newBulkDelete(c)
+	retried, err := bulk.retrying(c, r, "repo gc-apply", fmt.Sprintf("plan %x", sha256.Sum256(content)))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if retried != nil {
+		// only the deletions which failed, the others are done
+		failed := map[string]bool{}
+		for _, f := range retried.Failures {
+			failed[f.Image+":"+f.Tag] = true
+		}
+		var deletions []plan.Deletion
+		for _, d := range gc.Deletions {
+			if failed[d.Image+":"+d.Tag] {
+				deletions = append(deletions, d)
+			}
+		}
+		gc.Deletions = deletions
+	}
+	if !dryRun {
+		if len(gc.Deletions) > 0 {
+			if err := r.CheckDeletes(gc.Deletions[0].Image); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+		if err := confirmDeletion(c, r, len(gc.Deletions)); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	gone := 0
+	images, deletions := gc.Images()
+	for _, image := range images {
+		var tags []string
+		for _, d := range deletions[image] {
+			tags = append(tags, d.Tag)
+		}
+		deleter, err := bulk.tagDeleter(r, image, tags)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for _, d := range deletions[image] {
+			digest, ok := deleter.Digest(d.Tag)
+			if !ok {
+				// deleted meanwhile, e.g. by an earlier run of the plan
+				fmt.Println(output.Faint(fmt.Sprintf("%s:%s is gone already", image, d.Tag)))
+				gone++
+				bulk.stats.Skipped()
+				continue
+			}
+			if digest != d.Digest {
+				// pushed again since, what the tag holds now was never reviewed
+				err := errors.New(fmt.Sprintf("points to %s now, the plan was made for %s. Make a new plan", digest, d.Digest))
+				if bulk.failFast {
+					return cli.NewExitError(fmt.Sprintf("%s:%s %s", image, d.Tag, err), 1)
+				}
+				fmt.Println(output.Yellow(fmt.Sprintf("%s:%s changed since the plan was made, skipped", image, d.Tag)))
+				bulk.failures = append(bulk.failures, deleteFailure{image: image, tag: d.Tag, err: err})
+				bulk.stats.Failed()
+				continue
+			}
+			if dryRun {
+				if err := bulk.wouldDelete(image, d.Tag, deleter.Delete); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				continue
+			}
+			fmt.Println(output.Red(fmt.Sprintf("%s:%s image will be deleted ...", image, d.Tag)))
+			if err := bulk.delete(image, d.Tag, deleter.Delete); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+	}
+	if gone > 0 {
+		fmt.Printf("%d tags of the plan were gone already\n", gone)
+	}
+	return bulk.summary(c, r.Repository)
+}
+
+// imageGroupFlags let commands work on many images at once instead of the one given with -name
+var imageGroupFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "all-images",
+		Usage: "Work on every image of the repository",
+	},
+	cli.StringFlag{
+		Name:  "image-regex",
+		Usage: "Work on every image whose name matches the regular expression, e.g. '^team-x/'",
+	},
+	namespaceFlag,
+}
+
+// namespaceFlag selects the images with nested names below a namespace, see registry.NamespaceFilter
+var namespaceFlag = cli.StringFlag{
+	Name:  "namespace",
+	Usage: "Only the images below this namespace, e.g. team/project for team/project/service",
+}
+
+// namespaceFilter narrows f to --namespace, if given
+func namespaceFilter(c *cli.Context, f registry.NameFilter) (registry.NameFilter, error) {
+	if c.String("namespace") == "" {
+		return f, nil
+	}
+	namespace, err := registry.CleanNamespace(c.String("namespace"))
+	if err != nil {
+		return f, err
+	}
+	return f.Within(namespace), nil
+}
+
+// groupImages returns the images selected with imageGroupFlags, grouped is false if none was given. --namespace
+// narrows --image-regex, alone it selects all images below the namespace. The images nexus-cli keeps its own state
+// in are left out
+func groupImages(c *cli.Context, r registry.Registry) ([]string, bool, error) {
+	var pattern = c.String("image-regex")
+	if !c.Bool("all-images") && pattern == "" && c.String("namespace") == "" {
+		return nil, false, nil
+	}
+	var images []string
+	keep := func(image string) error {
+		if !internalImage(image) {
+			images = append(images, image)
+		}
+		return nil
+	}
+	if pattern == "" && c.String("namespace") == "" {
+		catalog, err := r.ListImages()
+		if err != nil {
+			return nil, true, err
+		}
+		for _, image := range catalog {
+			keep(image)
+		}
+		return images, true, nil
+	}
+	filter := registry.NameFilter{}
+	if pattern != "" {
+		matcher, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, true, errors.New(fmt.Sprintf("Invalid --image-regex: %s", err))
+		}
+		filter = registry.RegexpNameFilter(matcher)
+	}
+	filter, err := namespaceFilter(c, filter)
+	if err != nil {
+		return nil, true, err
+	}
+	// only the part of the catalog an anchored expression or the namespace can match is listed
+	if err := r.EachImageMatching(context.Background(), filter, keep); err != nil {
+		return nil, true, err
+	}
+	return images, true, nil
+}
+
+// imageGroup is the outcome of an operation run on several images
+type imageGroup struct {
+	total    int
+	done     int
+	skipped  int
+	failures []deleteFailure
+	stats    *registry.RunStats
+}
+
+// runImageGroup runs op for every image, telling the progress on stderr. Images op finds nothing for (a not found
+// error, e.g. the tag does not exist) are skipped, other errors are collected and do not stop the others. The outcome
+// of the images is recorded on stats
+func runImageGroup(images []string, stats *registry.RunStats, op func(image string) error) *imageGroup {
+	g := &imageGroup{total: len(images), stats: stats}
+	for i, image := range images {
+		fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", i+1, len(images), image)
+		err := op(image)
+		switch {
+		case err == nil:
+			g.done++
+			stats.Succeeded()
+		case registry.IsNotFound(err):
+			fmt.Println(output.Faint(fmt.Sprintf("%s skipped: %s", image, strings.SplitN(err.Error(), "\n", 2)[0])))
+			g.skipped++
+			stats.Skipped()
+		default:
+			fmt.Println(output.Red(fmt.Sprintf("%s failed: %s", image, err)))
+			g.failures = append(g.failures, deleteFailure{image: image, err: err})
+			stats.Failed()
+		}
+	}
+	return g
+}
+
+// summary prints how many images succeeded, were skipped or failed and the summary of the run on repository,
+// failing if any failed
+func (g *imageGroup) summary(c *cli.Context, repository string) error {
+	defer printSummary(c, repository, g.stats)
+	fmt.Printf("\n%d images: %d done, %d skipped, %d failed\n", g.total, g.done, g.skipped, len(g.failures))
+	if len(g.failures) == 0 {
+		return nil
+	}
+	t := output.NewTable("IMAGE", "ERROR")
+	for _, f := range g.failures {
+		t.Row(f.image, strings.SplitN(f.err.Error(), "\n", 2)[0])
+	}
+	if err := t.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return cli.NewExitError("", 1)
+}
+
+var uploadAttrFlag = cli.StringSliceFlag{
+	Name:  "attr",
+	Usage: "Coordinate of the component as key=value, e.g. groupId=org.acme of maven2 or directory=/tools of raw, can be repeated",
+}
+
+// bulkDelete collects the tags failing to delete during a bulk delete, so one bad tag does not stop the others
+// packageCommand builds the ls, upload and delete subcommands for a format served by the components API.
+// uploadFlags are added to upload for the form fields the format needs
+func packageCommand(format string, usage string, uploadFlags ...cli.Flag) cli.Command {
+	repositoryFlag := cli.StringFlag{
+		Name:  "repository, r",
+		Usage: "The " + format + " repository, defaults to the configured one",
+	}
+	return cli.Command{
+		Name:  format,
+		Usage: usage,
+		Subcommands: []cli.Command{
+			{
+				Name:  "ls",
+				Usage: "List packages and their versions",
+				Flags: []cli.Flag{
+					repositoryFlag,
+					cli.StringFlag{
+						Name:  "name, n",
+						Usage: "Only list this package",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return listPackages(c, format)
+				},
+			},
+			{
+				Name:      "upload",
+				Usage:     "Upload package files",
+				ArgsUsage: "<file>...",
+				Flags:     append([]cli.Flag{repositoryFlag, uploadAttrFlag}, uploadFlags...),
+				Action: func(c *cli.Context) error {
+					return uploadPackages(c, format)
+				},
+			},
+			{
+				Name:  "delete",
+				Usage: "Delete versions of a package",
+				Flags: []cli.Flag{
+					repositoryFlag,
+					cli.StringFlag{
+						Name:  "name, n",
+						Usage: "The package",
+					},
+					cli.StringSliceFlag{
+						Name:  "version, v",
+						Usage: "Version to delete, can be given several times",
+					},
+					cli.IntFlag{
+						Name:  "keep, k",
+						Usage: "Delete all but the given number of newest versions",
+					},
+					cli.BoolFlag{
+						Name:  "dry-run, d",
+						Usage: "Only list the versions that would be deleted",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return deletePackages(c, format)
+				},
+			},
+		},
+	}
+}
+
+// cleanupPolicyCommand builds the commands managing the server side cleanup policies of Nexus
+func cleanupPolicyCommand() cli.Command {
+	criteriaFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Value: "docker",
+			Usage: "Repository format the policy applies to",
+		},
+		cli.StringFlag{
+			Name:  "notes",
+			Usage: "Description of the policy",
+		},
+		cli.StringFlag{
+			Name:  "last-updated",
+			Usage: "Clean up components last updated longer ago than this, in whole days, e.g. 30d",
+		},
+		cli.StringFlag{
+			Name:  "last-downloaded",
+			Usage: "Clean up components last downloaded longer ago than this, in whole days, e.g. 90d",
+		},
+		cli.StringFlag{
+			Name:  "release-type",
+			Usage: "Only clean up RELEASES or PRERELEASES",
+		},
+		cli.StringFlag{
+			Name:  "asset-regex",
+			Usage: "Only clean up components with an asset whose path matches, e.g. v2/team-x/.*",
+		},
+		cli.IntFlag{
+			Name:  "retain",
+			Usage: "Keep this number of newest versions (Nexus Pro)",
+		},
+	}
+	fromPolicyFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "from-policy",
+			Usage: "Translate the rules of a nexus-cli policy file into docker cleanup policies, one per rule",
+		},
+		cli.StringFlag{
+			Name:  "prefix",
+			Value: "nexus-cli-",
+			Usage: "Put in front of the rule names to name the policies translated with --from-policy",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run, d",
+			Usage: "Only print the policies as JSON",
+		},
+	}
+	return cli.Command{
+		Name:  "cleanup-policy",
+		Usage: "Manage the cleanup policies Nexus runs server side",
+		Subcommands: []cli.Command{
+			{
+				Name:  "list",
+				Usage: "List the cleanup policies",
+				Action: func(c *cli.Context) error {
+					return listCleanupPolicies(c)
+				},
+			},
+			{
+				Name:      "create",
+				Usage:     "Create a cleanup policy from the given criteria, or one per rule of a policy file",
+				ArgsUsage: "[<name>]",
+				Flags:     append(append([]cli.Flag{}, criteriaFlags...), fromPolicyFlags...),
+				Action: func(c *cli.Context) error {
+					return saveCleanupPolicies(c, false)
+				},
+			},
+			{
+				Name:      "update",
+				Usage:     "Change the given criteria of a cleanup policy, or replace the policies translated from a policy file",
+				ArgsUsage: "[<name>]",
+				Flags:     append(append([]cli.Flag{}, criteriaFlags...), fromPolicyFlags...),
+				Action: func(c *cli.Context) error {
+					return saveCleanupPolicies(c, true)
+				},
+			},
+			{
+				Name:      "apply-to-repo",
+				Usage:     "Make a repository run cleanup policies, in addition to the ones it has",
+				ArgsUsage: "<policy>...",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "repository, r",
+						Usage: "The repository, defaults to the configured one",
+					},
+					cli.BoolFlag{
+						Name:  "replace",
+						Usage: "Remove the policies the repository had before",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return applyCleanupPolicies(c)
+				},
+			},
+		},
+	}
+}
+
+func listCleanupPolicies(c *cli.Context) error {
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	policies, err := r.CleanupPolicies()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	days := func(value *int) string {
+		if value == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%dd", *value)
+	}
+	table := output.NewTable("NAME", "FORMAT", "LAST UPDATED", "LAST DOWNLOADED", "RELEASE TYPE", "ASSET REGEX", "RETAIN")
+	for _, p := range policies {
+		retain := "-"
+		if p.Retain != nil {
+			retain = strconv.Itoa(*p.Retain)
+		}
+		table.Row(p.Name, p.Format, days(p.CriteriaLastBlobUpdated), days(p.CriteriaLastDownloaded), p.CriteriaReleaseType, p.CriteriaAssetRegex, retain)
+	}
+	if err := table.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+// saveCleanupPolicies creates or updates policies, given by flags or translated from a policy file
+func saveCleanupPolicies(c *cli.Context, update bool) error {
+	var policies []registry.CleanupPolicy
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if path := c.String("from-policy"); path != "" {
+		p, err := policy.Load(path)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if policies, err = p.CleanupPolicies(c.String("prefix")); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	} else {
+		if c.NArg() != 1 {
+			if err := cli.ShowSubcommandHelp(c); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			return nil
+		}
+		p := registry.CleanupPolicy{Name: c.Args().First()}
+		if update {
+			// only the given criteria change
+			if p, err = r.CleanupPolicy(p.Name); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+		if err := cleanupPolicyCriteria(c, &p, update); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		policies = []registry.CleanupPolicy{p}
+	}
+
+	for _, p := range policies {
+		if c.Bool("dry-run") {
+			content, err := json.MarshalIndent(p, "", "  ")
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			fmt.Println(string(content))
+			continue
+		}
+		save, done := r.CreateCleanupPolicy, "created"
+		if update {
+			save, done = r.UpdateCleanupPolicy, "updated"
+		}
+		if err := save(p); err != nil {
+			return cli.NewExitError(fmt.Sprintf("%s: %s", p.Name, err), 1)
+		}
+		fmt.Printf("Cleanup policy %s has been %s\n", p.Name, done)
+	}
+	return nil
+}
+
+// cleanupPolicyCriteria sets the criteria given as flags. With onlySet, flags left out keep the current values
+func cleanupPolicyCriteria(c *cli.Context, p *registry.CleanupPolicy, onlySet bool) error {
+	given := func(name string) bool {
+		return !onlySet || c.IsSet(name)
+	}
+	days := func(name string) (*int, error) {
+		value := c.String(name)
+		if value == "" {
+			return nil, nil
+		}
+		d, err := utils.ParseDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 || d%(24*time.Hour) != 0 {
+			return nil, errors.New(fmt.Sprintf("--%s must be a whole number of days", name))
+		}
+		n := int(d / (24 * time.Hour))
+		return &n, nil
+	}
+
+	var err error
+	if given("format") {
+		p.Format = c.String("format")
+	}
+	if given("notes") {
+		p.Notes = c.String("notes")
+	}
+	if given("last-updated") {
+		if p.CriteriaLastBlobUpdated, err = days("last-updated"); err != nil {
+			return err
+		}
+	}
+	if given("last-downloaded") {
+		if p.CriteriaLastDownloaded, err = days("last-downloaded"); err != nil {
+			return err
+		}
+	}
+	if given("release-type") {
+		p.CriteriaReleaseType = c.String("release-type")
+	}
+	if given("asset-regex") {
+		p.CriteriaAssetRegex = c.String("asset-regex")
+	}
+	if c.IsSet("retain") {
+		retain := c.Int("retain")
+		p.Retain = &retain
+	}
+	return nil
+}
+
+func applyCleanupPolicies(c *cli.Context) error {
+	if c.NArg() == 0 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	repository := r.Repository
+	if c.String("repository") != "" {
+		repository = c.String("repository")
+	}
+	names, err := r.SetCleanupPolicies(repository, c.Args(), !c.Bool("replace"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("Repository %s runs the cleanup policies %s\n", repository, strings.Join(names, ", "))
+	return nil
+}
+
+// adminFlag has to be given to the script commands, scripts run arbitrary code on the server
+var adminFlag = cli.BoolFlag{
+	Name:  "i-know-this-is-admin",
+	Usage: "Confirm running code with administrator rights on the Nexus server",
+}
+
+// scriptCommand builds the commands of the Nexus script API, the escape hatch for what the REST API can't do
+func scriptCommand() cli.Command {
+	return cli.Command{
+		Name:  "script",
+		Usage: "Upload, run and delete groovy scripts with the Nexus script API (administrators only)",
+		Subcommands: []cli.Command{
+			{
+				Name:      "upload",
+				Usage:     "Store a groovy script, replacing the one with the same name",
+				ArgsUsage: "<file.groovy>",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "name, n",
+						Usage: "Name of the script, defaults to the file name without extension",
+					},
+					adminFlag,
+				},
+				Action: func(c *cli.Context) error {
+					return uploadScript(c)
+				},
+			},
+			{
+				Name:      "run",
+				Usage:     "Run a stored script and print what it returns",
+				ArgsUsage: "<name>",
+				Flags: []cli.Flag{
+					cli.StringSliceFlag{
+						Name:  "arg, a",
+						Usage: "key=value passed to the script in a JSON object, can be repeated",
+					},
+					cli.StringFlag{
+						Name:  "args",
+						Usage: "Pass this string to the script as is instead of --arg, @file reads it from a file",
+					},
+					cli.StringFlag{
+						Name:  "output, o",
+						Usage: "Write the result to this file instead of stdout",
+					},
+					adminFlag,
+				},
+				Action: func(c *cli.Context) error {
+					return runScript(c)
+				},
+			},
+			{
+				Name:      "delete",
+				Usage:     "Delete a stored script",
+				ArgsUsage: "<name>",
+				Flags:     []cli.Flag{adminFlag},
+				Action: func(c *cli.Context) error {
+					return deleteScript(c)
+				},
+			},
+		},
+	}
+}
+
+// loadAdminRegistry is loadRegistry for the script commands, refusing to go on without the admin flag
+func loadAdminRegistry(c *cli.Context) (registry.Registry, error) {
+	if !c.Bool(adminFlag.Name) {
+		return registry.Registry{}, errors.New(fmt.Sprintf("Scripts run with administrator rights on the Nexus server, confirm with --%s", adminFlag.Name))
+	}
+	return loadRegistry(c)
+}
+
+func uploadScript(c *cli.Context) error {
+	if c.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	r, err := loadAdminRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	path := c.Args().First()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	name := c.String("name")
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := r.UploadScript(registry.Script{Name: name, Content: string(content)}); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("Script %s has been uploaded\n", name)
+	return nil
+}
+
+func runScript(c *cli.Context) error {
+	if c.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	r, err := loadAdminRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	args, err := scriptArgs(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	result, err := r.RunScript(c.Args().First(), args)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if path := c.String("output"); path != "" {
+		if err := ioutil.WriteFile(path, []byte(result.Result), 0644); err != nil {
+			return cli.NewExitError(err.Error(), 1)
 		}
-	} else {
-		r, err := registry.NewRegistry()
+		return nil
+	}
+	fmt.Println(result.Result)
+	return nil
+}
+
+// scriptArgs builds the argument string of a script run, either given raw with --args or as JSON object of the --arg pairs
+func scriptArgs(c *cli.Context) (string, error) {
+	if raw := c.String("args"); raw != "" {
+		if len(c.StringSlice("arg")) > 0 {
+			return "", errors.New("Give either --args or --arg")
+		}
+		if strings.HasPrefix(raw, "@") {
+			content, err := ioutil.ReadFile(raw[1:])
+			return string(content), err
+		}
+		return raw, nil
+	}
+	if len(c.StringSlice("arg")) == 0 {
+		return "", nil
+	}
+	args := map[string]string{}
+	for _, pair := range c.StringSlice("arg") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return "", errors.New(fmt.Sprintf("--arg %s is not key=value", pair))
+		}
+		args[parts[0]] = parts[1]
+	}
+	content, err := json.Marshal(args)
+	return string(content), err
+}
+
+func deleteScript(c *cli.Context) error {
+	if c.NArg() != 1 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	r, err := loadAdminRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if err := r.DeleteScript(c.Args().First()); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("Script %s has been deleted\n", c.Args().First())
+	return nil
+}
+
+// nugetCommand builds the nuget subcommands. Pushes and deletes use the NuGet protocol with the API key of the
+// profile, or the components API if there is none
+func nugetCommand() cli.Command {
+	repositoryFlag := cli.StringFlag{
+		Name:  "repository, r",
+		Usage: "The nuget repository, defaults to the configured one",
+	}
+	apiKeyFlag := cli.StringFlag{
+		Name:   "api-key",
+		EnvVar: "NUGET_API_KEY",
+		Usage:  "NuGet API key, defaults to nuget_api_key of the profile",
+	}
+	command := packageCommand("nuget", "Manage packages in nuget hosted repositories")
+	for i := range command.Subcommands {
+		command.Subcommands[i].Flags = append(command.Subcommands[i].Flags, apiKeyFlag)
+	}
+	command.Subcommands[0].Name, command.Subcommands[0].Aliases = "list", []string{"ls"}
+	command.Subcommands[1] = cli.Command{
+		Name:      "push",
+		Usage:     "Push .nupkg files",
+		ArgsUsage: "<file>...",
+		Flags:     []cli.Flag{repositoryFlag, apiKeyFlag},
+		Action: func(c *cli.Context) error {
+			return uploadPackages(c, "nuget")
+		},
+	}
+	return command
+}
+
+// goCommand builds the go subcommands. Go repositories are proxies, versions can only be purged from the cache
+func goCommand() cli.Command {
+	command := packageCommand("go", "List and purge modules cached by go proxy repositories")
+	purge := command.Subcommands[2]
+	purge.Name, purge.Usage = "purge", "Purge versions of a module from the proxy cache, e.g. retracted or leaked ones"
+	purge.Flags = append(purge.Flags, cli.BoolFlag{
+		Name:  "invalidate-cache",
+		Usage: "Invalidate the cache of the repository afterwards, so the version list of the module is fetched again",
+	})
+	command.Subcommands = []cli.Command{command.Subcommands[0], purge}
+	return command
+}
+
+// goModulePath decodes the case encoding of module paths in go proxies, github.com/!azure is github.com/Azure
+func goModulePath(path string) string {
+	var decoded []rune
+	upper := false
+	for _, r := range path {
+		switch {
+		case r == '!':
+			upper = true
+			continue
+		case upper:
+			r = []rune(strings.ToUpper(string(r)))[0]
+		}
+		upper = false
+		decoded = append(decoded, r)
+	}
+	return string(decoded)
+}
+
+// goEncodedModulePath applies the case encoding of go proxies, github.com/Azure is github.com/!azure
+func goEncodedModulePath(path string) string {
+	var encoded []rune
+	for _, r := range goModulePath(path) {
+		if lower := []rune(strings.ToLower(string(r)))[0]; lower != r {
+			encoded = append(encoded, '!', lower)
+			continue
+		}
+		encoded = append(encoded, r)
+	}
+	return string(encoded)
+}
+
+// loadPackageRegistry is loadRegistry with the repository of a package command
+func loadPackageRegistry(c *cli.Context) (registry.Registry, error) {
+	r, profile, err := registry.NewRegistryFromProfile(c.GlobalString("profile"))
+	if err != nil {
+		return r, err
+	}
+	if repository := c.String("repository"); repository != "" {
+		r.Repository = repository
+	}
+	if apiKey := c.String("api-key"); apiKey != "" {
+		r.NuGetAPIKey = apiKey
+	}
+	fmt.Fprintf(os.Stderr, "Using profile %s: %s, repository %s\n", profile, r.Host, r.Repository)
+	if c.GlobalBool("verbose") {
+		fmt.Fprintf(os.Stderr, "HTTP protocol %s\n", r.Protocol())
+	}
+	return r, nil
+}
+
+// compareVersions returns the version ordering of a format
+func compareVersions(format string) func(a string, b string) int {
+	switch format {
+	case "pypi":
+		return utils.ComparePythonVersions
+	case "nuget", "go":
+		return utils.CompareSemanticVersions
+	}
+	return utils.CompareVersions
+}
+
+// samePackage compares package names the way the format does
+func samePackage(format string, a string, b string) bool {
+	switch format {
+	case "pypi":
+		return utils.NormalizePythonName(a) == utils.NormalizePythonName(b)
+	case "nuget":
+		return strings.EqualFold(a, b)
+	case "go":
+		return goModulePath(a) == goModulePath(b)
+	}
+	return a == b
+}
+
+// packages searches the components of a format, sorted by name and version
+func packages(r registry.Registry, format string, name string) ([]registry.Component, error) {
+	names := []string{name}
+	switch {
+	case format == "pypi":
+		// Nexus keeps projects under their normalized name
+		names = []string{utils.NormalizePythonName(name)}
+	case format == "go" && goModulePath(name) != goEncodedModulePath(name):
+		// modules may be stored with the case encoding of the proxy protocol or without
+		names = []string{goModulePath(name), goEncodedModulePath(name)}
+	}
+
+	var components []registry.Component
+	seen := map[string]bool{}
+	for _, name := range names {
+		query := url.Values{}
+		query.Set("format", format)
+		if name != "" {
+			query.Set("name", name)
+		}
+		found, err := r.SearchComponents(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, component := range found {
+			if !seen[component.ID] {
+				seen[component.ID] = true
+				components = append(components, component)
+			}
+		}
+	}
+	sort.SliceStable(components, func(i, j int) bool {
+		if components[i].Name != components[j].Name {
+			return components[i].Name < components[j].Name
+		}
+		return compareVersions(format)(components[i].Version, components[j].Version) < 0
+	})
+	return components, nil
+}
+
+func listPackages(c *cli.Context, format string) error {
+	r, err := loadPackageRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	components, err := packages(r, format, c.String("name"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	table := output.NewTable("PACKAGE", "VERSION", "PATH")
+	for _, component := range components {
+		path := ""
+		if len(component.Assets) > 0 {
+			path = component.Assets[0].Path
+		}
+		if len(component.Assets) > 1 {
+			path += fmt.Sprintf(" (+%d files)", len(component.Assets)-1)
+		}
+		table.Row(component.Name, component.Version, path)
+	}
+	if err := table.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("There are %d packages in %s\n", len(components), r.Repository)
+	return nil
+}
+
+// uploadPackages uploads the files to the repository of the format, the format of the repository when it is empty
+func uploadPackages(c *cli.Context, format string) error {
+	if c.NArg() == 0 {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	attributes := map[string]string{}
+	for _, pair := range c.StringSlice("attr") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return cli.NewExitError(fmt.Sprintf("--attr %s is not key=value", pair), 1)
+		}
+		attributes[kv[0]] = kv[1]
+	}
+	if directory := c.String("directory"); directory != "" {
+		attributes["directory"] = directory
+	}
+	r, err := loadPackageRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if format == "" {
+		if format, err = r.RepositoryFormat(r.Repository); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	uploads, err := registry.PrepareUploads(format, c.Args(), attributes)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	for _, upload := range uploads {
+		var paths []string
+		for _, asset := range upload.Assets {
+			paths = append(paths, asset.Path)
+		}
+		if format == "nuget" {
+			err = r.PushNuGetPackage(paths[0])
+		} else {
+			err = r.Upload(upload)
+		}
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("%s: %s", strings.Join(paths, ", "), err), 1)
+		}
+		fmt.Printf("%s has been uploaded to %s\n", strings.Join(paths, ", "), r.Repository)
+	}
+	return nil
+}
+
+func deletePackages(c *cli.Context, format string) error {
+	var name = c.String("name")
+	var versions = c.StringSlice("version")
+	var keep = c.Int("keep")
+	var dryRun = c.Bool("dry-run")
+	if name == "" || len(versions) == 0 && !c.IsSet("keep") {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	r, err := loadPackageRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	components, err := packages(r, format, name)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	// the search matches names by wildcard, and one version may come as several components (e.g. architectures)
+	var distinct []string
+	exists := map[string]bool{}
+	for _, component := range components {
+		if samePackage(format, component.Name, name) && !exists[component.Version] {
+			exists[component.Version] = true
+			distinct = append(distinct, component.Version)
+		}
+	}
+	doomed := map[string]bool{}
+	for _, version := range versions {
+		if !exists[version] {
+			return cli.NewExitError(fmt.Sprintf("%s has no version %s", name, version), 1)
+		}
+		doomed[version] = true
+	}
+	if c.IsSet("keep") {
+		for i := 0; i < len(distinct)-keep; i++ {
+			doomed[distinct[i]] = true
+		}
+	}
+
+	failed := 0
+	for _, component := range components {
+		if !samePackage(format, component.Name, name) || !doomed[component.Version] {
+			continue
+		}
+		label := component.Name + " " + component.Version
+		if len(component.Assets) > 0 {
+			// versions may consist of several components, e.g. one per architecture
+			label += " (" + component.Assets[0].Path + ")"
+		}
+		if dryRun {
+			fmt.Println(output.Yellow(label + " would be deleted"))
+			continue
+		}
+		del := func() error { return r.DeleteComponent(component.ID) }
+		if format == "nuget" && r.NuGetAPIKey != "" {
+			del = func() error { return r.DeleteNuGetPackage(component.Name, component.Version) }
+		}
+		if err := del(); err != nil {
+			failed++
+			fmt.Println(output.Red(fmt.Sprintf("%s could not be deleted: %s", label, err)))
+			continue
+		}
+		fmt.Println(output.Red(label + " has been successfully deleted"))
+	}
+	if c.Bool("invalidate-cache") && !dryRun {
+		if err := r.InvalidateCache(); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Printf("The cache of %s has been invalidated\n", r.Repository)
+	}
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d versions could not be deleted", failed), 1)
+	}
+	return nil
+}
+
+type bulkDelete struct {
+	// dryRun checks the tags like a deletion does without deleting them, see registry.TagDeleter
+	dryRun   bool
+	failFast bool
+	// force and untag decide about tags sharing their manifest with tags which are kept, see registry.TagDeleter
+	force bool
+	untag bool
+	// quarantine is the repository the tags are copied to before they are deleted, empty to delete them for good
+	quarantine string
+	deleted    int
+	failures   []deleteFailure
+	// report records the tags for the report of the run if one was asked for, measure tells their size
+	report  *report.Report
+	measure func(image string, tag string) int64
+	// failuresPath is where --failures writes the failures of the run, to be retried with --retry-from
+	failuresPath string
+	retry        retry.File
+	// manifests are the deployment manifests of --manifests, the tags they declare are kept. declared holds their
+	// images once read, by image name
+	manifests []string
+	hosts     []string
+	declared  map[string][]declaredTag
+	// stats records what became of the tags for the summary of the run
+	stats *registry.RunStats
+	// referrers lists the artifacts deleted along with a tag, for the dry runs of --with-referrers. Nil without
+	referrers func(tag string) ([]registry.Referrer, error)
+}
+
+// declaredTag is a tag, or a digest, a deployment manifest declares
+type declaredTag struct {
+	tag    string
+	digest string
+	file   string
+}
+
+// manifestsFlags are the flags of the bulk deletes keeping the tags deployment manifests declare
+var manifestsFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:  "manifests",
+		Usage: "Keep the tags the Kubernetes manifests, Compose files and Helm values in this file or directory (e.g. a GitOps checkout) declare, can be given several times",
+	},
+	registryHostFlag,
+}
+
+// failureFlags are the flags of the bulk deletes leaving their failures to be retried later
+var failureFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "failures",
+		Usage: "Write the tags which could not be deleted to this JSON file, to retry them with --retry-from",
+	},
+	cli.StringFlag{
+		Name:  "retry-from",
+		Usage: "Only retry the tags a previous run wrote to this failures file, instead of selecting them again",
+	},
+}
+
+// sharedDigestFlags are the flags of the commands deleting tags which may share their manifest with other tags
+var sharedDigestFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "force",
+		Usage: "Delete tags even if other tags point to the same manifest, these are deleted as well",
+	},
+	cli.BoolFlag{
+		Name:  "untag",
+		Usage: "Only delete the tag, through the components API, if other tags point to the same manifest",
+	},
+}
+
+// confirmFlags are the flags of the commands deleting tags, see confirmDeletion
+var confirmFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "yes",
+		Usage: "Do not ask for the repository name before deleting many tags",
+	},
+	cli.IntFlag{
+		Name:   "confirm-threshold",
+		Usage:  "Deleting more tags than this at once asks for the repository name, overriding confirm_threshold of the profile (10)",
+		EnvVar: "NEXUS_CLI_CONFIRM_THRESHOLD",
+	},
+}
+
+// confirmDeletion tells on stderr in which environment, host and repository count tags are about to be deleted, so
+// a session on the wrong profile stands out. When more tags than the threshold are deleted, the repository name has
+// to be typed to go on. Only terminals are asked, scheduled runs go on as before
+func confirmDeletion(c *cli.Context, r registry.Registry, count int) error {
+	if count == 0 {
+		return nil
+	}
+	environment := r.Environment
+	if environment == "" {
+		environment = c.GlobalString("profile")
+		if environment == "" {
+			config, err := registry.LoadConfig()
+			if err != nil {
+				return err
+			}
+			environment = config.Current()
+		}
+	}
+	host := r.Host
+	if u, err := url.Parse(r.Host); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	fmt.Fprintln(os.Stderr, output.Red(fmt.Sprintf("%s %s / %s: %d tags will be deleted", strings.ToUpper(environment), host, r.Repository, count)))
+
+	threshold := r.ConfirmThreshold
+	if c.IsSet("confirm-threshold") {
+		threshold = c.Int("confirm-threshold")
+	} else if threshold == 0 {
+		threshold = registry.DefaultConfirmThreshold
+	}
+	if count <= threshold || c.Bool("yes") || !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Type the name of the repository to delete them: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if strings.TrimSpace(answer) != r.Repository {
+		return errors.New(fmt.Sprintf("%q is not %s, nothing was deleted", strings.TrimSpace(answer), r.Repository))
+	}
+	return nil
+}
+
+// quarantineFlag turns the deletions of a command into soft deletions, see registry.Registry.Quarantine
+var quarantineFlag = cli.StringFlag{
+	Name:   "quarantine",
+	Usage:  "Copy the tags to this repository, e.g. docker-trash, before deleting them, so 'quarantine restore' can bring them back",
+	EnvVar: "NEXUS_CLI_QUARANTINE",
+}
+
+func newBulkDelete(c *cli.Context) *bulkDelete {
+	return &bulkDelete{dryRun: c.Bool("dry-run"), failFast: c.Bool("fail-fast"), force: c.Bool("force"), untag: c.Bool("untag"),
+		quarantine: c.String("quarantine"), manifests: c.StringSlice("manifests"), hosts: c.StringSlice("registry-host"),
+		stats: registry.NewRunStats()}
+}
+
+// retrying prepares writing the failures of the run with --failures and loads those to retry with --retry-from, nil
+// without. job identifies the work of command, failures of other work are not retried, see retry.File
+func (b *bulkDelete) retrying(c *cli.Context, r registry.Registry, command string, job string) (*retry.File, error) {
+	// a dry run fails nothing, it must not replace the failures of the last real run
+	if path := perRepository(c.String("failures")); path != "" && !c.Bool("dry-run") {
+		b.failuresPath = path
+		b.retry = retry.File{Command: command, Host: r.Host, Repository: r.Repository, Job: job}
+	}
+	path := perRepository(c.String("retry-from"))
+	if path == "" {
+		return nil, nil
+	}
+	f, err := retry.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Check(command, r.Host, r.Repository, job); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Retrying %d failures of the run of %s\n", len(f.Failures), f.Created.Format(time.RFC3339))
+	return &f, nil
+}
+
+// saveFailures writes the failures of the run with --failures, none if everything was deleted
+func (b *bulkDelete) saveFailures() error {
+	if b.failuresPath == "" {
+		return nil
+	}
+	f := b.retry
+	f.Created = time.Now().UTC()
+	for _, failure := range b.failures {
+		f.Failures = append(f.Failures, retry.Failure{Image: failure.image, Tag: failure.tag, Error: strings.SplitN(failure.err.Error(), "\n", 2)[0]})
+	}
+	if err := f.Save(b.failuresPath); err != nil {
+		return err
+	}
+	if len(f.Failures) > 0 {
+		fmt.Fprintf(os.Stderr, "%d failures written to %s, retry them with --retry-from %s\n", len(f.Failures), b.failuresPath, b.failuresPath)
+	}
+	return nil
+}
+
+// tagDeleter prepares deleting the selected tags of image
+func (b *bulkDelete) tagDeleter(r registry.Registry, image string, tags []string) (*registry.TagDeleter, error) {
+	registry.WithRunStats(b.stats)(&r)
+	d, err := r.NewTagDeleter(image, tags)
+	if err != nil {
+		return nil, err
+	}
+	d.Force, d.Untag, d.DryRun = b.force, b.untag, b.dryRun
+	if len(b.manifests) > 0 && b.declared == nil {
+		if b.declared, err = declaredTags(r, b.manifests, b.hosts); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range b.declared[image] {
+		d.Keep(t.tag, t.digest, t.file)
+	}
+	if b.quarantine != "" {
+		q, err := r.Quarantine(b.quarantine)
+		if err != nil {
+			return nil, err
+		}
+		d.Quarantine = &q
+	}
+	return d, nil
+}
+
+// declaredTags reads the images the deployment manifests declare, those of the repository by image name
+func declaredTags(r registry.Registry, manifests []string, hosts []string) (map[string][]declaredTag, error) {
+	ours := pulledFrom(r, hosts)
+	declared := map[string][]declaredTag{}
+	count := 0
+	for _, root := range manifests {
+		images, err := k8s.ManifestImages(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range images {
+			host, name, tag, digest := k8s.SplitImage(d.Image)
+			if !ours(host) {
+				continue
+			}
+			if tag == "" && digest == "" {
+				tag = "latest"
+			}
+			declared[name] = append(declared[name], declaredTag{tag: tag, digest: digest, file: d.File})
+			count++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d references to images of %s found in %s, their tags are kept\n", count, r.Repository, strings.Join(manifests, ", "))
+	return declared, nil
+}
+
+type deleteFailure struct {
+	image string
+	tag   string
+	err   error
+}
+
+// delete deletes a tag with del and records the outcome. Only with failFast the error is returned
+func (b *bulkDelete) delete(image string, tag string, del func(tag string) error) error {
+	if b.dryRun {
+		return b.wouldDelete(image, tag, del)
+	}
+	// the size is gone once the tag is
+	size := b.size(image, tag)
+	err := del(tag)
+	if registry.IsProtected(err) {
+		// locked tags are left alone on purpose, they are no failure
+		reason := strings.SplitN(err.Error(), "\n", 2)[0]
+		fmt.Println(output.Yellow(reason + ", skipped"))
+		if b.report != nil {
+			b.report.Skipped = append(b.report.Skipped, report.Tag{Image: image, Tag: tag, Reason: reason})
+		}
+		return nil
+	}
+	if err != nil {
+		events.Failed("delete", image+":"+tag, err)
+		// the next tags would fail the same way
+		if b.failFast || registry.IsDeleteDisabled(err) {
+			return err
+		}
+		fmt.Printf("%s:%s could not be deleted, continuing ...\n", image, tag)
+		b.failures = append(b.failures, deleteFailure{image: image, tag: tag, err: err})
+		return nil
+	}
+	b.deleted++
+	events.Done("delete", image+":"+tag, b.deleted, 0)
+	b.stats.AddBytes(size)
+	b.record(image, tag, size)
+	return nil
+}
+
+// wouldDelete prints what deleting a tag with del, of a TagDeleter in dry run, would do: the tags which would be
+// refused are told, as in a real run, and with --with-referrers the artifacts deleted along. Only with failFast the
+// error is returned
+func (b *bulkDelete) wouldDelete(image string, tag string, del func(tag string) error) error {
+	err := del(tag)
+	reason := ""
+	if err != nil {
+		reason = strings.SplitN(err.Error(), "\n", 2)[0]
+	}
+	switch {
+	case registry.IsProtected(err):
+		fmt.Println(output.Yellow(reason + ", would be skipped (Dry Run)"))
+		if b.report != nil {
+			b.report.Skipped = append(b.report.Skipped, report.Tag{Image: image, Tag: tag, Reason: reason})
+		}
+	case err != nil:
+		if b.failFast {
+			return err
+		}
+		fmt.Println(output.Yellow("Would not be deleted (Dry Run): " + reason))
+	default:
+		fmt.Println(output.Yellow(fmt.Sprintf("%s:%s image would be deleted (Dry Run) ...", image, tag)))
+		b.record(image, tag, b.size(image, tag))
+		if b.referrers == nil {
+			return nil
+		}
+		referrers, err := b.referrers(tag)
 		if err != nil {
+			return err
+		}
+		printWouldDeleteReferrers(image, referrers)
+	}
+	return nil
+}
+
+// printWouldDeleteReferrers prints the artifacts a dry run of --with-referrers would delete along
+func printWouldDeleteReferrers(image string, referrers []registry.Referrer) {
+	for _, referrer := range referrers {
+		fmt.Println(output.Yellow(fmt.Sprintf("%s@%s (%s) would be deleted along with it (Dry Run)", image, referrer.Digest, referrer.ArtifactType)))
+	}
+}
+
+// size measures a tag for the report, 0 without one
+func (b *bulkDelete) size(image string, tag string) int64 {
+	if b.report == nil || b.measure == nil {
+		return 0
+	}
+	return b.measure(image, tag)
+}
+
+// record adds a deleted tag to the report, if there is one
+func (b *bulkDelete) record(image string, tag string, size int64) {
+	if b.report != nil {
+		b.report.Deleted = append(b.report.Deleted, report.Tag{Image: image, Tag: tag, Size: size})
+	}
+}
+
+// summary prints the failed tags with the error codes the registry gave and the summary of the run on repository,
+// failing if there are any
+func (b *bulkDelete) summary(c *cli.Context, repository string) error {
+	defer printSummary(c, repository, b.stats)
+	if err := b.saveFailures(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if len(b.failures) == 0 {
+		return nil
+	}
+	fmt.Printf("\n%d tags deleted, %s:\n", b.deleted, output.Red(fmt.Sprintf("%d failed", len(b.failures))))
+	t := output.NewTable("IMAGE", "TAG", "STATUS", "CODE", "MESSAGE")
+	for _, f := range b.failures {
+		status, code, message := "-", "-", f.err.Error()
+		if e, ok := f.err.(*registry.Error); ok {
+			status = strconv.Itoa(e.StatusCode)
+			if e.Code != "" {
+				code = e.Code
+			}
+			if e.Message != "" {
+				message = e.Message
+			}
+		}
+		t.Row(f.image, f.tag, output.Red(status), output.Red(code), message)
+	}
+	if err := t.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	// the hints repeat for each tag, show them once
+	hints := map[string]bool{}
+	for _, f := range b.failures {
+		if e, ok := f.err.(*registry.Error); ok && e.Hint != "" && !hints[e.Hint] {
+			hints[e.Hint] = true
+			fmt.Println(e.Hint)
+		}
+	}
+	return cli.NewExitError("", 1)
+}
+
+func listen(c *cli.Context) error {
+	var bind = c.String("bind")
+	var policyPath = c.String("on-push")
+	var mirrorTo = c.String("mirror-to")
+	var mirrorDeletes = c.Bool("mirror-deletes")
+	var dryRun = c.Bool("dry-run")
+	if policyPath == "" && mirrorTo == "" {
+		return cli.NewExitError("Nothing to do, give --on-push and/or --mirror-to", 1)
+	}
+
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	var p policy.Policy
+	if policyPath != "" {
+		if p, err = policy.Load(policyPath); err != nil {
 			return cli.NewExitError(err.Error(), 1)
 		}
-		if tag == "" {
-			if keep == 0 {
-				if _,err := fmt.Fprintf(c.App.Writer, "You should either specify the tag or how many images you want to keep\n"); err != nil {
-					return cli.NewExitError(err.Error(), 1)
-				}
-				if err := cli.ShowSubcommandHelp(c); err != nil {
-					return cli.NewExitError(err.Error(), 1)
-				}
-			} else {
-				tags, err := r.ListTagsByImage(imgName)
+	}
+	mirror := r
+	mirror.Repository = mirrorTo
+	if c.String("secret") == "" {
+		output.Warnf("No --secret given, webhook deliveries are not verified")
+	}
 
-				compareStringNumber := getSortComparisonStrategy(sort)
-				utils.Compare(compareStringNumber).Sort(tags)
+	// events are handled one after another, so actions never race each other on the same tags. The deletes queued
+	// up are handled together, the tags of an image share one TagDeleter which lists the tags of the mirror once
+	events := make(chan webhook.Event, 100)
+	go func() {
+		var deletedImages []string
+		deletedTags := map[string][]string{}
+		flush := func() {
+			for _, image := range deletedImages {
+				deleteFromMirror(mirror, image, deletedTags[image])
+			}
+			deletedImages, deletedTags = nil, map[string][]string{}
+		}
+		handle := func(event webhook.Event) {
+			if event.RepositoryName != r.Repository || event.Component.Format != "docker" {
+				return
+			}
+			image, tag := event.Component.Name, event.Component.Version
+			log.Printf("%s %s:%s by %s", event.Action, image, tag, event.Initiator)
 
-				if err != nil {
-					return cli.NewExitError(err.Error(), 1)
+			switch event.Action {
+			case "CREATED", "UPDATED":
+				// the tag may be pushed again right after it was deleted
+				flush()
+				if mirrorTo != "" {
+					if dryRun {
+						log.Printf("%s:%s would be mirrored to %s (Dry Run)", image, tag, mirrorTo)
+					} else if _, err := registry.CopyImage(r, image, tag, mirror, image, tag, true); err != nil {
+						log.Printf("Mirroring %s:%s to %s failed: %s", image, tag, mirrorTo, err)
+					} else {
+						log.Printf("%s:%s has been mirrored to %s", image, tag, mirrorTo)
+					}
 				}
-				if len(tags) >= keep {
-					for _, tag := range tags[:len(tags)-keep] {
-						if dryRun {
-							fmt.Printf("%s:%s image would be deleted (Dry Run) ...\n", imgName, tag)
-						} else {
-							fmt.Printf("%s:%s image will be deleted ...\n", imgName, tag)
-							if err := r.DeleteImageByTag(imgName, tag); err != nil {
-								return cli.NewExitError(err.Error(), 1)
-							}
-						}
+				if policyPath != "" {
+					if err := applyPolicy(r, p, image, dryRun, &bulkDelete{dryRun: dryRun, failFast: true}, nil); err != nil {
+						log.Printf("Applying %s to %s failed: %s", policyPath, image, err)
 					}
-				} else {
-					fmt.Printf("Only %d images are available\n", len(tags))
 				}
-			}
-		} else if strings.Contains(tag, ",") { // credits to https://github.com/mlabouardy/nexus-cli/pull/28
-			tags := strings.Split(tag, ",")
-			for _, value := range tags {
-				err = r.DeleteImageByTag(imgName, value)
-				if err != nil {
-					return cli.NewExitError(err.Error(), 1)
+			case "DELETED":
+				if mirrorTo != "" && mirrorDeletes {
+					if dryRun {
+						log.Printf("%s:%s would be deleted from %s (Dry Run)", image, tag, mirrorTo)
+					} else {
+						if deletedTags[image] == nil {
+							deletedImages = append(deletedImages, image)
+						}
+						deletedTags[image] = append(deletedTags[image], tag)
+					}
 				}
 			}
-		} else {
-			err = r.DeleteImageByTag(imgName, tag)
-			if err != nil {
-				return cli.NewExitError(err.Error(), 1)
+		}
+		for event := range events {
+			handle(event)
+			if len(events) == 0 {
+				flush()
 			}
 		}
+	}()
+
+	handler := webhook.Handler{Secret: []byte(c.String("secret")), Events: events}
+	log.Printf("Listening for Nexus webhooks on %s (repository %s)", bind, r.Repository)
+	// deliveries are only queued, they are answered at once
+	if err := httpServer(bind, handler, 30*time.Second).ListenAndServe(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
 	}
 	return nil
 }
 
-func getSortComparisonStrategy(sort string) func(str1, str2 string) bool {
-	var compareStringNumber func(str1, str2 string) bool
+// deleteFromMirror deletes tags of image deleted upstream from the mirror. They are selected together, tags sharing
+// a manifest with each other are deleted along
+func deleteFromMirror(mirror registry.Registry, image string, tags []string) {
+	d, err := mirror.NewTagDeleter(image, tags)
+	if err != nil {
+		log.Printf("Deleting %s:%s from %s failed: %s", image, strings.Join(tags, ","), mirror.Repository, err)
+		return
+	}
+	for _, tag := range tags {
+		if err := d.Delete(tag); err != nil {
+			log.Printf("Deleting %s:%s from %s failed: %s", image, tag, mirror.Repository, err)
+		}
+	}
+}
 
-	if sort == "default" || sort == "semver" {
-		compareStringNumber = func(str1, str2 string) bool {
-			if str1 == "latest" {
-				return false
-			}
-			if str2 == "latest" {
+// blobCommand builds the commands moving single blobs, e.g. to fetch a layer for debugging or to seed a config blob
+func blobCommand() cli.Command {
+	nameFlag := cli.StringFlag{
+		Name:  "name, n",
+		Usage: "The image the blob belongs to, the registry API serves blobs only through an image",
+	}
+	repositoryFlag := cli.StringFlag{
+		Name:  "repository, repo, r",
+		Usage: "The docker repository, defaults to the configured one",
+	}
+	return cli.Command{
+		Name:  "blob",
+		Usage: "Download and upload blobs by digest",
+		Subcommands: []cli.Command{
+			{
+				Name:      "get",
+				Usage:     "Download a blob, verifying its content against the digest",
+				ArgsUsage: "<digest>",
+				Flags: []cli.Flag{
+					nameFlag,
+					repositoryFlag,
+					cli.StringFlag{
+						Name:  "output, o",
+						Usage: "File to write the blob to, - for stdout",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return getBlob(c)
+				},
+			},
+			{
+				Name:      "put",
+				Usage:     "Upload a file as blob and print its digest, blobs the registry has already are not sent again",
+				ArgsUsage: "<file>",
+				Flags:     []cli.Flag{nameFlag, repositoryFlag},
+				Action: func(c *cli.Context) error {
+					return putBlob(c)
+				},
+			},
+		},
+	}
+}
+
+func k8sCommand() cli.Command {
+	return cli.Command{
+		Name:  "k8s",
+		Usage: "Find the tags deployed in Kubernetes clusters",
+		Subcommands: []cli.Command{
+			{
+				Name:  "scan",
+				Usage: "Lock the tags the workloads of a cluster run, so cleanups leave them alone, and release those not deployed anymore",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "kubeconfig",
+						Usage: "Path to the kubeconfig, defaults to the first file of KUBECONFIG or ~/.kube/config. Inside a pod the service account is used without one",
+					},
+					cli.StringFlag{
+						Name:  "context",
+						Usage: "Context of the kubeconfig, defaults to the current one",
+					},
+					cli.StringFlag{
+						Name:  "cluster",
+						Usage: "Name of the cluster in the locks, defaults to the context",
+					},
+					cli.StringFlag{
+						Name:  "kube-namespace",
+						Usage: "Only scan this Kubernetes namespace, defaults to all of them",
+					},
+					registryHostFlag,
+					cli.BoolFlag{
+						Name:  "dry-run, d",
+						Usage: "Only list the tags in use, do not lock them",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return scanCluster(c)
+				},
+			},
+		},
+	}
+}
+
+// registryHostFlag names the hosts the images of the repository are pulled from, for finding them in deployments
+var registryHostFlag = cli.StringSliceFlag{
+	Name:  "registry-host",
+	Usage: "Host images of the repository are pulled from, e.g. nexus.example.com:8082, can be given several times. Defaults to the host of the profile on any port",
+}
+
+// pulledFrom tells if images with the registry host of a reference are pulled from the repository: one of hosts, if
+// given, otherwise the host of the profile on any port, as the docker connectors of Nexus listen on their own
+func pulledFrom(r registry.Registry, hosts []string) func(host string) bool {
+	return func(host string) bool {
+		if len(hosts) == 0 {
+			own, err := url.Parse(r.Host)
+			return err == nil && host != "" && strings.SplitN(host, ":", 2)[0] == own.Hostname()
+		}
+		for _, h := range hosts {
+			if host == h {
 				return true
 			}
-			version1, err1 := semver.Make(str1)
-			if err1 != nil {
-				fmt.Printf("Error parsing version1: %q\n", err1)
+		}
+		return false
+	}
+}
+
+// deployedTag is a tag of the repository the workloads of a cluster run
+type deployedTag struct {
+	image     string
+	tag       string
+	workloads []string
+}
+
+func scanCluster(c *cli.Context) error {
+	r, err := loadRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	kubeconfig := c.String("kubeconfig")
+	if kubeconfig == "" {
+		kubeconfig = k8s.DefaultKubeconfig()
+	}
+	client, err := k8s.NewClient(kubeconfig, c.String("context"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	cluster := c.String("cluster")
+	if cluster == "" {
+		cluster = client.Context
+	}
+	// a scan of one namespace must not release the tags the others run
+	namespace := c.String("kube-namespace")
+	if namespace != "" {
+		cluster += "/" + namespace
+	}
+	containers, err := client.Containers(namespace)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Listing the workloads of %s failed: %s", client.Server, err), 1)
+	}
+
+	ours := pulledFrom(r, c.StringSlice("registry-host"))
+
+	// the tags and digests each image is deployed with, and by which workloads
+	type deployment struct {
+		tag       string
+		digest    string
+		workloads string
+	}
+	deployments := map[string][]deployment{}
+	var images []string
+	for _, container := range containers {
+		host, name, tag, digest := k8s.SplitImage(container.Image)
+		if !ours(host) {
+			continue
+		}
+		if container.Digest != "" {
+			digest = container.Digest
+		}
+		if _, ok := deployments[name]; !ok {
+			images = append(images, name)
+		}
+		deployments[name] = append(deployments[name], deployment{tag: tag, digest: digest, workloads: container.Namespace + "/" + container.Workload})
+	}
+	sort.Strings(images)
+
+	var deployed []deployedTag
+	for _, image := range images {
+		digests, err := r.TagDigests(image)
+		if registry.IsNotFound(err) {
+			fmt.Println(output.Faint(fmt.Sprintf("%s is not in %s, skipped", image, r.Repository)))
+			continue
+		} else if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		workloads := map[string]map[string]bool{}
+		use := func(tag string, workload string) {
+			if workloads[tag] == nil {
+				workloads[tag] = map[string]bool{}
+			}
+			workloads[tag][workload] = true
+		}
+		for _, d := range deployments[image] {
+			if _, ok := digests[d.tag]; ok {
+				use(d.tag, d.workloads)
+			}
+			// the tag may have been pushed again since, what runs is what the digest points to
+			for tag, digest := range digests {
+				if d.digest != "" && digest == d.digest {
+					use(tag, d.workloads)
+				}
 			}
-			version2, err2 := semver.Make(str2)
-			if err2 != nil {
-				fmt.Printf("Error parsing version2: %q\n", err2)
+		}
+		tags := make([]string, 0, len(workloads))
+		for tag := range workloads {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			var names []string
+			for workload := range workloads[tag] {
+				names = append(names, workload)
 			}
-			return version1.LT(version2)
+			sort.Strings(names)
+			deployed = append(deployed, deployedTag{image: image, tag: tag, workloads: names})
+		}
+	}
+
+	t := output.NewTable("IMAGE", "TAG", "WORKLOADS")
+	var inUse []registry.Protection
+	inUseImages := map[string]bool{}
+	for _, d := range deployed {
+		inUseImages[d.image] = true
+		t.Row(d.image, d.tag, strings.Join(d.workloads, ", "))
+		reason := fmt.Sprintf("in use in %s: %s", cluster, d.workloads[0])
+		if len(d.workloads) > 1 {
+			reason += fmt.Sprintf(" and %d more", len(d.workloads)-1)
+		}
+		inUse = append(inUse, registry.Protection{Image: d.image, Tag: d.tag, Reason: reason})
+	}
+	if err := t.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if c.Bool("dry-run") {
+		fmt.Printf("%d tags of %d images are in use in %s (Dry Run)\n", len(deployed), len(inUseImages), cluster)
+		return nil
+	}
+	added, released, err := r.ProtectInUse(cluster, inUse)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%d tags of %d images are in use in %s: %d newly locked, %d released\n", len(deployed), len(inUseImages), cluster, added, released)
+	return nil
+}
+
+func cacheCommand() cli.Command {
+	return cli.Command{
+		Name:  "cache",
+		Usage: "Inspect and clear the blob cache of --blob-cache-dir",
+		Subcommands: []cli.Command{
+			{
+				Name:  "stats",
+				Usage: "Show how many blobs the cache holds and their size",
+				Action: func(c *cli.Context) error {
+					return cacheStats(c)
+				},
+			},
+			{
+				Name:  "clear",
+				Usage: "Remove the blobs of the cache",
+				Flags: []cli.Flag{
+					cli.DurationFlag{
+						Name:  "older-than",
+						Usage: "Only remove the blobs not used for this long, e.g. 720h",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return clearCache(c)
+				},
+			},
+		},
+	}
+}
+
+func cacheStats(c *cli.Context) error {
+	stats, err := registry.BlobCache()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	limit := "none"
+	if stats.MaxSize > 0 {
+		limit = utils.HumanBytes(stats.MaxSize)
+	}
+	fmt.Printf("Directory: %s\n", stats.Dir)
+	fmt.Printf("Blobs:     %d\n", stats.Blobs)
+	fmt.Printf("Size:      %s (limit %s)\n", utils.HumanBytes(stats.Size), limit)
+	if stats.Blobs > 0 {
+		fmt.Printf("Used:      %s to %s\n", stats.Oldest.Format(time.RFC3339), stats.Newest.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func clearCache(c *cli.Context) error {
+	removed, freed, err := registry.ClearBlobCache(c.Duration("older-than"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%d blobs removed, %s freed\n", removed, utils.HumanBytes(freed))
+	return nil
+}
+
+// internalImage tells if image holds what nexus-cli keeps in the repository, commands working on all images skip it
+func internalImage(image string) bool {
+	return cleanup.Internal(image)
+}
+
+func quarantineCommand() cli.Command {
+	quarantine := cli.StringFlag{
+		Name:   "quarantine",
+		Usage:  "The quarantine repository, e.g. docker-trash",
+		EnvVar: "NEXUS_CLI_QUARANTINE",
+	}
+	return cli.Command{
+		Name:  "quarantine",
+		Usage: "List, restore and purge the tags deleted with --quarantine",
+		Subcommands: []cli.Command{
+			{
+				Name:  "ls",
+				Usage: "List the quarantined tags of all repositories",
+				Flags: []cli.Flag{quarantine},
+				Action: func(c *cli.Context) error {
+					return listQuarantine(c)
+				},
+			},
+			{
+				Name:  "restore",
+				Usage: "Copy quarantined tags back to the repository they were deleted from",
+				Flags: []cli.Flag{
+					quarantine,
+					cli.StringFlag{
+						Name: "name, n",
+					},
+					cli.StringFlag{
+						Name:  "tag, t",
+						Usage: "Give one or more comma-separated tags to restore, defaults to all quarantined tags of the image",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return restoreQuarantine(c)
+				},
+			},
+			{
+				Name:  "purge",
+				Usage: "Delete the tags quarantined for longer than the retention for good",
+				Flags: []cli.Flag{
+					quarantine,
+					cli.StringFlag{
+						Name:   "older-than",
+						Value:  "30d",
+						Usage:  "Retention of the quarantine, e.g. 30d, 2w or 0 to purge all",
+						EnvVar: "NEXUS_CLI_QUARANTINE_RETENTION",
+					},
+					cli.StringFlag{
+						Name:  "name, n",
+						Usage: "Only purge the tags of this image of the repository of the profile",
+					},
+					cli.StringFlag{
+						Name:  "tag, t",
+						Usage: "Give one or more comma-separated tags of --name to purge",
+					},
+					cli.BoolFlag{
+						Name: "dry-run, d",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return purgeQuarantine(c)
+				},
+			},
+		},
+	}
+}
+
+// loadQuarantine returns the registry of the profile and its quarantine, the entries of the quarantine of image
+// (all if empty) are returned, of the tags given as comma-separated list (all if empty)
+func loadQuarantine(c *cli.Context, image string, tags string) (registry.Registry, registry.Registry, []registry.Quarantined, error) {
+	r, err := loadRegistry(c)
+	if err != nil {
+		return r, r, nil, err
+	}
+	name := c.String("quarantine")
+	if name == "" {
+		return r, r, nil, errors.New("Give the quarantine repository with --quarantine")
+	}
+	q, err := r.Quarantine(name)
+	if err != nil {
+		return r, q, nil, err
+	}
+	quarantined, err := q.QuarantinedTags()
+	if err != nil {
+		return r, q, nil, err
+	}
+	if image == "" {
+		return r, q, quarantined, nil
+	}
+	selected := map[string]bool{}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag != "" {
+			selected[tag] = true
+		}
+	}
+	var matching []registry.Quarantined
+	for _, e := range quarantined {
+		if e.Repository == r.Repository && e.Image == image && (len(selected) == 0 || selected[e.Tag]) {
+			matching = append(matching, e)
+		}
+	}
+	return r, q, matching, nil
+}
+
+func listQuarantine(c *cli.Context) error {
+	_, _, quarantined, err := loadQuarantine(c, "", "")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	t := output.NewTable("REPOSITORY", "IMAGE", "TAG", "DIGEST", "QUARANTINED")
+	for _, e := range quarantined {
+		t.Row(e.Repository, e.Image, e.Tag, e.Digest, e.Quarantined.Format(time.RFC3339))
+	}
+	if err := t.Render(os.Stdout); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("There are %d quarantined tags\n", len(quarantined))
+	return nil
+}
+
+func restoreQuarantine(c *cli.Context) error {
+	var imgName = c.String("name")
+	if imgName == "" {
+		if err := cli.ShowSubcommandHelp(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
 		}
+		return nil
 	}
+	r, q, quarantined, err := loadQuarantine(c, imgName, c.String("tag"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if len(quarantined) == 0 {
+		return cli.NewExitError(fmt.Sprintf("No tags of %s of %s are in the quarantine %s", imgName, r.Repository, q.Repository), 1)
+	}
+	for _, e := range quarantined {
+		if err := q.Restore(r, e); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println(output.Green(fmt.Sprintf("%s:%s has been restored to %s (%s)", e.Image, e.Tag, r.Repository, e.Digest)))
+	}
+	return nil
+}
+
+func purgeQuarantine(c *cli.Context) error {
+	var imgName = c.String("name")
+	var dryRun = c.Bool("dry-run")
+	if imgName == "" && c.String("tag") != "" {
+		return cli.NewExitError("--tag needs --name", 1)
+	}
+	retention, err := utils.ParseDuration(c.String("older-than"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Invalid --older-than %s: %s", c.String("older-than"), err), 1)
+	}
+	_, q, quarantined, err := loadQuarantine(c, imgName, c.String("tag"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	purged := 0
+	for _, e := range quarantined {
+		if time.Since(e.Quarantined) < retention {
+			continue
+		}
+		purged++
+		label := fmt.Sprintf("%s/%s:%s", e.Repository, e.Image, e.Tag)
+		if dryRun {
+			fmt.Println(output.Yellow(label + " would be purged (Dry Run)"))
+			continue
+		}
+		if err := q.Purge(e); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println(output.Red(label + " has been purged"))
+	}
+	if dryRun {
+		fmt.Printf("%d of %d quarantined tags would be purged\n", purged, len(quarantined))
+	} else {
+		fmt.Printf("%d of %d quarantined tags purged\n", purged, len(quarantined))
+	}
+	return nil
+}
+
+// loadBlobRegistry is loadRegistry with the repository of a blob command
+func loadBlobRegistry(c *cli.Context) (registry.Registry, error) {
+	r, err := loadRegistry(c)
+	if err != nil {
+		return r, err
+	}
+	if repository := c.String("repository"); repository != "" {
+		r.Repository = repository
+	}
+	return r, nil
+}
 
-	return compareStringNumber
+func getBlob(c *cli.Context) error {
+	var digest = c.Args().First()
+	var imgName = c.String("name")
+	var path = c.String("output")
+	if digest == "" || imgName == "" || path == "" {
+		cli.ShowSubcommandHelp(c)
+		return cli.NewExitError("A digest, the image and the output file are required", 1)
+	}
+	r, err := loadBlobRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if path == "-" {
+		if _, err := r.DownloadBlob(imgName, digest, os.Stdout); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+	// download next to the destination and only move it there once verified, a broken download leaves nothing behind
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer os.Remove(tmp.Name())
+	size, err := r.DownloadBlob(imgName, digest, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Fprintf(os.Stderr, "%s (%s) has been written to %s\n", digest, utils.HumanBytes(size), path)
+	return nil
+}
+
+func putBlob(c *cli.Context) error {
+	var path = c.Args().First()
+	var imgName = c.String("name")
+	if path == "" || imgName == "" {
+		cli.ShowSubcommandHelp(c)
+		return cli.NewExitError("A file and the image are required", 1)
+	}
+	r, err := loadBlobRegistry(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	digest, size, uploaded, err := r.UploadFile(imgName, path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if uploaded {
+		fmt.Fprintf(os.Stderr, "%s (%s) has been uploaded to %s\n", path, utils.HumanBytes(size), imgName)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s is already in %s, nothing to upload\n", path, imgName)
+	}
+	fmt.Println(digest)
+	return nil
 }
@@ -0,0 +1,86 @@
+// Package output renders terminal output: colors, warnings and tables. Colors are only used on terminals and can
+// be switched off with NO_COLOR (https://no-color.org) or --no-color
+package output
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"regexp"
+)
+
+const (
+	reset  = "\x1b[0m"
+	bold   = "\x1b[1m"
+	faint  = "\x1b[2m"
+	red    = "\x1b[31m"
+	green  = "\x1b[32m"
+	yellow = "\x1b[33m"
+)
+
+var (
+	stdoutColor = detect(os.Stdout)
+	stderrColor = detect(os.Stderr)
+)
+
+func detect(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return terminal.IsTerminal(int(f.Fd()))
+}
+
+// Configure applies the --no-color flag, colors are detected per stream otherwise
+func Configure(noColor bool) {
+	if noColor {
+		stdoutColor = false
+		stderrColor = false
+	}
+}
+
+func paint(enabled bool, color string, s string) string {
+	if !enabled || s == "" {
+		return s
+	}
+	return color + s + reset
+}
+
+// Red marks deletions and failures
+func Red(s string) string {
+	return paint(stdoutColor, red, s)
+}
+
+// Yellow marks dry runs and warnings
+func Yellow(s string) string {
+	return paint(stdoutColor, yellow, s)
+}
+
+// Green marks successes
+func Green(s string) string {
+	return paint(stdoutColor, green, s)
+}
+
+// Bold marks headers and names
+func Bold(s string) string {
+	return paint(stdoutColor, bold, s)
+}
+
+// Faint marks details of lesser interest
+func Faint(s string) string {
+	return paint(stdoutColor, faint, s)
+}
+
+// Warnf prints a warning to stderr
+func Warnf(format string, args ...interface{}) {
+	fmt.Fprintln(os.Stderr, paint(stderrColor, yellow, "Warning: "+fmt.Sprintf(format, args...)))
+}
+
+var escapes = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// Width is the number of columns s takes on a terminal, ignoring color escapes
+func Width(s string) int {
+	return len([]rune(escapes.ReplaceAllString(s, "")))
+}
@@ -0,0 +1,52 @@
+package output
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// PrefixWriter writes the lines written to it to another writer, each prefixed, e.g. with the repository it is about
+// when the output of several runs is interleaved. Writers sharing a mutex never mix their lines
+type PrefixWriter struct {
+	out     io.Writer
+	prefix  []byte
+	mu      *sync.Mutex
+	partial []byte
+}
+
+// NewPrefixWriter creates a PrefixWriter writing to out under mu
+func NewPrefixWriter(out io.Writer, prefix string, mu *sync.Mutex) *PrefixWriter {
+	return &PrefixWriter{out: out, prefix: []byte(prefix), mu: mu}
+}
+
+func (w *PrefixWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	end := bytes.LastIndexByte(w.partial, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	var lines bytes.Buffer
+	for _, line := range bytes.SplitAfter(w.partial[:end+1], []byte("\n")) {
+		if len(line) > 0 {
+			lines.Write(w.prefix)
+			lines.Write(line)
+		}
+	}
+	w.partial = append(w.partial[:0], w.partial[end+1:]...)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.out.Write(lines.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes what follows the last line break
+func (w *PrefixWriter) Flush() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+	_, err := w.Write([]byte("\n"))
+	return err
+}
@@ -0,0 +1,177 @@
+package output
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Sink puts the output as an object to S3 or a service speaking its API (MinIO, Ceph), signed with signature
+// version 4
+type s3Sink struct {
+	bucket    string
+	key       string
+	endpoint  string
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+	body      bytes.Buffer
+}
+
+// NewS3Sink creates a sink putting the output to an s3://bucket/key URL. The credentials are taken from the standard
+// AWS environment: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, the region from AWS_REGION or
+// AWS_DEFAULT_REGION. AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL) points to other services than AWS, which are addressed
+// with the bucket in the path
+func NewS3Sink(uri string) (Sink, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || u.Host == "" || key == "" || strings.HasSuffix(key, "/") {
+		return nil, errors.New(fmt.Sprintf("%s is no s3://bucket/key URL", uri))
+	}
+	s := &s3Sink{
+		bucket:    u.Host,
+		key:       key,
+		region:    firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		endpoint:  strings.TrimSuffix(firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"), "/"),
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("Writing to S3 needs the credentials in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	return s, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (s *s3Sink) Write(p []byte) (int, error) {
+	return s.body.Write(p)
+}
+
+func (s *s3Sink) Close() error {
+	objectURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, s3Escape(s.key))
+	if s.endpoint != "" {
+		objectURL = fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, s3Escape(s.key))
+	}
+	req, err := http.NewRequest("PUT", objectURL, bytes.NewReader(s.body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType(s.body.Bytes()))
+	sum := sha256.Sum256(s.body.Bytes())
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+	// sent as signed, Go would leave more characters unescaped
+	req.URL.RawPath = s3Escape(req.URL.Path)
+	signV4(req, s.accessKey, s.secretKey, s.region, "s3", time.Now())
+	return send(req)
+}
+
+func (s *s3Sink) String() string {
+	return "s3://" + s.bucket + "/" + s.key
+}
+
+// signV4 signs a request with AWS signature version 4, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html. The X-Amz-Content-Sha256 header has
+// to be set already, all headers of the request are signed
+func signV4(req *http.Request, accessKey string, secretKey string, region string, service string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, queryEscape(name)+"="+queryEscape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func queryEscape(s string) string {
+	return strings.Replace(s3Escape(s), "/", "%2F", -1)
+}
+
+// s3Escape percent-encodes all but the unreserved characters of RFC 3986 and the slashes, as signature version 4
+// expects the path
+func s3Escape(s string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9', b == '-', b == '.', b == '_', b == '~', b == '/':
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,161 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Sink is a destination for the output of a command, e.g. for scheduled jobs publishing where dashboards read it.
+// What is written is buffered and published by Close, so readers never see half a report
+type Sink interface {
+	io.Writer
+	Close() error
+	// String names where the output goes, for messages
+	String() string
+}
+
+// fileSink writes the output to a file, by renaming a temporary file next to it
+type fileSink struct {
+	path string
+	tmp  *os.File
+}
+
+// NewFileSink creates a sink replacing the file at path
+func NewFileSink(path string) (Sink, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{path: path, tmp: tmp}, nil
+}
+
+func (s *fileSink) Write(p []byte) (int, error) {
+	return s.tmp.Write(p)
+}
+
+func (s *fileSink) Close() error {
+	if err := s.tmp.Close(); err != nil {
+		os.Remove(s.tmp.Name())
+		return err
+	}
+	// TempFile creates the file readable by its owner only
+	if err := os.Chmod(s.tmp.Name(), 0644); err != nil {
+		os.Remove(s.tmp.Name())
+		return err
+	}
+	return os.Rename(s.tmp.Name(), s.path)
+}
+
+func (s *fileSink) String() string {
+	return s.path
+}
+
+// httpSink posts the output to a URL
+type httpSink struct {
+	url     string
+	headers http.Header
+	body    bytes.Buffer
+}
+
+// NewHTTPSink creates a sink posting the output to url with the headers, given as "Name: value". The content type is
+// application/json for JSON output and text/plain otherwise, unless a header sets it
+func NewHTTPSink(url string, headers []string) (Sink, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, errors.New(fmt.Sprintf("%s is no http:// or https:// URL", url))
+	}
+	s := &httpSink{url: url, headers: http.Header{}}
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.New(fmt.Sprintf("Header %q is not in the form Name: value", header))
+		}
+		s.headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return s, nil
+}
+
+func (s *httpSink) Write(p []byte) (int, error) {
+	return s.body.Write(p)
+}
+
+func (s *httpSink) Close() error {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(s.body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType(s.body.Bytes()))
+	for name, values := range s.headers {
+		req.Header[name] = values
+	}
+	return send(req)
+}
+
+func (s *httpSink) String() string {
+	return s.url
+}
+
+// contentType tells JSON output from text
+func contentType(body []byte) string {
+	if json.Valid(body) {
+		return "application/json"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// send sends a request publishing output, failing unless it is answered with a success status
+func send(req *http.Request) error {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.New(fmt.Sprintf("%s %s: %s %s", req.Method, req.URL, resp.Status, strings.TrimSpace(string(body))))
+	}
+	return nil
+}
+
+// Capture sends what is written to stdout to the sinks instead, uncolored, until the function it returns is called.
+// That one puts stdout back and closes the sinks, which publishes the output. It returns the first error of them
+func Capture(sinks ...Sink) (func() error, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	writers := make([]io.Writer, len(sinks))
+	for i, sink := range sinks {
+		writers[i] = sink
+	}
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.MultiWriter(writers...), reader)
+		// keep draining, a writer blocked on a full pipe would never return
+		io.Copy(ioutil.Discard, reader)
+		copied <- err
+	}()
+	stdout, color := os.Stdout, stdoutColor
+	os.Stdout, stdoutColor = writer, false
+	return func() error {
+		os.Stdout, stdoutColor = stdout, color
+		writer.Close()
+		err := <-copied
+		reader.Close()
+		for _, sink := range sinks {
+			if closeErr := sink.Close(); closeErr != nil && err == nil {
+				err = errors.New(fmt.Sprintf("Writing the output to %s failed: %s", sink, closeErr))
+			}
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,30 @@
+package output
+
+// sparks are the bars of a sparkline, from the lowest value to the highest
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline draws the values as a line of bars, scaled from the lowest value to the highest. All values the same
+// are drawn as the lowest bar
+func Sparkline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	low, high := values[0], values[0]
+	for _, v := range values {
+		if v < low {
+			low = v
+		}
+		if v > high {
+			high = v
+		}
+	}
+	line := make([]rune, len(values))
+	for i, v := range values {
+		bar := 0
+		if high > low {
+			bar = int((v - low) / (high - low) * float64(len(sparks)-1))
+		}
+		line[i] = sparks[bar]
+	}
+	return string(line)
+}
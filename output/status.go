@@ -0,0 +1,230 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/events"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// statusRefresh is how often the live status is redrawn on terminals, statusLogInterval how often a line is
+	// logged instead when stderr is no terminal
+	statusRefresh     = 200 * time.Millisecond
+	statusLogInterval = 10 * time.Second
+	// statusLines is how many workers the live status shows at most, the others are summed up
+	statusLines = 12
+)
+
+// Status renders what parallel workers do on stderr: a line per worker with its current item below an overall
+// counter, redrawn in place. When stderr is no terminal (CI logs, cron mails) a summary line is logged every ten
+// seconds instead. All methods may be called from several goroutines
+type Status struct {
+	title string
+	out   io.Writer
+	live  bool
+	width int
+
+	mu      sync.Mutex
+	items   []string
+	busy    []bool
+	done    int
+	total   int
+	drawn   int
+	started time.Time
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewStatus starts rendering a status titled e.g. "Checking" on stderr, Stop ends it
+func NewStatus(title string) *Status {
+	s := &Status{
+		title:   title,
+		out:     os.Stderr,
+		live:    os.Getenv("TERM") != "dumb" && terminal.IsTerminal(int(os.Stderr.Fd())),
+		started: time.Now(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if s.live {
+		if width, _, err := terminal.GetSize(int(os.Stderr.Fd())); err == nil {
+			s.width = width
+		}
+	}
+	go s.run()
+	return s
+}
+
+func (s *Status) run() {
+	defer close(s.stopped)
+	interval := statusLogInterval
+	if s.live {
+		interval = statusRefresh
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.live {
+				s.redraw()
+			} else {
+				fmt.Fprintln(s.out, s.summary())
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Add announces n more items, the counter shows how many of them are done
+func (s *Status) Add(n int) {
+	s.mu.Lock()
+	s.total += n
+	s.mu.Unlock()
+}
+
+// Start shows that a worker works on item, the returned function tells it is done with it
+func (s *Status) Start(item string) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot := -1
+	for i, busy := range s.busy {
+		if !busy {
+			slot = i
+			break
+		}
+	}
+	if slot < 0 {
+		slot = len(s.busy)
+		s.busy = append(s.busy, false)
+		s.items = append(s.items, "")
+	}
+	s.busy[slot] = true
+	s.items[slot] = item
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.busy[slot] = false
+			s.items[slot] = ""
+			s.done++
+			events.Done(s.title, item, s.done, s.total)
+		})
+	}
+}
+
+// Printf prints a line above the live status, fmt.Print to stderr would garble it
+func (s *Status) Printf(format string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.erase()
+	fmt.Fprintf(s.out, format, args...)
+	if s.live {
+		s.redraw()
+	}
+}
+
+// Stop ends the status and removes it from the terminal, what follows is printed as usual
+func (s *Status) Stop() {
+	select {
+	case <-s.stop:
+		return
+	default:
+	}
+	close(s.stop)
+	<-s.stopped
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.erase()
+}
+
+// summary is the counter line, with the items worked on for log lines
+func (s *Status) summary() string {
+	var running []string
+	for i, busy := range s.busy {
+		if busy {
+			running = append(running, s.items[i])
+		}
+	}
+	line := fmt.Sprintf("%s: %d", s.title, s.done)
+	if s.total > 0 {
+		line += fmt.Sprintf("/%d", s.total)
+	}
+	line += fmt.Sprintf(" done in %s, %d running", time.Since(s.started).Round(time.Second), len(running))
+	if !s.live && len(running) > 0 {
+		if len(running) > 3 {
+			running = append(running[:3], "...")
+		}
+		line += ": " + strings.Join(running, ", ")
+	}
+	return line
+}
+
+// redraw draws the status over the one drawn before. Lines are cut to the width of the terminal, wrapped ones would
+// move the cursor elsewhere than redraw expects. Called with mu held
+func (s *Status) redraw() {
+	lines := []string{s.summary()}
+	hidden := 0
+	for i, busy := range s.busy {
+		if i >= statusLines {
+			if busy {
+				hidden++
+			}
+			continue
+		}
+		item := ""
+		if busy {
+			item = Faint(fmt.Sprintf("  [%d] %s", i+1, s.items[i]))
+		}
+		lines = append(lines, item)
+	}
+	if hidden > 0 {
+		lines = append(lines, Faint(fmt.Sprintf("  and %d more", hidden)))
+	}
+	// lines are never taken away while running, the status must not leave stale lines behind
+	for len(lines) < s.drawn {
+		lines = append(lines, "")
+	}
+
+	var b strings.Builder
+	if s.drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", s.drawn)
+	}
+	for _, line := range lines {
+		if s.width > 0 && Width(line) >= s.width {
+			line = truncate(line, s.width-1)
+		}
+		b.WriteString("\r\x1b[2K" + line + "\n")
+	}
+	io.WriteString(s.out, b.String())
+	s.drawn = len(lines)
+}
+
+// erase clears the lines drawn and leaves the cursor where they started. Called with mu held
+func (s *Status) erase() {
+	if s.drawn == 0 {
+		return
+	}
+	fmt.Fprintf(s.out, "\x1b[%dA\r\x1b[J", s.drawn)
+	s.drawn = 0
+}
+
+// truncate cuts s to n columns, dropping its colors
+func truncate(s string, n int) string {
+	runes := []rune(escapes.ReplaceAllString(s, ""))
+	if len(runes) > n {
+		runes = runes[:n]
+	}
+	return string(runes)
+}
@@ -0,0 +1,66 @@
+package output
+
+import (
+	"io"
+	"strings"
+)
+
+// Table aligns rows in columns like text/tabwriter, but knows about colors: cells may be colored without breaking
+// the alignment
+type Table struct {
+	header []string
+	rows   [][]string
+}
+
+// NewTable creates a table with the given column headers
+func NewTable(header ...string) *Table {
+	return &Table{header: header}
+}
+
+// Row adds a row
+func (t *Table) Row(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// Render writes the table with the header in bold, columns separated by two spaces
+func (t *Table) Render(w io.Writer) error {
+	var widths []int
+	measure := func(row []string) {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := Width(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	measure(t.header)
+	for _, row := range t.rows {
+		measure(row)
+	}
+
+	line := func(row []string, style func(string) string) error {
+		var b strings.Builder
+		for i, cell := range row {
+			b.WriteString(style(cell))
+			if i < len(row)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-Width(cell)+2))
+			}
+		}
+		b.WriteString("\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	if len(t.header) > 0 {
+		if err := line(t.header, Bold); err != nil {
+			return err
+		}
+	}
+	for _, row := range t.rows {
+		if err := line(row, func(s string) string { return s }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Package plan separates deciding what a cleanup deletes from deleting it: a plan lists the tags a policy selected
+// together with the digests they pointed to, to be reviewed and approved before it is applied exactly as it is
+package plan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/signing"
+)
+
+// Version is the format of the plans written, plans of other versions are refused
+const Version = 1
+
+// Plan is a reviewed list of deletions in a repository
+type Plan struct {
+	Version    int       `json:"version"`
+	Host       string    `json:"host"`
+	Repository string    `json:"repository"`
+	Created    time.Time `json:"created"`
+	// Policy is the policy file the plan was made with, PolicyDigest the sha256 of its content
+	Policy       string     `json:"policy"`
+	PolicyDigest string     `json:"policy_digest"`
+	Deletions    []Deletion `json:"deletions"`
+}
+
+// Deletion is a tag to delete. It is only deleted while it still points to Digest, a tag pushed again after the plan
+// was made holds something nobody reviewed
+type Deletion struct {
+	Image  string `json:"image"`
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// Size sums the sizes of the deletions, layers shared between them count for each
+func (p Plan) Size() int64 {
+	var size int64
+	for _, d := range p.Deletions {
+		size += d.Size
+	}
+	return size
+}
+
+// Images returns the images of the deletions sorted by name, with their deletions in the order of the plan
+func (p Plan) Images() ([]string, map[string][]Deletion) {
+	byImage := map[string][]Deletion{}
+	var images []string
+	for _, d := range p.Deletions {
+		if _, ok := byImage[d.Image]; !ok {
+			images = append(images, d.Image)
+		}
+		byImage[d.Image] = append(byImage[d.Image], d)
+	}
+	sort.Strings(images)
+	return images, byImage
+}
+
+// Save writes the plan as indented JSON, to be read in a review
+func (p Plan) Save(path string) error {
+	content, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// Load reads and validates a plan written by Save
+func Load(path string) (Plan, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Plan{}, err
+	}
+	return Parse(path, content)
+}
+
+// Parse validates the content of the plan file path, e.g. after its signature was checked
+func Parse(path string, content []byte) (Plan, error) {
+	var p Plan
+	if err := json.Unmarshal(content, &p); err != nil {
+		return p, errors.New(fmt.Sprintf("%s is not a valid plan: %s", path, err))
+	}
+	if err := p.validate(); err != nil {
+		return p, errors.New(fmt.Sprintf("%s is not a valid plan: %s", path, err))
+	}
+	return p, nil
+}
+
+func (p Plan) validate() error {
+	if p.Version != Version {
+		return errors.New(fmt.Sprintf("version %d, this nexus-cli reads version %d", p.Version, Version))
+	}
+	if p.Host == "" || p.Repository == "" {
+		return errors.New("host and repository are missing")
+	}
+	seen := map[string]bool{}
+	for i, d := range p.Deletions {
+		if d.Image == "" || d.Tag == "" || d.Digest == "" {
+			return errors.New(fmt.Sprintf("deletion %d needs an image, a tag and a digest", i+1))
+		}
+		if seen[d.Image+":"+d.Tag] {
+			return errors.New(fmt.Sprintf("%s:%s is listed twice", d.Image, d.Tag))
+		}
+		seen[d.Image+":"+d.Tag] = true
+	}
+	return nil
+}
+
+// Verify checks that one of the public keys signed the content of the plan file path and returns the key which did.
+// The signature is read from signaturePath, or the plan file with .sig appended. Without keys nothing can approve
+// the plan, it is refused like an unsigned one
+func Verify(path string, content []byte, signaturePath string, keys []string) (string, error) {
+	if len(keys) == 0 {
+		return "", errors.New(fmt.Sprintf("No key to verify the approval of %s with, give the public keys of the approvers as plan_keys in the profile or with --key", path))
+	}
+	if signaturePath == "" {
+		signaturePath = path + ".sig"
+	}
+	signature, err := ioutil.ReadFile(signaturePath)
+	if os.IsNotExist(err) {
+		return "", errors.New(fmt.Sprintf("%s is not signed (no %s), have it approved with: nexus-cli repo gc-approve %s --key <key>", path, signaturePath, path))
+	} else if err != nil {
+		return "", err
+	}
+	for _, keyPath := range keys {
+		key, err := signing.LoadPublicKey(keyPath)
+		if err != nil {
+			return "", err
+		}
+		if signing.VerifyBlob(content, string(signature), key) == nil {
+			return keyPath, nil
+		}
+	}
+	return "", errors.New(fmt.Sprintf("The signature %s does not match %s: the plan was changed after it was approved, or none of the trusted keys signed it", signaturePath, path))
+}
@@ -0,0 +1,89 @@
+package plan
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/signing"
+)
+
+// writeKey writes the public key of a new approver to dir and returns its path with the private key
+func writeKey(t *testing.T, dir string, name string) (string, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name+".pub")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, key
+}
+
+// TestVerify checks only plans signed by a trusted key are approved, and that a plan without a signature or
+// without any key to check it with is refused
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	alice, aliceKey := writeKey(t, dir, "alice")
+	bob, _ := writeKey(t, dir, "bob")
+
+	path := filepath.Join(dir, "plan.json")
+	p := Plan{Version: Version, Host: "https://nexus.example.com", Repository: "docker-hosted", Created: time.Now().UTC(),
+		Deletions: []Deletion{{Image: "team/app", Tag: "1.0", Digest: "sha256:a"}}}
+	if err := p.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Verify(path, content, "", []string{alice}); err == nil || !strings.Contains(err.Error(), "is not signed") {
+		t.Errorf("unsigned plan: %v, want it refused as not signed", err)
+	}
+
+	signature, err := signing.SignBlob(content, aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".sig", []byte(signature+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		content []byte
+		keys    []string
+		want    string
+	}{
+		{content, []string{bob, alice}, ""},
+		{content, nil, "No key to verify"},
+		{content, []string{bob}, "does not match"},
+		{append(content, ' '), []string{alice}, "does not match"},
+	}
+	for i, test := range tests {
+		approver, err := Verify(path, test.content, "", test.keys)
+		if test.want == "" {
+			if err != nil || approver != alice {
+				t.Errorf("%d: approved by %q, %v, want %s", i, approver, err, alice)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%d: %v, want %q", i, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Package expr implements the small expression language of policy files. Expressions work on numbers, strings,
+// booleans and string maps, for example:
+//
+//	tag matches "^feature-" && age > days(14) && !("keep" in labels)
+//
+// Operators are || && ! (also spelled or, and, not), == != < <= > >= + - * / %, matches (regular expression) and
+// in (key of a map or substring of a string). Maps are indexed with labels["key"], missing keys are "".
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Program is a compiled expression
+type Program struct {
+	source string
+	root   node
+	idents map[string]bool
+
+	regexpLock sync.Mutex
+	regexps    map[string]*regexp.Regexp
+}
+
+// Compile parses an expression
+func Compile(source string) (*Program, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, idents: map[string]bool{}}
+	root, err := p.parseExpression(1)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, errors.New(fmt.Sprintf("unexpected %q at position %d", t.value, t.pos+1))
+	}
+	return &Program{source: source, root: root, idents: p.idents, regexps: map[string]*regexp.Regexp{}}, nil
+}
+
+// Uses tells if the expression refers to the variable, so callers only compute variables which are needed
+func (p *Program) Uses(name string) bool {
+	return p.idents[name]
+}
+
+// String returns the source of the expression
+func (p *Program) String() string {
+	return p.source
+}
+
+// Eval evaluates the expression. Variables are float64, string, bool or map[string]string values
+func (p *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	return p.eval(p.root, vars)
+}
+
+// EvalBool evaluates an expression which has to result in a boolean
+func (p *Program) EvalBool(vars map[string]interface{}) (bool, error) {
+	value, err := p.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, errors.New(fmt.Sprintf("%s: expected a boolean result, got %s", p.source, typeName(value)))
+	}
+	return b, nil
+}
+
+// EvalString evaluates an expression and formats its result as string
+func (p *Program) EvalString(vars map[string]interface{}) (string, error) {
+	value, err := p.Eval(vars)
+	if err != nil {
+		return "", err
+	}
+	if f, ok := value.(float64); ok && f == math.Trunc(f) {
+		return fmt.Sprintf("%d", int64(f)), nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+func (p *Program) eval(n node, vars map[string]interface{}) (interface{}, error) {
+	switch n := n.(type) {
+	case literalNode:
+		return n.value, nil
+	case identNode:
+		value, ok := vars[n.name]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("unknown variable %s", n.name))
+		}
+		return value, nil
+	case unaryNode:
+		operand, err := p.eval(n.operand, vars)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "!" {
+			b, ok := operand.(bool)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("! needs a boolean, got %s", typeName(operand)))
+			}
+			return !b, nil
+		}
+		f, ok := operand.(float64)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("- needs a number, got %s", typeName(operand)))
+		}
+		return -f, nil
+	case binaryNode:
+		return p.evalBinary(n, vars)
+	case indexNode:
+		target, err := p.eval(n.target, vars)
+		if err != nil {
+			return nil, err
+		}
+		index, err := p.eval(n.index, vars)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := target.(map[string]string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("can only index maps, got %s", typeName(target)))
+		}
+		return m[fmt.Sprint(index)], nil
+	case callNode:
+		args := make([]interface{}, 0, len(n.args))
+		for _, arg := range n.args {
+			value, err := p.eval(arg, vars)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, value)
+		}
+		value, err := functions[n.name](p, args)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s(): %s", n.name, err))
+		}
+		return value, nil
+	}
+	return nil, errors.New("invalid expression")
+}
+
+func (p *Program) evalBinary(n binaryNode, vars map[string]interface{}) (interface{}, error) {
+	left, err := p.eval(n.left, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// short circuit
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("%s needs booleans, got %s", n.op, typeName(left)))
+		}
+		if (n.op == "&&" && !l) || (n.op == "||" && l) {
+			return l, nil
+		}
+		right, err := p.eval(n.right, vars)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("%s needs booleans, got %s", n.op, typeName(right)))
+		}
+		return r, nil
+	}
+
+	right, err := p.eval(n.right, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "matches":
+		s, ok1 := left.(string)
+		pattern, ok2 := right.(string)
+		if !ok1 || !ok2 {
+			return nil, errors.New("matches needs strings")
+		}
+		re, err := p.regexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(s), nil
+	case "in":
+		key, ok := left.(string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("in needs a string on the left, got %s", typeName(left)))
+		}
+		switch r := right.(type) {
+		case map[string]string:
+			_, found := r[key]
+			return found, nil
+		case string:
+			return strings.Contains(r, key), nil
+		}
+		return nil, errors.New(fmt.Sprintf("in needs a map or string on the right, got %s", typeName(right)))
+	}
+
+	if l, ok := left.(string); ok {
+		r, ok := right.(string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("%s needs two strings, got %s", n.op, typeName(right)))
+		}
+		switch n.op {
+		case "+":
+			return l + r, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+		return nil, errors.New(fmt.Sprintf("%s does not work on strings", n.op))
+	}
+
+	l, ok1 := left.(float64)
+	r, ok2 := right.(float64)
+	if !ok1 || !ok2 {
+		return nil, errors.New(fmt.Sprintf("%s needs two numbers, got %s and %s", n.op, typeName(left), typeName(right)))
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return math.Mod(l, r), nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown operator %s", n.op))
+}
+
+// regexp compiles patterns once per program
+func (p *Program) regexp(pattern string) (*regexp.Regexp, error) {
+	p.regexpLock.Lock()
+	defer p.regexpLock.Unlock()
+	if re, ok := p.regexps[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	p.regexps[pattern] = re
+	return re, nil
+}
+
+func equal(left interface{}, right interface{}) bool {
+	switch l := left.(type) {
+	case float64, string, bool:
+		return left == right
+	case map[string]string:
+		r, ok := right.(map[string]string)
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for k, v := range l {
+			if r[k] != v {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case map[string]string:
+		return "map"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,197 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// vars are the variables the tests evaluate with, like a policy does for a tag
+var vars = map[string]interface{}{
+	"tag":    "feature-login",
+	"image":  "team/app",
+	"age":    float64(20 * 86400),
+	"count":  float64(3),
+	"labels": map[string]string{"keep": "true", "team": "web"},
+}
+
+func TestPrecedence(t *testing.T) {
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		{"1 + 2 * 3", float64(7)},
+		{"(1 + 2) * 3", float64(9)},
+		{"10 - 4 - 3", float64(3)},
+		{"12 / 3 / 2", float64(2)},
+		{"7 % 4 * 2", float64(6)},
+		{"-2 * 3", float64(-6)},
+		{"- -2", float64(2)},
+		{"1 + 2 < 4", true},
+		{"1 < 2 == true", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!true || true", true},
+		{"!(true || true)", false},
+		{"not false and true", true},
+		{"false or true and true", true},
+		{"count > 2 && count < 4", true},
+		{`tag matches "^feature-" && age > days(14)`, true},
+		{`tag matches "^feature-" && !("keep" in labels)`, false},
+		{`"login" in tag || false`, true},
+		{`labels["team"] == "web"`, true},
+		{`labels["missing"] == ""`, true},
+		{`image + ":" + tag`, "team/app:feature-login"},
+		{`split(image, "/", 0) == "team"`, true},
+		{`len(tag) * 2`, float64(26)},
+	}
+	for _, test := range tests {
+		p, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %s", test.source, err)
+			continue
+		}
+		got, err := p.Eval(vars)
+		if err != nil {
+			t.Errorf("%q: %s", test.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q = %v, want %v", test.source, got, test.want)
+		}
+	}
+}
+
+// TestShortCircuit checks the right side of && and || is only evaluated if it decides
+func TestShortCircuit(t *testing.T) {
+	for _, source := range []string{"false && missing", "true || missing", "false && 1 / 0 > 1"} {
+		p, err := Compile(source)
+		if err != nil {
+			t.Fatalf("Compile(%q): %s", source, err)
+		}
+		if _, err := p.EvalBool(vars); err != nil {
+			t.Errorf("%q: %s", source, err)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"(1 + 2", `expected ")" at position 7`},
+		{"1 + 2)", `unexpected ")" at position 6`},
+		{`tag == "open`, "unterminated string at position 8"},
+		{"tag $ 1", `unexpected '$' at position 5`},
+		{"1.2.3 > 1", `invalid number "1.2.3" at position 1`},
+		{"labels[\"a\" == 1", `expected "]" at position 16`},
+		{"weeks(2)", "unknown function weeks at position 1"},
+		{`hasPrefix(tag "a")`, `expected "," or ")" at position 15`},
+		{"tag tag", `unexpected "tag" at position 5`},
+		{"* 2", `unexpected "*" at position 1`},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.source)
+		if err == nil {
+			t.Errorf("Compile(%q) succeeded, want %q", test.source, test.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Compile(%q): %q, want %q", test.source, err, test.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"missing > 1", "unknown variable missing"},
+		{"1 / 0", "division by zero"},
+		{"tag && true", "&& needs booleans, got string"},
+		{"!count", "! needs a boolean, got number"},
+		{"-tag", "- needs a number, got string"},
+		{"tag > 1", "> needs two strings, got number"},
+		{"1 > tag", "> needs two numbers, got number and string"},
+		{`tag - "a"`, "- does not work on strings"},
+		{`tag["a"]`, "can only index maps, got string"},
+		{`tag matches "("`, "error parsing regexp"},
+		{`duration("soon")`, "duration(): "},
+		{"days(tag)", "days(): needs a number, got string"},
+	}
+	for _, test := range tests {
+		p, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %s", test.source, err)
+			continue
+		}
+		_, err = p.Eval(vars)
+		if err == nil {
+			t.Errorf("%q succeeded, want %q", test.source, test.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: %q, want %q", test.source, err, test.want)
+		}
+	}
+
+	p, err := Compile("count + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.EvalBool(vars); err == nil || !strings.Contains(err.Error(), "expected a boolean result, got number") {
+		t.Errorf("EvalBool of a number: %v", err)
+	}
+}
+
+// TestDurations checks durations are seconds, comparable with the age of a tag
+func TestDurations(t *testing.T) {
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		{"days(1)", float64(86400)},
+		{"days(1.5)", float64(129600)},
+		{"hours(36)", float64(129600)},
+		{`duration("2w")`, float64(14 * 86400)},
+		{`duration("30d")`, float64(30 * 86400)},
+		{`duration("36h")`, float64(129600)},
+		{`duration("1h30m")`, float64(5400)},
+		{`duration(" 7d ")`, float64(7 * 86400)},
+		{"days(7) == hours(168)", true},
+		{`duration("2w") == days(14)`, true},
+		{"age > days(14)", true},
+		{"age > days(30)", false},
+	}
+	for _, test := range tests {
+		p, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %s", test.source, err)
+			continue
+		}
+		got, err := p.Eval(vars)
+		if err != nil {
+			t.Errorf("%q: %s", test.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q = %v, want %v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestUses(t *testing.T) {
+	p, err := Compile(`tag matches "^v" && labels["keep"] == "" && days(3) < age`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"tag": true, "labels": true, "age": true, "image": false, "days": false, "matches": false} {
+		if got := p.Uses(name); got != want {
+			t.Errorf("Uses(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/utils"
+	"strings"
+)
+
+type function func(p *Program, args []interface{}) (interface{}, error)
+
+// functions available in expressions. Durations are numbers of seconds, like the age variable of policies
+var functions = map[string]function{
+	"matches": func(p *Program, args []interface{}) (interface{}, error) {
+		s, pattern, err := twoStrings(args)
+		if err != nil {
+			return nil, err
+		}
+		re, err := p.regexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(s), nil
+	},
+	"replace": func(p *Program, args []interface{}) (interface{}, error) {
+		if len(args) != 3 {
+			return nil, errors.New("needs a string, a regular expression and a replacement")
+		}
+		s, ok1 := args[0].(string)
+		pattern, ok2 := args[1].(string)
+		replacement, ok3 := args[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, errors.New("needs strings")
+		}
+		re, err := p.regexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.ReplaceAllString(s, replacement), nil
+	},
+	"hasPrefix": func(p *Program, args []interface{}) (interface{}, error) {
+		s, prefix, err := twoStrings(args)
+		return strings.HasPrefix(s, prefix), err
+	},
+	"hasSuffix": func(p *Program, args []interface{}) (interface{}, error) {
+		s, suffix, err := twoStrings(args)
+		return strings.HasSuffix(s, suffix), err
+	},
+	"contains": func(p *Program, args []interface{}) (interface{}, error) {
+		s, sub, err := twoStrings(args)
+		return strings.Contains(s, sub), err
+	},
+	"lower": func(p *Program, args []interface{}) (interface{}, error) {
+		s, err := oneString(args)
+		return strings.ToLower(s), err
+	},
+	"upper": func(p *Program, args []interface{}) (interface{}, error) {
+		s, err := oneString(args)
+		return strings.ToUpper(s), err
+	},
+	"split": func(p *Program, args []interface{}) (interface{}, error) {
+		if len(args) != 3 {
+			return nil, errors.New("needs a string, a separator and an index")
+		}
+		s, ok1 := args[0].(string)
+		separator, ok2 := args[1].(string)
+		index, ok3 := args[2].(float64)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, errors.New("needs a string, a separator and an index")
+		}
+		parts := strings.Split(s, separator)
+		if int(index) < 0 || int(index) >= len(parts) {
+			return "", nil
+		}
+		return parts[int(index)], nil
+	},
+	"len": func(p *Program, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, errors.New("needs one argument")
+		}
+		switch v := args[0].(type) {
+		case string:
+			return float64(len(v)), nil
+		case map[string]string:
+			return float64(len(v)), nil
+		}
+		return nil, errors.New(fmt.Sprintf("needs a string or map, got %s", typeName(args[0])))
+	},
+	"days": func(p *Program, args []interface{}) (interface{}, error) {
+		n, err := oneNumber(args)
+		return n * 86400, err
+	},
+	"hours": func(p *Program, args []interface{}) (interface{}, error) {
+		n, err := oneNumber(args)
+		return n * 3600, err
+	},
+	"duration": func(p *Program, args []interface{}) (interface{}, error) {
+		s, err := oneString(args)
+		if err != nil {
+			return nil, err
+		}
+		d, err := utils.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		return d.Seconds(), nil
+	},
+}
+
+func oneString(args []interface{}) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("needs one string")
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("needs a string, got %s", typeName(args[0])))
+	}
+	return s, nil
+}
+
+func twoStrings(args []interface{}) (string, string, error) {
+	if len(args) != 2 {
+		return "", "", errors.New("needs two strings")
+	}
+	a, ok1 := args[0].(string)
+	b, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return "", "", errors.New("needs two strings")
+	}
+	return a, b, nil
+}
+
+func oneNumber(args []interface{}) (float64, error) {
+	if len(args) != 1 {
+		return 0, errors.New("needs one number")
+	}
+	n, ok := args[0].(float64)
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("needs a number, got %s", typeName(args[0])))
+	}
+	return n, nil
+}
@@ -0,0 +1,112 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// operators, longest first so "<=" wins over "<"
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ","}
+
+// keywords spelled as words, mapped to their operator
+var wordOperators = map[string]string{"and": "&&", "or": "||", "not": "!"}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(source); {
+		c := rune(source[pos])
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+		case unicode.IsDigit(c):
+			start := pos
+			for pos < len(source) && (unicode.IsDigit(rune(source[pos])) || source[pos] == '.') {
+				pos++
+			}
+			tokens = append(tokens, token{tokenNumber, source[start:pos], start})
+		case c == '"' || c == '\'':
+			start := pos
+			value, n, err := readString(source[pos:])
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%s at position %d", err, start+1))
+			}
+			pos += n
+			tokens = append(tokens, token{tokenString, value, start})
+		case unicode.IsLetter(c) || c == '_':
+			start := pos
+			for pos < len(source) && (unicode.IsLetter(rune(source[pos])) || unicode.IsDigit(rune(source[pos])) || source[pos] == '_') {
+				pos++
+			}
+			word := source[start:pos]
+			if op, ok := wordOperators[word]; ok {
+				tokens = append(tokens, token{tokenOperator, op, start})
+			} else {
+				tokens = append(tokens, token{tokenIdent, word, start})
+			}
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[pos:], op) {
+					tokens = append(tokens, token{tokenOperator, op, pos})
+					pos += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errors.New(fmt.Sprintf("unexpected %q at position %d", c, pos+1))
+			}
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(source)}), nil
+}
+
+// readString reads a quoted string starting at s[0], returning its value and the number of bytes consumed
+func readString(s string) (string, int, error) {
+	quote := s[0]
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case quote:
+			return value.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, errors.New("unterminated string")
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				// keeps regular expression escapes like \d readable: "\d" and "\\d" are the same
+				if s[i] != quote && s[i] != '\\' {
+					value.WriteByte('\\')
+				}
+				value.WriteByte(s[i])
+			}
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
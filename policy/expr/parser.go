@@ -0,0 +1,213 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+type node interface{}
+
+type literalNode struct {
+	value interface{}
+}
+
+type identNode struct {
+	name string
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+type callNode struct {
+	name string
+	args []node
+	pos  int
+}
+
+type indexNode struct {
+	target, index node
+}
+
+// binding power of the infix operators, higher binds tighter
+var precedence = map[string]int{
+	"||":      1,
+	"&&":      2,
+	"==":      3,
+	"!=":      3,
+	"<":       4,
+	"<=":      4,
+	">":       4,
+	">=":      4,
+	"matches": 4,
+	"in":      4,
+	"+":       5,
+	"-":       5,
+	"*":       6,
+	"/":       6,
+	"%":       6,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	idents map[string]bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(op string) error {
+	t := p.next()
+	if t.kind != tokenOperator || t.value != op {
+		return errors.New(fmt.Sprintf("expected %q at position %d", op, t.pos+1))
+	}
+	return nil
+}
+
+// infix returns the infix operator at the current position, "matches" and "in" are spelled as identifiers
+func (p *parser) infix() (string, bool) {
+	t := p.peek()
+	if t.kind == tokenOperator {
+		if _, ok := precedence[t.value]; ok {
+			return t.value, true
+		}
+	}
+	if t.kind == tokenIdent && (t.value == "matches" || t.value == "in") {
+		return t.value, true
+	}
+	return "", false
+}
+
+func (p *parser) parseExpression(minPrecedence int) (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.infix()
+		if !ok || precedence[op] < minPrecedence {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseExpression(precedence[op] + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.peek()
+	if t.kind == tokenOperator && (t.value == "!" || t.value == "-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: t.value, operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokenOperator || t.value != "[" {
+			return n, nil
+		}
+		p.next()
+		index, err := p.parseExpression(1)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		n = indexNode{target: n, index: index}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid number %q at position %d", t.value, t.pos+1))
+		}
+		return literalNode{value}, nil
+	case tokenString:
+		return literalNode{t.value}, nil
+	case tokenIdent:
+		switch t.value {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		}
+		if next := p.peek(); next.kind == tokenOperator && next.value == "(" {
+			return p.parseCall(t)
+		}
+		p.idents[t.value] = true
+		return identNode{t.value}, nil
+	case tokenOperator:
+		if t.value == "(" {
+			n, err := p.parseExpression(1)
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	case tokenEOF:
+		return nil, errors.New("unexpected end of expression")
+	}
+	return nil, errors.New(fmt.Sprintf("unexpected %q at position %d", t.value, t.pos+1))
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	if _, ok := functions[name.value]; !ok {
+		return nil, errors.New(fmt.Sprintf("unknown function %s at position %d", name.value, name.pos+1))
+	}
+	p.next()
+	call := callNode{name: name.value, pos: name.pos}
+	if t := p.peek(); t.kind == tokenOperator && t.value == ")" {
+		p.next()
+		return call, nil
+	}
+	for {
+		arg, err := p.parseExpression(1)
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+		t := p.next()
+		if t.kind == tokenOperator && t.value == ")" {
+			return call, nil
+		}
+		if t.kind != tokenOperator || t.value != "," {
+			return nil, errors.New(fmt.Sprintf("expected \",\" or \")\" at position %d", t.pos+1))
+		}
+	}
+}
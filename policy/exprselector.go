@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/policy/expr"
+	"sort"
+)
+
+func init() {
+	Register("expr", newExprSelector)
+}
+
+// exprSelector selects the tags an expression is true for. With group, the selected tags are grouped by the value of
+// a second expression and the keep most recent tags of every group are spared
+type exprSelector struct {
+	filter *expr.Program
+	group  *expr.Program
+	keep   int
+}
+
+func newExprSelector(params Params) (Selector, error) {
+	var s exprSelector
+	source := params.String("expr", "true")
+	filter, err := expr.Compile(source)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("expr: %s: %s", source, err))
+	}
+	s.filter = filter
+
+	if source := params.String("group", ""); source != "" {
+		group, err := expr.Compile(source)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("expr: group %s: %s", source, err))
+		}
+		s.group = group
+	}
+	if s.keep, err = params.Int("keep", 0); err != nil {
+		return nil, err
+	}
+	if s.keep < 0 {
+		return nil, errors.New("expr: keep must not be negative")
+	}
+	if s.keep > 0 && s.group == nil {
+		return nil, errors.New("expr: keep needs a group expression, use a count selector otherwise")
+	}
+	return s, nil
+}
+
+func (s exprSelector) Select(candidates []*Tag) ([]*Tag, error) {
+	var selected []*Tag
+	for _, tag := range candidates {
+		vars, err := exprVars(tag, s.filter, s.group)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := s.filter.EvalBool(vars)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s:%s: %s", tag.Image, tag.Name, err))
+		}
+		if ok {
+			selected = append(selected, tag)
+		}
+	}
+	if s.group == nil {
+		return selected, nil
+	}
+
+	groups := map[string][]*Tag{}
+	var order []string
+	for _, tag := range selected {
+		vars, err := exprVars(tag, s.group)
+		if err != nil {
+			return nil, err
+		}
+		key, err := s.group.EvalString(vars)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s:%s: %s", tag.Image, tag.Name, err))
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], tag)
+	}
+
+	var result []*Tag
+	for _, key := range order {
+		tags := groups[key]
+		if len(tags) <= s.keep {
+			continue
+		}
+		var err error
+		sort.SliceStable(tags, func(i, j int) bool {
+			left, errLeft := tags[i].Created()
+			right, errRight := tags[j].Created()
+			if errLeft != nil {
+				err = errLeft
+			} else if errRight != nil {
+				err = errRight
+			}
+			return left.Before(right)
+		})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, tags[:len(tags)-s.keep]...)
+	}
+	return result, nil
+}
+
+// exprVars provides the variables used by the programs. Metadata of the tag is only fetched if a program needs it
+func exprVars(tag *Tag, programs ...*expr.Program) (map[string]interface{}, error) {
+	uses := func(name string) bool {
+		for _, p := range programs {
+			if p != nil && p.Uses(name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	vars := map[string]interface{}{"name": tag.Image, "tag": tag.Name}
+	if uses("created") || uses("age") {
+		created, err := tag.Created()
+		if err != nil {
+			return nil, err
+		}
+		vars["created"] = float64(created.Unix())
+		vars["age"] = now().Sub(created).Seconds()
+	}
+	if uses("labels") {
+		labels, err := tag.Labels()
+		if err != nil {
+			return nil, err
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		vars["labels"] = labels
+	}
+	if uses("size") {
+		size, err := tag.Size()
+		if err != nil {
+			return nil, err
+		}
+		vars["size"] = float64(size)
+	}
+	if uses("last_download") {
+		last, ok, err := tag.LastDownloaded()
+		if err != nil {
+			return nil, err
+		}
+		vars["last_download"] = float64(0)
+		if ok {
+			vars["last_download"] = float64(last.Unix())
+		}
+	}
+	return vars, nil
+}
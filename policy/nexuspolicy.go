@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/registry"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var policyNameInvalid = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// CleanupPolicies translates the rules into Nexus cleanup policies for docker repositories, named prefix followed by
+// the rule name. Nexus only knows some of the criteria nexus-cli has: age becomes the last blob update, last-download
+// the last download, the images expression and regex selectors an asset regex on the manifest path and keep retains
+// versions (Nexus Pro only). Rules with label or expr selectors, inverted regexes, match any or delegate can't be translated
+func (p Policy) CleanupPolicies(prefix string) ([]registry.CleanupPolicy, error) {
+	var policies []registry.CleanupPolicy
+	for _, rule := range p.Rules {
+		policy, err := rule.cleanupPolicy(prefix)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %s", rule.Name, err))
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+func (rule Rule) cleanupPolicy(prefix string) (registry.CleanupPolicy, error) {
+	policy := registry.CleanupPolicy{
+		Name:   prefix + strings.Trim(policyNameInvalid.ReplaceAllString(rule.Name, "-"), "-"),
+		Notes:  fmt.Sprintf("Generated by nexus-cli from rule %s", rule.Name),
+		Format: "docker",
+	}
+	if rule.Delegate {
+		return policy, errors.New("Nexus can't read the retention of images from their labels, delegate has no equivalent")
+	}
+	// keep guards the selected tags with match any as retain does in Nexus
+	if rule.Match == MatchAny && len(rule.Selectors) > 1 {
+		return policy, errors.New("Nexus requires all criteria of a cleanup policy to match, match any has no equivalent")
+	}
+	if rule.Keep != 0 {
+		keep := rule.Keep
+		policy.Retain = &keep
+	}
+
+	tags := ".*"
+	for _, params := range rule.Selectors {
+		switch kind := params.String("type", ""); kind {
+		case "age", "last-download":
+			olderThan, err := params.Duration("older_than", 0)
+			if err != nil {
+				return policy, err
+			}
+			days, err := wholeDays(olderThan)
+			if err != nil {
+				return policy, err
+			}
+			if kind == "age" {
+				policy.CriteriaLastBlobUpdated = &days
+			} else {
+				policy.CriteriaLastDownloaded = &days
+			}
+		case "regex":
+			if invert, _ := params.Bool("invert", false); invert {
+				return policy, errors.New("Nexus asset regexes can't be inverted")
+			}
+			if tags != ".*" {
+				return policy, errors.New("Nexus takes only one asset regex, combine the regex selectors")
+			}
+			tags = wholeMatch(params.String("pattern", ".*"))
+		case "count":
+			keep, err := params.Int("keep", 0)
+			if err != nil {
+				return policy, err
+			}
+			if policy.Retain != nil {
+				return policy, errors.New("Nexus retains only one number of versions, give keep once")
+			}
+			policy.Retain = &keep
+		default:
+			return policy, errors.New(fmt.Sprintf("%s selectors have no equivalent in Nexus cleanup policies", kind))
+		}
+	}
+
+	if images := wholeMatch(rule.Images); images != ".*" || tags != ".*" {
+		// docker assets are stored as v2/<image>/manifests/<tag>
+		policy.CriteriaAssetRegex = fmt.Sprintf("v2/%s/manifests/%s", images, tags)
+	}
+	return policy, nil
+}
+
+// wholeMatch turns an expression matching part of a string into one matching all of it, as Nexus asset regexes do
+func wholeMatch(expression string) string {
+	if expression == "" || expression == ".*" || expression == "^.*$" {
+		return ".*"
+	}
+	start, end := ".*", ".*"
+	if strings.HasPrefix(expression, "^") {
+		start, expression = "", expression[1:]
+	}
+	if strings.HasSuffix(expression, "$") && !strings.HasSuffix(expression, `\$`) {
+		end, expression = "", expression[:len(expression)-1]
+	}
+	return start + "(?:" + expression + ")" + end
+}
+
+func wholeDays(d time.Duration) (int, error) {
+	if d <= 0 || d%(24*time.Hour) != 0 {
+		return 0, errors.New(fmt.Sprintf("Nexus counts in days, %s is not a whole number of days", d))
+	}
+	return int(d / (24 * time.Hour)), nil
+}
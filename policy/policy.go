@@ -0,0 +1,333 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/registry"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Policy is a list of retention rules, usually loaded from a YAML file:
+//
+//	rules:
+//	  - name: team-x
+//	    images: '^team-x/'
+//	    keep: 5
+//	    selectors:
+//	      - type: age
+//	        older_than: 30d
+//	  - images: '.*'
+//	    keep: 10
+//
+// The first rule whose images expression matches an image name applies to it
+type Policy struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+const (
+	// MatchAll runs the selectors as a pipeline, each one choosing from the tags selected by the previous one.
+	// Only tags selected by every selector are deleted
+	MatchAll = "all"
+	// MatchAny gives every selector all tags, tags selected by at least one selector are deleted
+	MatchAny = "any"
+)
+
+// Rule decides which tags of the images matching Images are deleted
+type Rule struct {
+	Name   string `yaml:"name"`
+	Images string `yaml:"images"`
+	// Keep is a shorthand for a count selector running first, keeping the most recent Keep tags. With MatchAny the
+	// most recent Keep tags are kept whichever selector selects them
+	Keep int `yaml:"keep"`
+	// Sort is the tag sort strategy of the Keep shorthand: default, semver or created, like for a count selector
+	Sort      string   `yaml:"sort"`
+	Match     string   `yaml:"match"`
+	Selectors []Params `yaml:"selectors"`
+	// Delegate lets images decide with their RetentionLabel, which replaces keep and the selectors of the rule.
+	// Images without the label are handled by the rule, or left alone if it has neither keep nor selectors
+	Delegate bool `yaml:"delegate"`
+
+	images    *regexp.Regexp
+	selectors []Selector
+	// keep is the Keep shorthand of MatchAny rules, it guards the tags their selectors select
+	keep Selector
+}
+
+// Load reads and validates a policy file
+func Load(path string) (Policy, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+	p, err := parse(content)
+	if err != nil {
+		return p, errors.New(fmt.Sprintf("Invalid policy %s: %s", path, err))
+	}
+	return p, nil
+}
+
+// Parse validates a policy given as YAML, e.g. by programs embedding the policies in their own configuration
+func Parse(content []byte) (Policy, error) {
+	p, err := parse(content)
+	if err != nil {
+		return p, errors.New(fmt.Sprintf("Invalid policy: %s", err))
+	}
+	return p, nil
+}
+
+func parse(content []byte) (Policy, error) {
+	var p Policy
+	if err := yaml.UnmarshalStrict(content, &p); err != nil {
+		return p, err
+	}
+	return p, p.compile()
+}
+
+func (p *Policy) compile() error {
+	if len(p.Rules) == 0 {
+		return errors.New("no rules defined")
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Images == "" {
+			rule.Images = ".*"
+		}
+		var err error
+		if rule.images, err = regexp.Compile(rule.Images); err != nil {
+			return errors.New(fmt.Sprintf("%s: %s", rule.Name, err))
+		}
+		if rule.Match == "" {
+			rule.Match = MatchAll
+		}
+		if rule.Match != MatchAll && rule.Match != MatchAny {
+			return errors.New(fmt.Sprintf("%s: match must be %s or %s", rule.Name, MatchAll, MatchAny))
+		}
+		if rule.Sort == "" {
+			rule.Sort = "default"
+		}
+		if !validSort(rule.Sort) {
+			return errors.New(fmt.Sprintf("%s: unknown sort %q, use default, semver or created", rule.Name, rule.Sort))
+		}
+
+		rule.selectors, rule.keep = nil, nil
+		if rule.Keep != 0 {
+			count, err := newCountSelector(Params{"keep": rule.Keep, "sort": rule.Sort})
+			if err != nil {
+				return errors.New(fmt.Sprintf("%s: %s", rule.Name, err))
+			}
+			if rule.Match == MatchAny {
+				rule.keep = count
+			} else {
+				rule.selectors = append(rule.selectors, count)
+			}
+		}
+		for _, params := range rule.Selectors {
+			selector, err := newSelector(params)
+			if err != nil {
+				return errors.New(fmt.Sprintf("%s: %s", rule.Name, err))
+			}
+			rule.selectors = append(rule.selectors, selector)
+		}
+		if len(rule.selectors) == 0 && rule.keep == nil && !rule.Delegate {
+			return errors.New(fmt.Sprintf("%s: give keep, at least one selector or delegate", rule.Name))
+		}
+	}
+	return nil
+}
+
+// RuleFor returns the first rule applying to the image, ok is false if no rule matches
+func (p Policy) RuleFor(image string) (Rule, bool) {
+	for _, rule := range p.Rules {
+		if rule.images.MatchString(image) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Select runs the selectors of the rule on the candidates and returns the tags to delete
+func (rule Rule) Select(candidates []*Tag) ([]*Tag, error) {
+	if rule.Match == MatchAny {
+		seen := make(map[*Tag]bool)
+		for _, selector := range rule.selectors {
+			selected, err := selector.Select(candidates)
+			if err != nil {
+				return nil, err
+			}
+			for _, tag := range selected {
+				seen[tag] = true
+			}
+		}
+		if rule.keep != nil {
+			// the most recent tags are kept, whichever selector selects them
+			deletable, err := rule.keep.Select(candidates)
+			if err != nil {
+				return nil, err
+			}
+			if len(rule.selectors) == 0 {
+				return deletable, nil
+			}
+			allowed := make(map[*Tag]bool)
+			for _, tag := range deletable {
+				allowed[tag] = true
+			}
+			for tag := range seen {
+				if !allowed[tag] {
+					delete(seen, tag)
+				}
+			}
+		}
+		var selected []*Tag
+		for _, tag := range candidates {
+			if seen[tag] {
+				selected = append(selected, tag)
+			}
+		}
+		return selected, nil
+	}
+
+	selected := candidates
+	for _, selector := range rule.selectors {
+		var err error
+		if selected, err = selector.Select(selected); err != nil {
+			return nil, err
+		}
+		if len(selected) == 0 {
+			break
+		}
+	}
+	return selected, nil
+}
+
+// Evaluate returns the tags of image the policy wants deleted. Images without a matching rule are left alone.
+// Tags holding referrers (signatures, attestations ..) are never selected, they go with their subject
+func (p Policy) Evaluate(r registry.Registry, image string) ([]string, error) {
+	rule, ok := p.RuleFor(image)
+	if !ok {
+		return nil, nil
+	}
+
+	all, err := r.ListTagsByImage(image)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []*Tag
+	for _, tag := range all {
+		if !registry.IsReferrerTag(tag) {
+			candidates = append(candidates, NewTag(r, image, tag))
+		}
+	}
+
+	if rule.Delegate {
+		delegated, ok, err := rule.delegated(candidates)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rule = delegated
+		}
+	}
+	if len(rule.selectors) == 0 && rule.keep == nil {
+		return nil, nil
+	}
+
+	selected, err := rule.Select(candidates)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", rule.Name, err))
+	}
+	tags := make([]string, 0, len(selected))
+	for _, tag := range selected {
+		tags = append(tags, tag.Name)
+	}
+	return tags, nil
+}
+
+// RetentionLabel is the label of image configurations rules with delegate read the retention of the image from, e.g.
+//
+//	LABEL nexus-cli.retention="keep=5,max-age=30d"
+//
+// keeps the 5 most recent tags and deletes the others once they are older than 30 days
+const RetentionLabel = "nexus-cli.retention"
+
+// delegated returns the rule given by the retention label of the image, ok is false if the image has no label. The
+// label is read from the most recent tag, as the keep of the rule sorts them, so the latest build decides
+func (rule Rule) delegated(candidates []*Tag) (Rule, bool, error) {
+	if len(candidates) == 0 {
+		return rule, false, nil
+	}
+	sorted, err := sortTags(candidates, rule.Sort)
+	if err != nil {
+		return rule, false, err
+	}
+	newest := sorted[len(sorted)-1]
+	labels, err := newest.Labels()
+	if err != nil {
+		return rule, false, err
+	}
+	value, ok := labels[RetentionLabel]
+	if !ok {
+		return rule, false, nil
+	}
+
+	selectors, err := parseRetention(value, rule.Sort)
+	if err != nil {
+		return rule, false, errors.New(fmt.Sprintf("%s: label %s of %s:%s: %s", rule.Name, RetentionLabel, newest.Image, newest.Name, err))
+	}
+	rule.Name = fmt.Sprintf("%s (%s=%q of %s:%s)", rule.Name, RetentionLabel, value, newest.Image, newest.Name)
+	rule.Match = MatchAll
+	rule.selectors, rule.keep = selectors, nil
+	return rule, true, nil
+}
+
+// parseRetention turns a retention label into selectors: keep=N becomes a count selector, max-age=D an age selector
+// running after it
+func parseRetention(value string, sortBy string) ([]Selector, error) {
+	var keep, maxAge Selector
+	for _, setting := range strings.Split(value, ",") {
+		setting = strings.TrimSpace(setting)
+		if setting == "" {
+			continue
+		}
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New(fmt.Sprintf("%q is not in the form key=value", setting))
+		}
+		key, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		var err error
+		switch key {
+		case "keep":
+			n, convErr := strconv.Atoi(v)
+			if convErr != nil {
+				return nil, errors.New(fmt.Sprintf("keep must be a number, not %q", v))
+			}
+			keep, err = newCountSelector(Params{"keep": n, "sort": sortBy})
+		case "max-age":
+			maxAge, err = newAgeSelector(Params{"older_than": v})
+		default:
+			return nil, errors.New(fmt.Sprintf("unknown setting %q, use keep and max-age", key))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var selectors []Selector
+	if keep != nil {
+		selectors = append(selectors, keep)
+	}
+	if maxAge != nil {
+		selectors = append(selectors, maxAge)
+	}
+	if len(selectors) == 0 {
+		return nil, errors.New("give keep or max-age")
+	}
+	return selectors, nil
+}
@@ -0,0 +1,82 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/registry"
+)
+
+// tags returns candidates built a day apart in the order given, their metadata is known without a registry
+func tags(names ...string) []*Tag {
+	built := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var candidates []*Tag
+	for i, name := range names {
+		config := registry.ImageConfig{Created: built.Add(time.Duration(i) * 24 * time.Hour)}
+		candidates = append(candidates, &Tag{Image: "team/app", Name: name, config: &config})
+	}
+	return candidates
+}
+
+func names(selected []*Tag) []string {
+	result := []string{}
+	for _, tag := range selected {
+		result = append(result, tag.Name)
+	}
+	return result
+}
+
+func TestRuleSelect(t *testing.T) {
+	tests := []struct {
+		policy string
+		tags   []*Tag
+		want   []string
+	}{
+		{`{rules: [{keep: 2}]}`, tags("1.0.0", "2.0.0", "1.1.0"), []string{"1.0.0"}},
+		{`{rules: [{keep: 2, sort: semver}]}`, tags("1.0.0", "2.0.0", "1.1.0"), []string{"1.0.0"}},
+		// built last, 1.0.0 is the most recent
+		{`{rules: [{keep: 1, sort: created}]}`, tags("2.0.0", "1.1.0", "1.0.0"), []string{"2.0.0", "1.1.0"}},
+		// keep runs first, the selectors choose from the older tags
+		{`{rules: [{keep: 2, selectors: [{type: regex, pattern: '^1\.'}]}]}`, tags("1.0.0", "1.1.0", "1.2.0", "2.0.0"), []string{"1.0.0", "1.1.0"}},
+		// with match any keep still spares the most recent tags, whichever selector selects them
+		{`{rules: [{keep: 2, match: any, selectors: [{type: regex, pattern: '^1\.'}, {type: regex, pattern: '^2\.'}]}]}`, tags("1.0.0", "1.1.0", "1.2.0", "2.0.0"), []string{"1.0.0", "1.1.0"}},
+		{`{rules: [{keep: 2, match: any, selectors: [{type: regex, pattern: '^2\.'}]}]}`, tags("1.0.0", "2.0.0", "2.1.0"), []string{}},
+		{`{rules: [{keep: 1, match: any}]}`, tags("1.0.0", "2.0.0"), []string{"1.0.0"}},
+		{`{rules: [{match: any, selectors: [{type: regex, pattern: '^1\.'}, {type: regex, pattern: '^2\.'}]}]}`, tags("1.0.0", "2.0.0", "3.0.0"), []string{"1.0.0", "2.0.0"}},
+	}
+	for _, test := range tests {
+		p, err := Parse([]byte(test.policy))
+		if err != nil {
+			t.Errorf("%s: %s", test.policy, err)
+			continue
+		}
+		selected, err := p.Rules[0].Select(test.tags)
+		if err != nil {
+			t.Errorf("%s: %s", test.policy, err)
+			continue
+		}
+		if got := names(selected); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s selected %q, want %q", test.policy, got, test.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{`{rules: [{keep: 2, sort: newest}]}`, `unknown sort "newest", use default, semver or created`},
+		{`{rules: [{keep: 2, match: some}]}`, "match must be all or any"},
+		{`{rules: [{images: '^team/'}]}`, "give keep, at least one selector or delegate"},
+		{`{rules: []}`, "no rules defined"},
+	}
+	for _, test := range tests {
+		_, err := Parse([]byte(test.policy))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: %v, want %q", test.policy, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/utils"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Selector picks the tags a rule wants deleted out of the candidates it is given
+type Selector interface {
+	Select(candidates []*Tag) ([]*Tag, error)
+}
+
+// Factory creates a selector from the parameters given for it in the policy file
+type Factory func(params Params) (Selector, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = map[string]Factory{}
+)
+
+// Register makes a selector type available to policy files. Programs embedding nexus-cli use it to add custom
+// rules, it has to be called before the policy is loaded. Registering a type twice replaces the former factory
+func Register(kind string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	factories[kind] = factory
+}
+
+func newSelector(params Params) (Selector, error) {
+	kind := params.String("type", "")
+	factoriesLock.RLock()
+	factory, ok := factories[kind]
+	factoriesLock.RUnlock()
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown selector type %q", kind))
+	}
+	return factory(params)
+}
+
+// Params are the settings of a selector in the policy file
+type Params map[string]interface{}
+
+// String returns the parameter as string, or def if it is not set
+func (p Params) String(key string, def string) string {
+	value, ok := p[key]
+	if !ok || value == nil {
+		return def
+	}
+	return fmt.Sprint(value)
+}
+
+// Int returns the parameter as integer, or def if it is not set
+func (p Params) Int(key string, def int) (int, error) {
+	value, ok := p[key]
+	if !ok || value == nil {
+		return def, nil
+	}
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case string:
+		return strconv.Atoi(v)
+	default:
+		return 0, errors.New(fmt.Sprintf("%s must be a number", key))
+	}
+}
+
+// Bool returns the parameter as boolean, or def if it is not set
+func (p Params) Bool(key string, def bool) (bool, error) {
+	value, ok := p[key]
+	if !ok || value == nil {
+		return def, nil
+	}
+	if v, ok := value.(bool); ok {
+		return v, nil
+	}
+	return false, errors.New(fmt.Sprintf("%s must be true or false", key))
+}
+
+// Duration returns the parameter as duration, or def if it is not set. Besides the units of time.ParseDuration,
+// days (30d) and weeks (2w) are understood
+func (p Params) Duration(key string, def time.Duration) (time.Duration, error) {
+	value := p.String(key, "")
+	if value == "" {
+		return def, nil
+	}
+	duration, err := utils.ParseDuration(value)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("%s: %s", key, err))
+	}
+	return duration, nil
+}
+
+// Regexp returns the parameter as compiled regular expression, nil if it is not set
+func (p Params) Regexp(key string) (*regexp.Regexp, error) {
+	value := p.String(key, "")
+	if value == "" {
+		return nil, nil
+	}
+	return regexp.Compile(value)
+}
@@ -0,0 +1,215 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/utils"
+	"regexp"
+	"sort"
+	"time"
+)
+
+func init() {
+	Register("count", newCountSelector)
+	Register("age", newAgeSelector)
+	Register("regex", newRegexSelector)
+	Register("label", newLabelSelector)
+	Register("last-download", newLastDownloadSelector)
+}
+
+// now is the reference time of age based selectors
+var now = time.Now
+
+// countSelector keeps the most recent tags and selects all others
+type countSelector struct {
+	keep int
+	sort string
+}
+
+func newCountSelector(params Params) (Selector, error) {
+	keep, err := params.Int("keep", 0)
+	if err != nil {
+		return nil, err
+	}
+	if keep < 1 {
+		return nil, errors.New("count: keep must be at least 1")
+	}
+	s := countSelector{keep: keep, sort: params.String("sort", "default")}
+	if !validSort(s.sort) {
+		return nil, errors.New(fmt.Sprintf("count: unknown sort %q, use semver or created", s.sort))
+	}
+	return s, nil
+}
+
+func (s countSelector) Select(candidates []*Tag) ([]*Tag, error) {
+	if len(candidates) <= s.keep {
+		return nil, nil
+	}
+	sorted, err := sortTags(candidates, s.sort)
+	if err != nil {
+		return nil, err
+	}
+	return sorted[:len(sorted)-s.keep], nil
+}
+
+// validSort tells if sortBy is a sort of count selectors and the keep of rules
+func validSort(sortBy string) bool {
+	return sortBy == "default" || sortBy == "semver" || sortBy == "created"
+}
+
+// sortTags returns the tags sorted oldest first, by their names or with created by their build time
+func sortTags(tags []*Tag, sortBy string) ([]*Tag, error) {
+	sorted := append([]*Tag(nil), tags...)
+	if sortBy != "created" {
+		compare := utils.GetSortComparisonStrategy(sortBy)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return compare(sorted[i].Name, sorted[j].Name)
+		})
+		return sorted, nil
+	}
+	var err error
+	sort.SliceStable(sorted, func(i, j int) bool {
+		left, errLeft := sorted[i].Created()
+		right, errRight := sorted[j].Created()
+		if errLeft != nil {
+			err = errLeft
+		} else if errRight != nil {
+			err = errRight
+		}
+		return left.Before(right)
+	})
+	return sorted, err
+}
+
+// ageSelector selects tags of images built longer ago than the given duration
+type ageSelector struct {
+	olderThan time.Duration
+}
+
+func newAgeSelector(params Params) (Selector, error) {
+	olderThan, err := params.Duration("older_than", 0)
+	if err != nil {
+		return nil, err
+	}
+	if olderThan <= 0 {
+		return nil, errors.New("age: older_than is required")
+	}
+	return ageSelector{olderThan: olderThan}, nil
+}
+
+func (s ageSelector) Select(candidates []*Tag) ([]*Tag, error) {
+	var selected []*Tag
+	for _, tag := range candidates {
+		created, err := tag.Created()
+		if err != nil {
+			return nil, err
+		}
+		if now().Sub(created) > s.olderThan {
+			selected = append(selected, tag)
+		}
+	}
+	return selected, nil
+}
+
+// regexSelector selects tags whose name matches (or with invert: does not match) the pattern
+type regexSelector struct {
+	pattern *regexp.Regexp
+	invert  bool
+}
+
+func newRegexSelector(params Params) (Selector, error) {
+	pattern, err := params.Regexp("pattern")
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("regex: %s", err))
+	}
+	if pattern == nil {
+		return nil, errors.New("regex: pattern is required")
+	}
+	invert, err := params.Bool("invert", false)
+	if err != nil {
+		return nil, err
+	}
+	return regexSelector{pattern: pattern, invert: invert}, nil
+}
+
+func (s regexSelector) Select(candidates []*Tag) ([]*Tag, error) {
+	var selected []*Tag
+	for _, tag := range candidates {
+		if s.pattern.MatchString(tag.Name) != s.invert {
+			selected = append(selected, tag)
+		}
+	}
+	return selected, nil
+}
+
+// labelSelector selects tags of images carrying a label, optionally with a specific value
+type labelSelector struct {
+	key    string
+	value  string
+	invert bool
+}
+
+func newLabelSelector(params Params) (Selector, error) {
+	s := labelSelector{key: params.String("key", ""), value: params.String("value", "")}
+	if s.key == "" {
+		return nil, errors.New("label: key is required")
+	}
+	invert, err := params.Bool("invert", false)
+	if err != nil {
+		return nil, err
+	}
+	s.invert = invert
+	return s, nil
+}
+
+func (s labelSelector) Select(candidates []*Tag) ([]*Tag, error) {
+	var selected []*Tag
+	for _, tag := range candidates {
+		labels, err := tag.Labels()
+		if err != nil {
+			return nil, err
+		}
+		value, ok := labels[s.key]
+		matches := ok && (s.value == "" || value == s.value)
+		if matches != s.invert {
+			selected = append(selected, tag)
+		}
+	}
+	return selected, nil
+}
+
+// lastDownloadSelector selects tags not pulled for longer than the given duration. Tags never pulled are judged by
+// the time the image was built
+type lastDownloadSelector struct {
+	olderThan time.Duration
+}
+
+func newLastDownloadSelector(params Params) (Selector, error) {
+	olderThan, err := params.Duration("older_than", 0)
+	if err != nil {
+		return nil, err
+	}
+	if olderThan <= 0 {
+		return nil, errors.New("last-download: older_than is required")
+	}
+	return lastDownloadSelector{olderThan: olderThan}, nil
+}
+
+func (s lastDownloadSelector) Select(candidates []*Tag) ([]*Tag, error) {
+	var selected []*Tag
+	for _, tag := range candidates {
+		last, ok, err := tag.LastDownloaded()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if last, err = tag.Created(); err != nil {
+				return nil, err
+			}
+		}
+		if now().Sub(last) > s.olderThan {
+			selected = append(selected, tag)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"github.com/eugenmayer/nexus-cli/registry"
+	"time"
+)
+
+// Tag is a tag under evaluation. Its metadata is fetched from the registry on first use and then cached, so
+// selectors only pay for what they look at
+type Tag struct {
+	Image string
+	Name  string
+
+	registry registry.Registry
+	config   *registry.ImageConfig
+	asset    *registry.Asset
+	size     *int64
+}
+
+// NewTag creates a candidate tag whose metadata is loaded from r
+func NewTag(r registry.Registry, image string, name string) *Tag {
+	return &Tag{Image: image, Name: name, registry: r}
+}
+
+// Config returns the image configuration of the tag
+func (t *Tag) Config() (registry.ImageConfig, error) {
+	if t.config == nil {
+		config, err := t.registry.ImageConfig(t.Image, t.Name)
+		if err != nil {
+			return config, err
+		}
+		t.config = &config
+	}
+	return *t.config, nil
+}
+
+// Created returns when the image was built, as recorded in its configuration
+func (t *Tag) Created() (time.Time, error) {
+	config, err := t.Config()
+	return config.Created, err
+}
+
+// Labels returns the labels of the image configuration
+func (t *Tag) Labels() (map[string]string, error) {
+	config, err := t.Config()
+	return config.Config.Labels, err
+}
+
+// LastDownloaded returns when the tag was last pulled according to Nexus, ok is false if it never was
+func (t *Tag) LastDownloaded() (time.Time, bool, error) {
+	if t.asset == nil {
+		asset, err := t.registry.ManifestAsset(t.Image, t.Name)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		t.asset = &asset
+	}
+	if t.asset.LastDownloaded == nil {
+		return time.Time{}, false, nil
+	}
+	return *t.asset.LastDownloaded, true, nil
+}
+
+// Size returns the bytes stored for the image of the tag
+func (t *Tag) Size() (int64, error) {
+	if t.size == nil {
+		size, err := t.registry.ImageSize(t.Image, t.Name)
+		if err != nil {
+			return 0, err
+		}
+		t.size = &size
+	}
+	return *t.size, nil
+}
+
+// Registry returns the registry the tag lives in, for selectors needing more than the provided metadata
+func (t *Tag) Registry() registry.Registry {
+	return t.registry
+}
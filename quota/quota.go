@@ -0,0 +1,167 @@
+// Package quota attributes the storage of a repository to teams by image name prefix
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/eugenmayer/nexus-cli/registry"
+	"github.com/eugenmayer/nexus-cli/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// Unassigned is the team of images no prefix matches
+const Unassigned = "(unassigned)"
+
+// Config maps image name prefixes to teams, usually loaded from a YAML file:
+//
+//	teams:
+//	  - name: payments
+//	    prefixes: [payments/, billing/]
+//	    budget: 50GiB
+//	  - name: web
+//	    prefixes: [web/]
+//	    budget: 200GB
+//
+// An image belongs to the team with the longest matching prefix
+type Config struct {
+	Teams []Team `yaml:"teams"`
+}
+
+// Team owns the images starting with one of its prefixes
+type Team struct {
+	Name     string   `yaml:"name"`
+	Prefixes []string `yaml:"prefixes"`
+	// Budget is the storage the team may use, e.g. 50GiB. Teams without budget are only reported
+	Budget string `yaml:"budget"`
+
+	budget int64
+}
+
+// Load reads and validates a quota file
+func Load(path string) (Config, error) {
+	var c Config
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := yaml.UnmarshalStrict(content, &c); err != nil {
+		return c, errors.New(fmt.Sprintf("Invalid quotas %s: %s", path, err))
+	}
+	if err := c.compile(); err != nil {
+		return c, errors.New(fmt.Sprintf("Invalid quotas %s: %s", path, err))
+	}
+	return c, nil
+}
+
+func (c *Config) compile() error {
+	if len(c.Teams) == 0 {
+		return errors.New("no teams defined")
+	}
+	owners := map[string]string{}
+	for i := range c.Teams {
+		team := &c.Teams[i]
+		if team.Name == "" || team.Name == Unassigned {
+			return errors.New(fmt.Sprintf("team %d needs a name", i+1))
+		}
+		if len(team.Prefixes) == 0 {
+			return errors.New(fmt.Sprintf("%s: no prefixes defined", team.Name))
+		}
+		for _, prefix := range team.Prefixes {
+			if owner, ok := owners[prefix]; ok {
+				return errors.New(fmt.Sprintf("%s: prefix %s belongs to %s already", team.Name, prefix, owner))
+			}
+			owners[prefix] = team.Name
+		}
+		if team.Budget != "" {
+			var err error
+			if team.budget, err = utils.ParseBytes(team.Budget); err != nil {
+				return errors.New(fmt.Sprintf("%s: %s", team.Name, err))
+			}
+		}
+	}
+	return nil
+}
+
+// TeamOf returns the team an image belongs to, Unassigned if none
+func (c Config) TeamOf(image string) string {
+	team, longest := Unassigned, -1
+	for _, t := range c.Teams {
+		for _, prefix := range t.Prefixes {
+			if strings.HasPrefix(image, prefix) && len(prefix) > longest {
+				team, longest = t.Name, len(prefix)
+			}
+		}
+	}
+	return team
+}
+
+// Usage is the storage attributed to a team
+type Usage struct {
+	Team   string `json:"team"`
+	Images int    `json:"images"`
+	Tags   int    `json:"tags"`
+	// Bytes counts every blob the images of the team reference once, layers shared with other teams included. It is
+	// what the team would need on its own
+	Bytes int64 `json:"bytes"`
+	// Exclusive counts the blobs no other team references, what deleting all images of the team would free
+	Exclusive int64 `json:"exclusive_bytes"`
+	// Budget is 0 if the team has none
+	Budget int64 `json:"budget,omitempty"`
+}
+
+// Over tells if the team uses more than its budget
+func (u Usage) Over() bool {
+	return u.Budget > 0 && u.Bytes > u.Budget
+}
+
+// Report attributes the blobs of the images (see registry.ImageBlobs) to the teams. Teams are sorted by their usage,
+// the largest first. Unassigned images are reported as team Unassigned if there are any
+func (c Config) Report(inventory registry.Inventory, blobs map[string]map[string]int64) []Usage {
+	usage := map[string]*Usage{}
+	teamBlobs := map[string]map[string]int64{}
+	for _, t := range c.Teams {
+		usage[t.Name] = &Usage{Team: t.Name, Budget: t.budget}
+		teamBlobs[t.Name] = map[string]int64{}
+	}
+
+	// owners counts the teams referencing each blob
+	owners := map[string]map[string]bool{}
+	for image, tags := range inventory {
+		team := c.TeamOf(image)
+		if _, ok := usage[team]; !ok {
+			usage[team] = &Usage{Team: team}
+			teamBlobs[team] = map[string]int64{}
+		}
+		usage[team].Images++
+		usage[team].Tags += len(tags)
+		for blob, size := range blobs[image] {
+			teamBlobs[team][blob] = size
+			if owners[blob] == nil {
+				owners[blob] = map[string]bool{}
+			}
+			owners[blob][team] = true
+		}
+	}
+
+	var report []Usage
+	for team, u := range usage {
+		for blob, size := range teamBlobs[team] {
+			u.Bytes += size
+			if len(owners[blob]) == 1 {
+				u.Exclusive += size
+			}
+		}
+		report = append(report, *u)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Bytes != report[j].Bytes {
+			return report[i].Bytes > report[j].Bytes
+		}
+		return report[i].Team < report[j].Team
+	})
+	return report
+}
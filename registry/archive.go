@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// archiveManifest is an entry of manifest.json in archives as written by `docker save`
+type archiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// WriteDockerArchive streams an image in the format of `docker save` to w, as accepted by `docker load` and
+// `ctr images import`. Layers are written as stored, both load them compressed. For indexes the manifest of the given
+// platform is used, see PlatformManifest. repoTags are the names the image is loaded as
+func (r Registry) WriteDockerArchive(w io.Writer, image string, reference string, platform string, repoTags []string) error {
+	manifest, err := r.PlatformManifest(image, reference, platform)
+	if err != nil {
+		return err
+	}
+	if manifest.Config.Digest == "" {
+		return errors.New(fmt.Sprintf("%s:%s is not an image", image, reference))
+	}
+
+	tw := tar.NewWriter(w)
+	entry := archiveManifest{Config: archiveName(manifest.Config.Digest) + ".json", RepoTags: repoTags}
+	if err := r.writeBlobEntry(tw, image, manifest.Config, entry.Config); err != nil {
+		return err
+	}
+	for _, layer := range manifest.Layers {
+		if strings.Contains(layer.MediaType, "foreign") || strings.Contains(layer.MediaType, "nondistributable") {
+			return errors.New(fmt.Sprintf("%s:%s has a foreign layer %s which is not stored in the registry", image, reference, layer.Digest))
+		}
+		name := archiveName(layer.Digest) + "/layer.tar"
+		if err := r.writeBlobEntry(tw, image, layer, name); err != nil {
+			return err
+		}
+		entry.Layers = append(entry.Layers, name)
+	}
+
+	index, err := json.Marshal([]archiveManifest{entry})
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(index))}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(index); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func (r Registry) writeBlobEntry(tw *tar.Writer, image string, blob LayerInfo, name string) error {
+	content, size, err := r.GetBlob(image, blob.Digest)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	if size < 0 {
+		size = blob.Size
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, content)
+	return err
+}
+
+// archiveName turns a digest into a file name, sha256:abc becomes abc
+func archiveName(digest string) string {
+	if i := strings.Index(digest, ":"); i >= 0 {
+		return digest[i+1:]
+	}
+	return digest
+}
@@ -0,0 +1,199 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// BaseCurrent marks images built on the current tag of their base
+	BaseCurrent = "current"
+	// BaseOutdated marks images built on another, older tag of their base image
+	BaseOutdated = "outdated"
+	// BaseUnknown marks images whose leading layers match none of the known bases
+	BaseUnknown = "unknown"
+	// BaseStale marks images built on the current tag of their base, but not on the layers it has upstream anymore
+	BaseStale = "stale"
+)
+
+// BaseRef names a base image and its current tag. All other tags of the image are known as older versions of it
+type BaseRef struct {
+	Image string
+	Tag   string
+}
+
+// BaseUsage tells which base an application image is built on
+type BaseUsage struct {
+	Image  string `json:"image"`
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+	// Base is the image:tag whose layers the image starts with, empty if unknown
+	Base string `json:"base,omitempty"`
+	// Current is the image:tag the base should be
+	Current string `json:"current,omitempty"`
+	// UpstreamDigest is the digest of the current tag in the upstream registry, if one was given
+	UpstreamDigest string `json:"upstream_digest,omitempty"`
+	Status         string `json:"status"`
+}
+
+// baseLayers is one tag of a base image with the layers of its manifest
+type baseLayers struct {
+	ref     BaseRef
+	current BaseRef
+	layers  []string
+	// upstream is set for the current tag as resolved in the upstream registry
+	upstream string
+}
+
+// BaseImageReport matches the leading layers of the tags of the given images (all images of the repository but the
+// bases if none are given) against every tag of the base images, read from bases. The base sharing the most layers
+// wins. Images are current when that base has the layers of the current tag. For indexes the manifest of platform
+// is compared, see PlatformManifest. With an upstream registry (e.g. a proxy of Docker Hub) the current tags are
+// resolved there instead, images are current only if they have its layers and stale if they are on the local copy
+// of the current tag that upstream has moved on from
+func (r Registry) BaseImageReport(bases Registry, upstream *Registry, refs []BaseRef, images []string, platform string) ([]BaseUsage, error) {
+	var known []baseLayers
+	isBase := map[string]bool{}
+	for _, ref := range refs {
+		isBase[ref.Image] = true
+		if upstream != nil {
+			manifest, err := upstream.PlatformManifest(ref.Image, ref.Tag, platform)
+			if err != nil {
+				return nil, err
+			}
+			if manifest.IsIndex() || len(manifest.Layers) == 0 {
+				// no layers lead every image, all would be current
+				return nil, errors.New(fmt.Sprintf("%s:%s is no image upstream, it has no layers to compare", ref.Image, ref.Tag))
+			}
+			digest, err := upstream.ImageDigest(ref.Image, ref.Tag)
+			if err != nil {
+				return nil, err
+			}
+			known = append(known, baseLayers{ref, ref, layerDigests(manifest), digest})
+		}
+		tags, err := bases.ListTagsByImage(ref.Image)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			manifest, err := bases.PlatformManifest(ref.Image, tag, platform)
+			if err != nil {
+				return nil, err
+			}
+			if manifest.IsIndex() || len(manifest.Layers) == 0 {
+				// artifacts and indexes without the platform can't be a base
+				continue
+			}
+			known = append(known, baseLayers{BaseRef{ref.Image, tag}, ref, layerDigests(manifest), ""})
+		}
+	}
+
+	if len(images) == 0 {
+		all, err := r.ListImages()
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range all {
+			if !isBase[image] || bases.Host != r.Host || bases.Repository != r.Repository {
+				images = append(images, image)
+			}
+		}
+	}
+
+	var report []BaseUsage
+	for _, image := range images {
+		tags, err := r.ListTagsByImage(image)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			manifest, err := r.PlatformManifest(image, tag, platform)
+			if err != nil {
+				return nil, err
+			}
+			if manifest.IsIndex() || len(manifest.Layers) == 0 {
+				continue
+			}
+			digest, err := r.ImageDigest(image, tag)
+			if err != nil {
+				return nil, err
+			}
+			usage := BaseUsage{Image: image, Tag: tag, Digest: digest, Status: BaseUnknown}
+			if match := matchBase(known, layerDigests(manifest)); match != nil {
+				usage.Base = match.ref.Image + ":" + match.ref.Tag
+				usage.Current = match.current.Image + ":" + match.current.Tag
+				usage.UpstreamDigest = upstreamDigest(known, match.current)
+				usage.Status = BaseOutdated
+				if isCurrentBase(known, match, upstream != nil) {
+					usage.Status = BaseCurrent
+				} else if upstream != nil && match.ref == match.current {
+					usage.Status = BaseStale
+				}
+			}
+			report = append(report, usage)
+		}
+	}
+	return report, nil
+}
+
+// matchBase returns the base with the most layers all of which lead the given layers. On a tie the current tag is
+// preferred, so images are not reported outdated because an older tag shares the layers
+func matchBase(known []baseLayers, layers []string) *baseLayers {
+	var best *baseLayers
+	for i, base := range known {
+		if !hasLayerPrefix(layers, base.layers) {
+			continue
+		}
+		if best == nil || len(base.layers) > len(best.layers) ||
+			len(base.layers) == len(best.layers) && base.ref == base.current && best.ref != best.current {
+			best = &known[i]
+		}
+	}
+	return best
+}
+
+// isCurrentBase tells if the matched base has the same layers as the current tag of its image, as resolved upstream
+// if there is an upstream registry
+func isCurrentBase(known []baseLayers, match *baseLayers, upstream bool) bool {
+	for _, base := range known {
+		if base.ref == match.current && (base.upstream != "") == upstream {
+			return equalLayers(base.layers, match.layers)
+		}
+	}
+	return false
+}
+
+func upstreamDigest(known []baseLayers, current BaseRef) string {
+	for _, base := range known {
+		if base.ref == current && base.upstream != "" {
+			return base.upstream
+		}
+	}
+	return ""
+}
+
+func layerDigests(manifest ImageManifest) []string {
+	var digests []string
+	for _, layer := range manifest.Layers {
+		digests = append(digests, layer.Digest)
+	}
+	return digests
+}
+
+func hasLayerPrefix(layers []string, prefix []string) bool {
+	return len(prefix) <= len(layers) && equalLayers(layers[:len(prefix)], prefix)
+}
+
+func equalLayers(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
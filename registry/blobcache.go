@@ -0,0 +1,268 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// blobCacheDrain is how much of a blob is still read when it is closed early, so the cache gets it anyway. Tar
+// readers stop before the padding at the end of a layer
+const blobCacheDrain = 1 << 20
+
+var (
+	// blobCacheDir holds blobs by digest, empty disables the blob cache
+	blobCacheDir string
+	blobCacheMax int64
+
+	// blobCacheMu keeps the evictions of parallel downloads apart
+	blobCacheMu sync.Mutex
+)
+
+// ConfigureBlobCache applies the --blob-cache-dir and --blob-cache-size flags. Blobs read (layers copied, scanned,
+// extracted) are then kept in dir by digest, so they are downloaded once however often they are used. The least
+// recently used ones are removed once the cache grows beyond maxSize, 0 is no limit
+func ConfigureBlobCache(dir string, maxSize int64) {
+	blobCacheDir, blobCacheMax = dir, maxSize
+}
+
+// blobCachePath returns where a blob is cached, sha256/ab/abcdef...
+func blobCachePath(digest string) (string, bool) {
+	parts := strings.SplitN(digest, ":", 2)
+	if blobCacheDir == "" || len(parts) != 2 || len(parts[1]) < 3 || strings.ContainsAny(parts[1], `/\.`) {
+		return "", false
+	}
+	return filepath.Join(blobCacheDir, parts[0], parts[1][:2], parts[1]), true
+}
+
+// cachedBlob opens a blob of the cache, marking it as used
+func cachedBlob(digest string) (io.ReadCloser, int64, bool) {
+	path, ok := blobCachePath(digest)
+	if !ok {
+		return nil, 0, false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return f, info.Size(), true
+}
+
+// cachingReader passes a blob on while writing it to the cache. It is only added once its content matched the
+// digest, a download ending early leaves nothing behind
+type cachingReader struct {
+	body      io.ReadCloser
+	digest    string
+	path      string
+	h         hash.Hash
+	tmp       *os.File
+	remaining int64
+}
+
+// cacheBlob returns body writing the blob to the cache as it is read, or body itself if the cache is off
+func cacheBlob(body io.ReadCloser, digest string, size int64) io.ReadCloser {
+	path, ok := blobCachePath(digest)
+	if !ok {
+		return body
+	}
+	h, err := digestHash(digest)
+	if err != nil {
+		return body
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return body
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return body
+	}
+	return &cachingReader{body: body, digest: digest, path: path, h: h, tmp: tmp, remaining: size}
+}
+
+func (c *cachingReader) Read(p []byte) (int, error) {
+	n, err := c.body.Read(p)
+	if n > 0 && c.tmp != nil {
+		c.h.Write(p[:n])
+		if _, writeErr := c.tmp.Write(p[:n]); writeErr != nil {
+			// e.g. a full disk, the download goes on without the cache
+			c.abandon()
+		}
+	}
+	if c.remaining > 0 {
+		c.remaining -= int64(n)
+	}
+	if err == io.EOF && c.tmp != nil {
+		c.store()
+	}
+	return n, err
+}
+
+func (c *cachingReader) Close() error {
+	if c.tmp != nil && c.remaining >= 0 && c.remaining <= blobCacheDrain {
+		io.Copy(ioutil.Discard, c)
+	}
+	c.abandon()
+	return c.body.Close()
+}
+
+func (c *cachingReader) abandon() {
+	if c.tmp != nil {
+		c.tmp.Close()
+		os.Remove(c.tmp.Name())
+		c.tmp = nil
+	}
+}
+
+func (c *cachingReader) store() {
+	tmp := c.tmp
+	c.tmp = nil
+	if err := tmp.Close(); err != nil || hashDigest(c.digest, c.h) != c.digest {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if blobCacheMax > 0 {
+		blobCacheMu.Lock()
+		evictBlobs(blobCacheDir, blobCacheMax)
+		blobCacheMu.Unlock()
+	}
+}
+
+type cacheEntry struct {
+	path string
+	size int64
+	used time.Time
+}
+
+// cacheEntries lists the blobs of a blob cache, leftovers of interrupted downloads are removed
+func cacheEntries(dir string) ([]cacheEntry, error) {
+	var entries []cacheEntry
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// removed by another process meanwhile
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".tmp-") {
+			if time.Since(info.ModTime()) > time.Hour {
+				os.Remove(path)
+			}
+			return nil
+		}
+		entries = append(entries, cacheEntry{path: path, size: info.Size(), used: info.ModTime()})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// evictBlobs removes the least recently used blobs until the cache holds at most maxSize bytes
+func evictBlobs(dir string, maxSize int64) (int, int64, error) {
+	entries, err := cacheEntries(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+	removed, freed := 0, int64(0)
+	for _, e := range entries {
+		if total <= maxSize {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return removed, freed, err
+		}
+		total -= e.size
+		removed++
+		freed += e.size
+	}
+	return removed, freed, nil
+}
+
+// BlobCacheStats tells what the blob cache holds
+type BlobCacheStats struct {
+	Dir     string
+	Blobs   int
+	Size    int64
+	MaxSize int64
+	// Oldest and Newest are when the least and the most recently used blobs were used
+	Oldest time.Time
+	Newest time.Time
+}
+
+// BlobCache returns the statistics of the blob cache given with ConfigureBlobCache
+func BlobCache() (BlobCacheStats, error) {
+	stats := BlobCacheStats{Dir: blobCacheDir, MaxSize: blobCacheMax}
+	if blobCacheDir == "" {
+		return stats, errors.New("No blob cache configured, give --blob-cache-dir or NEXUS_CLI_BLOB_CACHE_DIR")
+	}
+	entries, err := cacheEntries(blobCacheDir)
+	if err != nil {
+		return stats, err
+	}
+	for _, e := range entries {
+		stats.Blobs++
+		stats.Size += e.size
+		if stats.Oldest.IsZero() || e.used.Before(stats.Oldest) {
+			stats.Oldest = e.used
+		}
+		if e.used.After(stats.Newest) {
+			stats.Newest = e.used
+		}
+	}
+	return stats, nil
+}
+
+// ClearBlobCache removes the blobs of the cache not used for olderThan, all of them for 0. Returns how many were
+// removed and the bytes freed
+func ClearBlobCache(olderThan time.Duration) (int, int64, error) {
+	if blobCacheDir == "" {
+		return 0, 0, errors.New("No blob cache configured, give --blob-cache-dir or NEXUS_CLI_BLOB_CACHE_DIR")
+	}
+	blobCacheMu.Lock()
+	defer blobCacheMu.Unlock()
+	entries, err := cacheEntries(blobCacheDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	removed, freed := 0, int64(0)
+	for _, e := range entries {
+		if olderThan > 0 && time.Since(e.used) < olderThan {
+			continue
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return removed, freed, errors.New(fmt.Sprintf("Removing %s failed: %s", e.path, err))
+		}
+		removed++
+		freed += e.size
+	}
+	return removed, freed, nil
+}
@@ -0,0 +1,218 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/events"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// BlobExists checks whether the blob with the given digest is available for the image
+func (r Registry) BlobExists(image string, digest string) (bool, error) {
+	exists, _, err := r.statBlob(image, digest)
+	return exists, err
+}
+
+// statBlob checks whether a blob is available and returns its size as reported by the registry, -1 if it does not tell
+func (r Registry) statBlob(image string, digest string) (bool, int64, error) {
+	blobURL := fmt.Sprintf("%s/repository/%s/v2/%s/blobs/%s", r.Host, r.Repository, imagePath(image), digest)
+	resp, err := r.do("HEAD", blobURL, "", "", nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		return true, resp.ContentLength, nil
+	case 404:
+		return false, 0, nil
+	default:
+		return false, 0, r.newError(resp)
+	}
+}
+
+// GetBlob opens the content of a blob, the caller has to close it. Returns the size as reported by the registry.
+// With the blob cache configured (see ConfigureBlobCache) cached blobs are read from disk and others are added
+func (r Registry) GetBlob(image string, digest string) (io.ReadCloser, int64, error) {
+	if content, size, ok := cachedBlob(digest); ok {
+		return content, size, nil
+	}
+	blobURL := fmt.Sprintf("%s/repository/%s/v2/%s/blobs/%s", r.Host, r.Repository, imagePath(image), digest)
+	resp, err := r.do("GET", blobURL, "", "", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, 0, r.newError(resp)
+	}
+
+	body := resp.Body
+	if events.Enabled() {
+		body = &countingBody{body: body, digest: digest}
+	}
+	return cacheBlob(body, digest, resp.ContentLength), resp.ContentLength, nil
+}
+
+// countingBody emits the Bytes event of a blob download once it is closed. Uploads of the blob close it as well
+type countingBody struct {
+	body   io.ReadCloser
+	digest string
+	n      int64
+	once   sync.Once
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	b.once.Do(func() {
+		events.Transferred(b.digest, b.n, "download")
+	})
+	return b.body.Close()
+}
+
+// DownloadBlob writes the content of a blob to w, verifying it against the digest. On a mismatch the content written
+// so far is not to be trusted. Returns the number of bytes written
+func (r Registry) DownloadBlob(image string, digest string, w io.Writer) (int64, error) {
+	h, err := digestHash(digest)
+	if err != nil {
+		return 0, err
+	}
+	content, _, err := r.GetBlob(image, digest)
+	if err != nil {
+		return 0, err
+	}
+	defer content.Close()
+
+	n, err := io.Copy(io.MultiWriter(w, h), content)
+	if err != nil {
+		return n, err
+	}
+	if actual := hashDigest(digest, h); actual != digest {
+		return n, errors.New(fmt.Sprintf("blob %s has the digest %s after download", digest, actual))
+	}
+	return n, nil
+}
+
+// UploadFile pushes a file as blob of image and returns its sha256 digest and size. Blobs the registry has already
+// are not sent again, uploaded tells if it was
+func (r Registry) UploadFile(image string, path string) (digest string, size int64, uploaded bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if size, err = io.Copy(h, f); err != nil {
+		return "", 0, false, err
+	}
+	digest = "sha256:" + hex.EncodeToString(h.Sum(nil))
+	exists, err := r.BlobExists(image, digest)
+	if err != nil || exists {
+		return digest, size, false, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, false, err
+	}
+	// the registry checks the content against the digest, so a file changed meanwhile is refused
+	return digest, size, true, r.UploadBlob(image, digest, size, f)
+}
+
+// digestHash returns the hash a digest was computed with
+func digestHash(digest string) (hash.Hash, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errors.New(fmt.Sprintf("invalid digest %q, expected e.g. sha256:<hex>", digest))
+	}
+	switch parts[0] {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported digest algorithm %q in %s", parts[0], digest))
+	}
+}
+
+func hashDigest(expected string, h hash.Hash) string {
+	return strings.SplitN(expected, ":", 2)[0] + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// UploadBlob pushes a blob as a monolithic upload. The registry verifies the content against the digest
+func (r Registry) UploadBlob(image string, digest string, size int64, content io.Reader) error {
+	location, err := r.startUpload(image)
+	if err != nil {
+		return err
+	}
+	if err := r.finishUpload(location, digest, size, content); err != nil {
+		return err
+	}
+	events.Transferred(digest, size, "upload")
+	return nil
+}
+
+// startUpload starts an upload session for a blob of image and returns its location
+func (r Registry) startUpload(image string) (string, error) {
+	uploadURL := fmt.Sprintf("%s/repository/%s/v2/%s/blobs/uploads/", r.Host, r.Repository, imagePath(image))
+	resp, err := r.do("POST", uploadURL, "", "", nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 202 {
+		return "", r.newError(resp)
+	}
+	return r.resolveLocation(resp.Header.Get("Location"))
+}
+
+// finishUpload completes an upload session with the last size bytes of the blob, the registry verifies the digest
+func (r Registry) finishUpload(location string, digest string, size int64, content io.Reader) error {
+	separator := "?"
+	if strings.Contains(location, "?") {
+		separator = "&"
+	}
+	location = location + separator + "digest=" + url.QueryEscape(digest)
+
+	resp, err := r.doSized("PUT", location, "application/octet-stream", content, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 201 {
+		return r.newError(resp)
+	}
+	return nil
+}
+
+// resolveLocation turns the (possibly relative) Location header of an upload into an absolute url
+func (r Registry) resolveLocation(location string) (string, error) {
+	if location == "" {
+		return "", errors.New("registry did not return an upload location")
+	}
+	base, err := url.Parse(r.Host + "/")
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
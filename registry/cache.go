@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// cacheDir holds the responses kept for revalidation, empty disables the cache
+var cacheDir string
+
+// ConfigureCache applies the --cache-dir flag. Catalogs, tag lists and manifests are then kept in dir and
+// revalidated with If-None-Match, so a repeated listing costs Nexus a 304 instead of the whole answer
+func ConfigureCache(dir string) {
+	cacheDir = dir
+}
+
+// cachedResponse is a response stored in the cache
+type cachedResponse struct {
+	ETag   string      `json:"etag"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// cachedHeaders are the response headers callers of get read
+var cachedHeaders = []string{"Content-Type", "Docker-Content-Digest", "Etag"}
+
+// get sends a GET request. With the cache enabled the ETag of the cached response is sent as If-None-Match and a
+// 304 is answered from the cache, as a 200 with the cached body
+func (r Registry) get(url string, accept string) (*http.Response, error) {
+	return r.getContext(context.Background(), url, accept)
+}
+
+// getContext is like get, the request is canceled with ctx
+func (r Registry) getContext(ctx context.Context, url string, accept string) (*http.Response, error) {
+	if cacheDir == "" {
+		return r.doContext(ctx, "GET", url, accept, "", nil)
+	}
+
+	// the user is part of the key, others may not see the same content
+	key := fmt.Sprintf("%x", sha256.Sum256([]byte(r.Username+"\n"+url+"\n"+accept)))
+	path := filepath.Join(cacheDir, key[:2], key)
+	var cached *cachedResponse
+	if content, err := ioutil.ReadFile(path); err == nil {
+		var entry cachedResponse
+		if json.Unmarshal(content, &entry) == nil && entry.ETag != "" {
+			cached = &entry
+		}
+	}
+
+	req, err := r.newRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if accept != "" {
+		req.Header.Add("Accept", accept)
+	}
+	if cached != nil {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		for _, name := range cachedHeaders {
+			if value := cached.Header.Get(name); value != "" {
+				resp.Header.Set(name, value)
+			}
+		}
+		resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+		resp.Body = ioutil.NopCloser(bytes.NewReader(cached.Body))
+		return resp, nil
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Etag") == "" {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	entry := cachedResponse{ETag: resp.Header.Get("Etag"), Header: http.Header{}, Body: body}
+	for _, name := range cachedHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			entry.Header.Set(name, value)
+		}
+	}
+	// the cache only saves requests, failing to write it is not worth failing the command
+	if content, err := json.Marshal(entry); err == nil {
+		if os.MkdirAll(filepath.Dir(path), 0700) == nil {
+			writeCacheFile(path, content)
+		}
+	}
+	return resp, nil
+}
+
+// writeCacheFile replaces the file atomically, parallel requests for the same URL must not see a torn entry
+func writeCacheFile(path string, content []byte) {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || os.Rename(f.Name(), path) != nil {
+		os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,113 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// NameFilter selects images by name while the catalog is listed, see EachImageMatching
+type NameFilter struct {
+	re   *regexp.Regexp
+	glob string
+	// namespace ends with a slash, only images below it are matched
+	namespace string
+	// prefix begins every name the filter matches, empty if there is no such prefix
+	prefix string
+}
+
+// ParseNameFilter parses a filter given as name=~<regular expression> or name=<glob>, e.g. name=~^team-x/ or
+// name=team-x/*. As in paths, * does not match the slashes of nested names, team-x/* does not match
+// team-x/project/service, see NamespaceFilter
+func ParseNameFilter(expr string) (NameFilter, error) {
+	switch {
+	case strings.HasPrefix(expr, "name=~"):
+		re, err := regexp.Compile(strings.TrimPrefix(expr, "name=~"))
+		if err != nil {
+			return NameFilter{}, errors.New(fmt.Sprintf("Invalid filter %s: %s", expr, err))
+		}
+		return RegexpNameFilter(re), nil
+	case strings.HasPrefix(expr, "name="):
+		glob := strings.TrimPrefix(expr, "name=")
+		if _, err := path.Match(glob, ""); err != nil {
+			return NameFilter{}, errors.New(fmt.Sprintf("Invalid filter %s: %s", expr, err))
+		}
+		prefix := glob
+		if i := strings.IndexAny(glob, `*?[\`); i >= 0 {
+			prefix = glob[:i]
+		}
+		return NameFilter{glob: glob, prefix: prefix}, nil
+	default:
+		return NameFilter{}, errors.New(fmt.Sprintf("Invalid filter %s, expected name=~<regular expression> or name=<glob>", expr))
+	}
+}
+
+// RegexpNameFilter filters by a regular expression. Only those anchored with ^ have a prefix to narrow the listing to
+func RegexpNameFilter(re *regexp.Regexp) NameFilter {
+	f := NameFilter{re: re}
+	if strings.HasPrefix(re.String(), "^") {
+		f.prefix, _ = re.LiteralPrefix()
+	}
+	return f
+}
+
+// NamespaceFilter filters the images below a namespace, at any depth: team/project selects team/project/service and
+// team/project/api/v1 but not team/project-x/service. The namespace has to be cleaned, see CleanNamespace
+func NamespaceFilter(namespace string) NameFilter {
+	return NameFilter{}.Within(namespace)
+}
+
+// Within narrows the filter to the images below namespace, see NamespaceFilter
+func (f NameFilter) Within(namespace string) NameFilter {
+	f.namespace = namespace + "/"
+	if strings.HasPrefix(f.namespace, f.prefix) {
+		f.prefix = f.namespace
+	}
+	return f
+}
+
+// Match tells if the filter selects the image
+func (f NameFilter) Match(image string) bool {
+	if !strings.HasPrefix(image, f.namespace) {
+		return false
+	}
+	if f.re != nil {
+		return f.re.MatchString(image)
+	}
+	if f.glob == "" {
+		return true
+	}
+	matched, _ := path.Match(f.glob, image)
+	return matched
+}
+
+// EachImageMatching calls fn with the images f matches as the pages of the catalog come in, instead of loading the
+// whole catalog first. The registry API lists the catalog in lexical order: a filter whose matches all begin with
+// the same prefix starts the listing right before it and stops once past it. Catalogs found out of order are listed
+// completely. fn returning an error stops the listing, the error is returned
+func (r Registry) EachImageMatching(ctx context.Context, f NameFilter, fn func(image string) error) error {
+	it := r.ImagesIter(ctx)
+	if len(f.prefix) > 1 {
+		// last is exclusive and nothing before the prefix matches
+		it.query.Set("last", f.prefix[:len(f.prefix)-1])
+	}
+	sorted, previous := true, ""
+	for it.Next() {
+		image := it.Value()
+		if image < previous {
+			sorted = false
+		}
+		previous = image
+		if f.Match(image) {
+			if err := fn(image); err != nil {
+				return err
+			}
+		} else if sorted && f.prefix != "" && image > f.prefix && !strings.HasPrefix(image, f.prefix) {
+			return nil
+		}
+	}
+	return it.Err()
+}
@@ -0,0 +1,102 @@
+package registry_test
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eugenmayer/nexus-cli/registry"
+	"github.com/eugenmayer/nexus-cli/registrytest"
+)
+
+func TestNameFilterMatch(t *testing.T) {
+	namespace := registry.NamespaceFilter("team/project")
+	glob, err := registry.ParseNameFilter("name=team/*/service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, err := registry.ParseNameFilter("name=~^team/.*-api$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		filter registry.NameFilter
+		image  string
+		want   bool
+	}{
+		{"namespace", namespace, "team/project/service", true},
+		{"namespace", namespace, "team/project/api/v1", true},
+		{"namespace", namespace, "team/projectx/service", false},
+		{"namespace", namespace, "team/project-x/service", false},
+		{"namespace", namespace, "team/project", false},
+		{"namespace", namespace, "other/team/project/service", false},
+		{"glob", glob, "team/project/service", true},
+		{"glob", glob, "team/project/api/service", false},
+		{"glob within", glob.Within("team/project"), "team/project/service", true},
+		{"glob within", glob.Within("team/project"), "team/projectx/service", false},
+		{"regexp", re, "team/project/web-api", true},
+		{"regexp within", re.Within("team/projectx"), "team/project/web-api", false},
+		{"regexp within", re.Within("team/projectx"), "team/projectx/web-api", true},
+	}
+	for _, test := range tests {
+		if got := test.filter.Match(test.image); got != test.want {
+			t.Errorf("%s filter: Match(%q) = %t, want %t", test.name, test.image, got, test.want)
+		}
+	}
+}
+
+func TestParseNameFilterInvalid(t *testing.T) {
+	for _, expr := range []string{"team/*", "name=~(", "name=team/[", "tag=1.0"} {
+		if _, err := registry.ParseNameFilter(expr); err == nil {
+			t.Errorf("ParseNameFilter(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+// TestEachImageMatchingNamespace checks a namespace lists its images only, the listing starting right before it and
+// stopping once past it
+func TestEachImageMatchingNamespace(t *testing.T) {
+	srv := registrytest.NewServer()
+	defer srv.Close()
+	srv.PageSize = 2
+	for _, image := range []string{"alpha", "team/project-x/service", "team/project/api/v1", "team/project/service", "team/projectx/service", "zeta/a", "zeta/b"} {
+		srv.PushImage("docker-hosted", image, "1.0", registrytest.Image{})
+	}
+	r := srv.Registry("docker-hosted")
+
+	tests := []struct {
+		namespace string
+		want      []string
+	}{
+		{"team/project", []string{"team/project/api/v1", "team/project/service"}},
+		{"team/projectx", []string{"team/projectx/service"}},
+		{"team/project-x", []string{"team/project-x/service"}},
+		{"team", []string{"team/project-x/service", "team/project/api/v1", "team/project/service", "team/projectx/service"}},
+	}
+	for _, test := range tests {
+		before := len(srv.Requests())
+		var got []string
+		err := r.EachImageMatching(context.Background(), registry.NamespaceFilter(test.namespace), func(image string) error {
+			got = append(got, image)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("--namespace %s: %s", test.namespace, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("--namespace %s lists %q, want %q", test.namespace, got, test.want)
+		}
+		requests := srv.Requests()[before:]
+		if want := "last=" + url.QueryEscape(test.namespace) + "&"; !strings.Contains(requests[0], want) {
+			t.Errorf("--namespace %s started the listing with %s, want it right before %s/", test.namespace, requests[0], test.namespace)
+		}
+		for _, request := range requests {
+			if strings.Contains(request, "last=zeta") {
+				t.Errorf("--namespace %s listed past the namespace: %s", test.namespace, request)
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// CleanupPolicy is a Nexus cleanup policy. Criteria left nil (or empty) do not restrict what is cleaned up, Nexus
+// deletes components matching all given criteria
+type CleanupPolicy struct {
+	Name   string `json:"name"`
+	Notes  string `json:"notes,omitempty"`
+	Format string `json:"format"`
+	// CriteriaLastBlobUpdated and CriteriaLastDownloaded are in days
+	CriteriaLastBlobUpdated *int   `json:"criteriaLastBlobUpdated,omitempty"`
+	CriteriaLastDownloaded  *int   `json:"criteriaLastDownloaded,omitempty"`
+	CriteriaReleaseType     string `json:"criteriaReleaseType,omitempty"`
+	CriteriaAssetRegex      string `json:"criteriaAssetRegex,omitempty"`
+	// Retain keeps the given number of newest versions, only Nexus Pro supports it
+	Retain *int `json:"retain,omitempty"`
+}
+
+// RepositoryInfo is a repository as listed by the repositories API
+type RepositoryInfo struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+}
+
+// CleanupPolicies lists the cleanup policies defined on the server
+func (r Registry) CleanupPolicies() ([]CleanupPolicy, error) {
+	if err := r.Require(FeatureCleanupPolicies); err != nil {
+		return nil, err
+	}
+	var policies []CleanupPolicy
+	return policies, r.restJSON("GET", "/service/rest/v1/cleanup-policies", nil, &policies)
+}
+
+// CleanupPolicy fetches a cleanup policy by name
+func (r Registry) CleanupPolicy(name string) (CleanupPolicy, error) {
+	var policy CleanupPolicy
+	if err := r.Require(FeatureCleanupPolicies); err != nil {
+		return policy, err
+	}
+	return policy, r.restJSON("GET", "/service/rest/v1/cleanup-policies/"+url.PathEscape(name), nil, &policy)
+}
+
+// CreateCleanupPolicy defines a new cleanup policy
+func (r Registry) CreateCleanupPolicy(policy CleanupPolicy) error {
+	if err := r.Require(FeatureCleanupPolicies); err != nil {
+		return err
+	}
+	return r.restJSON("POST", "/service/rest/v1/cleanup-policies", policy, nil)
+}
+
+// UpdateCleanupPolicy replaces the criteria of an existing cleanup policy
+func (r Registry) UpdateCleanupPolicy(policy CleanupPolicy) error {
+	if err := r.Require(FeatureCleanupPolicies); err != nil {
+		return err
+	}
+	return r.restJSON("PUT", "/service/rest/v1/cleanup-policies/"+url.PathEscape(policy.Name), policy, nil)
+}
+
+// Repositories lists all repositories of the server
+func (r Registry) Repositories() ([]RepositoryInfo, error) {
+	var repositories []RepositoryInfo
+	return repositories, r.restJSON("GET", "/service/rest/v1/repositories", nil, &repositories)
+}
+
+// SetCleanupPolicies sets the cleanup policies of a repository, with keepExisting in addition to the ones it has.
+// All other settings of the repository are kept. Returns the policies the repository has now
+func (r Registry) SetCleanupPolicies(repository string, names []string, keepExisting bool) ([]string, error) {
+	if err := r.Require(FeatureRepositorySettings); err != nil {
+		return nil, err
+	}
+	repositories, err := r.Repositories()
+	if err != nil {
+		return nil, err
+	}
+	var info *RepositoryInfo
+	for i := range repositories {
+		if repositories[i].Name == repository {
+			info = &repositories[i]
+		}
+	}
+	if info == nil {
+		return nil, errors.New(fmt.Sprintf("repository %s does not exist on %s", repository, r.Host))
+	}
+	if info.Type == "group" {
+		return nil, errors.New(fmt.Sprintf("%s is a group repository, cleanup policies apply to its members", repository))
+	}
+
+	path := fmt.Sprintf("/service/rest/v1/repositories/%s/%s/%s", info.Format, info.Type, url.PathEscape(repository))
+	var settings map[string]json.RawMessage
+	if err := r.restJSON("GET", path, nil, &settings); err != nil {
+		return nil, err
+	}
+	var cleanup struct {
+		PolicyNames []string `json:"policyNames"`
+	}
+	if raw, ok := settings["cleanup"]; ok && keepExisting {
+		if err := json.Unmarshal(raw, &cleanup); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		if !containsString(cleanup.PolicyNames, name) {
+			cleanup.PolicyNames = append(cleanup.PolicyNames, name)
+		}
+	}
+	raw, err := json.Marshal(cleanup)
+	if err != nil {
+		return nil, err
+	}
+	settings["cleanup"] = raw
+	return cleanup.PolicyNames, r.restJSON("PUT", path, settings, nil)
+}
+
+// restJSON sends body (if not nil) as JSON to a REST API path of the server and decodes the answer into result
+// (if not nil)
+func (r Registry) restJSON(method string, path string, body interface{}, result interface{}) error {
+	var content *bytes.Reader
+	contentType := ""
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		content, contentType = bytes.NewReader(encoded), "application/json"
+	} else {
+		content = bytes.NewReader(nil)
+	}
+
+	resp, err := r.do(method, r.Host+path, "application/json", contentType, content)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return r.newError(resp)
+	}
+	if result == nil || resp.StatusCode == 204 {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+)
+
+// Component is a package (apt, yum, pypi ...) as returned by the Nexus components and search APIs
+type Component struct {
+	ID         string  `json:"id"`
+	Repository string  `json:"repository"`
+	Format     string  `json:"format"`
+	Group      string  `json:"group"`
+	Name       string  `json:"name"`
+	Version    string  `json:"version"`
+	Assets     []Asset `json:"assets"`
+}
+
+type componentPage struct {
+	Items             []Component `json:"items"`
+	ContinuationToken *string     `json:"continuationToken"`
+}
+
+// SearchComponents queries the Nexus search API for components of the repository, query holds the search parameters
+// (e.g. format, name and version). All pages are fetched
+func (r Registry) SearchComponents(query url.Values) ([]Component, error) {
+	var components []Component
+	query.Set("repository", r.Repository)
+
+	for {
+		searchURL := fmt.Sprintf("%s/service/rest/v1/search?%s", r.Host, query.Encode())
+		resp, err := r.do("GET", searchURL, "application/json", "", nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != 200 {
+			err := r.newError(resp)
+			resp.Body.Close()
+			if resp.StatusCode == 404 {
+				if err := r.Require(FeatureSearchAPI); err != nil {
+					return nil, err
+				}
+			}
+			return nil, err
+		}
+
+		var page componentPage
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		components = append(components, page.Items...)
+		if page.ContinuationToken == nil || *page.ContinuationToken == "" {
+			return components, nil
+		}
+		query.Set("continuationToken", *page.ContinuationToken)
+	}
+}
+
+// UploadComponent uploads a package file to a hosted repository of the given format. The file is sent as the
+// <format>.asset field, fields holds the other form fields the format needs (e.g. yum.directory)
+func (r Registry) UploadComponent(format string, path string, fields map[string]string) error {
+	return r.Upload(Upload{Assets: []UploadAsset{{Field: format + ".asset", Path: path}}, Fields: fields})
+}
+
+func writeUploadForm(form *multipart.Writer, field string, filename string, content io.Reader, fields map[string]string) error {
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+	part, err := form.CreateFormFile(field, filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, content); err != nil {
+		return err
+	}
+	return form.Close()
+}
+
+// DeleteComponent deletes a component with all its assets
+func (r Registry) DeleteComponent(id string) error {
+	deleteURL := fmt.Sprintf("%s/service/rest/v1/components/%s", r.Host, url.PathEscape(id))
+	resp, err := r.do("DELETE", deleteURL, "application/json", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 204 {
+		return r.newError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/eugenmayer/nexus-cli/output"
+	"github.com/eugenmayer/nexus-cli/utils"
+	"github.com/eugenmayer/nexus-cli/vault"
+	"html"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultProfile names the settings at the top level of the configuration file
+const DefaultProfile = "default"
+
+// DefaultConfirmThreshold is how many tags profiles without confirm_threshold delete at once without asking
+const DefaultConfirmThreshold = 10
+
+// ConfigVersion is the layout written by this release. Version 1 files (without config_version) were rendered by
+// an html template which escaped passwords, they are migrated when loaded
+const ConfigVersion = 2
+
+// Config is the content of ~/.nexus-cli. The top level settings form the default profile, further registries are
+// configured as [profiles.<name>] tables using the same keys
+type Config struct {
+	Registry
+	Version       int                 `toml:"config_version"`
+	ActiveProfile string              `toml:"active_profile,omitempty"`
+	Profiles      map[string]Registry `toml:"profiles,omitempty"`
+}
+
+// ConfigError lists everything wrong with a configuration file
+type ConfigError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("Invalid configuration %s:\n  %s", e.Path, strings.Join(e.Problems, "\n  "))
+}
+
+// ConfigurationPath returns where the configuration is stored
+func ConfigurationPath() string {
+	return utils.ExpandTildeInPath("~/.nexus-cli")
+}
+
+// LoadConfig reads and validates the configuration file. Files of older layouts are migrated and saved again
+func LoadConfig() (Config, error) {
+	return readConfig(true)
+}
+
+// ReadConfig reads the configuration file like LoadConfig, without validating it. For fixing a broken
+// configuration, e.g. by 'nexus-cli configure'
+func ReadConfig() (Config, error) {
+	return readConfig(false)
+}
+
+func readConfig(validate bool) (Config, error) {
+	var c Config
+	configurationPath := ConfigurationPath()
+	content, err := ioutil.ReadFile(configurationPath)
+	if os.IsNotExist(err) {
+		return c, errors.New(fmt.Sprintf("Configuration not found at %s - please run 'nexus-cli configure'\n", configurationPath))
+	} else if err != nil {
+		return c, err
+	}
+
+	md, err := toml.Decode(string(content), &c)
+	if err != nil {
+		// the parser reports line numbers itself
+		return c, &ConfigError{Path: configurationPath, Problems: []string{err.Error()}}
+	}
+
+	if c.Version < ConfigVersion {
+		c.migrate()
+		if err := c.Save(); err != nil {
+			output.Warnf("Migrating %s to config_version %d failed, using it as it is: %s", configurationPath, ConfigVersion, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Migrated %s to config_version %d\n", configurationPath, ConfigVersion)
+		}
+	} else if c.Version > ConfigVersion {
+		return c, &ConfigError{Path: configurationPath, Problems: []string{
+			fmt.Sprintf("config_version %d is written by a newer nexus-cli, this one knows up to %d", c.Version, ConfigVersion),
+		}}
+	}
+
+	if !validate {
+		return c, nil
+	}
+	if problems := c.validate(md, keyLines(content)); len(problems) > 0 {
+		return c, &ConfigError{Path: configurationPath, Problems: problems}
+	}
+	return c, nil
+}
+
+// migrate converts a version 1 configuration. Its values were html escaped, e.g. & was written as &amp;
+func (c *Config) migrate() {
+	unescape := func(r Registry) Registry {
+		r.Host = html.UnescapeString(r.Host)
+		r.Username = html.UnescapeString(r.Username)
+		r.Password = html.UnescapeString(r.Password)
+		r.Repository = html.UnescapeString(r.Repository)
+		return r
+	}
+	c.Registry = unescape(c.Registry)
+	for name, r := range c.Profiles {
+		c.Profiles[name] = unescape(r)
+	}
+	c.Version = ConfigVersion
+}
+
+var knownKeys = []string{"nexus_host", "nexus_username", "nexus_password", "nexus_repository", "password_command", "vault_path", "vault_address", "vault_auth", "vault_role_id", "nuget_api_key", "http_protocol", "environment", "confirm_threshold", "pre_delete_hook", "post_delete_hook", "post_run_hook", "plan_keys", "config_version", "active_profile", "profiles"}
+
+func (c Config) validate(md toml.MetaData, lines map[string]int) []string {
+	var problems []string
+	at := func(key string) string {
+		if line, ok := lines[key]; ok {
+			return fmt.Sprintf("line %d: ", line)
+		}
+		return ""
+	}
+
+	for _, key := range md.Undecoded() {
+		name := key[len(key)-1]
+		problem := fmt.Sprintf("%sunknown key %s", at(key.String()), key.String())
+		if suggestion := closestKey(name); suggestion != "" {
+			problem += fmt.Sprintf(", did you mean %s?", suggestion)
+		}
+		problems = append(problems, problem)
+	}
+
+	checkProfile := func(name string, prefix string, r Registry) {
+		if r.Host == "" {
+			problems = append(problems, fmt.Sprintf("profile %s: nexus_host is missing", name))
+		} else if u, err := url.Parse(r.Host); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%sprofile %s: nexus_host %q is not a URL like https://nexus.example.com", at(prefix+"nexus_host"), name, r.Host))
+		} else if u.Path != "" && u.Path != "/" {
+			problems = append(problems, fmt.Sprintf("%sprofile %s: nexus_host %q must not contain a path, the repository is given as nexus_repository", at(prefix+"nexus_host"), name, r.Host))
+		}
+		if r.Repository == "" {
+			problems = append(problems, fmt.Sprintf("profile %s: nexus_repository is missing", name))
+		}
+		if r.Password != "" && r.PasswordCommand != "" {
+			problems = append(problems, fmt.Sprintf("%sprofile %s: give either nexus_password or password_command", at(prefix+"password_command"), name))
+		}
+		if r.VaultPath != "" && (r.Password != "" || r.PasswordCommand != "") {
+			problems = append(problems, fmt.Sprintf("%sprofile %s: give either vault_path or nexus_password / password_command", at(prefix+"vault_path"), name))
+		}
+		if !vault.ValidAuth(r.VaultAuth) {
+			problems = append(problems, fmt.Sprintf("%sprofile %s: vault_auth %q is neither %s nor %s", at(prefix+"vault_auth"), name, r.VaultAuth, vault.AuthToken, vault.AuthAppRole))
+		} else if r.VaultAuth == vault.AuthAppRole && r.VaultRoleID == "" {
+			problems = append(problems, fmt.Sprintf("%sprofile %s: vault_auth %s needs vault_role_id", at(prefix+"vault_auth"), name, vault.AuthAppRole))
+		}
+		if !ValidProtocol(r.HTTPProtocol) {
+			problems = append(problems, fmt.Sprintf("%sprofile %s: http_protocol %q is neither %s nor %s", at(prefix+"http_protocol"), name, r.HTTPProtocol, ProtocolAuto, ProtocolHTTP1))
+		}
+		if r.ConfirmThreshold < 0 {
+			problems = append(problems, fmt.Sprintf("%sprofile %s: confirm_threshold must not be negative", at(prefix+"confirm_threshold"), name))
+		}
+	}
+
+	// the default profile may be left empty if only named profiles are used
+	if !reflect.DeepEqual(c.Registry, Registry{}) || len(c.Profiles) == 0 || c.Current() == DefaultProfile {
+		checkProfile(DefaultProfile, "", c.Registry)
+	}
+	for _, name := range c.ProfileNames()[1:] {
+		checkProfile(name, "profiles."+name+".", c.Profiles[name])
+	}
+	if c.Current() != DefaultProfile {
+		if _, ok := c.Profiles[c.Current()]; !ok {
+			problems = append(problems, fmt.Sprintf("%sactive_profile %s is not defined", at("active_profile"), c.Current()))
+		}
+	}
+	return problems
+}
+
+var (
+	tableLine = regexp.MustCompile(`^\s*\[\s*([^\]]+?)\s*\]`)
+	keyLine   = regexp.MustCompile(`^\s*([A-Za-z0-9_-]+)\s*=`)
+)
+
+// keyLines maps the dotted keys of a TOML document to the lines defining them, good enough for error messages
+func keyLines(content []byte) map[string]int {
+	lines := map[string]int{}
+	table := ""
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if m := tableLine.FindStringSubmatch(line); m != nil {
+			table = strings.Trim(m[1], "[] ") + "."
+			lines[strings.TrimSuffix(table, ".")] = n
+		} else if m := keyLine.FindStringSubmatch(line); m != nil {
+			if _, ok := lines[table+m[1]]; !ok {
+				lines[table+m[1]] = n
+			}
+		}
+	}
+	return lines
+}
+
+// closestKey suggests a known key for a misspelled one
+func closestKey(key string) string {
+	best, bestDistance := "", 3
+	for _, known := range knownKeys {
+		if len(key) > 3 && strings.HasPrefix(known, key) {
+			return known
+		}
+		if d := editDistance(key, known); d < bestDistance {
+			best, bestDistance = known, d
+		}
+	}
+	return best
+}
+
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// Save writes the configuration file, readable by the current user only
+func (c Config) Save() error {
+	c.Version = ConfigVersion
+	f, err := os.OpenFile(ConfigurationPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, "# Nexus Credentials"); err != nil {
+		return err
+	}
+	return toml.NewEncoder(f).Encode(c)
+}
+
+// Current returns the name of the active profile
+func (c Config) Current() string {
+	if c.ActiveProfile == "" {
+		return DefaultProfile
+	}
+	return c.ActiveProfile
+}
+
+// ProfileNames returns the default profile followed by all others in alphabetical order
+func (c Config) ProfileNames() []string {
+	var names []string
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{DefaultProfile}, names...)
+}
+
+// Profile returns the registry settings of a profile
+func (c Config) Profile(name string) (Registry, error) {
+	if name == DefaultProfile {
+		return c.Registry, nil
+	}
+	r, ok := c.Profiles[name]
+	if !ok {
+		return r, errors.New(fmt.Sprintf("Profile %s not found in %s", name, ConfigurationPath()))
+	}
+	return r, nil
+}
+
+// ProfileForHost returns the first profile, in the order of ProfileNames, on the server host names (a docker
+// reference host, e.g. nexus.example.com:8082). Ports are ignored: docker is usually served on other ports than the
+// API Nexus is configured with
+func (c Config) ProfileForHost(host string) (string, bool) {
+	for _, name := range c.ProfileNames() {
+		r, err := c.Profile(name)
+		if err == nil && r.Host != "" && SameHost(r.Host, host) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// SameHost tells if a Nexus host (https://nexus.example.com:8081) and a docker reference host
+// (nexus.example.com:8082) name the same server
+func SameHost(nexusHost string, host string) bool {
+	return strings.EqualFold(hostname(nexusHost), hostname(host))
+}
+
+func hostname(host string) string {
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexAny(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	return strings.Trim(host, "[]")
+}
+
+// SetProfile stores the registry settings of a profile
+func (c *Config) SetProfile(name string, r Registry) {
+	if name == DefaultProfile {
+		c.Registry = r
+		return
+	}
+	if c.Profiles == nil {
+		c.Profiles = map[string]Registry{}
+	}
+	c.Profiles[name] = r
+}
+
+// NewRegistryFromProfile loads the registry of the given profile, or of the active one if name is empty. It returns
+// the name of the profile used as well
+func NewRegistryFromProfile(name string) (Registry, string, error) {
+	c, err := LoadConfig()
+	if err != nil {
+		return Registry{}, "", err
+	}
+	if name == "" {
+		name = c.Current()
+	}
+	r, err := c.Profile(name)
+	if err != nil {
+		return r, name, err
+	}
+	r.Host = strings.TrimRight(r.Host, "/")
+	if r.PasswordCommand != "" {
+		if r.Password, err = passwordFromCommand(r.PasswordCommand); err != nil {
+			return r, name, errors.New(fmt.Sprintf("Profile %s: %s", name, err))
+		}
+	}
+	if r.VaultPath != "" {
+		secret, err := vault.Secret(vault.Config{Address: r.VaultAddress, Path: r.VaultPath, Auth: r.VaultAuth, RoleID: r.VaultRoleID})
+		if err != nil {
+			return r, name, errors.New(fmt.Sprintf("Profile %s: %s", name, err))
+		}
+		if secret["password"] == "" {
+			return r, name, errors.New(fmt.Sprintf("Profile %s: the secret %s in Vault has no password field", name, r.VaultPath))
+		}
+		r.Password = secret["password"]
+		if secret["username"] != "" {
+			r.Username = secret["username"]
+		}
+	}
+	return r, name, nil
+}
+
+// passwordFromCommand runs a password_command with the shell and returns the first line it prints. Its stdin and
+// stderr are those of nexus-cli, so secret tools may ask for a passphrase
+func passwordFromCommand(command string) (string, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("password_command %q failed: %s", command, err))
+	}
+	password := strings.TrimRight(strings.SplitN(string(out), "\n", 2)[0], "\r")
+	if password == "" {
+		return "", errors.New(fmt.Sprintf("password_command %q printed no password", command))
+	}
+	return password, nil
+}
@@ -0,0 +1,124 @@
+package registry
+
+import (
+	"encoding/json"
+)
+
+// CopyImage copies a tag from src to dst, including all blobs and, for indexes, all platform manifests. src and dst
+// may point to different repositories or even different hosts. The manifest is copied verbatim, so the digest stays
+// the same. With withReferrers, the artifacts attached to the image (signatures, attestations, SBOMs) are copied
+// as well. Returns the digest of the copied manifest
+func CopyImage(src Registry, srcImage string, srcTag string, dst Registry, dstImage string, dstTag string, withReferrers bool) (string, error) {
+	return copyManifest(src, srcImage, srcTag, dst, dstImage, dstTag, withReferrers)
+}
+
+func copyManifest(src Registry, srcImage string, srcRef string, dst Registry, dstImage string, dstRef string, withReferrers bool) (string, error) {
+	body, mediaType, digest, err := src.RawManifest(srcImage, srcRef)
+	if err != nil {
+		return "", err
+	}
+
+	var manifest ImageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return "", err
+	}
+	if manifest.MediaType != "" {
+		mediaType = manifest.MediaType
+	}
+	if digest == "" {
+		digest = digestOf(body)
+	}
+
+	// the manifest is pushed last, if dst has it what it references was copied as well. Copying again, e.g. after
+	// a mirror job failed halfway, only sends what is missing
+	existing, err := dst.manifestDigest(dstImage, dstRef)
+	if err != nil {
+		return "", err
+	}
+	if existing != digest {
+		if manifest.IsIndex() {
+			for _, m := range manifest.Manifests {
+				if _, err := copyManifest(src, srcImage, m.Digest, dst, dstImage, m.Digest, false); err != nil {
+					return "", err
+				}
+			}
+		} else {
+			blobs := append([]LayerInfo{manifest.Config}, manifest.Layers...)
+			for _, blob := range blobs {
+				if blob.Digest == "" {
+					continue
+				}
+				if err := copyBlob(src, srcImage, dst, dstImage, blob.Digest); err != nil {
+					return "", err
+				}
+			}
+		}
+
+		if _, err := dst.PutManifest(dstImage, dstRef, mediaType, body); err != nil {
+			return "", err
+		}
+	}
+
+	if withReferrers {
+		if err := copyReferrers(src, srcImage, dst, dstImage, digest); err != nil {
+			return "", err
+		}
+	}
+
+	return digest, nil
+}
+
+// copyReferrers copies the artifacts attached to digest, keeping the tags of artifacts attached by tag convention
+func copyReferrers(src Registry, srcImage string, dst Registry, dstImage string, digest string) error {
+	referrers, err := src.Referrers(srcImage, digest)
+	if err != nil {
+		return err
+	}
+	for _, referrer := range referrers {
+		dstRef := referrer.Digest
+		if referrer.Tag != "" {
+			dstRef = referrer.Tag
+		}
+		if _, err := copyManifest(src, srcImage, referrer.Digest, dst, dstImage, dstRef, true); err != nil {
+			return err
+		}
+	}
+
+	// keep the referrers tag schema index, registries without the referrers API depend on it
+	tags, err := src.ListTagsByImage(srcImage)
+	if err != nil {
+		return err
+	}
+	if containsString(tags, referrersTag(digest)) {
+		if _, err := copyManifest(src, srcImage, referrersTag(digest), dst, dstImage, referrersTag(digest), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyBlob(src Registry, srcImage string, dst Registry, dstImage string, digest string) error {
+	exists, err := dst.BlobExists(dstImage, digest)
+	if err != nil || exists {
+		return err
+	}
+	if err := dst.throttle.admit(); err != nil {
+		return err
+	}
+	if dst.uploads != nil {
+		if copied, err := copyBlobInChunks(src, srcImage, dst, dstImage, digest); copied || err != nil {
+			return err
+		}
+	}
+	content, size, err := src.GetBlob(srcImage, digest)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	if err := dst.UploadBlob(dstImage, digest, size, dst.throttle.reader(content)); err != nil {
+		return err
+	}
+	dst.runStats.AddBytes(size)
+	return nil
+}
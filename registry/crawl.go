@@ -0,0 +1,292 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// crawlWorkers is the concurrency crawls grow to at most, unless the registry was given a crawler
+const crawlWorkers = 16
+
+const (
+	// crawlRetries is how often a request answered with 429 or 503 is tried again
+	crawlRetries = 5
+	// crawlSlowdown is the factor the smoothed latency may exceed the best one seen before the crawl backs off,
+	// crawlSlack what it may exceed it by anyway, jitter of fast answers is no reason to
+	crawlSlowdown = 2
+	crawlSlack    = 50 * time.Millisecond
+)
+
+// CrawlStats tells how a crawl went
+type CrawlStats struct {
+	Requests int
+	// Throttled counts the answers 429 Too Many Requests and 503 Service Unavailable, which were retried
+	Throttled int
+	// Slowdowns counts the back offs because requests got slow
+	Slowdowns   int
+	PeakWorkers int
+	Workers     int
+	MeanLatency time.Duration
+	Duration    time.Duration
+}
+
+func (s CrawlStats) String() string {
+	rate := 0.0
+	if s.Duration > 0 {
+		rate = float64(s.Requests) / s.Duration.Seconds()
+	}
+	return fmt.Sprintf("%d requests in %s (%.1f/s), mean latency %s, %d throttled, %d slowdowns, %d workers at the peak and %d at the end",
+		s.Requests, s.Duration.Round(time.Millisecond), rate, s.MeanLatency.Round(time.Millisecond), s.Throttled, s.Slowdowns, s.PeakWorkers, s.Workers)
+}
+
+// Crawler runs the many small requests of a crawl in parallel without hammering the registry. The number of
+// requests in flight starts at one and grows while the registry answers as fast as before. It is cut when the
+// latency rises and halved when the registry answers 429 or 503, those requests are retried after a pause. A crawler
+// may be shared by several crawls, they then adapt together
+type Crawler struct {
+	maxWorkers int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   float64
+	running int
+	// slowStart doubles the limit per round trip until the first back off, like TCP does
+	slowStart bool
+	latency   time.Duration
+	best      time.Duration
+	lastCut   time.Time
+	pause     time.Time
+	total     time.Duration
+	started   time.Time
+	stats     CrawlStats
+	progress  Progress
+}
+
+// Progress is told about the jobs of the crawls, e.g. to render a live status. Its methods are called by the workers
+// concurrently
+type Progress interface {
+	// Add announces n more jobs
+	Add(n int)
+	// Start tells that a job on item started, the returned function that it finished
+	Start(item string) func()
+}
+
+// NewCrawler creates a crawler running up to maxWorkers requests at once
+func NewCrawler(maxWorkers int) *Crawler {
+	if maxWorkers < 1 {
+		maxWorkers = 1
+	}
+	c := &Crawler{maxWorkers: maxWorkers, limit: 1, slowStart: true}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// WithCrawler sends the crawls of the registry (inventories, snapshots, digests of many tags) through crawler, e.g.
+// to limit their concurrency or to read its statistics afterwards
+func WithCrawler(crawler *Crawler) Option {
+	return func(r *Registry) {
+		r.crawler = crawler
+	}
+}
+
+// Crawler returns the crawler given with WithCrawler, or a new one
+func (r Registry) Crawler() *Crawler {
+	if r.crawler != nil {
+		return r.crawler
+	}
+	return NewCrawler(crawlWorkers)
+}
+
+// Stats returns the statistics of the crawls run so far
+func (c *Crawler) Stats() CrawlStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Workers = int(c.limit)
+	if stats.Requests > 0 {
+		stats.MeanLatency = c.total / time.Duration(stats.Requests)
+	}
+	if !c.started.IsZero() {
+		stats.Duration = time.Since(c.started)
+	}
+	return stats
+}
+
+// Observe tells progress about the jobs of the crawls from now on
+func (c *Crawler) Observe(progress Progress) {
+	c.mu.Lock()
+	c.progress = progress
+	c.mu.Unlock()
+}
+
+// Run calls job for 0 to n-1, as many at once as the crawler allows. Once a job fails no further jobs are started,
+// the first error is returned after the running ones finished
+func (c *Crawler) Run(n int, job func(i int) error) error {
+	return c.RunLabeled(n, nil, job)
+}
+
+// RunLabeled is Run telling the Progress observing the crawler what the jobs work on, label names the item of job i
+func (c *Crawler) RunLabeled(n int, label func(i int) string, job func(i int) error) error {
+	c.mu.Lock()
+	if c.started.IsZero() {
+		c.started = time.Now()
+	}
+	progress := c.progress
+	c.mu.Unlock()
+	if progress != nil {
+		progress.Add(n)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+	for i := 0; i < n; i++ {
+		errMu.Lock()
+		failed := firstErr != nil
+		errMu.Unlock()
+		if failed {
+			break
+		}
+		c.acquire()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer c.release()
+			if progress != nil {
+				item := ""
+				if label != nil {
+					item = label(i)
+				}
+				defer progress.Start(item)()
+			}
+			if err := c.try(func() error { return job(i) }); err != nil {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// try runs a job, retrying it while the registry is throttling
+func (c *Crawler) try(job func() error) error {
+	for attempt := 0; ; attempt++ {
+		started := time.Now()
+		err := job()
+		if !IsThrottling(err) || attempt == crawlRetries {
+			c.done(time.Since(started), false)
+			return err
+		}
+		c.done(time.Since(started), true)
+		time.Sleep(c.backoff(attempt))
+	}
+}
+
+// IsThrottling tells if the registry refused a request as too busy, with 429 Too Many Requests or 503 Service
+// Unavailable
+func IsThrottling(err error) bool {
+	e, ok := err.(*Error)
+	return ok && (e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable)
+}
+
+func (c *Crawler) acquire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if wait := time.Until(c.pause); wait > 0 {
+			c.mu.Unlock()
+			time.Sleep(wait)
+			c.mu.Lock()
+			continue
+		}
+		if c.running < int(c.limit) {
+			break
+		}
+		c.cond.Wait()
+	}
+	c.running++
+	if c.running > c.stats.PeakWorkers {
+		c.stats.PeakWorkers = c.running
+	}
+}
+
+func (c *Crawler) release() {
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+// backoff pauses all new requests after a throttled one, doubling with every attempt up to 30 seconds
+func (c *Crawler) backoff(attempt int) time.Duration {
+	wait := time.Second << uint(attempt)
+	if wait > 30*time.Second {
+		wait = 30 * time.Second
+	}
+	c.mu.Lock()
+	if until := time.Now().Add(wait); until.After(c.pause) {
+		c.pause = until
+	}
+	c.mu.Unlock()
+	return wait
+}
+
+// done adapts the limit to how a request went
+func (c *Crawler) done(latency time.Duration, throttled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Requests++
+	c.total += latency
+
+	if throttled {
+		c.stats.Throttled++
+		// the requests in flight are answered the same, halve once per round trip
+		if time.Since(c.lastCut) > c.latency {
+			c.cut(0.5)
+		}
+		return
+	}
+	// the smoothed latency, the best one seen is what the registry manages when it is not busy
+	if c.latency == 0 {
+		c.latency = latency
+	} else {
+		c.latency = (4*c.latency + latency) / 5
+	}
+	if c.best == 0 || c.latency < c.best {
+		c.best = c.latency
+	}
+
+	if c.latency > crawlSlowdown*c.best && c.latency > c.best+crawlSlack {
+		if time.Since(c.lastCut) > c.latency {
+			c.stats.Slowdowns++
+			c.cut(0.75)
+		}
+		return
+	}
+	if c.slowStart {
+		c.limit++
+	} else {
+		c.limit += 1 / c.limit
+	}
+	if c.limit > float64(c.maxWorkers) {
+		c.limit = float64(c.maxWorkers)
+	}
+	c.cond.Broadcast()
+}
+
+func (c *Crawler) cut(factor float64) {
+	c.slowStart = false
+	c.lastCut = time.Now()
+	c.limit *= factor
+	if c.limit < 1 {
+		c.limit = 1
+	}
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// probeDigest is a manifest no repository holds, deleting it tells if deletes are allowed without deleting anything
+const probeDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+// DeleteDisabledError is returned when the repository refuses deleting manifests at all: it is a proxy or group
+// repository, its deployment policy is read-only or its docker connector does not allow deletes. Every other tag
+// would fail the same way
+type DeleteDisabledError struct {
+	Host       string
+	Repository string
+	// Cause is what Nexus answered
+	Cause error
+}
+
+func (e *DeleteDisabledError) Error() string {
+	cause := strings.SplitN(e.Cause.Error(), "\n", 2)[0]
+	return fmt.Sprintf("Repository %s on %s does not allow deleting images (%s)\n"+
+		"Nothing can be deleted there: check that it is a hosted repository whose deployment policy is not read-only and "+
+		"which allows deletes, e.g. in the repository settings of the Nexus admin UI", e.Repository, e.Host, cause)
+}
+
+// IsDeleteDisabled tells if deleting failed because the repository does not allow deletes
+func IsDeleteDisabled(err error) bool {
+	_, ok := err.(*DeleteDisabledError)
+	return ok
+}
+
+// deleteRefused tells if e is the answer of the registry API to a delete it does not support, 405 or the code
+// UNSUPPORTED, rather than to a delete of something missing or forbidden
+func deleteRefused(e *Error) bool {
+	return e.Method == "DELETE" && (e.StatusCode == http.StatusMethodNotAllowed || e.Code == "UNSUPPORTED")
+}
+
+// CheckDeletes tells up front if manifests of image can be deleted, by deleting one which does not exist. A
+// *DeleteDisabledError is returned if the repository refuses deletes, other errors (e.g. missing privileges) as
+// they are
+func (r Registry) CheckDeletes(image string) error {
+	if err := r.DeleteManifest(image, probeDigest); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// TagRef names a tag of an image
+type TagRef struct {
+	Image string
+	Tag   string
+}
+
+// ResolveDigests resolves the manifest digests of many tags with HEAD requests, up to workers at a time as the
+// registry copes (see Crawler). Every tag is resolved once however often it is given. The first failure is returned
+// after the running requests finished
+func (r Registry) ResolveDigests(refs []TagRef, workers int) (map[TagRef]string, error) {
+	return r.resolveDigests(refs, NewCrawler(workers))
+}
+
+func (r Registry) resolveDigests(refs []TagRef, crawler *Crawler) (map[TagRef]string, error) {
+	seen := make(map[TagRef]bool, len(refs))
+	var unique []TagRef
+	for _, ref := range refs {
+		if !seen[ref] {
+			seen[ref] = true
+			unique = append(unique, ref)
+		}
+	}
+
+	digests := make(map[TagRef]string, len(unique))
+	var mu sync.Mutex
+	err := crawler.RunLabeled(len(unique), func(i int) string { return unique[i].Image + ":" + unique[i].Tag }, func(i int) error {
+		digest, err := r.getImageSHA(unique[i].Image, unique[i].Tag)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		digests[unique[i]] = digest
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// Digests are the digests a tag points to, for pinning it in deployments
+type Digests struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+	// Config is the digest of the image config. Indexes have none, their platforms have one each
+	Config string `json:"config,omitempty"`
+	// Platforms are the platform manifests of an index, attestations left out
+	Platforms []PlatformDigest `json:"platforms,omitempty"`
+}
+
+// PlatformDigest is a platform manifest of an index
+type PlatformDigest struct {
+	Platform string `json:"platform"`
+	Digest   string `json:"digest"`
+	Config   string `json:"config,omitempty"`
+}
+
+// PinDigests resolves the digests of a tag (or digest). The config digests of the platforms of an index cost a
+// request each, they are only read with configs
+func (r Registry) PinDigests(image string, reference string, configs bool) (Digests, error) {
+	body, mediaType, digest, err := r.RawManifest(image, reference)
+	if err != nil {
+		return Digests{}, err
+	}
+	var manifest ImageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return Digests{}, err
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = mediaType
+	}
+	if digest == "" {
+		digest = digestOf(body)
+	}
+	d := Digests{Digest: digest, MediaType: manifest.MediaType}
+	if !manifest.IsIndex() {
+		d.Config = manifest.Config.Digest
+		return d, nil
+	}
+	for _, m := range manifest.Manifests {
+		// buildx attaches provenance as manifests of the platform unknown/unknown
+		if m.Annotations["vnd.docker.reference.type"] == "attestation-manifest" {
+			continue
+		}
+		p := PlatformDigest{Platform: "unknown", Digest: m.Digest}
+		if m.Platform != nil {
+			p.Platform = m.Platform.String()
+		}
+		if configs {
+			child, err := r.ImageManifest(image, m.Digest)
+			if err != nil {
+				return d, err
+			}
+			p.Config = child.Config.Digest
+		}
+		d.Platforms = append(d.Platforms, p)
+	}
+	return d, nil
+}
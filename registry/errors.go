@@ -0,0 +1,202 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Error is returned when Nexus answers a request with an unexpected status. It carries what the server said about
+// the failure and, where the cause is a common one, a hint how to fix it
+type Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// Code is the error code of the registry API, e.g. MANIFEST_UNKNOWN. Empty for other Nexus APIs
+	Code    string
+	Message string
+	Detail  string
+	Hint    string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Detail != "" {
+		msg += " (" + e.Detail + ")"
+	}
+	if e.Hint != "" {
+		msg += "\n" + e.Hint
+	}
+	return msg
+}
+
+// IsNotFound tells if err is an Error for a missing image, tag, blob or repository
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// DecodeError is returned when Nexus answers with a success status but a body which is not the JSON expected, e.g.
+// the HTML login page of a single sign-on proxy in front of it. It tells what came instead
+type DecodeError struct {
+	Method      string
+	URL         string
+	StatusCode  int
+	ContentType string
+	// Body is the start of the body, on one line
+	Body string
+	Err  error
+	Hint string
+}
+
+func (e *DecodeError) Error() string {
+	msg := fmt.Sprintf("%s %s: %d %s: the answer is no valid JSON (%s)", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode), e.Err)
+	if e.ContentType != "" {
+		msg += ", Content-Type " + e.ContentType
+	}
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	if e.Hint != "" {
+		msg += "\n" + e.Hint
+	}
+	return msg
+}
+
+// IsDecodeError tells if err is a DecodeError
+func IsDecodeError(err error) bool {
+	_, ok := err.(*DecodeError)
+	return ok
+}
+
+// decode reads the JSON body of a response into v, failing with a DecodeError which shows the start of the body
+func (r Registry) decode(resp *http.Response, v interface{}) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(body, v)
+	if err == nil {
+		return nil
+	}
+	if len(body) == 0 {
+		err = errors.New("the body is empty")
+	}
+	e := &DecodeError{StatusCode: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Err: err}
+	if resp.Request != nil {
+		e.Method = resp.Request.Method
+		e.URL = resp.Request.URL.String()
+	}
+	start := body
+	if len(start) > 120 {
+		start = start[:120]
+	}
+	e.Body = strings.Join(strings.Fields(string(start)), " ")
+	if len(body) > 120 {
+		e.Body += "..."
+	}
+	if strings.Contains(e.ContentType, "html") || bytes.HasPrefix(bytes.TrimSpace(body), []byte("<")) {
+		e.Hint = fmt.Sprintf("An HTML page came instead, likely the login page of a proxy in front of %s. Use the URL of Nexus itself as nexus_host or let the proxy pass %s without a login", r.Host, e.URL)
+	}
+	return e
+}
+
+// registry API errors, see https://docs.docker.com/registry/spec/api/#errors
+type registryErrors struct {
+	Errors []struct {
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+		Detail  json.RawMessage `json:"detail"`
+	} `json:"errors"`
+}
+
+// validation errors of the Nexus REST API
+type nexusError struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// newError builds the Error for a response, reading (but not closing) its body
+func (r Registry) newError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	if resp.Request != nil {
+		e.Method = resp.Request.Method
+		e.URL = resp.Request.URL.String()
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var regErrors registryErrors
+	var nexusErrors []nexusError
+	var single nexusError
+	switch {
+	case len(body) == 0:
+	case json.Unmarshal(body, &regErrors) == nil && len(regErrors.Errors) > 0:
+		first := regErrors.Errors[0]
+		e.Code = first.Code
+		e.Message = first.Message
+		if detail := strings.TrimSpace(string(first.Detail)); detail != "" && detail != "null" && detail != "{}" && detail != "[]" {
+			e.Detail = detail
+		}
+	case json.Unmarshal(body, &nexusErrors) == nil && len(nexusErrors) > 0:
+		var messages []string
+		for _, ne := range nexusErrors {
+			messages = append(messages, ne.Message)
+		}
+		e.Message = strings.Join(messages, ", ")
+	case json.Unmarshal(body, &single) == nil && single.Message != "":
+		e.Message = single.Message
+	case !strings.Contains(resp.Header.Get("Content-Type"), "html"):
+		// plain text, Nexus error pages are html and not worth showing
+		text := strings.TrimSpace(strings.SplitN(string(body), "\n", 2)[0])
+		if len(text) > 200 {
+			text = text[:200] + "..."
+		}
+		e.Message = text
+	}
+
+	e.Hint = r.errorHint(e)
+	return e
+}
+
+func (r Registry) errorHint(e *Error) string {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return fmt.Sprintf("Check the username and password for %s, e.g. with 'nexus-cli configure'", r.Host)
+	case http.StatusForbidden:
+		if !strings.Contains(e.URL, "/repository/") {
+			// the REST API serves every format
+			return fmt.Sprintf("User %s lacks the privileges for this, it needs nx-repository-view-*-%s-* with the read, add or delete action", r.Username, r.Repository)
+		}
+		return fmt.Sprintf("User %s lacks the privileges for this, it needs nx-repository-view-docker-%s-* (or nx-repository-admin-docker-%s-* for deletes)", r.Username, r.Repository, r.Repository)
+	case http.StatusNotFound:
+		switch e.Code {
+		case "NAME_UNKNOWN":
+			return "The image does not exist in repository " + r.Repository
+		case "MANIFEST_UNKNOWN":
+			return "The tag or digest does not exist, list tags with 'nexus-cli image tags'"
+		case "BLOB_UNKNOWN":
+			return "The blob is missing, a compact blobstore task may have removed it"
+		}
+		if strings.Contains(e.URL, "/repository/") {
+			return fmt.Sprintf("Check that %s is a docker repository on %s", r.Repository, r.Host)
+		}
+	case http.StatusMethodNotAllowed:
+		return fmt.Sprintf("Repository %s does not allow this, e.g. because it is a proxy or group repository or deletes are disabled", r.Repository)
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Sprintf("%s is unavailable or a proxy in front of it failed, try again later", r.Host)
+	case http.StatusInternalServerError:
+		return "Nexus failed, its nexus.log tells why"
+	}
+	return ""
+}
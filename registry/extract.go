@@ -0,0 +1,235 @@
+package registry
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxSymlinks is how many symbolic links are followed resolving a path before giving up, like Linux does
+const maxSymlinks = 40
+
+// Resolve follows the symbolic links in a path, in its directories too (/bin/sh with /bin linking to usr/bin is
+// /usr/bin/sh), and returns the file it ends at. Hard links resolve to the entry holding the content
+func (fs Filesystem) Resolve(name string) (File, error) {
+	name = cleanLayerPath(name)
+	for hops := 0; hops <= maxSymlinks; hops++ {
+		components := strings.Split(name, "/")
+		current, redirected := "", false
+		for i, component := range components {
+			next := path.Join(current, component)
+			f, ok := fs[next]
+			if !ok || f.Type != tar.TypeSymlink {
+				current = next
+				continue
+			}
+			target := f.Linkname
+			if !path.IsAbs(target) {
+				target = path.Join(current, target)
+			}
+			name = cleanLayerPath(path.Join(append([]string{target}, components[i+1:]...)...))
+			redirected = true
+			break
+		}
+		if redirected {
+			continue
+		}
+		f, ok := fs[current]
+		if !ok {
+			if current == "" {
+				return File{Type: tar.TypeDir, Mode: os.ModeDir | 0755}, nil
+			}
+			return f, errors.New(fmt.Sprintf("/%s does not exist in the image", current))
+		}
+		if f.Type == tar.TypeLink {
+			target, ok := fs[cleanLayerPath(f.Linkname)]
+			if !ok {
+				return f, errors.New(fmt.Sprintf("/%s is a hard link to /%s, which does not exist", current, cleanLayerPath(f.Linkname)))
+			}
+			return target, nil
+		}
+		return f, nil
+	}
+	return File{}, errors.New(fmt.Sprintf("too many symbolic links resolving /%s", name))
+}
+
+// CatFile writes the content of a file of an image to w, following symbolic links. Only the layer holding the file
+// is read
+func (r Registry) CatFile(image string, reference string, platform string, name string, w io.Writer) error {
+	fs, manifest, err := r.ImageFilesystem(image, reference, platform, false)
+	if err != nil {
+		return err
+	}
+	f, err := fs.Resolve(name)
+	if err != nil {
+		return err
+	}
+	if f.Type != tar.TypeReg {
+		return errors.New(fmt.Sprintf("/%s is no regular file (%s)", f.Path, f.Mode))
+	}
+	found := false
+	err = r.WalkLayer(image, manifest.Layers[f.Layer], func(header *tar.Header, content io.Reader) error {
+		if found || cleanLayerPath(header.Name) != f.Path || header.FileInfo().IsDir() {
+			return nil
+		}
+		found = true
+		_, err := io.Copy(w, content)
+		return err
+	})
+	if err == nil && !found {
+		err = errors.New(fmt.Sprintf("/%s is missing in layer %s", f.Path, manifest.Layers[f.Layer].Digest))
+	}
+	return err
+}
+
+// ExtractStats tells what ExtractFiles wrote
+type ExtractStats struct {
+	Files       int
+	Directories int
+	Links       int
+	// Skipped are devices and fifos, they cannot be created without privileges
+	Skipped int
+}
+
+// ExtractFiles writes a file or a directory of an image, as the final filesystem has it, to dir: dir gets the content
+// of the directory, or the file under its name. Only the layers holding extracted files are read. Symbolic links are
+// created as they are, after everything else, so none can redirect writes outside dir. Owners are not kept
+func (r Registry) ExtractFiles(image string, reference string, platform string, name string, dir string) (ExtractStats, error) {
+	var stats ExtractStats
+	fs, manifest, err := r.ImageFilesystem(image, reference, platform, false)
+	if err != nil {
+		return stats, err
+	}
+	root, err := fs.Resolve(name)
+	if err != nil {
+		return stats, err
+	}
+
+	// wanted maps the entries holding content to where it goes, hard links get the content of their target
+	wanted := map[string][]string{}
+	var dirs, symlinks []File
+	destination := func(p string) string {
+		if !root.IsDir() {
+			return filepath.Join(dir, path.Base(cleanLayerPath(name)))
+		}
+		return filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(strings.TrimPrefix(p, root.Path), "/")))
+	}
+	prefix := root.Path + "/"
+	if root.Path == "" {
+		prefix = ""
+	}
+	for _, p := range fs.Paths() {
+		if p != root.Path && !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		f := fs[p]
+		switch f.Type {
+		case tar.TypeDir:
+			dirs = append(dirs, f)
+		case tar.TypeReg:
+			wanted[p] = append(wanted[p], destination(p))
+		case tar.TypeLink:
+			target := cleanLayerPath(f.Linkname)
+			if _, ok := fs[target]; !ok {
+				return stats, errors.New(fmt.Sprintf("/%s is a hard link to /%s, which does not exist", p, target))
+			}
+			wanted[target] = append(wanted[target], destination(p))
+		case tar.TypeSymlink:
+			symlinks = append(symlinks, f)
+		default:
+			stats.Skipped++
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return stats, err
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(destination(d.Path), 0755); err != nil {
+			return stats, err
+		}
+	}
+
+	layers := map[int]bool{}
+	for p := range wanted {
+		layers[fs[p].Layer] = true
+	}
+	var order []int
+	for i := range layers {
+		order = append(order, i)
+	}
+	sort.Ints(order)
+	for _, i := range order {
+		err := r.WalkLayer(image, manifest.Layers[i], func(header *tar.Header, content io.Reader) error {
+			p := cleanLayerPath(header.Name)
+			targets, ok := wanted[p]
+			if !ok || fs[p].Layer != i || header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+				return nil
+			}
+			for j, target := range targets {
+				if j == 0 {
+					if err := writeExtracted(target, content, fs[p]); err != nil {
+						return err
+					}
+					stats.Files++
+					continue
+				}
+				if err := os.Link(targets[0], target); err != nil {
+					return err
+				}
+				stats.Links++
+			}
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	for _, s := range symlinks {
+		target := destination(s.Path)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return stats, err
+		}
+		os.Remove(target)
+		if err := os.Symlink(s.Linkname, target); err != nil {
+			return stats, err
+		}
+		stats.Links++
+	}
+	// directories get their modes last, a read-only one would have refused the files
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(destination(dirs[i].Path), dirs[i].Mode.Perm()); err != nil {
+			return stats, err
+		}
+		stats.Directories++
+	}
+	return stats, nil
+}
+
+func writeExtracted(target string, content io.Reader, f File) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, content); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(target, f.Mode.Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(target, f.ModTime, f.ModTime)
+}
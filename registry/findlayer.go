@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// LayerMatch is a tag whose image contains a searched layer
+type LayerMatch struct {
+	Image string `json:"image"`
+	Tag   string `json:"tag"`
+	// Platform is set for multi-arch images, naming the platform manifest containing the layer
+	Platform string `json:"platform,omitempty"`
+	Digest   string `json:"digest"`
+}
+
+// FindLayer lists every tag of the given images (all images of the repository if none are given) whose manifest, or
+// for indexes one of the platform manifests, contains the layer. Manifests are fetched by workers in parallel, each
+// manifest only once even if several tags point to it
+func (r Registry) FindLayer(layer string, images []string, workers int) ([]LayerMatch, error) {
+	if len(images) == 0 {
+		var err error
+		if images, err = r.ListImages(); err != nil {
+			return nil, err
+		}
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	type job struct{ image, tag string }
+	jobs := make(chan job)
+	var (
+		mu       sync.Mutex
+		matches  []LayerMatch
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	// platforms containing the layer per manifest digest, shared by all tags of the image
+	cache := map[string][]string{}
+	var cacheLock sync.Mutex
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				found, err := r.layerPlatforms(j.image, j.tag, layer, cache, &cacheLock)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				for _, m := range found {
+					m.Image, m.Tag = j.image, j.tag
+					matches = append(matches, m)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, image := range images {
+		tags, err := r.ListTagsByImage(image)
+		if err != nil {
+			close(jobs)
+			wg.Wait()
+			return nil, err
+		}
+		for _, tag := range tags {
+			jobs <- job{image, tag}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Image != matches[j].Image {
+			return matches[i].Image < matches[j].Image
+		}
+		if matches[i].Tag != matches[j].Tag {
+			return matches[i].Tag < matches[j].Tag
+		}
+		return matches[i].Platform < matches[j].Platform
+	})
+	return matches, nil
+}
+
+// layerPlatforms checks a tag for the layer. It returns one match per (platform) manifest containing it
+func (r Registry) layerPlatforms(image string, reference string, layer string, cache map[string][]string, cacheLock *sync.Mutex) ([]LayerMatch, error) {
+	body, _, digest, err := r.RawManifest(image, reference)
+	if err != nil {
+		return nil, err
+	}
+	key := image + "@" + digest
+	cacheLock.Lock()
+	platforms, cached := cache[key]
+	cacheLock.Unlock()
+
+	if !cached {
+		var manifest ImageManifest
+		if err := json.Unmarshal(body, &manifest); err != nil {
+			return nil, err
+		}
+		if manifest.IsIndex() {
+			for _, m := range manifest.Manifests {
+				child, err := r.ImageManifest(image, m.Digest)
+				if err != nil {
+					return nil, err
+				}
+				if containsLayer(child, layer) {
+					platform := m.Digest
+					if m.Platform != nil {
+						platform = m.Platform.OS + "/" + m.Platform.Architecture
+						if m.Platform.Variant != "" {
+							platform += "/" + m.Platform.Variant
+						}
+					}
+					platforms = append(platforms, platform)
+				}
+			}
+		} else if containsLayer(manifest, layer) {
+			// an empty platform marks a single image manifest
+			platforms = []string{""}
+		}
+		if platforms == nil {
+			platforms = []string{}
+		}
+		cacheLock.Lock()
+		cache[key] = platforms
+		cacheLock.Unlock()
+	}
+
+	var matches []LayerMatch
+	for _, platform := range platforms {
+		matches = append(matches, LayerMatch{Platform: platform, Digest: digest})
+	}
+	return matches, nil
+}
+
+func containsLayer(manifest ImageManifest, layer string) bool {
+	for _, l := range manifest.Layers {
+		if l.Digest == layer {
+			return true
+		}
+	}
+	return false
+}
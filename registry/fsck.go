@@ -0,0 +1,139 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Damage is a reference of a tag the registry cannot serve, e.g. a layer garbage collected while the manifest stayed.
+// Pushing and listing such an image works, pulling it fails
+type Damage struct {
+	Image string `json:"image"`
+	Tag   string `json:"tag"`
+	// Manifest is the digest of the manifest holding the reference, empty if the manifest of the tag itself is missing
+	Manifest string `json:"manifest,omitempty"`
+	// Kind is what is referenced: manifest, config or layer
+	Kind    string `json:"kind"`
+	Digest  string `json:"digest,omitempty"`
+	Problem string `json:"problem"`
+}
+
+// Fsck checks every tag of an image: its manifest, the manifests of an index and the config and layer blobs have to
+// be there with the size they are referenced with. Blobs are only asked for with HEAD, their content is not verified.
+// The damages are returned sorted by tag
+func (r Registry) Fsck(image string) ([]Damage, error) {
+	tags, err := r.ListTagsByImage(image)
+	if err != nil {
+		return nil, err
+	}
+	f := &fsck{r: r, image: image, blobs: map[string]*blobStat{}}
+	damages := make([][]Damage, len(tags))
+	err = r.Crawler().RunLabeled(len(tags), func(i int) string { return image + ":" + tags[i] }, func(i int) error {
+		var err error
+		damages[i], err = f.manifest(tags[i], tags[i], "", -1)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var all []Damage
+	for _, d := range damages {
+		all = append(all, d...)
+	}
+	return all, nil
+}
+
+type blobStat struct {
+	exists bool
+	size   int64
+}
+
+// fsck remembers the blobs checked, tags of an image share most of their layers
+type fsck struct {
+	r     Registry
+	image string
+
+	mu    sync.Mutex
+	blobs map[string]*blobStat
+}
+
+// manifest checks the manifest of reference, parent is the digest of the index listing it and size the size the index
+// gives, -1 for the manifest of the tag
+func (f *fsck) manifest(tag string, reference string, parent string, size int64) ([]Damage, error) {
+	damage := func(kind string, digest string, problem string) Damage {
+		return Damage{Image: f.image, Tag: tag, Manifest: parent, Kind: kind, Digest: digest, Problem: problem}
+	}
+	body, _, digest, err := f.r.RawManifest(f.image, reference)
+	if IsNotFound(err) {
+		if parent == "" {
+			return []Damage{damage("manifest", "", "missing, the tag is listed but its manifest cannot be fetched")}, nil
+		}
+		return []Damage{damage("manifest", reference, "missing")}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if size >= 0 && int64(len(body)) != size {
+		return []Damage{damage("manifest", reference, fmt.Sprintf("has %d bytes, referenced with %d", len(body), size))}, nil
+	}
+	var manifest ImageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, err
+	}
+	if digest == "" {
+		digest = digestOf(body)
+	}
+
+	var damages []Damage
+	if manifest.IsIndex() {
+		for _, m := range manifest.Manifests {
+			found, err := f.manifest(tag, m.Digest, digest, m.Size)
+			if err != nil {
+				return nil, err
+			}
+			damages = append(damages, found...)
+		}
+		return damages, nil
+	}
+
+	parent = digest
+	blobs := append([]LayerInfo{manifest.Config}, manifest.Layers...)
+	for i, blob := range blobs {
+		if blob.Digest == "" {
+			continue
+		}
+		kind := "layer"
+		if i == 0 {
+			kind = "config"
+		}
+		stat, err := f.stat(blob.Digest)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !stat.exists:
+			damages = append(damages, damage(kind, blob.Digest, "missing"))
+		case stat.size >= 0 && stat.size != blob.Size:
+			damages = append(damages, damage(kind, blob.Digest, fmt.Sprintf("has %d bytes, referenced with %d", stat.size, blob.Size)))
+		}
+	}
+	return damages, nil
+}
+
+func (f *fsck) stat(digest string) (*blobStat, error) {
+	f.mu.Lock()
+	stat, ok := f.blobs[digest]
+	f.mu.Unlock()
+	if ok {
+		return stat, nil
+	}
+	exists, size, err := f.r.statBlob(f.image, digest)
+	if err != nil {
+		return nil, err
+	}
+	stat = &blobStat{exists: exists, size: size}
+	f.mu.Lock()
+	f.blobs[digest] = stat
+	f.mu.Unlock()
+	return stat, nil
+}
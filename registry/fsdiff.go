@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Kinds of FileChange
+const (
+	FileAdded   = "added"
+	FileRemoved = "removed"
+	FileChanged = "changed"
+)
+
+// FileChange is a difference between the filesystems of two images
+type FileChange struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	// Changes tells what differs for changed files: type, content, size, mode, owner, link or mtime
+	Changes []string `json:"changes,omitempty"`
+	Before  *File    `json:"before,omitempty"`
+	After   *File    `json:"after,omitempty"`
+}
+
+// DiffFilesystems compares two filesystems read with digests, sorted by path. Modification times of directories are
+// not compared, they change whenever something in them does
+func DiffFilesystems(before Filesystem, after Filesystem, ignoreModTime bool) []FileChange {
+	var changes []FileChange
+	for p, b := range before {
+		b := b
+		a, ok := after[p]
+		if !ok {
+			changes = append(changes, FileChange{Path: p, Kind: FileRemoved, Before: &b})
+			continue
+		}
+		if differences := compareFiles(b, a, ignoreModTime); len(differences) > 0 {
+			changes = append(changes, FileChange{Path: p, Kind: FileChanged, Changes: differences, Before: &b, After: &a})
+		}
+	}
+	for p, a := range after {
+		a := a
+		if _, ok := before[p]; !ok {
+			changes = append(changes, FileChange{Path: p, Kind: FileAdded, After: &a})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func compareFiles(b File, a File, ignoreModTime bool) []string {
+	if b.Type != a.Type {
+		return []string{"type"}
+	}
+	var differences []string
+	if b.Digest != a.Digest {
+		differences = append(differences, "content")
+	}
+	if b.Size != a.Size && !b.IsDir() {
+		differences = append(differences, "size")
+	}
+	if b.Mode != a.Mode {
+		differences = append(differences, "mode")
+	}
+	if b.UID != a.UID || b.GID != a.GID {
+		differences = append(differences, "owner")
+	}
+	if b.Linkname != a.Linkname {
+		differences = append(differences, "link")
+	}
+	if !ignoreModTime && !b.IsDir() && !b.ModTime.Equal(a.ModTime) {
+		differences = append(differences, "mtime")
+	}
+	return differences
+}
+
+// DiffImageFiles compares the filesystems of two tags, e.g. to find out why the digest changed when nothing should
+// have. Layers both share are read once. For indexes the manifest of platform is compared, see PlatformManifest
+func (r Registry) DiffImageFiles(image string, reference string, otherImage string, otherReference string, platform string, ignoreModTime bool) ([]FileChange, error) {
+	layers := map[string][]File{}
+	read := func(image string, reference string) (Filesystem, error) {
+		manifest, err := r.PlatformManifest(image, reference, platform)
+		if err != nil {
+			return nil, err
+		}
+		if manifest.Config.Digest == "" {
+			return nil, errors.New(fmt.Sprintf("%s:%s is not an image", image, reference))
+		}
+		fs := Filesystem{}
+		for i, layer := range manifest.Layers {
+			files, ok := layers[layer.Digest]
+			if !ok {
+				if files, err = r.LayerFiles(image, layer, i, true); err != nil {
+					return nil, err
+				}
+				layers[layer.Digest] = files
+			}
+			// the layer may be at another position in the other image
+			placed := make([]File, len(files))
+			for j, f := range files {
+				f.Layer = i
+				placed[j] = f
+			}
+			fs.Apply(placed)
+		}
+		return fs, nil
+	}
+
+	before, err := read(image, reference)
+	if err != nil {
+		return nil, err
+	}
+	after, err := read(otherImage, otherReference)
+	if err != nil {
+		return nil, err
+	}
+	return DiffFilesystems(before, after, ignoreModTime), nil
+}
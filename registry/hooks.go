@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Hook points. The pre_delete_hook, post_delete_hook and post_run_hook settings of a profile are shell commands run
+// there, reading the JSON of a DeleteEvent or RunEvent on stdin, e.g. to look up a ticket or the state of a CMDB
+const (
+	// HookPreDelete runs before a tag is deleted, a non zero exit code vetoes the deletion
+	HookPreDelete = "pre-delete"
+	// HookPostDelete runs after a tag was deleted or failed to, with the error
+	HookPostDelete = "post-delete"
+	// HookPostRun runs once a command which deleted tags is done, with what became of them
+	HookPostRun = "post-run"
+)
+
+// DeleteEvent is what the pre-delete and post-delete hooks read
+type DeleteEvent struct {
+	Hook       string `json:"hook"`
+	Host       string `json:"host"`
+	Repository string `json:"repository"`
+	Image      string `json:"image"`
+	Tag        string `json:"tag"`
+	Digest     string `json:"digest"`
+	// Action is delete, or untag if only the tag is deleted and its manifest stays
+	Action string `json:"action"`
+	// Along are the other tags pointing to the manifest, deleted with it
+	Along []string `json:"along,omitempty"`
+	// Quarantine is the repository the tags are copied to before
+	Quarantine string `json:"quarantine,omitempty"`
+	// Error is why the deletion failed, for post-delete
+	Error string `json:"error,omitempty"`
+}
+
+// RunEvent is what the post-run hook reads
+type RunEvent struct {
+	Hook       string `json:"hook"`
+	Command    string `json:"command"`
+	Host       string `json:"host"`
+	Repository string `json:"repository"`
+	Deleted    int    `json:"deleted"`
+	Failed     int    `json:"failed"`
+	Skipped    int    `json:"skipped"`
+	ExitCode   int    `json:"exit_code"`
+	Error      string `json:"error,omitempty"`
+}
+
+// VetoedError is returned when the pre-delete hook refused the deletion of a tag. IsProtected is true for it: it was
+// left alone on purpose
+type VetoedError struct {
+	Image    string
+	Tag      string
+	ExitCode int
+	// Message is the last line the hook wrote to stderr
+	Message string
+}
+
+func (e *VetoedError) Error() string {
+	msg := fmt.Sprintf("%s:%s is vetoed by the pre_delete_hook (exit code %d)", e.Image, e.Tag, e.ExitCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// runs are the post-run hooks of the registries which deleted tags, by hook, host and repository
+var (
+	runsMu sync.Mutex
+	runs   = map[string]*RunEvent{}
+)
+
+// preDelete runs the pre-delete hook of the registry for event, returning a *VetoedError if it refuses
+func (r Registry) preDelete(event *DeleteEvent) error {
+	if r.PreDeleteHook == "" {
+		return nil
+	}
+	event.Hook = HookPreDelete
+	code, message, err := runHook(r.PreDeleteHook, event)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Running the pre_delete_hook for %s:%s: %s", event.Image, event.Tag, err))
+	}
+	if code != 0 {
+		return &VetoedError{Image: event.Image, Tag: event.Tag, ExitCode: code, Message: message}
+	}
+	return nil
+}
+
+// postDelete runs the post-delete hook of the registry with the outcome of the deletion of event. The hook cannot
+// undo it, its failures are only reported
+func (r Registry) postDelete(event *DeleteEvent, deleteErr error) {
+	if r.PostDeleteHook == "" {
+		return
+	}
+	event.Hook, event.Error = HookPostDelete, ""
+	if deleteErr != nil {
+		event.Error = deleteErr.Error()
+	}
+	reportHook(HookPostDelete)(runHook(r.PostDeleteHook, event))
+}
+
+// countRun records the outcome of a deletion for the post-run hook of the registry
+func (r Registry) countRun(err error) {
+	if r.PostRunHook == "" {
+		return
+	}
+	runsMu.Lock()
+	defer runsMu.Unlock()
+	key := r.PostRunHook + "\n" + r.Host + "\n" + r.Repository
+	run, ok := runs[key]
+	if !ok {
+		run = &RunEvent{Hook: HookPostRun, Host: r.Host, Repository: r.Repository}
+		runs[key] = run
+	}
+	switch {
+	case IsProtected(err):
+		run.Skipped++
+	case err != nil:
+		run.Failed++
+	default:
+		run.Deleted++
+	}
+}
+
+// RunPostRunHooks runs the post-run hooks of the registries which deleted tags since the last call, once the command
+// is done. Programs embedding TagDeleter call it when they are done deleting
+func RunPostRunHooks(command string, err error, exitCode int) {
+	runsMu.Lock()
+	pending := runs
+	runs = map[string]*RunEvent{}
+	runsMu.Unlock()
+	for key, run := range pending {
+		run.Command, run.ExitCode = command, exitCode
+		if err != nil {
+			run.Error = err.Error()
+		}
+		reportHook(HookPostRun)(runHook(strings.SplitN(key, "\n", 2)[0], run))
+	}
+}
+
+// runHook runs a hook with the shell, event on stdin. Its output goes to stderr, stdout being the one of the command
+// running it. It returns the exit code and the last line written to stderr, err if the hook could not be run
+func runHook(hook string, event interface{}) (int, string, error) {
+	input, err := json.Marshal(event)
+	if err != nil {
+		return 0, "", err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", hook)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	cmd.Env = append(os.Environ(), "NEXUS_CLI_HOOK="+hookName(event))
+	err = cmd.Run()
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	message := strings.TrimSpace(lines[len(lines)-1])
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), message, nil
+	}
+	return 0, message, err
+}
+
+func hookName(event interface{}) string {
+	switch e := event.(type) {
+	case *DeleteEvent:
+		return e.Hook
+	case *RunEvent:
+		return e.Hook
+	}
+	return ""
+}
+
+// reportHook reports a failing hook whose outcome does not change anything
+func reportHook(hook string) func(int, string, error) {
+	return func(code int, message string, err error) {
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Running the %s hook failed: %s\n", hook, err)
+		case code != 0:
+			fmt.Fprintf(os.Stderr, "The %s hook exited with %d: %s\n", hook, code, message)
+		}
+	}
+}
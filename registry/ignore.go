@@ -0,0 +1,220 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the rules file looked for in the working directory when no other is given
+const IgnoreFile = ".nexusignore"
+
+// IgnoreRules exclude images and tags from listings, reports, cleanups and mirroring, and protect them against
+// deletion, like .gitignore does for files. A rules file has a pattern per line:
+//
+//	# the images of the legacy team, at any depth
+//	legacy/
+//	# release tags of all images
+//	**:release-*
+//	# but this one may go
+//	!legacy/tools:release-1
+//
+// Patterns match image names, * and ? within a component, ** across components, a trailing slash everything below
+// a namespace. After a colon they match tags, without one all tags of the image. ! includes again what an earlier
+// line excludes, the last line matching decides. The methods of a nil *IgnoreRules exclude nothing
+type IgnoreRules struct {
+	// Path is the file the rules were read from, for messages
+	Path  string
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	negated bool
+	image   *regexp.Regexp
+	// tag is a glob, empty for all tags
+	tag string
+}
+
+// LoadIgnoreRules reads a rules file
+func LoadIgnoreRules(file string) (*IgnoreRules, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := ParseIgnoreRules(content)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid rules file %s: %s", file, err))
+	}
+	rules.Path = file
+	return rules, nil
+}
+
+// ParseIgnoreRules parses the content of a rules file
+func ParseIgnoreRules(content []byte) (*IgnoreRules, error) {
+	rules := &IgnoreRules{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negated, line = true, strings.TrimSpace(line[1:])
+		}
+		pattern := line
+		if i := strings.LastIndex(line, ":"); i >= 0 {
+			pattern, rule.tag = line[:i], line[i+1:]
+			if _, err := path.Match(rule.tag, ""); err != nil || rule.tag == "" {
+				return nil, errors.New(fmt.Sprintf("line %d: invalid tag pattern %q", n, rule.tag))
+			}
+		}
+		if pattern == "" {
+			return nil, errors.New(fmt.Sprintf("line %d: no image pattern, use ** for all images", n))
+		}
+		re, err := globRegexp(pattern)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d: invalid image pattern %q: %s", n, pattern, err))
+		}
+		rule.image = re
+		rules.rules = append(rules.rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// globRegexp translates an image pattern into a regular expression matching whole names
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			j := strings.IndexByte(pattern[i:], ']')
+			if j < 0 {
+				return nil, errors.New("unclosed [")
+			}
+			re.WriteString(strings.Replace(pattern[i:i+j+1], "[!", "[^", 1))
+			i += j
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+// Tag tells if a tag of an image is excluded
+func (r *IgnoreRules) Tag(image string, tag string) bool {
+	if r == nil {
+		return false
+	}
+	excluded := false
+	for _, rule := range r.rules {
+		if !rule.image.MatchString(image) {
+			continue
+		}
+		if rule.tag != "" {
+			if ok, _ := path.Match(rule.tag, tag); !ok {
+				continue
+			}
+		}
+		excluded = !rule.negated
+	}
+	return excluded
+}
+
+// Image tells if an image is excluded as a whole: a line excludes all its tags and no later one includes any again
+func (r *IgnoreRules) Image(image string) bool {
+	if r == nil {
+		return false
+	}
+	excluded := false
+	for _, rule := range r.rules {
+		if !rule.image.MatchString(image) {
+			continue
+		}
+		switch {
+		case rule.negated:
+			excluded = false
+		case rule.tag == "":
+			excluded = true
+		}
+	}
+	return excluded
+}
+
+// Images returns the images which are not excluded as a whole
+func (r *IgnoreRules) Images(images []string) []string {
+	if r == nil {
+		return images
+	}
+	kept := []string{}
+	for _, image := range images {
+		if !r.Image(image) {
+			kept = append(kept, image)
+		}
+	}
+	return kept
+}
+
+// Tags returns the tags of image which are not excluded
+func (r *IgnoreRules) Tags(image string, tags []string) []string {
+	if r == nil {
+		return tags
+	}
+	kept := []string{}
+	for _, tag := range tags {
+		if !r.Tag(image, tag) {
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
+
+// Inventory removes the excluded images and tags from an inventory
+func (r *IgnoreRules) Inventory(inventory Inventory) {
+	if r == nil {
+		return
+	}
+	for image, tags := range inventory {
+		if r.Image(image) {
+			delete(inventory, image)
+			continue
+		}
+		for tag := range tags {
+			if r.Tag(image, tag) {
+				delete(tags, tag)
+			}
+		}
+	}
+}
+
+// WithIgnoreRules protects the tags the rules exclude against deletion by TagDeleter, see IgnoreRules
+func WithIgnoreRules(rules *IgnoreRules) Option {
+	return func(r *Registry) {
+		r.ignore = rules
+	}
+}
+
+// IgnoreRules returns the rules given with WithIgnoreRules, nil if there are none
+func (r Registry) IgnoreRules() *IgnoreRules {
+	return r.ignore
+}
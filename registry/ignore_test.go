@@ -0,0 +1,183 @@
+package registry
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseRules(t *testing.T, lines ...string) *IgnoreRules {
+	rules, err := ParseIgnoreRules([]byte(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		image   string
+		want    bool
+	}{
+		{"team/app", "team/app", true},
+		{"team/app", "team/app/db", false},
+		{"team/app", "other/team/app", false},
+		{"team/*", "team/app", true},
+		{"team/*", "team/app/db", false},
+		{"team/", "team/app/db", true},
+		{"team/", "team", false},
+		{"team/", "teams/app", false},
+		{"team/**", "team/app/db", true},
+		{"**/cache", "cache", true},
+		{"**/cache", "team/app/cache", true},
+		{"**/cache", "team/cached", false},
+		{"team/**/db", "team/db", true},
+		{"team/**/db", "team/app/eu/db", true},
+		{"team/?pp", "team/app", true},
+		{"team/?pp", "team/a/p", false},
+		{"team/[ab]pp", "team/bpp", true},
+		{"team/[!a]pp", "team/app", false},
+		{"team/[!a]pp", "team/opp", true},
+		{"team/app.v1", "team/appxv1", false},
+	}
+	for _, test := range tests {
+		rules := parseRules(t, test.pattern)
+		if got := rules.Image(test.image); got != test.want {
+			t.Errorf("%q: Image(%q) = %t, want %t", test.pattern, test.image, got, test.want)
+		}
+	}
+}
+
+// TestIgnoreRules checks the rules of the documented example: negations include again, the last line matching
+// decides, and a tag line excludes tags without excluding the image
+func TestIgnoreRules(t *testing.T) {
+	rules := parseRules(t,
+		"# the images of the legacy team, at any depth",
+		"legacy/",
+		"",
+		"# release tags of all images",
+		"**:release-*",
+		"# but this one may go",
+		"!legacy/tools:release-1",
+		"team/app:*-rc?",
+		"!team/app",
+	)
+
+	images := []struct {
+		image string
+		want  bool
+	}{
+		{"legacy/db", true},
+		{"legacy/old/db", true},
+		// a later line includes one of its tags again
+		{"legacy/tools", false},
+		// tag lines leave the image
+		{"team/app", false},
+		{"team/web", false},
+		{"legacy", false},
+	}
+	for _, test := range images {
+		if got := rules.Image(test.image); got != test.want {
+			t.Errorf("Image(%q) = %t, want %t", test.image, got, test.want)
+		}
+	}
+
+	tags := []struct {
+		image, tag string
+		want       bool
+	}{
+		{"legacy/db", "1.0", true},
+		{"legacy/tools", "1.0", true},
+		{"legacy/tools", "release-1", false},
+		{"legacy/tools", "release-2", true},
+		{"team/web", "release-3", true},
+		{"team/web", "1.0", false},
+		{"team/web", "1.0-rc1", false},
+		// excluded by a line, included again by the last one
+		{"team/app", "1.0-rc1", false},
+		{"team/app", "release-1", false},
+	}
+	for _, test := range tags {
+		if got := rules.Tag(test.image, test.tag); got != test.want {
+			t.Errorf("Tag(%q, %q) = %t, want %t", test.image, test.tag, got, test.want)
+		}
+	}
+
+	if got, want := rules.Images([]string{"legacy/db", "legacy/tools", "team/app"}), []string{"legacy/tools", "team/app"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Images = %q, want %q", got, want)
+	}
+	if got, want := rules.Tags("legacy/tools", []string{"1.0", "release-1", "release-2"}), []string{"release-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags = %q, want %q", got, want)
+	}
+}
+
+func TestIgnoreOrder(t *testing.T) {
+	tests := []struct {
+		lines []string
+		image string
+		tag   string
+		want  bool
+	}{
+		{[]string{"team/", "!team/app"}, "team/app", "1.0", false},
+		{[]string{"!team/app", "team/"}, "team/app", "1.0", true},
+		{[]string{"team/app:*", "!team/app:1.*", "team/app:1.0"}, "team/app", "1.0", true},
+		{[]string{"team/app:*", "!team/app:1.*", "team/app:1.0"}, "team/app", "1.1", false},
+		{[]string{"team/app:*", "!team/app:1.*", "team/app:1.0"}, "team/app", "2.0", true},
+		{[]string{"!team/app"}, "team/app", "1.0", false},
+	}
+	for _, test := range tests {
+		rules := parseRules(t, test.lines...)
+		if got := rules.Tag(test.image, test.tag); got != test.want {
+			t.Errorf("%q: Tag(%q, %q) = %t, want %t", test.lines, test.image, test.tag, got, test.want)
+		}
+	}
+
+	// an image is only excluded as a whole if no later line includes a tag of it again, even if another line
+	// excludes that tag once more
+	rules := parseRules(t, "team/", "!team/app:1.0", "team/app:1.0")
+	if rules.Image("team/app") {
+		t.Error("Image(team/app) = true, a later line includes its tag 1.0 again")
+	}
+}
+
+func TestIgnoreInventory(t *testing.T) {
+	rules := parseRules(t, "legacy/", "**:*-SNAPSHOT")
+	inventory := Inventory{
+		"legacy/db": {"1.0": "sha256:a"},
+		"team/app":  {"1.0": "sha256:b", "1.1-SNAPSHOT": "sha256:c"},
+	}
+	rules.Inventory(inventory)
+	if want := (Inventory{"team/app": {"1.0": "sha256:b"}}); !reflect.DeepEqual(inventory, want) {
+		t.Errorf("Inventory = %v, want %v", inventory, want)
+	}
+}
+
+func TestIgnoreNil(t *testing.T) {
+	var rules *IgnoreRules
+	if rules.Image("team/app") || rules.Tag("team/app", "1.0") {
+		t.Error("nil rules exclude")
+	}
+	if got := rules.Tags("team/app", []string{"1.0"}); !reflect.DeepEqual(got, []string{"1.0"}) {
+		t.Errorf("nil rules Tags = %q", got)
+	}
+}
+
+func TestParseIgnoreRulesInvalid(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{":1.0", "line 1: no image pattern"},
+		{"!", "line 1: no image pattern"},
+		{"# comment\nteam/app:", `line 2: invalid tag pattern ""`},
+		{"team/app:[1", `line 1: invalid tag pattern "[1"`},
+		{"team/[app", `line 1: invalid image pattern "team/[app": unclosed [`},
+	}
+	for _, test := range tests {
+		_, err := ParseIgnoreRules([]byte(test.content))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("ParseIgnoreRules(%q): %v, want %q", test.content, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ImageConfig is the part of the image configuration blob nexus-cli cares about
+type ImageConfig struct {
+	Created      time.Time `json:"created"`
+	Author       string    `json:"author"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+	History []HistoryEntry `json:"history"`
+}
+
+// HistoryEntry is a build step, the steps not marked EmptyLayer created the layers in order
+type HistoryEntry struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by"`
+	Comment    string    `json:"comment"`
+	EmptyLayer bool      `json:"empty_layer"`
+}
+
+// ImageConfig fetches the configuration of a tag. For indexes the linux/amd64 manifest is used, or the first one
+// if there is none for that platform
+func (r Registry) ImageConfig(image string, reference string) (ImageConfig, error) {
+	var config ImageConfig
+	manifest, err := r.PlatformManifest(image, reference, "")
+	if err != nil {
+		return config, err
+	}
+
+	blob, _, err := r.GetBlob(image, manifest.Config.Digest)
+	if err != nil {
+		return config, err
+	}
+	defer blob.Close()
+
+	if err := json.NewDecoder(blob).Decode(&config); err != nil {
+		return config, errors.New(fmt.Sprintf("invalid image config of %s:%s: %s", image, reference, err))
+	}
+	return config, nil
+}
+
+// PlatformManifest returns the image manifest of a tag. For indexes the manifest of the platform ("os/arch" or
+// "os/arch/variant") is picked. Without a platform linux/amd64 is preferred, falling back to the first manifest
+func (r Registry) PlatformManifest(image string, reference string, platform string) (ImageManifest, error) {
+	manifest, err := r.ImageManifest(image, reference)
+	if err != nil || !manifest.IsIndex() {
+		return manifest, err
+	}
+	if len(manifest.Manifests) == 0 {
+		return manifest, errors.New(fmt.Sprintf("%s:%s is an empty index", image, reference))
+	}
+
+	var chosen *ManifestInfo
+	if platform == "" {
+		chosen = &manifest.Manifests[0]
+		platform = "linux/amd64"
+	}
+	var available []string
+	for i, m := range manifest.Manifests {
+		if m.Platform == nil {
+			continue
+		}
+		name := m.Platform.OS + "/" + m.Platform.Architecture
+		if m.Platform.Variant != "" {
+			name += "/" + m.Platform.Variant
+		}
+		available = append(available, name)
+		if name == platform || m.Platform.OS+"/"+m.Platform.Architecture == platform {
+			chosen = &manifest.Manifests[i]
+			break
+		}
+	}
+	if chosen == nil {
+		return manifest, errors.New(fmt.Sprintf("%s:%s has no manifest for %s, available: %s", image, reference, platform, strings.Join(available, ", ")))
+	}
+	return r.ImageManifest(image, chosen.Digest)
+}
@@ -0,0 +1,206 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// Inventory maps image names to their tags and the manifest digests the tags point to
+type Inventory map[string]map[string]string
+
+// Change is a difference of a tag between two inventories
+type Change struct {
+	Image string `json:"image"`
+	Tag   string `json:"tag"`
+	// Kind is one of ChangeAdded, ChangeRemoved or ChangeDigest
+	Kind           string `json:"kind"`
+	Digest         string `json:"digest,omitempty"`
+	PreviousDigest string `json:"previous_digest,omitempty"`
+}
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeDigest  = "changed"
+)
+
+// TagDigests lists the tags of the image together with the digest each of them points to
+func (r Registry) TagDigests(image string) (map[string]string, error) {
+	inventory, err := r.tagDigests([]string{image})
+	if err != nil {
+		return nil, err
+	}
+	return inventory[image], nil
+}
+
+// tagDigests lists the tags of the images and resolves all their digests, both in parallel through the crawler of
+// the registry
+func (r Registry) tagDigests(images []string) (Inventory, error) {
+	crawler := r.Crawler()
+	tags := make([][]string, len(images))
+	err := crawler.RunLabeled(len(images), func(i int) string { return images[i] }, func(i int) error {
+		var err error
+		tags[i], err = r.ListTagsByImage(images[i])
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var refs []TagRef
+	for i, image := range images {
+		for _, tag := range tags[i] {
+			refs = append(refs, TagRef{Image: image, Tag: tag})
+		}
+	}
+	digests, err := r.resolveDigests(refs, crawler)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := make(Inventory, len(images))
+	for _, image := range images {
+		inventory[image] = map[string]string{}
+	}
+	for ref, digest := range digests {
+		inventory[ref.Image][ref.Tag] = digest
+	}
+	return inventory, nil
+}
+
+// Inventory collects the tags and digests of the given images, or of all images in the repository if images is empty.
+// Given images missing in the repository are part of the inventory without tags
+func (r Registry) Inventory(images []string) (Inventory, error) {
+	catalog, err := r.ListImages()
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		images = catalog
+	}
+
+	var present []string
+	for _, image := range images {
+		if containsString(catalog, image) {
+			present = append(present, image)
+		}
+	}
+	inventory, err := r.tagDigests(present)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		if _, ok := inventory[image]; !ok {
+			inventory[image] = map[string]string{}
+		}
+	}
+	return inventory, nil
+}
+
+// DiffInventory lists the tags added, removed or pointing to a different digest in after compared to before,
+// sorted by image and tag
+func DiffInventory(before Inventory, after Inventory) []Change {
+	var changes []Change
+	for image, tags := range after {
+		for tag, digest := range tags {
+			previous, ok := before[image][tag]
+			if !ok {
+				changes = append(changes, Change{Image: image, Tag: tag, Kind: ChangeAdded, Digest: digest})
+			} else if previous != digest {
+				changes = append(changes, Change{Image: image, Tag: tag, Kind: ChangeDigest, Digest: digest, PreviousDigest: previous})
+			}
+		}
+	}
+	for image, tags := range before {
+		for tag, digest := range tags {
+			if _, ok := after[image][tag]; !ok {
+				changes = append(changes, Change{Image: image, Tag: tag, Kind: ChangeRemoved, PreviousDigest: digest})
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Image != changes[j].Image {
+			return changes[i].Image < changes[j].Image
+		}
+		return changes[i].Tag < changes[j].Tag
+	})
+	return changes
+}
+
+// DiffImages lists the images of after which are not in before and those of before which are not in after, sorted
+func DiffImages(before Inventory, after Inventory) ([]string, []string) {
+	var added, removed []string
+	for image := range after {
+		if _, ok := before[image]; !ok {
+			added = append(added, image)
+		}
+	}
+	for image := range before {
+		if _, ok := after[image]; !ok {
+			removed = append(removed, image)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// Snapshot is the inventory of a repository at a point in time, as stored by 'repo snapshot'
+type Snapshot struct {
+	Host       string    `json:"host"`
+	Repository string    `json:"repository"`
+	Created    time.Time `json:"created"`
+	Images     Inventory `json:"images"`
+}
+
+// Snapshot captures every image, tag and digest of the repository
+func (r Registry) Snapshot() (Snapshot, error) {
+	snapshot := Snapshot{Host: r.Host, Repository: r.Repository, Created: time.Now().UTC()}
+	inventory, err := r.Inventory(nil)
+	if err != nil {
+		return snapshot, err
+	}
+	snapshot.Images = inventory
+	return snapshot, nil
+}
+
+// Save writes the snapshot as JSON
+func (s Snapshot) Save(path string) error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// LoadSnapshot reads a snapshot written by Save
+func LoadSnapshot(path string) (Snapshot, error) {
+	var snapshot Snapshot
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return snapshot, err
+	}
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return snapshot, errors.New(fmt.Sprintf("%s is not a valid snapshot: %s", path, err))
+	}
+	return snapshot, nil
+}
+
+// EnsureNotOverwritten fails if image:tag exists and points to another digest than the given one. Pushing the same
+// digest again is fine
+func (r Registry) EnsureNotOverwritten(image string, tag string, digest string) error {
+	existing, err := r.ImageDigest(image, tag)
+	if IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if existing != digest {
+		return errors.New(fmt.Sprintf("%s:%s already exists with digest %s, refusing to overwrite it with %s", image, tag, existing, digest))
+	}
+	return nil
+}
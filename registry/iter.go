@@ -0,0 +1,142 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// PageSize is the number of images or tags the iterators ask the registry for per request
+var PageSize = 1000
+
+// nextLink finds the query of the next page in a Link header, e.g. </v2/_catalog?last=b&n=100>; rel="next"
+var nextLink = regexp.MustCompile(`<([^>]*)>\s*;\s*rel="?next"?`)
+
+// Iterator walks a listing the registry sends page by page. The next page is only fetched once the previous one is
+// used up, so consumers process the first entries right away and memory stays at one page:
+//
+//	it := r.ImagesIter(ctx)
+//	for it.Next() {
+//		fmt.Println(it.Value())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	ctx   context.Context
+	fetch func(query url.Values) ([]string, url.Values, error)
+
+	query url.Values
+	page  []string
+	value string
+	done  bool
+	err   error
+}
+
+// Next advances to the next entry, false once the listing is complete or failed
+func (it *Iterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		if it.err = it.ctx.Err(); it.err != nil {
+			return false
+		}
+		it.page, it.query, it.err = it.fetch(it.query)
+		if it.err != nil {
+			return false
+		}
+		it.done = it.query == nil
+	}
+	it.value, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Value returns the current entry
+func (it *Iterator) Value() string {
+	return it.value
+}
+
+// Err returns the error that stopped the iteration, nil if it completed
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// ImagesIter iterates over the images of the repository
+func (r Registry) ImagesIter(ctx context.Context) *Iterator {
+	endpoint := fmt.Sprintf("%s/repository/%s/v2/_catalog", r.Host, r.Repository)
+	return r.iterate(ctx, endpoint, func(page *listPage) []string { return page.Repositories })
+}
+
+// TagsIter iterates over the tags of an image
+func (r Registry) TagsIter(ctx context.Context, image string) *Iterator {
+	endpoint := fmt.Sprintf("%s/repository/%s/v2/%s/tags/list", r.Host, r.Repository, imagePath(image))
+	return r.iterate(ctx, endpoint, func(page *listPage) []string { return page.Tags })
+}
+
+// EachImagePage calls fn with the images of the repository page by page. fn returning an error stops the listing,
+// the error is returned
+func (r Registry) EachImagePage(ctx context.Context, fn func(images []string) error) error {
+	return eachPage(r.ImagesIter(ctx), fn)
+}
+
+// EachTagPage calls fn with the tags of an image page by page, like EachImagePage
+func (r Registry) EachTagPage(ctx context.Context, image string, fn func(tags []string) error) error {
+	return eachPage(r.TagsIter(ctx, image), fn)
+}
+
+func eachPage(it *Iterator, fn func(page []string) error) error {
+	for it.Next() {
+		// Next took the first entry of the page
+		page := append([]string{it.Value()}, it.page...)
+		it.page = nil
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// listPage is a page of the catalog or of a tag list
+type listPage struct {
+	Repositories []string `json:"repositories"`
+	Tags         []string `json:"tags"`
+}
+
+func (r Registry) iterate(ctx context.Context, endpoint string, entries func(*listPage) []string) *Iterator {
+	fetch := func(query url.Values) ([]string, url.Values, error) {
+		// canceling ctx aborts the page being fetched, not only those after it
+		resp, err := r.getContext(ctx, endpoint+"?"+query.Encode(), AcceptHeader)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, nil, r.newError(resp)
+		}
+		var page listPage
+		if err := r.decode(resp, &page); err != nil {
+			return nil, nil, err
+		}
+		return entries(&page), nextQuery(resp.Header.Get("Link")), nil
+	}
+	return &Iterator{ctx: ctx, fetch: fetch, query: url.Values{"n": {strconv.Itoa(PageSize)}}}
+}
+
+// nextQuery returns the query of the next page, nil on the last page. Only the query of the link is used, Nexus
+// links are relative to the registry root and not to the repository path
+func nextQuery(link string) url.Values {
+	match := nextLink.FindStringSubmatch(link)
+	if match == nil {
+		return nil
+	}
+	next, err := url.Parse(match[1])
+	if err != nil || next.Query().Get("last") == "" {
+		return nil
+	}
+	return next.Query()
+}
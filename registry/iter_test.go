@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestIteratorCancel checks canceling the context aborts the page being fetched instead of waiting for it
+func TestIteratorCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", `</v2/_catalog?last=a&n=1>; rel="next"`)
+			w.Write([]byte(`{"repositories": ["a"]}`))
+			return
+		}
+		// the second page hangs until the client gives up
+		select {
+		case <-req.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	// released before the server closes, which waits for the handler
+	defer close(release)
+	r := New(srv.URL, WithRepository("docker-hosted"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it := r.ImagesIter(ctx)
+	if !it.Next() || it.Value() != "a" {
+		t.Fatalf("first page: %q, %v", it.Value(), it.Err())
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	done := make(chan bool, 1)
+	go func() { done <- it.Next() }()
+	select {
+	case next := <-done:
+		if next {
+			t.Fatalf("Next after canceling returned %q", it.Value())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Next kept waiting for the page after the context was canceled")
+	}
+	if err := it.Err(); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("Err = %v, want %s", err, context.Canceled)
+	}
+}
@@ -0,0 +1,197 @@
+package registry
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Whiteouts mark files of lower layers as deleted (.wh.<name>) or a directory as replaced (.wh..wh..opq), see
+// https://github.com/opencontainers/image-spec/blob/main/layer.md#whiteouts
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// File is an entry of the filesystem of an image
+type File struct {
+	// Path is relative to the root, without a leading slash
+	Path     string
+	Type     byte
+	Mode     os.FileMode
+	Size     int64
+	UID      int
+	GID      int
+	ModTime  time.Time
+	Linkname string
+	// Digest is the sha256 digest of the content of regular files, only set if it was asked for
+	Digest string
+	// Layer is the index of the layer the file comes from
+	Layer int
+}
+
+// IsDir tells if the file is a directory
+func (f File) IsDir() bool {
+	return f.Type == tar.TypeDir
+}
+
+// WalkLayer streams the tarball of a layer, calling fn for every entry. The content is only readable during the
+// call. Layers compressed with gzip and uncompressed ones are supported, others (zstd) are refused
+func (r Registry) WalkLayer(image string, layer LayerInfo, fn func(header *tar.Header, content io.Reader) error) error {
+	if strings.Contains(layer.MediaType, "zstd") {
+		return errors.New(fmt.Sprintf("layer %s is compressed with zstd, which is not supported", layer.Digest))
+	}
+	blob, _, err := r.GetBlob(image, layer.Digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	// the media type does not always tell, docker and OCI both allow uncompressed layers
+	content := bufio.NewReader(blob)
+	var stream io.Reader = content
+	if magic, _ := content.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(content)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		stream = gz
+	}
+
+	tr := tar.NewReader(stream)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.New(fmt.Sprintf("layer %s: %s", layer.Digest, err))
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// LayerFiles lists the entries of a layer as they are, whiteouts included. With digests the content of regular files
+// is hashed
+func (r Registry) LayerFiles(image string, layer LayerInfo, index int, digests bool) ([]File, error) {
+	var files []File
+	err := r.WalkLayer(image, layer, func(header *tar.Header, content io.Reader) error {
+		f := File{
+			Path:     cleanLayerPath(header.Name),
+			Type:     header.Typeflag,
+			Mode:     header.FileInfo().Mode(),
+			Size:     header.Size,
+			UID:      header.Uid,
+			GID:      header.Gid,
+			ModTime:  header.ModTime,
+			Linkname: header.Linkname,
+			Layer:    index,
+		}
+		if f.Type == tar.TypeRegA {
+			f.Type = tar.TypeReg
+		}
+		if f.Path == "" {
+			return nil
+		}
+		if digests && f.Type == tar.TypeReg {
+			h := sha256.New()
+			if _, err := io.Copy(h, content); err != nil {
+				return err
+			}
+			f.Digest = "sha256:" + hex.EncodeToString(h.Sum(nil))
+		}
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
+
+// cleanLayerPath makes entry names comparable, ./etc/passwd and /etc/passwd are etc/passwd
+func cleanLayerPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// Filesystem is the filesystem of an image, its layers applied on top of each other
+type Filesystem map[string]File
+
+// Apply puts the entries of a layer on top of the filesystem. Whiteouts delete what lower layers have, they are not
+// part of the result. Entries which are not directories replace lower directories with everything in them
+func (fs Filesystem) Apply(files []File) {
+	// whiteouts only hide lower layers, entries of the layer itself stay whatever their order in the tarball
+	for _, f := range files {
+		dir, base := path.Split(f.Path)
+		switch {
+		case base == whiteoutOpaque:
+			fs.remove(strings.TrimSuffix(dir, "/"), false)
+		case strings.HasPrefix(base, whiteoutPrefix):
+			fs.remove(dir+strings.TrimPrefix(base, whiteoutPrefix), true)
+		}
+	}
+	for _, f := range files {
+		if strings.HasPrefix(path.Base(f.Path), whiteoutPrefix) {
+			continue
+		}
+		// a file or link replacing a directory of a lower layer replaces its content as well
+		if !f.IsDir() {
+			fs.remove(f.Path, false)
+		}
+		fs[f.Path] = f
+	}
+}
+
+// remove deletes what is below dir, and dir itself with self
+func (fs Filesystem) remove(dir string, self bool) {
+	if self {
+		delete(fs, dir)
+	}
+	prefix := dir + "/"
+	if dir == "" {
+		prefix = ""
+	}
+	for p := range fs {
+		if strings.HasPrefix(p, prefix) && p != dir {
+			delete(fs, p)
+		}
+	}
+}
+
+// Paths returns the paths of the filesystem, sorted
+func (fs Filesystem) Paths() []string {
+	paths := make([]string, 0, len(fs))
+	for p := range fs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ImageFilesystem reads the layers of a tag and returns its final filesystem. For indexes the manifest of platform
+// is used, see PlatformManifest. With digests the content of regular files is hashed, which reads every layer in full
+func (r Registry) ImageFilesystem(image string, reference string, platform string, digests bool) (Filesystem, ImageManifest, error) {
+	manifest, err := r.PlatformManifest(image, reference, platform)
+	if err != nil {
+		return nil, manifest, err
+	}
+	fs := Filesystem{}
+	for i, layer := range manifest.Layers {
+		files, err := r.LayerFiles(image, layer, i, digests)
+		if err != nil {
+			return nil, manifest, err
+		}
+		fs.Apply(files)
+	}
+	return fs, manifest, nil
+}
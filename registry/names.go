@@ -0,0 +1,48 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Image names are paths: a repository holds team/project/service as well as service. The slashes separate the
+// components of the name and stay as they are in the URLs of the registry API, only the components are escaped
+
+// imagePath returns image for the URLs of the registry API, each component escaped
+func imagePath(image string) string {
+	components := strings.Split(image, "/")
+	for i, component := range components {
+		components[i] = url.PathEscape(component)
+	}
+	return strings.Join(components, "/")
+}
+
+// CleanNamespace returns the namespace of images given on the command line, e.g. team/project, without the
+// leading and trailing slashes. Empty components (team//project) are refused
+func CleanNamespace(namespace string) (string, error) {
+	cleaned := strings.Trim(namespace, "/")
+	if cleaned == "" {
+		return "", errors.New(fmt.Sprintf("Invalid namespace %q, expected e.g. team/project", namespace))
+	}
+	for _, component := range strings.Split(cleaned, "/") {
+		if component == "" {
+			return "", errors.New(fmt.Sprintf("Invalid namespace %q, it has an empty component", namespace))
+		}
+	}
+	return cleaned, nil
+}
+
+// Namespace returns the first depth components of the namespace of image, team/project of team/project/service
+// for depth 2. Images with fewer components have the namespace of all but their last one, empty for service
+func Namespace(image string, depth int) string {
+	components := strings.Split(image, "/")
+	if depth > len(components)-1 {
+		depth = len(components) - 1
+	}
+	if depth <= 0 {
+		return ""
+	}
+	return strings.Join(components[:depth], "/")
+}
@@ -0,0 +1,130 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestImagePath(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"service", "service"},
+		{"team/project/service", "team/project/service"},
+		{"team/100%/service", "team/100%25/service"},
+		{"team/c++/service", "team/c++/service"},
+		{"team/my project/service", "team/my%20project/service"},
+		{"team/a?b/c#d", "team/a%3Fb/c%23d"},
+	}
+	for _, test := range tests {
+		if got := imagePath(test.image); got != test.want {
+			t.Errorf("imagePath(%q) = %q, want %q", test.image, got, test.want)
+		}
+	}
+}
+
+func TestCleanNamespace(t *testing.T) {
+	tests := []struct {
+		namespace string
+		want      string
+		fails     bool
+	}{
+		{"team/project", "team/project", false},
+		{"/team/project/", "team/project", false},
+		{"team", "team", false},
+		{"team//project", "", true},
+		{"/", "", true},
+		{"", "", true},
+	}
+	for _, test := range tests {
+		got, err := CleanNamespace(test.namespace)
+		if test.fails {
+			if err == nil {
+				t.Errorf("CleanNamespace(%q) = %q, want an error", test.namespace, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("CleanNamespace(%q) = %q, %v, want %q", test.namespace, got, err, test.want)
+		}
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	tests := []struct {
+		image string
+		depth int
+		want  string
+	}{
+		{"team/project/service", 1, "team"},
+		{"team/project/service", 2, "team/project"},
+		{"team/project/service", 3, "team/project"},
+		{"team/service", 2, "team"},
+		{"service", 1, ""},
+		{"team/project/service", 0, ""},
+	}
+	for _, test := range tests {
+		if got := Namespace(test.image, test.depth); got != test.want {
+			t.Errorf("Namespace(%q, %d) = %q, want %q", test.image, test.depth, got, test.want)
+		}
+	}
+}
+
+// TestTagOperationURLs checks the requests of the tag operations keep the slashes of nested names and escape their
+// components
+func TestTagOperationURLs(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.Method+" "+req.RequestURI)
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		switch req.Method {
+		case "DELETE":
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.Write([]byte(`{"tags": []}`))
+		}
+	}))
+	defer srv.Close()
+	r := New(srv.URL, WithRepository("docker-hosted"))
+
+	tests := []struct {
+		image string
+		path  string
+	}{
+		{"team/project/service", "/repository/docker-hosted/v2/team/project/service"},
+		{"team/100%/service", "/repository/docker-hosted/v2/team/100%25/service"},
+		{"team/c++/service", "/repository/docker-hosted/v2/team/c++/service"},
+		{"team/my project/service", "/repository/docker-hosted/v2/team/my%20project/service"},
+	}
+	for _, test := range tests {
+		requests = nil
+		if _, err := r.ListTagsByImage(test.image); err != nil {
+			t.Fatalf("ListTagsByImage(%q): %s", test.image, err)
+		}
+		if _, err := r.ImageDigest(test.image, "1.0"); err != nil {
+			t.Fatalf("ImageDigest(%q): %s", test.image, err)
+		}
+		if _, _, _, err := r.RawManifest(test.image, "1.0"); err != nil {
+			t.Fatalf("RawManifest(%q): %s", test.image, err)
+		}
+		if err := r.DeleteManifest(test.image, "sha256:abc"); err != nil {
+			t.Fatalf("DeleteManifest(%q): %s", test.image, err)
+		}
+		want := []string{
+			"GET " + test.path + "/tags/list",
+			"HEAD " + test.path + "/manifests/1.0",
+			"GET " + test.path + "/manifests/1.0",
+			"DELETE " + test.path + "/manifests/sha256:abc",
+		}
+		if len(requests) != len(want) {
+			t.Fatalf("%q: requests %q, want %q", test.image, requests, want)
+		}
+		for i := range want {
+			if requests[i] != want[i] {
+				t.Errorf("%q: request %q, want %q", test.image, requests[i], want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// nugetPublishType is the resource of the NuGet v3 service index packages are pushed to and deleted from
+const nugetPublishType = "PackagePublish/2.0.0"
+
+// nugetPublishURL finds the package publish resource of the repository in its v3 service index. Repositories not
+// serving the v3 protocol publish at their root, the v2 protocol uses the same requests there
+func (r Registry) nugetPublishURL() (string, error) {
+	root := fmt.Sprintf("%s/repository/%s/", r.Host, r.Repository)
+	resp, err := r.do("GET", root+"index.json", "application/json", "", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return root, nil
+	}
+	if resp.StatusCode != 200 {
+		return "", r.newError(resp)
+	}
+
+	var index struct {
+		Resources []struct {
+			ID   string `json:"@id"`
+			Type string `json:"@type"`
+		} `json:"resources"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return "", err
+	}
+	for _, resource := range index.Resources {
+		if resource.Type == nugetPublishType {
+			return strings.TrimRight(resource.ID, "/") + "/", nil
+		}
+	}
+	return root, nil
+}
+
+// PushNuGetPackage pushes a .nupkg with the NuGet protocol, authenticated by the API key of the profile. Without
+// an API key the package is uploaded through the components API instead
+func (r Registry) PushNuGetPackage(path string) error {
+	if r.NuGetAPIKey == "" {
+		return r.UploadComponent("nuget", path, nil)
+	}
+	publishURL, err := r.nugetPublishURL()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeUploadForm(form, "package", filepath.Base(path), f, nil))
+	}()
+
+	req, err := http.NewRequest("PUT", publishURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("X-NuGet-ApiKey", r.NuGetAPIKey)
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 201 && resp.StatusCode != 202 && resp.StatusCode != 200 {
+		return r.nugetError(resp)
+	}
+	return nil
+}
+
+// nugetError is newError with a hint about the API key, the password plays no part in NuGet requests
+func (r Registry) nugetError(resp *http.Response) error {
+	err := r.newError(resp)
+	if e, ok := err.(*Error); ok && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		e.Hint = "Check the NuGet API key (nuget_api_key of the profile or --api-key) and that the NuGet API-Key realm is active in Nexus"
+	}
+	return err
+}
+
+// DeleteNuGetPackage deletes a package version with the NuGet protocol. Requires the API key of the profile
+func (r Registry) DeleteNuGetPackage(id string, version string) error {
+	publishURL, err := r.nugetPublishURL()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("DELETE", publishURL+url.PathEscape(id)+"/"+url.PathEscape(version), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-NuGet-ApiKey", r.NuGetAPIKey)
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 204 && resp.StatusCode != 200 {
+		return r.nugetError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AnnotationRefName names the manifests of an OCI image layout, usually after their tag
+const AnnotationRefName = "org.opencontainers.image.ref.name"
+
+const ociLayoutVersion = `{"imageLayoutVersion":"1.0.0"}`
+
+// layoutIndex is the index.json of an OCI image layout
+type layoutIndex struct {
+	SchemaVersion int64          `json:"schemaVersion"`
+	MediaType     string         `json:"mediaType,omitempty"`
+	Manifests     []ManifestInfo `json:"manifests"`
+}
+
+// ExportOCILayout writes a tag to an OCI image layout directory (https://github.com/opencontainers/image-spec/blob/main/image-layout.md)
+// as used by oras, skopeo and buildkit. Indexes are exported with all platforms. The directory is created if needed,
+// an existing layout is added to, replacing a manifest of the same ref name. Returns the digest of the manifest
+func (r Registry) ExportOCILayout(dir string, image string, tag string, refName string) (string, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs"), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "oci-layout"), []byte(ociLayoutVersion), 0644); err != nil {
+		return "", err
+	}
+
+	body, mediaType, digest, err := r.exportManifest(dir, image, tag)
+	if err != nil {
+		return "", err
+	}
+
+	index, err := readLayoutIndex(dir)
+	if err != nil {
+		return "", err
+	}
+	var manifests []ManifestInfo
+	for _, m := range index.Manifests {
+		if m.Annotations[AnnotationRefName] != refName {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = append(manifests, ManifestInfo{
+		MediaType:   mediaType,
+		Digest:      digest,
+		Size:        int64(len(body)),
+		Annotations: map[string]string{AnnotationRefName: refName},
+	})
+	indexBody, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return digest, ioutil.WriteFile(filepath.Join(dir, "index.json"), indexBody, 0644)
+}
+
+// exportManifest writes a manifest, and everything it references, as blobs of the layout
+func (r Registry) exportManifest(dir string, image string, reference string) ([]byte, string, string, error) {
+	body, mediaType, digest, err := r.RawManifest(image, reference)
+	if err != nil {
+		return nil, "", "", err
+	}
+	var manifest ImageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, "", "", err
+	}
+	if manifest.MediaType != "" {
+		mediaType = manifest.MediaType
+	}
+	if digest == "" {
+		digest = digestOf(body)
+	}
+
+	if manifest.IsIndex() {
+		for _, m := range manifest.Manifests {
+			if _, _, _, err := r.exportManifest(dir, image, m.Digest); err != nil {
+				return nil, "", "", err
+			}
+		}
+	} else {
+		for _, blob := range append([]LayerInfo{manifest.Config}, manifest.Layers...) {
+			if blob.Digest == "" {
+				continue
+			}
+			if err := r.exportBlob(dir, image, blob.Digest); err != nil {
+				return nil, "", "", err
+			}
+		}
+	}
+
+	path, err := layoutBlobPath(dir, digest)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, "", "", err
+	}
+	return body, mediaType, digest, ioutil.WriteFile(path, body, 0644)
+}
+
+// exportBlob downloads a blob into the layout unless it is there already, verifying its digest
+func (r Registry) exportBlob(dir string, image string, digest string) error {
+	path, err := layoutBlobPath(dir, digest)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	content, _, err := r.GetBlob(image, digest)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != digest {
+		return errors.New(fmt.Sprintf("blob %s has the digest %s after download", digest, actual))
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ImportOCILayout pushes a manifest of an OCI image layout directory as image:tag. refName chooses the manifest by
+// its ref name annotation, it may be empty if the layout holds only one. Without tag the ref name is used as tag.
+// With denyOverwrite an existing tag pointing to another digest is not replaced. Blobs already in the registry are
+// skipped. Returns the digest and tag of the pushed manifest
+func (r Registry) ImportOCILayout(dir string, refName string, image string, tag string, denyOverwrite bool) (string, string, error) {
+	index, err := readLayoutIndex(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if len(index.Manifests) == 0 {
+		return "", "", errors.New(fmt.Sprintf("%s is not an OCI layout or holds no manifests", dir))
+	}
+
+	var chosen *ManifestInfo
+	var names []string
+	for i, m := range index.Manifests {
+		name := m.Annotations[AnnotationRefName]
+		names = append(names, name)
+		if refName == "" && len(index.Manifests) == 1 || refName != "" && name == refName {
+			chosen = &index.Manifests[i]
+		}
+	}
+	if chosen == nil {
+		if refName == "" {
+			return "", "", errors.New(fmt.Sprintf("%s holds several manifests, choose one of: %s", dir, strings.Join(names, ", ")))
+		}
+		return "", "", errors.New(fmt.Sprintf("%s has no manifest named %s, available: %s", dir, refName, strings.Join(names, ", ")))
+	}
+
+	if tag == "" {
+		if tag = chosen.Annotations[AnnotationRefName]; tag == "" {
+			return "", "", errors.New(fmt.Sprintf("the manifest in %s has no ref name, give the tag to push it as", dir))
+		}
+	}
+	if denyOverwrite {
+		if err := r.EnsureNotOverwritten(image, tag, chosen.Digest); err != nil {
+			return "", "", err
+		}
+	}
+	return chosen.Digest, tag, r.importManifest(dir, chosen.Digest, chosen.MediaType, image, tag)
+}
+
+func (r Registry) importManifest(dir string, digest string, mediaType string, image string, reference string) error {
+	path, err := layoutBlobPath(dir, digest)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var manifest ImageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return errors.New(fmt.Sprintf("invalid manifest %s: %s", digest, err))
+	}
+	if manifest.MediaType != "" {
+		mediaType = manifest.MediaType
+	}
+
+	if manifest.IsIndex() {
+		for _, m := range manifest.Manifests {
+			if err := r.importManifest(dir, m.Digest, m.MediaType, image, m.Digest); err != nil {
+				return err
+			}
+		}
+	} else {
+		for _, blob := range append([]LayerInfo{manifest.Config}, manifest.Layers...) {
+			if blob.Digest == "" {
+				continue
+			}
+			if err := r.importBlob(dir, image, blob.Digest); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, _, err = r.PushManifest(image, reference, mediaType, body)
+	return err
+}
+
+func (r Registry) importBlob(dir string, image string, digest string) error {
+	exists, err := r.BlobExists(image, digest)
+	if err != nil || exists {
+		return err
+	}
+	path, err := layoutBlobPath(dir, digest)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return r.UploadBlob(image, digest, info.Size(), f)
+}
+
+func readLayoutIndex(dir string) (layoutIndex, error) {
+	index := layoutIndex{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}
+	body, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if os.IsNotExist(err) {
+		return index, nil
+	} else if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(body, &index); err != nil {
+		return index, errors.New(fmt.Sprintf("invalid index.json in %s: %s", dir, err))
+	}
+	return index, nil
+}
+
+// layoutBlobPath returns the file of a blob, blobs/<algorithm>/<hex>
+func layoutBlobPath(dir string, digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.ContainsAny(digest, "/\\.") {
+		return "", errors.New(fmt.Sprintf("invalid digest %q", digest))
+	}
+	return filepath.Join(dir, "blobs", parts[0], parts[1]), nil
+}
+
+func digestOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,85 @@
+// Package registry is the client of the Nexus docker registry and REST APIs. Programs embedding it create a
+// Registry with New and options, nexus-cli itself loads one from a profile of ~/.nexus-cli:
+//
+//	r := registry.New("https://nexus.example.com",
+//		registry.WithBasicAuth("ci", os.Getenv("NEXUS_PASSWORD")),
+//		registry.WithRepository("docker-hosted"),
+//		registry.WithTimeout(30*time.Second))
+//	images, err := r.ListImages()
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/tracing"
+)
+
+// Option configures a Registry created with New
+type Option func(*Registry)
+
+// New creates a client for the Nexus at host, without reading the configuration file. Requests are anonymous
+// without WithBasicAuth, the docker registry calls need WithRepository
+func New(host string, options ...Option) Registry {
+	r := Registry{Host: strings.TrimRight(host, "/")}
+	for _, option := range options {
+		option(&r)
+	}
+	return r
+}
+
+// WithBasicAuth authenticates requests with a Nexus user
+func WithBasicAuth(username string, password string) Option {
+	return func(r *Registry) {
+		r.Username, r.Password = username, password
+	}
+}
+
+// WithRepository selects the repository the docker registry calls go to
+func WithRepository(repository string) Option {
+	return func(r *Registry) {
+		r.Repository = repository
+	}
+}
+
+// WithHTTPClient sends the requests with the given client, e.g. for custom TLS settings or proxies
+func WithHTTPClient(client *http.Client) Option {
+	return func(r *Registry) {
+		r.client = client
+	}
+}
+
+// WithTimeout limits the time a request may take, including reading the answer. The client given with
+// WithHTTPClient is copied, not changed
+func WithTimeout(timeout time.Duration) Option {
+	return func(r *Registry) {
+		client := http.Client{}
+		if r.client != nil {
+			client = *r.client
+		}
+		client.Timeout = timeout
+		r.client = &client
+	}
+}
+
+// httpClient returns the client requests are sent with. Clients without a transport of their own, like those of
+// WithTimeout, use the transport shared by all registries speaking the same protocol
+func (r Registry) httpClient() *http.Client {
+	shared := sharedClient(r.Protocol())
+	client := r.client
+	if client == nil {
+		client = shared
+	} else if client.Transport == nil {
+		copied := *client
+		copied.Transport = shared.Transport
+		client = &copied
+	}
+	if tracing.Enabled() {
+		client = traced(client)
+	}
+	if verbose {
+		return logged(client)
+	}
+	return client
+}
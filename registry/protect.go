@@ -0,0 +1,245 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProtectionImage is the image holding the tags locked with 'image lock', cleanups have to leave it alone
+const ProtectionImage = "nexus-cli-protected"
+
+// ProtectionArtifactType marks the manifest listing the locked tags
+const ProtectionArtifactType = "application/vnd.nexus-cli.protection.v1"
+
+const (
+	protectionTag         = "tags"
+	annotationProtections = "io.github.nexus-cli.protections"
+)
+
+// Protection is a tag locked against deletion
+type Protection struct {
+	Image   string    `json:"image"`
+	Tag     string    `json:"tag"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	// Cluster is set for the tags 'k8s scan' found deployed in the cluster, the next scan of it replaces them
+	Cluster string `json:"cluster,omitempty"`
+	// Declared is the deployment manifest declaring the tag, for tags kept with TagDeleter.Keep. They are not stored
+	Declared string `json:"-"`
+	// Ignored is the rules file excluding the tag, see WithIgnoreRules. They are not stored either
+	Ignored string `json:"-"`
+}
+
+// ProtectedError is returned when deleting a tag would delete a locked tag, the tag itself or one pointing to the
+// same manifest
+type ProtectedError struct {
+	Protection Protection
+	// Along is the tag being deleted if the locked tag would only be deleted along with it, or the digest of the
+	// manifest being deleted
+	Along string
+}
+
+func (e *ProtectedError) Error() string {
+	p := e.Protection
+	state := "locked"
+	if p.Ignored != "" {
+		state = "excluded by " + p.Ignored
+	}
+	msg := fmt.Sprintf("%s:%s is %s", p.Image, p.Tag, state)
+	switch {
+	case strings.Contains(e.Along, ":"):
+		msg = fmt.Sprintf("%s@%s is the manifest of %s:%s, which is %s", p.Image, e.Along, p.Image, p.Tag, state)
+	case e.Along != "":
+		msg = fmt.Sprintf("%s:%s shares its manifest with %s:%s, which is %s", p.Image, e.Along, p.Image, p.Tag, state)
+	}
+	if p.Reason != "" {
+		msg += " (" + p.Reason + ")"
+	}
+	if p.Declared != "" {
+		return msg + fmt.Sprintf("\nIt is declared in %s, deleting it would break deploying it", p.Declared)
+	}
+	if p.Ignored != "" {
+		return msg + fmt.Sprintf("\nChange the rules of %s to allow deleting it", p.Ignored)
+	}
+	if p.Cluster != "" {
+		return msg + fmt.Sprintf("\nIt is deployed in cluster %s, the next 'nexus-cli k8s scan' of it releases it once it is not anymore", p.Cluster)
+	}
+	return msg + fmt.Sprintf("\nRun 'nexus-cli image unlock -n %s -t %s' to allow deleting it", p.Image, p.Tag)
+}
+
+// IsProtected tells if deleting failed because of a locked tag, or because the pre-delete hook vetoed it
+func IsProtected(err error) bool {
+	switch err.(type) {
+	case *ProtectedError, *VetoedError:
+		return true
+	}
+	return false
+}
+
+// Protections lists the locked tags of the repository, sorted by image and tag
+func (r Registry) Protections() ([]Protection, error) {
+	protections, _, err := r.readProtections()
+	return protections, err
+}
+
+// Protect locks tags of image against deletion. Locking a tag again replaces its reason. The list is read, changed
+// and written back, two processes changing it at the very same time may lose one of the changes
+func (r Registry) Protect(image string, tags []string, reason string) error {
+	protections, digest, err := r.readProtections()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, tag := range tags {
+		protections = removeProtection(protections, image, tag)
+		protections = append(protections, Protection{Image: image, Tag: tag, Reason: reason, Created: now})
+	}
+	return r.writeProtections(protections, digest)
+}
+
+// Unprotect removes the locks of tags of image. Tags which are not locked are ignored
+func (r Registry) Unprotect(image string, tags []string) error {
+	protections, digest, err := r.readProtections()
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		protections = removeProtection(protections, image, tag)
+	}
+	return r.writeProtections(protections, digest)
+}
+
+// ProtectInUse replaces the locks of the tags in use in cluster by those of inUse, releasing the tags which are not
+// deployed there anymore. Locks of other clusters and those of 'image lock' stay. Returns how many locks were added
+// and released
+func (r Registry) ProtectInUse(cluster string, inUse []Protection) (int, int, error) {
+	protections, digest, err := r.readProtections()
+	if err != nil {
+		return 0, 0, err
+	}
+	former := map[string]Protection{}
+	var kept []Protection
+	for _, p := range protections {
+		if p.Cluster == cluster {
+			former[p.Image+":"+p.Tag] = p
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	added := 0
+	now := time.Now().UTC()
+	for _, p := range inUse {
+		p.Cluster = cluster
+		// tags still in use keep the time they were found in use first
+		if f, ok := former[p.Image+":"+p.Tag]; ok {
+			p.Created = f.Created
+			delete(former, p.Image+":"+p.Tag)
+		} else {
+			p.Created = now
+			added++
+		}
+		kept = append(kept, p)
+	}
+	return added, len(former), r.writeProtections(kept, digest)
+}
+
+func removeProtection(protections []Protection, image string, tag string) []Protection {
+	var kept []Protection
+	for _, p := range protections {
+		if p.Image != image || p.Tag != tag {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// readProtections returns the locked tags and the digest of the manifest listing them, empty if nothing was locked yet
+func (r Registry) readProtections() ([]Protection, string, error) {
+	var protections []Protection
+	digest, err := r.readList(ProtectionImage, protectionTag, ProtectionArtifactType, annotationProtections, "locked tags", &protections)
+	return protections, digest, err
+}
+
+// writeProtections pushes the list as annotation of an artifact and removes the manifest of the former list
+func (r Registry) writeProtections(protections []Protection, previous string) error {
+	sort.Slice(protections, func(i, j int) bool {
+		if protections[i].Image != protections[j].Image {
+			return protections[i].Image < protections[j].Image
+		}
+		return protections[i].Tag < protections[j].Tag
+	})
+	if protections == nil {
+		protections = []Protection{}
+	}
+	return r.writeList(ProtectionImage, protectionTag, ProtectionArtifactType, annotationProtections, protections, previous)
+}
+
+// readList decodes a list nexus-cli keeps in the registry, as annotation of the artifact image:tag, into list.
+// Returns the digest of the artifact, empty if there is none yet. what names the list in errors
+func (r Registry) readList(image string, tag string, artifactType string, annotation string, what string, list interface{}) (string, error) {
+	body, _, digest, err := r.RawManifest(image, tag)
+	if IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	var manifest ImageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return "", err
+	}
+	if manifest.ArtifactType != artifactType {
+		return "", errors.New(fmt.Sprintf("%s:%s is not the list of %s of nexus-cli", image, tag, what))
+	}
+	if err := json.Unmarshal([]byte(manifest.Annotations[annotation]), list); err != nil {
+		return "", errors.New(fmt.Sprintf("The list of %s in %s:%s is broken: %s", what, image, tag, err))
+	}
+	return digest, nil
+}
+
+// writeList pushes list as annotation of the artifact image:tag and removes the manifest of the former list
+func (r Registry) writeList(image string, tag string, artifactType string, annotation string, list interface{}, previous string) error {
+	content, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	emptyBlob := []byte("{}")
+	sum := sha256.Sum256(emptyBlob)
+	empty := LayerInfo{MediaType: "application/vnd.oci.empty.v1+json", Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(emptyBlob))}
+	exists, err := r.BlobExists(image, empty.Digest)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := r.UploadBlob(image, empty.Digest, empty.Size, bytes.NewReader(emptyBlob)); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := json.Marshal(ImageManifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		ArtifactType:  artifactType,
+		Config:        empty,
+		Layers:        []LayerInfo{empty},
+		Annotations:   map[string]string{annotation: string(content)},
+	})
+	if err != nil {
+		return err
+	}
+	digest, err := r.PutManifest(image, tag, MediaTypeOCIManifest, manifest)
+	if err != nil {
+		return err
+	}
+	if previous != "" && previous != digest {
+		return r.DeleteManifest(image, previous)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Annotations and labels of the OCI image spec describing where an image comes from
+const (
+	ociPrefix            = "org.opencontainers.image."
+	AnnotationCreated    = ociPrefix + "created"
+	AnnotationSource     = ociPrefix + "source"
+	AnnotationRevision   = ociPrefix + "revision"
+	AnnotationBaseName   = ociPrefix + "base.name"
+	AnnotationBaseDigest = ociPrefix + "base.digest"
+)
+
+// Provenance is what an image tells about how and from what it was built: the config, the OCI labels and
+// annotations and the artifacts attached to it
+type Provenance struct {
+	Image    string    `json:"image"`
+	Tag      string    `json:"tag"`
+	Digest   string    `json:"digest"`
+	Platform string    `json:"platform,omitempty"`
+	Created  time.Time `json:"created"`
+	Author   string    `json:"author,omitempty"`
+	// Source and Revision are the repository and the commit the image was built from
+	Source   string `json:"source,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	// BaseName and BaseDigest are the base image as the build recorded it
+	BaseName   string `json:"base_name,omitempty"`
+	BaseDigest string `json:"base_digest,omitempty"`
+	// OCI holds the org.opencontainers.image.* labels of the config and annotations of the manifest, the
+	// annotations win
+	OCI          map[string]string `json:"oci,omitempty"`
+	Layers       []ProvenanceLayer `json:"layers"`
+	Attestations []Attestation     `json:"attestations,omitempty"`
+}
+
+// ProvenanceLayer is a layer with the build step which created it
+type ProvenanceLayer struct {
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	CreatedBy string `json:"created_by,omitempty"`
+	// Base is set for the leading layers shared with the base given to ImageProvenance
+	Base bool `json:"base,omitempty"`
+}
+
+// Attestation is an artifact attached to the image: a signature, an SBOM, a SLSA provenance ...
+type Attestation struct {
+	ArtifactType string            `json:"artifact_type"`
+	Digest       string            `json:"digest"`
+	Tag          string            `json:"tag,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// ImageProvenance collects the provenance of a tag. For indexes the manifest of platform is described, see
+// PlatformManifest, the attestations are those attached to the tag. With a base, the leading layers the image shares
+// with that image:tag of the repository are marked
+func (r Registry) ImageProvenance(image string, tag string, platform string, base *BaseRef) (Provenance, error) {
+	p := Provenance{Image: image, Tag: tag, OCI: map[string]string{}}
+	digest, err := r.getImageSHA(image, tag)
+	if err != nil {
+		return p, err
+	}
+	p.Digest = digest
+	tagManifest, err := r.ImageManifest(image, tag)
+	if err != nil {
+		return p, err
+	}
+	manifest := tagManifest
+	if tagManifest.IsIndex() {
+		if manifest, err = r.PlatformManifest(image, tag, platform); err != nil {
+			return p, err
+		}
+	}
+
+	var config ImageConfig
+	if manifest.Config.Digest != "" {
+		blob, _, err := r.GetBlob(image, manifest.Config.Digest)
+		if err != nil {
+			return p, err
+		}
+		err = json.NewDecoder(blob).Decode(&config)
+		blob.Close()
+		if err != nil {
+			return p, errors.New(fmt.Sprintf("invalid image config of %s:%s: %s", image, tag, err))
+		}
+	}
+	p.Created, p.Author = config.Created, config.Author
+	if config.OS != "" {
+		p.Platform = config.OS + "/" + config.Architecture
+	}
+	for _, labels := range []map[string]string{config.Config.Labels, tagManifest.Annotations, manifest.Annotations} {
+		for key, value := range labels {
+			if strings.HasPrefix(key, ociPrefix) {
+				p.OCI[key] = value
+			}
+		}
+	}
+	if created, err := time.Parse(time.RFC3339, p.OCI[AnnotationCreated]); err == nil && p.Created.IsZero() {
+		p.Created = created
+	}
+	p.Source, p.Revision = p.OCI[AnnotationSource], p.OCI[AnnotationRevision]
+	// label-schema.org, the predecessor of the OCI labels, is still set by many builds
+	if p.Source == "" {
+		p.Source = config.Config.Labels["org.label-schema.vcs-url"]
+	}
+	if p.Revision == "" {
+		p.Revision = config.Config.Labels["org.label-schema.vcs-ref"]
+	}
+	p.BaseName, p.BaseDigest = p.OCI[AnnotationBaseName], p.OCI[AnnotationBaseDigest]
+
+	var steps []string
+	for _, h := range config.History {
+		if !h.EmptyLayer {
+			steps = append(steps, h.CreatedBy)
+		}
+	}
+	for i, layer := range manifest.Layers {
+		l := ProvenanceLayer{Digest: layer.Digest, Size: layer.Size}
+		if len(steps) == len(manifest.Layers) {
+			l.CreatedBy = steps[i]
+		}
+		p.Layers = append(p.Layers, l)
+	}
+	if base != nil {
+		baseManifest, err := r.PlatformManifest(base.Image, base.Tag, p.Platform)
+		if err != nil {
+			return p, err
+		}
+		for i, layer := range baseManifest.Layers {
+			if i >= len(p.Layers) || p.Layers[i].Digest != layer.Digest {
+				break
+			}
+			p.Layers[i].Base = true
+		}
+	}
+
+	referrers, err := r.Referrers(image, digest)
+	if err != nil {
+		return p, err
+	}
+	for _, referrer := range referrers {
+		p.Attestations = append(p.Attestations, Attestation{ArtifactType: referrer.ArtifactType, Digest: referrer.Digest, Tag: referrer.Tag, Annotations: referrer.Annotations})
+	}
+	return p, nil
+}
@@ -0,0 +1,184 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// QuarantineImage is the image of a quarantine repository listing the tags quarantined there, cleanups of the
+// quarantine have to leave it alone
+const QuarantineImage = "nexus-cli-quarantine"
+
+// QuarantineArtifactType marks the manifest listing the quarantined tags
+const QuarantineArtifactType = "application/vnd.nexus-cli.quarantine.v1"
+
+const (
+	quarantineTag         = "tags"
+	annotationQuarantined = "io.github.nexus-cli.quarantined"
+)
+
+// Quarantined is a tag deleted in soft-delete mode: before it was deleted from Repository, it was copied to the
+// quarantine repository as Repository/Image:Tag, see QuarantineImage
+type Quarantined struct {
+	Repository  string    `json:"repository"`
+	Image       string    `json:"image"`
+	Tag         string    `json:"tag"`
+	Digest      string    `json:"digest"`
+	Quarantined time.Time `json:"quarantined"`
+}
+
+// Name is the image the tag has in the quarantine repository, prefixed with the repository it came from so images
+// of several repositories can share the quarantine
+func (q Quarantined) Name() string {
+	return q.Repository + "/" + q.Image
+}
+
+// Quarantine returns the registry of the quarantine repository name on the same host, for soft-deleting the tags of
+// r. Tags are copied there before being deleted, see TagDeleter.Quarantine
+func (r Registry) Quarantine(name string) (Registry, error) {
+	if name == r.Repository {
+		return r, errors.New(fmt.Sprintf("%s can't be the quarantine of itself", name))
+	}
+	q := r
+	q.Repository = name
+	// what is quarantined before a delete is no outcome of the bulk operation
+	q.runStats = nil
+	return q, nil
+}
+
+// QuarantinedTags lists the tags in the quarantine repository q, sorted by repository, image and tag
+func (q Registry) QuarantinedTags() ([]Quarantined, error) {
+	quarantined, _, err := q.readQuarantined()
+	return quarantined, err
+}
+
+// QuarantineTags copies tags of image, all pointing to the manifest digest, from src into the quarantine q, blobs q
+// already has are not copied again. The artifacts attached to the manifest are copied too. A tag quarantined again
+// replaces the former copy
+func (q Registry) QuarantineTags(src Registry, image string, digest string, tags []string) error {
+	for _, tag := range tags {
+		e := Quarantined{Repository: src.Repository, Image: image, Tag: tag}
+		if _, err := CopyImage(src, image, tag, q, e.Name(), tag, true); err != nil {
+			return errors.New(fmt.Sprintf("%s:%s could not be quarantined in %s, it was not deleted: %s", image, tag, q.Repository, err))
+		}
+	}
+	quarantined, previous, err := q.readQuarantined()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	var replaced []Quarantined
+	for _, tag := range tags {
+		e := Quarantined{Repository: src.Repository, Image: image, Tag: tag, Digest: digest, Quarantined: now}
+		var former []Quarantined
+		quarantined, former = removeQuarantined(quarantined, e)
+		quarantined = append(quarantined, e)
+		replaced = append(replaced, former...)
+	}
+	if err := q.writeQuarantined(quarantined, previous); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		fmt.Printf("%s:%s has been quarantined in %s\n", image, tag, q.Repository)
+	}
+	return q.deleteUnused(quarantined, replaced)
+}
+
+// Restore copies a quarantined tag back to the repository dst, which has to be the one it came from, and removes it
+// from the quarantine. A tag which exists again with another manifest is not overwritten
+func (q Registry) Restore(dst Registry, e Quarantined) error {
+	if dst.Repository != e.Repository {
+		return errors.New(fmt.Sprintf("%s:%s was quarantined from %s, not %s", e.Image, e.Tag, e.Repository, dst.Repository))
+	}
+	digest, err := dst.getImageSHA(e.Image, e.Tag)
+	if err == nil && digest != e.Digest {
+		return errors.New(fmt.Sprintf("%s:%s exists again as %s, delete it before restoring %s", e.Image, e.Tag, digest, e.Digest))
+	} else if err != nil && !IsNotFound(err) {
+		return err
+	}
+	if _, err := CopyImage(q, e.Name(), e.Tag, dst, e.Image, e.Tag, true); err != nil {
+		return err
+	}
+	return q.remove(e)
+}
+
+// Purge deletes a tag from the quarantine for good
+func (q Registry) Purge(e Quarantined) error {
+	return q.remove(e)
+}
+
+// remove drops a tag from the list of the quarantine, and its copy once no other quarantined tag points to it
+func (q Registry) remove(e Quarantined) error {
+	quarantined, previous, err := q.readQuarantined()
+	if err != nil {
+		return err
+	}
+	quarantined, removed := removeQuarantined(quarantined, e)
+	if len(removed) == 0 {
+		return errors.New(fmt.Sprintf("%s:%s of %s is not in the quarantine %s", e.Image, e.Tag, e.Repository, q.Repository))
+	}
+	if err := q.writeQuarantined(quarantined, previous); err != nil {
+		return err
+	}
+	return q.deleteUnused(quarantined, removed)
+}
+
+// deleteUnused deletes the copies of the removed tags no tag left in the quarantine points to. Tags sharing the
+// manifest share the copy, it is deleted with the last of them
+func (q Registry) deleteUnused(quarantined []Quarantined, removed []Quarantined) error {
+	used := map[string]bool{}
+	for _, e := range quarantined {
+		used[e.Name()+"@"+e.Digest] = true
+	}
+	for _, e := range removed {
+		if used[e.Name()+"@"+e.Digest] {
+			continue
+		}
+		used[e.Name()+"@"+e.Digest] = true
+		if err := q.deleteReferrersOf(e.Name(), e.Digest); err != nil {
+			return err
+		}
+		if err := q.DeleteManifest(e.Name(), e.Digest); err != nil && !IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeQuarantined removes the entries of the tag of e from the list, returning the list left and those removed
+func removeQuarantined(quarantined []Quarantined, e Quarantined) ([]Quarantined, []Quarantined) {
+	var kept, removed []Quarantined
+	for _, other := range quarantined {
+		if other.Repository == e.Repository && other.Image == e.Image && other.Tag == e.Tag {
+			removed = append(removed, other)
+		} else {
+			kept = append(kept, other)
+		}
+	}
+	return kept, removed
+}
+
+func (q Registry) readQuarantined() ([]Quarantined, string, error) {
+	var quarantined []Quarantined
+	digest, err := q.readList(QuarantineImage, quarantineTag, QuarantineArtifactType, annotationQuarantined, "quarantined tags", &quarantined)
+	return quarantined, digest, err
+}
+
+func (q Registry) writeQuarantined(quarantined []Quarantined, previous string) error {
+	sort.Slice(quarantined, func(i, j int) bool {
+		a, b := quarantined[i], quarantined[j]
+		if a.Repository != b.Repository {
+			return a.Repository < b.Repository
+		}
+		if a.Image != b.Image {
+			return a.Image < b.Image
+		}
+		return a.Tag < b.Tag
+	})
+	if quarantined == nil {
+		quarantined = []Quarantined{}
+	}
+	return q.writeList(QuarantineImage, quarantineTag, QuarantineArtifactType, annotationQuarantined, quarantined, previous)
+}
@@ -0,0 +1,120 @@
+package registry
+
+import (
+	"encoding/json"
+)
+
+// ReclaimEstimate describes how much storage deleting a set of tags would free once the blobstore is compacted
+type ReclaimEstimate struct {
+	// Manifests is the number of manifests that would be deleted (several tags can point to the same manifest)
+	Manifests int
+	// Blobs and Bytes count the blobs which would no longer be referenced by any manifest in the repository
+	Blobs int
+	Bytes int64
+	// SharedBlobs and SharedBytes count the blobs of deleted manifests which are still used by remaining images
+	SharedBlobs int
+	SharedBytes int64
+}
+
+// EstimateReclaimable computes which blobs would become unreferenced if the given tags of image were deleted.
+// Since deleting a manifest removes every tag pointing to it, and layers are shared between images, all manifests
+// of the repository are inspected to find what remains referenced afterwards
+func (r Registry) EstimateReclaimable(image string, tags []string) (ReclaimEstimate, error) {
+	var estimate ReclaimEstimate
+
+	deleted := make(map[string]bool)
+	deletedBlobs := make(map[string]int64)
+	for _, tag := range tags {
+		digest, blobs, err := r.manifestBlobs(image, tag)
+		if err != nil {
+			return estimate, err
+		}
+		deleted[digest] = true
+		for blob, size := range blobs {
+			deletedBlobs[blob] = size
+		}
+	}
+	estimate.Manifests = len(deleted)
+
+	remaining := make(map[string]bool)
+	images, err := r.ListImages()
+	if err != nil {
+		return estimate, err
+	}
+	for _, other := range images {
+		otherTags, err := r.ListTagsByImage(other)
+		if err != nil {
+			return estimate, err
+		}
+		seen := make(map[string]bool)
+		for _, tag := range otherTags {
+			digest, blobs, err := r.manifestBlobs(other, tag)
+			if err != nil {
+				return estimate, err
+			}
+			if seen[digest] || (other == image && deleted[digest]) {
+				continue
+			}
+			seen[digest] = true
+			for blob := range blobs {
+				remaining[blob] = true
+			}
+		}
+	}
+
+	for blob, size := range deletedBlobs {
+		if remaining[blob] {
+			estimate.SharedBlobs++
+			estimate.SharedBytes += size
+		} else {
+			estimate.Blobs++
+			estimate.Bytes += size
+		}
+	}
+	return estimate, nil
+}
+
+// manifestBlobs resolves a tag or digest and returns the manifest digest together with all blobs it references
+// (for indexes: the blobs of all platform manifests) and their sizes. Manifests themselves count as blobs as well
+func (r Registry) manifestBlobs(image string, reference string) (string, map[string]int64, error) {
+	body, _, digest, err := r.RawManifest(image, reference)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var manifest ImageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return "", nil, err
+	}
+
+	blobs := map[string]int64{digest: int64(len(body))}
+	if manifest.Config.Digest != "" {
+		blobs[manifest.Config.Digest] = manifest.Config.Size
+	}
+	for _, layer := range manifest.Layers {
+		blobs[layer.Digest] = layer.Size
+	}
+	for _, m := range manifest.Manifests {
+		_, children, err := r.manifestBlobs(image, m.Digest)
+		if err != nil {
+			return "", nil, err
+		}
+		for blob, size := range children {
+			blobs[blob] = size
+		}
+	}
+	return digest, blobs, nil
+}
+
+// ImageSize returns the stored size of a tag or digest: the manifest, its config and layers, counting each blob once
+func (r Registry) ImageSize(image string, reference string) (int64, error) {
+	_, blobs, err := r.manifestBlobs(image, reference)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, s := range blobs {
+		size += s
+	}
+	return size, nil
+}
@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Reference is an image reference as other container tools take it, e.g.
+// nexus.example.com/docker-hosted/team/app:1.2@sha256:... Host, Repository, Tag and Digest are empty if the reference
+// has none
+type Reference struct {
+	Host       string
+	Repository string
+	Image      string
+	Tag        string
+	Digest     string
+}
+
+// digestPattern is what a digest may look like, see the OCI image spec
+var digestPattern = regexp.MustCompile(`^[a-z0-9]+([+._-][a-z0-9]+)*:[A-Fa-f0-9]{32,}$`)
+
+// IsDigest tells if value looks like a digest, e.g. sha256:<hex>
+func IsDigest(value string) bool {
+	return digestPattern.MatchString(value)
+}
+
+// ParseReference splits an image reference into its parts. The first component is the host if it looks like one: it
+// has a dot or a port, or is localhost. Behind a host without a port, where Nexus routes docker requests by path, the
+// next component is the repository, a leading repository/ as in the URLs of Nexus is skipped. A host with a port is
+// the connector of a repository, which is not part of the reference then
+func ParseReference(ref string) (Reference, error) {
+	var r Reference
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.Digest = name[:i], name[i+1:]
+		if !digestPattern.MatchString(r.Digest) {
+			return r, errors.New(fmt.Sprintf("Invalid reference %q, the digest %q is not in the form sha256:<hex>", ref, r.Digest))
+		}
+	}
+	// the tag follows the last colon after the last slash, a colon before it separates the port
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.Tag = name[:i], name[i+1:]
+		if !tagPattern.MatchString(r.Tag) {
+			return r, errors.New(fmt.Sprintf("Invalid reference %q, the tag %q has characters tags cannot have", ref, r.Tag))
+		}
+	}
+	components := strings.Split(name, "/")
+	if len(components) > 1 && (strings.ContainsAny(components[0], ".:") || components[0] == "localhost") {
+		r.Host, components = components[0], components[1:]
+		if !strings.Contains(r.Host, ":") && len(components) > 1 {
+			if components[0] == "repository" && len(components) > 2 {
+				components = components[1:]
+			}
+			r.Repository, components = components[0], components[1:]
+		}
+	}
+	for _, component := range components {
+		if component == "" {
+			return r, errors.New(fmt.Sprintf("Invalid reference %q, the image name has an empty component", ref))
+		}
+	}
+	r.Image = strings.Join(components, "/")
+	if r.Image == "" {
+		return r, errors.New(fmt.Sprintf("Invalid reference %q, it has no image name", ref))
+	}
+	return r, nil
+}
+
+// HasLocation tells if the reference names the server or the repository the image is on, not only the image
+func (r Reference) HasLocation() bool {
+	return r.Host != "" || r.Repository != ""
+}
+
+// Version returns what the manifest is requested with: the digest if the reference has one, the tag otherwise
+func (r Reference) Version() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r Reference) String() string {
+	s := r.Image
+	if r.Repository != "" {
+		s = r.Repository + "/" + s
+	}
+	if r.Host != "" {
+		s = r.Host + "/" + s
+	}
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
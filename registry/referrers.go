@@ -0,0 +1,214 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// cosign (and tools following it) attach signatures, attestations and SBOMs as tags derived from the subject digest
+var cosignTagSuffixes = []string{".sig", ".att", ".sbom"}
+
+// Referrer is an artifact (signature, attestation, SBOM ...) attached to a subject manifest
+type Referrer struct {
+	ManifestInfo
+	// Tag is set if the referrer was discovered through a tag rather than through the referrers API
+	Tag string
+}
+
+// Referrers lists all artifacts attached to the manifest with the given digest. The OCI 1.1 referrers API is used if
+// the registry supports it, otherwise the referrers tag schema (an index tagged sha256-<hex>) is consulted. Artifacts
+// attached with the cosign tag convention (sha256-<hex>.sig, .att and .sbom) are included as well
+func (r Registry) Referrers(image string, digest string) ([]Referrer, error) {
+	var referrers []Referrer
+
+	index, found, err := r.referrersIndex(image, digest)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		// fallback to the tag schema
+		index, _, err = r.optionalIndex(image, referrersTag(digest))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range index.Manifests {
+		referrers = append(referrers, Referrer{ManifestInfo: m})
+	}
+
+	tags, err := r.ListTagsByImage(image)
+	if err != nil {
+		return nil, err
+	}
+	for _, suffix := range cosignTagSuffixes {
+		tag := referrersTag(digest) + suffix
+		if !containsString(tags, tag) {
+			continue
+		}
+		manifest, err := r.ImageManifest(image, tag)
+		if err != nil {
+			return nil, err
+		}
+		sha, err := r.getImageSHA(image, tag)
+		if err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, Referrer{
+			ManifestInfo: ManifestInfo{
+				MediaType:    manifest.MediaType,
+				ArtifactType: manifest.ArtifactTypeOrConfig(),
+				Digest:       sha,
+				Annotations:  manifest.Annotations,
+			},
+			Tag: tag,
+		})
+	}
+
+	return referrers, nil
+}
+
+// DeleteReferrers deletes all artifacts attached to the given tag, including artifacts attached to those artifacts
+func (r Registry) DeleteReferrers(image string, tag string) error {
+	sha, err := r.getImageSHA(image, tag)
+	if err != nil {
+		return err
+	}
+	return r.deleteReferrersOf(image, sha)
+}
+
+// ReferrerTree lists the artifacts attached to the manifest with the given digest and those attached to them, in
+// the order deleting them with it goes: the artifacts attached to an artifact come before it
+func (r Registry) ReferrerTree(image string, digest string) ([]Referrer, error) {
+	referrers, err := r.Referrers(image, digest)
+	if err != nil {
+		return nil, err
+	}
+	var tree []Referrer
+	for _, referrer := range referrers {
+		attached, err := r.ReferrerTree(image, referrer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		tree = append(append(tree, attached...), referrer)
+	}
+	return tree, nil
+}
+
+func (r Registry) deleteReferrersOf(image string, digest string) error {
+	referrers, err := r.Referrers(image, digest)
+	if err != nil {
+		return err
+	}
+	for _, referrer := range referrers {
+		if err := r.deleteReferrersOf(image, referrer.Digest); err != nil {
+			return err
+		}
+		if err := r.DeleteManifest(image, referrer.Digest); err != nil {
+			return err
+		}
+		fmt.Printf("%s@%s (%s) has been successfully deleted\n", image, referrer.Digest, referrer.ArtifactType)
+	}
+
+	// the index of the referrers tag schema is meaningless without its subject
+	tags, err := r.ListTagsByImage(image)
+	if err != nil {
+		return err
+	}
+	if containsString(tags, referrersTag(digest)) {
+		sha, err := r.getImageSHA(image, referrersTag(digest))
+		if err != nil {
+			return err
+		}
+		if err := r.DeleteManifest(image, sha); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteManifest deletes a manifest by its digest, removing every tag pointing to it
+func (r Registry) DeleteManifest(image string, digest string) error {
+	url := fmt.Sprintf("%s/repository/%s/v2/%s/manifests/%s", r.Host, r.Repository, imagePath(image), digest)
+	resp, err := r.do("DELETE", url, AcceptHeader, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 202 {
+		err := r.newError(resp)
+		if deleteRefused(err.(*Error)) {
+			return &DeleteDisabledError{Host: r.Host, Repository: r.Repository, Cause: err}
+		}
+		return err
+	}
+	return nil
+}
+
+// referrersIndex queries the referrers API. found is false if the registry does not implement it
+func (r Registry) referrersIndex(image string, digest string) (ImageManifest, bool, error) {
+	var index ImageManifest
+	url := fmt.Sprintf("%s/repository/%s/v2/%s/referrers/%s", r.Host, r.Repository, imagePath(image), digest)
+	resp, err := r.do("GET", url, MediaTypeOCIIndex, "", nil)
+	if err != nil {
+		return index, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 || resp.StatusCode == 405 {
+		return index, false, nil
+	}
+	if resp.StatusCode != 200 {
+		return index, false, r.newError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return index, false, err
+	}
+	return index, true, nil
+}
+
+// optionalIndex fetches an index by tag, found is false if the tag does not exist
+func (r Registry) optionalIndex(image string, tag string) (ImageManifest, bool, error) {
+	var index ImageManifest
+	url := fmt.Sprintf("%s/repository/%s/v2/%s/manifests/%s", r.Host, r.Repository, imagePath(image), tag)
+	resp, err := r.do("GET", url, MediaTypeOCIIndex, "", nil)
+	if err != nil {
+		return index, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return index, false, nil
+	}
+	if resp.StatusCode != 200 {
+		return index, false, r.newError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return index, false, err
+	}
+	return index, true, nil
+}
+
+// referrersTag builds the tag of the referrers tag schema, sha256:abc becomes sha256-abc
+func referrersTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1)
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+var referrerTagPattern = regexp.MustCompile(`^sha256-[0-9a-f]{64}(\.[a-z]+)?$`)
+
+// IsReferrerTag tells if a tag belongs to the referrers tag schema or the cosign tag convention, i.e. it holds
+// artifacts attached to another manifest rather than an image of its own
+func IsReferrerTag(tag string) bool {
+	return referrerTagPattern.MatchString(tag)
+}
@@ -1,23 +1,74 @@
 package registry
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/BurntSushi/toml"
-	"github.com/eugenmayer/nexus-cli/utils"
-	"html"
+	"io"
+	"io/ioutil"
 	"net/http"
-	"os"
+	"strings"
 )
 
 const AcceptHeader = "application/vnd.docker.distribution.manifest.v2+json"
 
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// ManifestAcceptHeader accepts single image manifests as well as indexes, so OCI content and multi-arch images
+// are returned as they are stored instead of failing content negotiation
+var ManifestAcceptHeader = strings.Join([]string{
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeOCIIndex,
+}, ", ")
+
 type Registry struct {
 	Host       string `toml:"nexus_host"`
 	Username   string `toml:"nexus_username"`
 	Password   string `toml:"nexus_password"`
 	Repository string `toml:"nexus_repository"`
+	// PasswordCommand is run instead of storing nexus_password, the first line it prints is the password, e.g.
+	// pass show nexus/ci
+	PasswordCommand string `toml:"password_command,omitempty"`
+	// VaultPath is the KV version 2 secret holding the username and password fields of the profile, e.g.
+	// secret/nexus/ci. VaultAddress defaults to VAULT_ADDR, VaultAuth is vault.AuthToken or vault.AuthAppRole
+	// logging in with VaultRoleID
+	VaultPath    string `toml:"vault_path,omitempty"`
+	VaultAddress string `toml:"vault_address,omitempty"`
+	VaultAuth    string `toml:"vault_auth,omitempty"`
+	VaultRoleID  string `toml:"vault_role_id,omitempty"`
+	// NuGetAPIKey authenticates pushes and deletes through the NuGet protocol
+	NuGetAPIKey string `toml:"nuget_api_key,omitempty"`
+	// HTTPProtocol is ProtocolAuto (the default) or ProtocolHTTP1
+	HTTPProtocol string `toml:"http_protocol,omitempty"`
+	// Environment names what the profile is about in the confirmations of deletions, e.g. PROD. Defaults to the
+	// profile name
+	Environment string `toml:"environment,omitempty"`
+	// ConfirmThreshold is how many tags may be deleted at once before the repository name has to be typed, 0 is
+	// DefaultConfirmThreshold
+	ConfirmThreshold int `toml:"confirm_threshold,omitempty"`
+	// PreDeleteHook, PostDeleteHook and PostRunHook are shell commands run around the deletion of tags, see
+	// HookPreDelete
+	PreDeleteHook  string `toml:"pre_delete_hook,omitempty"`
+	PostDeleteHook string `toml:"post_delete_hook,omitempty"`
+	PostRunHook    string `toml:"post_run_hook,omitempty"`
+	// PlanKeys are the public keys of the approvers, repo gc-apply only applies plans one of them signed
+	PlanKeys []string `toml:"plan_keys,omitempty"`
+
+	client   *http.Client
+	crawler  *Crawler
+	runStats *RunStats
+	ignore   *IgnoreRules
+	throttle *Throttle
+	uploads  UploadJournal
+	chunk    int64
 }
 
 type Repositories struct {
@@ -30,167 +81,393 @@ type ImageTags struct {
 }
 
 type ImageManifest struct {
-	SchemaVersion int64       `json:"schemaVersion"`
-	MediaType     string      `json:"mediaType"`
-	Config        LayerInfo   `json:"config"`
-	Layers        []LayerInfo `json:"layers"`
+	SchemaVersion int64             `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        LayerInfo         `json:"config"`
+	Layers        []LayerInfo       `json:"layers"`
+	Manifests     []ManifestInfo    `json:"manifests,omitempty"`
+	Subject       *LayerInfo        `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
 }
 type LayerInfo struct {
-	MediaType string `json:"mediaType"`
-	Size      int64  `json:"size"`
-	Digest    string `json:"digest"`
+	MediaType   string            `json:"mediaType"`
+	Size        int64             `json:"size"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
-func NewRegistry() (Registry, error) {
-	r := Registry{}
-	configurationPath := utils.ExpandTildeInPath("~/.nexus-cli")
-	if _, err := os.Stat(configurationPath); os.IsNotExist(err) {
-		return r, errors.New(fmt.Sprintf("Configuration not found at %s - please run 'nexus-cli configure'\n", configurationPath))
-	} else if err != nil {
-		return r, err
+// ManifestInfo is an entry of an index (or docker manifest list) pointing to a platform specific manifest
+type ManifestInfo struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Size         int64             `json:"size"`
+	Digest       string            `json:"digest"`
+	Platform     *Platform         `json:"platform,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ArtifactTypeOrConfig returns the artifact type of the manifest. Artifacts pushed before OCI 1.1 only carry it as
+// config media type, or (like cosign signatures) as the media type shared by all their layers
+func (m ImageManifest) ArtifactTypeOrConfig() string {
+	if m.ArtifactType != "" {
+		return m.ArtifactType
+	}
+	if m.Config.MediaType != "application/vnd.oci.image.config.v1+json" && m.Config.MediaType != "" {
+		return m.Config.MediaType
 	}
+	for _, layer := range m.Layers {
+		if layer.MediaType != m.Layers[0].MediaType {
+			return m.Config.MediaType
+		}
+	}
+	if len(m.Layers) > 0 {
+		return m.Layers[0].MediaType
+	}
+	return m.Config.MediaType
+}
 
-	if _, err := toml.DecodeFile(configurationPath, &r); err != nil {
-		return r, err
+// String returns the platform as os/arch or os/arch/variant
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
 	}
+	return p.OS + "/" + p.Architecture
+}
 
-	// credits https://github.com/mlabouardy/nexus-cli/pull/12/files
-	r.Password = html.UnescapeString(r.Password)
-	return r, nil
+// IsIndex tells if the manifest is an index / manifest list rather than a single image manifest
+func (m ImageManifest) IsIndex() bool {
+	return m.MediaType == MediaTypeOCIIndex || m.MediaType == MediaTypeDockerManifestList
 }
 
-func (r Registry) ListImages() ([]string, error) {
-	client := &http.Client{}
+// NewRegistry loads the registry of the active profile
+func NewRegistry() (Registry, error) {
+	r, _, err := NewRegistryFromProfile("")
+	return r, err
+}
 
+func (r Registry) ListImages() ([]string, error) {
 	url := fmt.Sprintf("%s/repository/%s/v2/_catalog", r.Host, r.Repository)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.SetBasicAuth(r.Username, r.Password)
-	req.Header.Add("Accept", AcceptHeader)
-
-	resp, err := client.Do(req)
+	resp, err := r.get(url, AcceptHeader)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, errors.New(fmt.Sprintf("HTTP Code: %d", resp.StatusCode))
+		return nil, r.newError(resp)
 	}
 
 	var repositories Repositories
-	json.NewDecoder(resp.Body).Decode(&repositories)
+	if err := r.decode(resp, &repositories); err != nil {
+		return nil, err
+	}
 
 	return repositories.Images, nil
 }
 
 func (r Registry) ListTagsByImage(image string) ([]string, error) {
-	client := &http.Client{}
-
-	url := fmt.Sprintf("%s/repository/%s/v2/%s/tags/list", r.Host, r.Repository, image)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.SetBasicAuth(r.Username, r.Password)
-	req.Header.Add("Accept", AcceptHeader)
-
-	resp, err := client.Do(req)
+	url := fmt.Sprintf("%s/repository/%s/v2/%s/tags/list", r.Host, r.Repository, imagePath(image))
+	resp, err := r.get(url, AcceptHeader)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, errors.New(fmt.Sprintf("HTTP Code: %d", resp.StatusCode))
+		return nil, r.newError(resp)
 	}
 
 	var imageTags ImageTags
-	json.NewDecoder(resp.Body).Decode(&imageTags)
+	if err := r.decode(resp, &imageTags); err != nil {
+		return nil, err
+	}
 
 	return imageTags.Tags, nil
 }
 
 func (r Registry) ImageManifest(image string, tag string) (ImageManifest, error) {
 	var imageManifest ImageManifest
-	client := &http.Client{}
 
-	url := fmt.Sprintf("%s/repository/%s/v2/%s/manifests/%s", r.Host, r.Repository, image, tag)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return imageManifest, err
-	}
-	req.SetBasicAuth(r.Username, r.Password)
-	req.Header.Add("Accept", AcceptHeader)
-
-	resp, err := client.Do(req)
+	url := fmt.Sprintf("%s/repository/%s/v2/%s/manifests/%s", r.Host, r.Repository, imagePath(image), tag)
+	resp, err := r.get(url, ManifestAcceptHeader)
 	if err != nil {
 		return imageManifest, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return imageManifest, errors.New(fmt.Sprintf("HTTP Code: %d", resp.StatusCode))
+		return imageManifest, r.newError(resp)
 	}
 
-	json.NewDecoder(resp.Body).Decode(&imageManifest)
+	if err := r.decode(resp, &imageManifest); err != nil {
+		return imageManifest, err
+	}
+	if imageManifest.MediaType == "" {
+		// OCI manifests are not required to carry their media type in the body
+		imageManifest.MediaType = resp.Header.Get("Content-Type")
+	}
 
 	return imageManifest, nil
 
 }
 
+// DeleteImageByTag deletes the manifest the tag points to. Other tags pointing to the same manifest would be deleted
+// with it, a *SharedDigestError is returned instead, see TagDeleter to delete them anyway or to only untag. Finding
+// them lists and resolves all tags of the image: to delete several tags, prepare one TagDeleter for all of them
 func (r Registry) DeleteImageByTag(image string, tag string) error {
-	sha, err := r.getImageSHA(image, tag)
+	d, err := r.NewTagDeleter(image, []string{tag})
 	if err != nil {
 		return err
 	}
-	client := &http.Client{}
+	return d.Delete(tag)
+}
+
+// ImageDigest resolves the manifest digest a tag points to
+func (r Registry) ImageDigest(image string, tag string) (string, error) {
+	return r.getImageSHA(image, tag)
+}
 
-	url := fmt.Sprintf("%s/repository/%s/v2/%s/manifests/%s", r.Host, r.Repository, image, sha)
-	req, err := http.NewRequest("DELETE", url, nil)
+// getImageSHA resolves the digest with a HEAD request, the manifest itself is not needed. Failures and registries
+// not sending the digest on HEAD fall back to a GET, whose body carries the error details
+func (r Registry) getImageSHA(image string, tag string) (string, error) {
+	url := fmt.Sprintf("%s/repository/%s/v2/%s/manifests/%s", r.Host, r.Repository, imagePath(image), tag)
+	resp, err := r.do("HEAD", url, ManifestAcceptHeader, "", nil)
 	if err != nil {
-		return err
+		return "", err
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get("docker-content-digest"); resp.StatusCode == 200 && digest != "" {
+		return digest, nil
 	}
-	req.SetBasicAuth(r.Username, r.Password)
-	req.Header.Add("Accept", AcceptHeader)
 
-	resp, err := client.Do(req)
+	resp, err = r.do("GET", url, ManifestAcceptHeader, "", nil)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 202 {
-		return errors.New(fmt.Sprintf("HTTP Code: %d", resp.StatusCode))
+	if resp.StatusCode != 200 {
+		return "", r.newError(resp)
 	}
 
-	fmt.Printf("%s:%s has been successfully deleted\n", image, tag)
+	return resp.Header.Get("docker-content-digest"), nil
+}
+
+// RawManifest fetches the manifest (or index) of the given tag or digest exactly as stored, returning the body,
+// its media type and its digest
+func (r Registry) RawManifest(image string, reference string) ([]byte, string, string, error) {
+	url := fmt.Sprintf("%s/repository/%s/v2/%s/manifests/%s", r.Host, r.Repository, imagePath(image), reference)
+	resp, err := r.get(url, ManifestAcceptHeader)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, "", "", r.newError(resp)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
 
-	return nil
+	return body, resp.Header.Get("Content-Type"), resp.Header.Get("docker-content-digest"), nil
 }
 
-func (r Registry) getImageSHA(image string, tag string) (string, error) {
-	client := &http.Client{}
+// PutManifest uploads a manifest under the given tag (or digest) and returns the digest the registry assigned
+func (r Registry) PutManifest(image string, reference string, mediaType string, body []byte) (string, error) {
+	url := fmt.Sprintf("%s/repository/%s/v2/%s/manifests/%s", r.Host, r.Repository, imagePath(image), reference)
+	resp, err := r.do("PUT", url, "", mediaType, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 201 {
+		return "", r.newError(resp)
+	}
 
-	url := fmt.Sprintf("%s/repository/%s/v2/%s/manifests/%s", r.Host, r.Repository, image, tag)
-	req, err := http.NewRequest("GET", url, nil)
+	return resp.Header.Get("docker-content-digest"), nil
+}
+
+// PushManifest is PutManifest skipping the upload if reference points to the manifest already, pushed tells if it
+// was uploaded. Pushing the same manifest again is then cheap and leaves the tag as it is
+func (r Registry) PushManifest(image string, reference string, mediaType string, body []byte) (string, bool, error) {
+	digest := digestOf(body)
+	existing, err := r.manifestDigest(image, reference)
+	if err != nil {
+		return "", false, err
+	}
+	if existing == digest {
+		return digest, false, nil
+	}
+	digest, err = r.PutManifest(image, reference, mediaType, body)
+	return digest, err == nil, err
+}
+
+// manifestDigest returns the digest of the manifest reference points to, empty if there is none or the registry
+// does not tell
+func (r Registry) manifestDigest(image string, reference string) (string, error) {
+	url := fmt.Sprintf("%s/repository/%s/v2/%s/manifests/%s", r.Host, r.Repository, imagePath(image), reference)
+	resp, err := r.do("HEAD", url, ManifestAcceptHeader, "", nil)
 	if err != nil {
 		return "", err
 	}
-	req.SetBasicAuth(r.Username, r.Password)
-	req.Header.Add("Accept", AcceptHeader)
+	defer resp.Body.Close()
 
-	resp, err := client.Do(req)
+	switch resp.StatusCode {
+	case 200:
+		return resp.Header.Get("docker-content-digest"), nil
+	case 404:
+		return "", nil
+	default:
+		return "", r.newError(resp)
+	}
+}
+
+// AnnotateImage adds the given annotations to the manifest (or index) of a tag and pushes the result under the same
+// tag. All other fields of the manifest are kept as they are. Since this changes the digest, the old manifest stays
+// behind untagged. Returns the new digest
+func (r Registry) AnnotateImage(image string, tag string, annotations map[string]string) (string, error) {
+	body, mediaType, _, err := r.RawManifest(image, tag)
 	if err != nil {
 		return "", err
 	}
+
+	var manifest map[string]json.RawMessage
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return "", err
+	}
+
+	existing := map[string]string{}
+	if raw, ok := manifest["annotations"]; ok {
+		if err := json.Unmarshal(raw, &existing); err != nil {
+			return "", err
+		}
+	}
+	for key, value := range annotations {
+		existing[key] = value
+	}
+
+	raw, err := json.Marshal(existing)
+	if err != nil {
+		return "", err
+	}
+	manifest["annotations"] = raw
+
+	if raw, ok := manifest["mediaType"]; ok {
+		if err := json.Unmarshal(raw, &mediaType); err != nil {
+			return "", err
+		}
+	}
+
+	body, err = json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	return r.PutManifest(image, tag, mediaType, body)
+}
+
+// MissingReferences lists what a manifest references but image does not have: config and layer blobs of a manifest,
+// the manifests of an index. A registry would accept such a manifest and only fail when it is pulled. The subject is
+// not checked, referrers may be pushed before what they refer to
+func (r Registry) MissingReferences(image string, manifest ImageManifest) ([]string, error) {
+	var missing []string
+	for _, m := range manifest.Manifests {
+		exists, err := r.manifestExists(image, m.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, "manifest "+m.Digest)
+		}
+	}
+	if manifest.IsIndex() {
+		return missing, nil
+	}
+	for _, blob := range append([]LayerInfo{manifest.Config}, manifest.Layers...) {
+		if blob.Digest == "" {
+			continue
+		}
+		exists, err := r.BlobExists(image, blob.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, "blob "+blob.Digest)
+		}
+	}
+	return missing, nil
+}
+
+func (r Registry) manifestExists(image string, digest string) (bool, error) {
+	url := fmt.Sprintf("%s/repository/%s/v2/%s/manifests/%s", r.Host, r.Repository, imagePath(image), digest)
+	resp, err := r.do("HEAD", url, ManifestAcceptHeader, "", nil)
+	if err != nil {
+		return false, err
+	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return "", errors.New(fmt.Sprintf("HTTP Code: %d", resp.StatusCode))
+	switch resp.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, r.newError(resp)
 	}
+}
 
-	return resp.Header.Get("docker-content-digest"), nil
+// doSized is like do, but sets the content length of the body so streamed content is not sent chunked
+func (r Registry) doSized(method string, url string, contentType string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := r.newRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if size >= 0 {
+		req.ContentLength = size
+	}
+
+	return r.httpClient().Do(req)
+}
+
+// do executes an authenticated request against the registry. accept and contentType are only set if not empty
+func (r Registry) do(method string, url string, accept string, contentType string, body io.Reader) (*http.Response, error) {
+	return r.doContext(context.Background(), method, url, accept, contentType, body)
+}
+
+// doContext is like do, the request is canceled with ctx
+func (r Registry) doContext(ctx context.Context, method string, url string, accept string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := r.newRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if accept != "" {
+		req.Header.Add("Accept", accept)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	return r.httpClient().Do(req)
+}
+
+func (r Registry) newRequest(method string, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(r.Username, r.Password)
+	return req, nil
 }
@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// CheckProxy fails unless repository is a proxy repository. Evicting from the cache deletes assets, on a hosted
+// repository they would be gone for good
+func (r Registry) CheckProxy(repository string) error {
+	repositories, err := r.Repositories()
+	if err != nil {
+		return err
+	}
+	for _, info := range repositories {
+		if info.Name != repository {
+			continue
+		}
+		if info.Type != "proxy" {
+			return errors.New(fmt.Sprintf("%s is a %s repository, only the cache of proxy repositories is invalidated", repository, info.Type))
+		}
+		return nil
+	}
+	return errors.New(fmt.Sprintf("repository %s does not exist on %s", repository, r.Host))
+}
+
+// InvalidateCache drops the cached content and metadata of a proxy (or the members of a group) repository, so
+// for example a go proxy fetches the version list of a module again
+func (r Registry) InvalidateCache() error {
+	if err := r.Require(FeatureInvalidateCache); err != nil {
+		return err
+	}
+	invalidateURL := fmt.Sprintf("%s/service/rest/v1/repositories/%s/invalidate-cache", r.Host, url.PathEscape(r.Repository))
+	resp, err := r.do("POST", invalidateURL, "application/json", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 204 && resp.StatusCode != 200 {
+		return r.newError(resp)
+	}
+	return nil
+}
+
+// RebuildIndex rebuilds the search index of the repository, for formats keeping one (e.g. maven or npm proxies)
+func (r Registry) RebuildIndex() error {
+	rebuildURL := fmt.Sprintf("%s/service/rest/v1/repositories/%s/rebuild-index", r.Host, url.PathEscape(r.Repository))
+	resp, err := r.do("POST", rebuildURL, "application/json", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 204 && resp.StatusCode != 200 {
+		return r.newError(resp)
+	}
+	return nil
+}
+
+// DeleteAsset deletes a single asset. For proxy repositories this evicts it from the cache, it is fetched from
+// upstream again on the next request
+func (r Registry) DeleteAsset(id string) error {
+	deleteURL := fmt.Sprintf("%s/service/rest/v1/assets/%s", r.Host, url.PathEscape(id))
+	resp, err := r.do("DELETE", deleteURL, "application/json", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 204 {
+		return r.newError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/eugenmayer/nexus-cli/events"
+)
+
+// DefaultChunkSize is the size of the chunks of resumable uploads if WithUploadJournal is not given one
+const DefaultChunkSize = 32 * 1024 * 1024
+
+// UploadJournal records the uploads of blobs in progress, so a copy interrupted halfway through a big layer
+// continues where it stopped instead of sending it again. The sync of mirror keeps it in its checkpoint
+type UploadJournal interface {
+	// Upload returns the location of the upload of a blob into image and how many bytes were sent, ok is false if
+	// there is none
+	Upload(image string, digest string) (location string, offset int64, ok bool)
+	// SaveUpload records the progress of an upload, location is empty once the blob is complete
+	SaveUpload(image string, digest string, location string, offset int64) error
+}
+
+// WithUploadJournal uploads the blobs copied into the registry which are bigger than chunkSize (DefaultChunkSize if
+// 0) in chunks, recording the progress in journal after each
+func WithUploadJournal(journal UploadJournal, chunkSize int64) Option {
+	return func(r *Registry) {
+		if chunkSize <= 0 {
+			chunkSize = DefaultChunkSize
+		}
+		r.uploads, r.chunk = journal, chunkSize
+	}
+}
+
+// copyBlobInChunks copies a blob from src to dst in chunks, resuming the upload the journal of dst has for it.
+// Outside of the windows of the throttle of dst the source is closed until the next window, or the copy stops with
+// the upload kept for the next run. It returns false if it did not copy the blob, small blobs are sent at once
+func copyBlobInChunks(src Registry, srcImage string, dst Registry, dstImage string, digest string) (bool, error) {
+	exists, size, err := src.statBlob(srcImage, digest)
+	if err != nil || !exists || size <= dst.chunk {
+		// a missing blob fails when it is opened
+		return false, err
+	}
+
+	location, offset, ok := dst.uploads.Upload(dstImage, digest)
+	if ok {
+		// upload sessions expire, and the registry may have kept part of the chunk being sent when the copy was
+		// interrupted: it tells what it has
+		sent, err := dst.uploadOffset(location)
+		if err != nil || sent < 0 || sent > size {
+			ok = false
+		}
+		offset = sent
+	}
+	if !ok {
+		if location, err = dst.startUpload(dstImage); err != nil {
+			return true, err
+		}
+		offset = 0
+	}
+	started := offset
+
+	var content io.ReadCloser
+	defer func() {
+		if content != nil {
+			content.Close()
+		}
+	}()
+	for offset < size {
+		if !dst.throttle.Open() && content != nil {
+			// no connection is kept open waiting for the next window
+			content.Close()
+			content = nil
+		}
+		if err := dst.throttle.admit(); err != nil {
+			return true, err
+		}
+		if content == nil {
+			if content, err = src.blobFrom(srcImage, digest, offset); err != nil {
+				return true, err
+			}
+		}
+		n := dst.chunk
+		if size-offset < n {
+			n = size - offset
+		}
+		chunk := io.LimitReader(dst.throttle.reader(content), n)
+		if size-offset == n {
+			// the last chunk completes the upload
+			if err := dst.finishUpload(location, digest, n, chunk); err != nil {
+				// e.g. a digest mismatch of a resumed upload, the next run starts over
+				dst.uploads.SaveUpload(dstImage, digest, "", 0)
+				return true, err
+			}
+			break
+		}
+		if location, err = dst.uploadChunk(location, offset, n, chunk); err != nil {
+			return true, err
+		}
+		offset += n
+		if err := dst.uploads.SaveUpload(dstImage, digest, location, offset); err != nil {
+			return true, err
+		}
+	}
+	if err := dst.uploads.SaveUpload(dstImage, digest, "", size); err != nil {
+		return true, err
+	}
+	events.Transferred(digest, size-started, "upload")
+	dst.runStats.AddBytes(size - started)
+	return true, nil
+}
+
+// uploadOffset returns how many bytes the registry has of an upload, -1 if the upload is gone
+func (r Registry) uploadOffset(location string) (int64, error) {
+	resp, err := r.do("GET", location, "", "", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 204:
+		// Range is 0-<last byte>
+		bounds := strings.SplitN(resp.Header.Get("Range"), "-", 2)
+		if len(bounds) != 2 {
+			return -1, nil
+		}
+		last, err := strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil {
+			return -1, nil
+		}
+		return last + 1, nil
+	case 404:
+		return -1, nil
+	default:
+		return 0, r.newError(resp)
+	}
+}
+
+// uploadChunk sends size bytes at offset of an upload and returns the location to continue it at
+func (r Registry) uploadChunk(location string, offset int64, size int64, content io.Reader) (string, error) {
+	req, err := r.newRequest("PATCH", location, content)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+size-1))
+	req.ContentLength = size
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 202 {
+		return "", r.newError(resp)
+	}
+	if resp.Header.Get("Location") == "" {
+		return location, nil
+	}
+	return r.resolveLocation(resp.Header.Get("Location"))
+}
+
+// blobFrom opens the content of a blob from offset on. Registries ignoring the range send it all, the start is
+// skipped then
+func (r Registry) blobFrom(image string, digest string, offset int64) (io.ReadCloser, error) {
+	if offset == 0 {
+		content, _, err := r.GetBlob(image, digest)
+		return content, err
+	}
+	blobURL := fmt.Sprintf("%s/repository/%s/v2/%s/blobs/%s", r.Host, r.Repository, imagePath(image), digest)
+	req, err := r.newRequest("GET", blobURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case 206:
+		return resp.Body, nil
+	case 200:
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		return resp.Body, nil
+	default:
+		resp.Body.Close()
+		return nil, r.newError(resp)
+	}
+}
@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// tagPattern is what a tag may look like, see the distribution spec
+var tagPattern = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// Retag is a tag of an image to be copied to another tag of it
+type Retag struct {
+	From string
+	To   string
+	// SupersededBy is set for tags not copied because another tag maps to To as well and wins, see PlanRetags
+	SupersededBy string
+	groups       []string
+}
+
+// IsValidTag tells if a tag is allowed by registries
+func IsValidTag(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+// PlanRetags maps the tags expr matches to the tags template expands to, $1 or ${name} standing for the groups of
+// the match (see regexp.Expand). Tags which expand to themselves are left out. When several tags map to the same
+// tag, e.g. 1.2.0-rc1 and 1.2.0-rc2 to 1.2.0, the one with the highest groups wins, compared as numbers where both
+// are numbers, rc10 wins over rc9. The others are returned as well, with SupersededBy set. The result is sorted by
+// the target tag
+func PlanRetags(tags []string, expr *regexp.Regexp, template string) []Retag {
+	var retags []Retag
+	winner := map[string]int{}
+	for _, tag := range tags {
+		match := expr.FindStringSubmatchIndex(tag)
+		if match == nil {
+			continue
+		}
+		to := string(expr.ExpandString(nil, template, tag, match))
+		if to == tag {
+			continue
+		}
+		retag := Retag{From: tag, To: to}
+		for i := 2; i < len(match); i += 2 {
+			group := ""
+			if match[i] >= 0 {
+				group = tag[match[i]:match[i+1]]
+			}
+			retag.groups = append(retag.groups, group)
+		}
+		retags = append(retags, retag)
+		if i, ok := winner[to]; !ok || higherGroups(retag.groups, retags[i].groups) {
+			winner[to] = len(retags) - 1
+		}
+	}
+	for i := range retags {
+		if w := winner[retags[i].To]; w != i {
+			retags[i].SupersededBy = retags[w].From
+		}
+	}
+	sort.SliceStable(retags, func(i, j int) bool { return retags[i].To < retags[j].To })
+	return retags
+}
+
+// higherGroups compares the groups of two matches from the first on, as numbers if both are
+func higherGroups(a []string, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		x, errX := strconv.ParseUint(a[i], 10, 64)
+		y, errY := strconv.ParseUint(b[i], 10, 64)
+		if errX == nil && errY == nil {
+			return x > y
+		}
+		return a[i] > b[i]
+	}
+	return len(a) > len(b)
+}
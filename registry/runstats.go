@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/utils"
+	"sync"
+	"time"
+)
+
+// RunSummary tells how a bulk operation went: the items (tags or images) it processed and how many of them
+// succeeded, failed or were skipped, e.g. because they are locked, and the bytes it copied or deleted
+type RunSummary struct {
+	Command    string        `json:"command"`
+	Repository string        `json:"repository,omitempty"`
+	Processed  int           `json:"processed"`
+	Succeeded  int           `json:"succeeded"`
+	Failed     int           `json:"failed"`
+	Skipped    int           `json:"skipped"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"-"`
+	Seconds    float64       `json:"duration_seconds"`
+}
+
+func (s RunSummary) String() string {
+	text := fmt.Sprintf("%s: %d processed, %d succeeded, %d failed, %d skipped", s.Command, s.Processed, s.Succeeded, s.Failed, s.Skipped)
+	if s.Bytes > 0 {
+		text += ", " + utils.HumanBytes(s.Bytes)
+	}
+	return text + " in " + s.Duration.Round(time.Millisecond).String()
+}
+
+// RunStats collects the outcome of a bulk operation while it runs. Operations running in parallel may share it. The
+// registry operations given it with WithRunStats record on it: TagDeleter what became of each tag, copies the bytes of
+// the blobs they upload. The methods do nothing on a nil RunStats
+type RunStats struct {
+	mu        sync.Mutex
+	started   time.Time
+	succeeded int
+	failed    int
+	skipped   int
+	bytes     int64
+}
+
+// NewRunStats starts collecting, the duration of the run is measured from now
+func NewRunStats() *RunStats {
+	return &RunStats{started: time.Now()}
+}
+
+// WithRunStats records the outcome of the bulk operations of the registry on stats
+func WithRunStats(stats *RunStats) Option {
+	return func(r *Registry) {
+		r.runStats = stats
+	}
+}
+
+// Succeeded counts an item done
+func (s *RunStats) Succeeded() {
+	s.add(1, 0, 0, 0)
+}
+
+// Failed counts an item which failed
+func (s *RunStats) Failed() {
+	s.add(0, 1, 0, 0)
+}
+
+// Skipped counts an item left alone on purpose or because there was nothing to do
+func (s *RunStats) Skipped() {
+	s.add(0, 0, 1, 0)
+}
+
+// AddBytes counts bytes copied or deleted
+func (s *RunStats) AddBytes(n int64) {
+	s.add(0, 0, 0, n)
+}
+
+func (s *RunStats) add(succeeded int, failed int, skipped int, bytes int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.succeeded += succeeded
+	s.failed += failed
+	s.skipped += skipped
+	s.bytes += bytes
+	s.mu.Unlock()
+}
+
+// Summary returns the outcome so far of command run on repository
+func (s *RunStats) Summary(command string, repository string) RunSummary {
+	summary := RunSummary{Command: command, Repository: repository}
+	if s == nil {
+		return summary
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary.Succeeded, summary.Failed, summary.Skipped, summary.Bytes = s.succeeded, s.failed, s.skipped, s.bytes
+	summary.Processed = s.succeeded + s.failed + s.skipped
+	summary.Duration = time.Since(s.started)
+	summary.Seconds = summary.Duration.Seconds()
+	return summary
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Script is a groovy script stored in Nexus by the script API
+type Script struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Type    string `json:"type"`
+}
+
+// ScriptResult is the outcome of running a script, Result holds what the script returned as a string
+type ScriptResult struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+}
+
+// UploadScript stores a script, replacing the one with the same name
+func (r Registry) UploadScript(script Script) error {
+	if script.Type == "" {
+		script.Type = "groovy"
+	}
+	err := r.scriptJSON("GET", "/"+url.PathEscape(script.Name), nil, nil)
+	if e, ok := err.(*Error); ok && e.StatusCode == http.StatusNotFound {
+		return r.scriptJSON("POST", "", script, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return r.scriptJSON("PUT", "/"+url.PathEscape(script.Name), script, nil)
+}
+
+// DeleteScript removes a script
+func (r Registry) DeleteScript(name string) error {
+	return r.scriptJSON("DELETE", "/"+url.PathEscape(name), nil, nil)
+}
+
+// RunScript runs a stored script. args is passed as is, scripts read it from their args variable and usually parse
+// it as JSON. A script failing is an error holding the message of the exception
+func (r Registry) RunScript(name string, args string) (ScriptResult, error) {
+	var result ScriptResult
+	if err := r.Require(FeatureScriptAPI); err != nil {
+		return result, err
+	}
+	runURL := fmt.Sprintf("%s/service/rest/v1/script/%s/run", r.Host, url.PathEscape(name))
+	resp, err := r.do("POST", runURL, "application/json", "text/plain", strings.NewReader(args))
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 200 {
+		return result, json.NewDecoder(resp.Body).Decode(&result)
+	}
+	if resp.StatusCode == http.StatusInternalServerError {
+		// failed scripts answer with the exception as result
+		body, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(body, &result) == nil && result.Result != "" {
+			return result, errors.New(fmt.Sprintf("script %s failed: %s", name, result.Result))
+		}
+		resp.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+	}
+	return result, r.scriptError(resp)
+}
+
+func (r Registry) scriptJSON(method string, path string, body interface{}, result interface{}) error {
+	if err := r.Require(FeatureScriptAPI); err != nil {
+		return err
+	}
+	err := r.restJSON(method, "/service/rest/v1/script"+path, body, result)
+	if e, ok := err.(*Error); ok {
+		e.Hint = scriptHint(e, e.Hint)
+	}
+	return err
+}
+
+func (r Registry) scriptError(resp *http.Response) error {
+	err := r.newError(resp)
+	if e, ok := err.(*Error); ok {
+		e.Hint = scriptHint(e, e.Hint)
+	}
+	return err
+}
+
+// scriptHint explains the script API specific failures, falling back to the general hint
+func scriptHint(e *Error, hint string) string {
+	switch e.StatusCode {
+	case http.StatusForbidden:
+		return "The script API needs the nx-script-*-* privileges, usually only administrators have them"
+	case http.StatusGone:
+		return "Nexus 3.21.2 and later disable creating scripts, set nexus.scripts.allowCreation=true in nexus.properties to enable it"
+	}
+	return hint
+}
@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Asset is an asset as returned by the Nexus search API
+type Asset struct {
+	ID             string     `json:"id"`
+	Path           string     `json:"path"`
+	Repository     string     `json:"repository"`
+	Format         string     `json:"format"`
+	ContentType    string     `json:"contentType"`
+	LastModified   *time.Time `json:"lastModified"`
+	LastDownloaded *time.Time `json:"lastDownloaded"`
+	FileSize       int64      `json:"fileSize"`
+}
+
+type assetPage struct {
+	Items             []Asset `json:"items"`
+	ContinuationToken *string `json:"continuationToken"`
+}
+
+// SearchAssets queries the Nexus search API for assets of the repository, query holds the search parameters
+// (e.g. name and version). All pages are fetched
+func (r Registry) SearchAssets(query url.Values) ([]Asset, error) {
+	var assets []Asset
+	query.Set("repository", r.Repository)
+
+	for {
+		searchURL := fmt.Sprintf("%s/service/rest/v1/search/assets?%s", r.Host, query.Encode())
+		resp, err := r.do("GET", searchURL, "application/json", "", nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != 200 {
+			err := r.newError(resp)
+			resp.Body.Close()
+			// older releases answer 404, tell which one is needed instead
+			if resp.StatusCode == 404 {
+				if err := r.Require(FeatureSearchAPI); err != nil {
+					return nil, err
+				}
+			}
+			return nil, err
+		}
+
+		var page assetPage
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		assets = append(assets, page.Items...)
+		if page.ContinuationToken == nil || *page.ContinuationToken == "" {
+			return assets, nil
+		}
+		query.Set("continuationToken", *page.ContinuationToken)
+	}
+}
+
+// ManifestAsset returns the asset holding the manifest of a docker tag, which records when the tag was last pulled
+func (r Registry) ManifestAsset(image string, tag string) (Asset, error) {
+	// older releases do not report download times, treating their tags as never pulled would delete them
+	if err := r.Require(FeatureAssetTimestamps); err != nil {
+		return Asset{}, err
+	}
+
+	query := url.Values{}
+	query.Set("format", "docker")
+	query.Set("name", image)
+	query.Set("version", tag)
+
+	assets, err := r.SearchAssets(query)
+	if err != nil {
+		return Asset{}, err
+	}
+	for _, asset := range assets {
+		if strings.HasSuffix(asset.Path, "/manifests/"+tag) {
+			return asset, nil
+		}
+	}
+	return Asset{}, errors.New(fmt.Sprintf("no manifest asset found for %s:%s", image, tag))
+}
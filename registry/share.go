@@ -0,0 +1,205 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SharedBlob is a blob of a shared image and the pre-authenticated URL it is downloaded from without credentials
+type SharedBlob struct {
+	Digest string
+	Size   int64
+	URL    string
+	// Expires is when the URL stops working, zero if it does not tell
+	Expires time.Time
+}
+
+// Share is what downloading an image without credentials for Nexus takes: the presigned URLs of its blobs, see
+// ShareImage
+type Share struct {
+	Image  string
+	Tag    string
+	Config SharedBlob
+	Layers []SharedBlob
+}
+
+// NotPresignedError is returned by ShareImage when the blob store does not hand out pre-authenticated URLs for a
+// blob: Nexus serves it itself, or the URL it redirects to needs the credentials as well
+type NotPresignedError struct {
+	Image  string
+	Digest string
+	Reason string
+}
+
+func (e *NotPresignedError) Error() string {
+	return fmt.Sprintf("There is no pre-authenticated URL for the blob %s of %s, %s", e.Digest, e.Image, e.Reason)
+}
+
+// IsNotPresigned tells if an image can't be shared by URLs because the blob store does not presign them
+func IsNotPresigned(err error) bool {
+	_, ok := err.(*NotPresignedError)
+	return ok
+}
+
+// Expires returns when the first URL of the share expires, zero if none tells
+func (s Share) Expires() time.Time {
+	var first time.Time
+	for _, blob := range append([]SharedBlob{s.Config}, s.Layers...) {
+		if !blob.Expires.IsZero() && (first.IsZero() || blob.Expires.Before(first)) {
+			first = blob.Expires
+		}
+	}
+	return first
+}
+
+// ShareImage collects the pre-authenticated URLs of the config and the layers of a tag, for indexes of the manifest of
+// the platform, see PlatformManifest. Blob stores like S3 or Azure answer the blob downloads of Nexus with a redirect
+// to a presigned URL, which works without credentials until it expires. A *NotPresignedError is returned if a blob is
+// served otherwise, the image then has to be shared as a file, see WriteDockerArchive
+func (r Registry) ShareImage(image string, tag string, platform string) (Share, error) {
+	share := Share{Image: image, Tag: tag}
+	manifest, err := r.PlatformManifest(image, tag, platform)
+	if err != nil {
+		return share, err
+	}
+	if manifest.Config.Digest == "" {
+		return share, errors.New(fmt.Sprintf("%s:%s is not an image", image, tag))
+	}
+	if share.Config, err = r.sharedBlob(image, manifest.Config); err != nil {
+		return share, err
+	}
+	for _, layer := range manifest.Layers {
+		if strings.Contains(layer.MediaType, "foreign") || strings.Contains(layer.MediaType, "nondistributable") {
+			return share, errors.New(fmt.Sprintf("%s:%s has a foreign layer %s which is not stored in the registry", image, tag, layer.Digest))
+		}
+		blob, err := r.sharedBlob(image, layer)
+		if err != nil {
+			return share, err
+		}
+		share.Layers = append(share.Layers, blob)
+	}
+	return share, nil
+}
+
+// sharedBlob asks for a blob without following the redirect and checks that the URL redirected to works without
+// credentials
+func (r Registry) sharedBlob(image string, blob LayerInfo) (SharedBlob, error) {
+	shared := SharedBlob{Digest: blob.Digest, Size: blob.Size}
+	client := *r.httpClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	req, err := r.newRequest("GET", fmt.Sprintf("%s/repository/%s/v2/%s/blobs/%s", r.Host, r.Repository, imagePath(image), blob.Digest), nil)
+	if err != nil {
+		return shared, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return shared, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	case http.StatusOK:
+		return shared, &NotPresignedError{Image: image, Digest: blob.Digest, Reason: "Nexus serves it itself"}
+	default:
+		return shared, r.newError(resp)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return shared, err
+	}
+
+	// only the first byte, the blob is downloaded by the one it is shared with
+	probe, err := http.NewRequest("GET", location.String(), nil)
+	if err != nil {
+		return shared, err
+	}
+	probe.Header.Set("Range", "bytes=0-0")
+	anonymous := &http.Client{Timeout: time.Minute, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}
+	answer, err := anonymous.Do(probe)
+	if err != nil {
+		return shared, err
+	}
+	answer.Body.Close()
+	if answer.StatusCode != http.StatusOK && answer.StatusCode != http.StatusPartialContent {
+		return shared, &NotPresignedError{Image: image, Digest: blob.Digest, Reason: fmt.Sprintf("the URL Nexus redirects to answers %s without credentials", answer.Status)}
+	}
+	shared.URL, shared.Expires = location.String(), urlExpiry(location)
+	return shared, nil
+}
+
+// urlExpiry reads when a presigned URL expires from its query: X-Amz-Date and X-Amz-Expires of S3 (signature
+// version 4), X-Goog-Date and X-Goog-Expires of Google Cloud Storage, the epoch seconds of Expires (S3 signature
+// version 2, CloudFront) or se of Azure shared access signatures. Zero if it has none of them
+func urlExpiry(u *url.URL) time.Time {
+	query := u.Query()
+	for _, prefix := range []string{"X-Amz-", "X-Goog-"} {
+		signed, err := time.Parse("20060102T150405Z", query.Get(prefix+"Date"))
+		seconds, secondsErr := strconv.Atoi(query.Get(prefix + "Expires"))
+		if err == nil && secondsErr == nil {
+			return signed.Add(time.Duration(seconds) * time.Second)
+		}
+	}
+	if epoch, err := strconv.ParseInt(query.Get("Expires"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	if expires, err := time.Parse(time.RFC3339, query.Get("se")); err == nil {
+		return expires
+	}
+	return time.Time{}
+}
+
+var shareScript = template.Must(template.New("share").Parse(`#!/bin/sh
+# Loads {{.Image}}:{{.Tag}} into Docker as {{.Name}}, no credentials needed.{{if .Expires}} The links work until {{.Expires}}{{end}}
+set -e
+dir=$(mktemp -d)
+trap 'rm -rf "$dir"' EXIT
+cd "$dir"
+{{range .Blobs}}{{if .Dir}}mkdir -p {{.Dir}}
+{{end}}curl -fsSL -o {{.File}} {{.URL}}
+echo '{{.Hex}}  {{.File}}' | sha256sum -c -
+{{end}}cat > manifest.json <<'MANIFEST'
+{{.Manifest}}
+MANIFEST
+tar -cf - . | docker load
+`))
+
+// WriteScript writes a shell script downloading the image from the URLs of the share, checking the digests and
+// loading it into Docker as name, the archive it builds is the one of WriteDockerArchive
+func (s Share) WriteScript(w io.Writer, name string) error {
+	type blob struct {
+		Dir, File, URL, Hex string
+	}
+	entry := archiveManifest{Config: archiveName(s.Config.Digest) + ".json", RepoTags: []string{name}}
+	blobs := []blob{{File: entry.Config, URL: shellQuote(s.Config.URL), Hex: archiveName(s.Config.Digest)}}
+	for _, layer := range s.Layers {
+		dir := archiveName(layer.Digest)
+		entry.Layers = append(entry.Layers, dir+"/layer.tar")
+		blobs = append(blobs, blob{Dir: dir, File: dir + "/layer.tar", URL: shellQuote(layer.URL), Hex: dir})
+	}
+	manifest, err := json.Marshal([]archiveManifest{entry})
+	if err != nil {
+		return err
+	}
+	expires := ""
+	if e := s.Expires(); !e.IsZero() {
+		expires = e.Format(time.RFC3339)
+	}
+	return shareScript.Execute(w, map[string]interface{}{
+		"Image": s.Image, "Tag": s.Tag, "Name": name, "Expires": expires, "Blobs": blobs, "Manifest": string(manifest),
+	})
+}
+
+// shellQuote quotes a word for sh, presigned URLs are full of & and =
+func shellQuote(word string) string {
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,339 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// SharedDigestError is returned when deleting a tag, or a manifest by digest (Tag is empty then), would delete other
+// tags too. A manifest is deleted by its digest, together with every tag pointing to it, e.g. deleting nightly also
+// deletes latest if both point to the same build
+type SharedDigestError struct {
+	Image  string
+	Tag    string
+	Digest string
+	// Tags are the other tags pointing to Digest
+	Tags []string
+}
+
+func (e *SharedDigestError) Error() string {
+	if e.Tag == "" {
+		return fmt.Sprintf("%s@%s is the manifest of %s, deleting it would delete them as well\n"+
+			"Give --force to delete them too", e.Image, e.Digest, strings.Join(e.Tags, ", "))
+	}
+	return fmt.Sprintf("%s:%s shares its manifest %s with %s, deleting it would delete them as well\n"+
+		"Give --force to delete them too or --untag to only delete the tag", e.Image, e.Tag, e.Digest, strings.Join(e.Tags, ", "))
+}
+
+// IsSharedDigest tells if deleting failed because other tags share the manifest
+func IsSharedDigest(err error) bool {
+	_, ok := err.(*SharedDigestError)
+	return ok
+}
+
+// TagDeleter deletes tags of an image without taking other tags along by surprise, and never deletes locked tags.
+// The digests of all tags of the image and the locks are read once, deleting many tags does not cost a listing per tag
+type TagDeleter struct {
+	// Force deletes the manifest even if other tags point to it, they are deleted along
+	Force bool
+	// Untag deletes only the tag, through the components API, if other tags point to its manifest
+	Untag bool
+	// WithReferrers deletes the signatures, attestations and SBOMs attached to the deleted manifests. Untagging keeps
+	// them, the manifest they belong to stays
+	WithReferrers bool
+	// Quarantine, if set, is the quarantine repository the tags are copied to before they are deleted, so they can
+	// be restored, see Registry.Quarantine
+	Quarantine *Registry
+	// DryRun makes the checks of a deletion without deleting: Delete and DeleteDigest return the errors deleting
+	// would, nil for what would be deleted, and print nothing. No hooks run and nothing is recorded on the RunStats
+	DryRun bool
+
+	r        Registry
+	image    string
+	digests  map[string]string
+	selected map[string]bool
+	locked   map[string]Protection
+	// deleted maps the digests deleted so far to the tag they were deleted with, empty if by digest
+	deleted map[string]string
+	// attempt is the deletion the hooks are run for, nil until it is tried
+	attempt *DeleteEvent
+}
+
+// NewTagDeleter prepares deleting the selected tags of image. Selected tags may share a manifest among each other,
+// the first of them deletes it and the others are gone with it
+func (r Registry) NewTagDeleter(image string, selected []string) (*TagDeleter, error) {
+	digests, err := r.TagDigests(image)
+	if err != nil {
+		return nil, err
+	}
+	protections, err := r.Protections()
+	if err != nil {
+		return nil, err
+	}
+	d := &TagDeleter{r: r, image: image, digests: digests, selected: map[string]bool{}, locked: map[string]Protection{}, deleted: map[string]string{}}
+	for _, tag := range selected {
+		d.selected[tag] = true
+	}
+	for _, p := range protections {
+		if p.Image == image {
+			d.locked[p.Tag] = p
+		}
+	}
+	for tag := range digests {
+		if _, ok := d.locked[tag]; !ok && r.ignore.Tag(image, tag) {
+			d.locked[tag] = Protection{Image: image, Tag: tag, Ignored: r.ignore.Path}
+		}
+	}
+	return d, nil
+}
+
+// Keep protects a tag like a lock does, without storing a lock, because the deployment manifest file declares it.
+// With a digest the tags pointing to it are kept, the tag may have moved on
+func (d *TagDeleter) Keep(tag string, digest string, file string) {
+	p := Protection{Image: d.image, Reason: "declared in " + file, Declared: file}
+	for t, other := range d.digests {
+		if t == tag || digest != "" && other == digest {
+			if _, ok := d.locked[t]; !ok {
+				p.Tag = t
+				d.locked[t] = p
+			}
+		}
+	}
+}
+
+// Digest returns the digest a tag of the image pointed to when the deleter was prepared, false if it was not there
+func (d *TagDeleter) Digest(tag string) (string, bool) {
+	digest, ok := d.digests[tag]
+	return digest, ok
+}
+
+// Tags returns the tags of the image pointing to digest, sorted
+func (d *TagDeleter) Tags(digest string) []string {
+	var tags []string
+	for tag, tagDigest := range d.digests {
+		if tagDigest == digest {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Delete deletes a tag. If tags which are not selected point to its manifest, a *SharedDigestError is returned and
+// nothing is deleted, unless Force or Untag is set. Locked tags are never deleted, a *ProtectedError is returned for
+// them and for tags whose manifest a locked tag points to, unless Untag is set. The hooks of the registry run around
+// the deletion, the pre-delete hook returning a *VetoedError if it refuses it. The outcome is recorded on the
+// RunStats of the registry, locked and vetoed tags as skipped
+func (d *TagDeleter) Delete(tag string) error {
+	return d.record(d.delete(tag))
+}
+
+// DeleteDigest deletes a manifest by its digest, e.g. an untagged one fsck reported, without resolving a tag. The
+// tags pointing to it are guarded like those Delete takes along: a *SharedDigestError is returned for them unless
+// Force is set or they are selected, a *ProtectedError if one is locked. Their manifest cannot be untagged, Untag
+// does not apply. Hooks and RunStats are the ones of Delete
+func (d *TagDeleter) DeleteDigest(digest string) error {
+	return d.record(d.deleteDigest(digest))
+}
+
+// record runs the post-delete hook for the outcome of the attempt to delete and records it
+func (d *TagDeleter) record(err error) error {
+	if d.DryRun {
+		return err
+	}
+	if d.attempt != nil {
+		if !IsProtected(err) {
+			d.r.postDelete(d.attempt, err)
+		}
+		d.r.countRun(err)
+	}
+	switch {
+	case IsProtected(err):
+		d.r.runStats.Skipped()
+	case err != nil:
+		d.r.runStats.Failed()
+	default:
+		d.r.runStats.Succeeded()
+	}
+	return err
+}
+
+func (d *TagDeleter) delete(tag string) error {
+	d.attempt = nil
+	if p, ok := d.locked[tag]; ok {
+		return &ProtectedError{Protection: p}
+	}
+	digest, ok := d.digests[tag]
+	if !ok {
+		// not listed (anymore), the registry tells what is wrong with it
+		var err error
+		if digest, err = d.r.getImageSHA(d.image, tag); err != nil {
+			return err
+		}
+	}
+	if by, ok := d.deleted[digest]; ok {
+		if d.DryRun {
+			return nil
+		}
+		if by == "" {
+			fmt.Printf("%s:%s has been deleted along with its manifest %s\n", d.image, tag, digest)
+		} else {
+			fmt.Printf("%s:%s has been deleted along with %s:%s\n", d.image, tag, d.image, by)
+		}
+		return nil
+	}
+
+	// others point to the same manifest, shared are those not selected for deletion
+	var others, shared []string
+	for other, otherDigest := range d.digests {
+		if other != tag && otherDigest == digest {
+			others = append(others, other)
+		}
+	}
+	sort.Strings(others)
+	var locked *Protection
+	for _, other := range others {
+		if p, ok := d.locked[other]; ok && locked == nil {
+			locked = &p
+		}
+		if !d.selected[other] {
+			shared = append(shared, other)
+		}
+	}
+	if locked != nil && !d.Untag {
+		return &ProtectedError{Protection: *locked, Along: tag}
+	}
+	if len(shared) > 0 && !d.Force || locked != nil {
+		if !d.Untag {
+			return &SharedDigestError{Image: d.image, Tag: tag, Digest: digest, Tags: shared}
+		}
+		if d.DryRun {
+			delete(d.digests, tag)
+			return nil
+		}
+		if err := d.preDelete(tag, digest, "untag", nil); err != nil {
+			return err
+		}
+		if err := d.quarantine(digest, []string{tag}); err != nil {
+			return err
+		}
+		if err := d.r.UntagImage(d.image, tag); err != nil {
+			return err
+		}
+		delete(d.digests, tag)
+		fmt.Printf("%s:%s has been untagged, its manifest %s stays for %s\n", d.image, tag, digest, strings.Join(others, ", "))
+		return nil
+	}
+
+	if d.DryRun {
+		d.deleted[digest] = tag
+		return nil
+	}
+	if err := d.preDelete(tag, digest, "delete", others); err != nil {
+		return err
+	}
+	if err := d.quarantine(digest, append([]string{tag}, others...)); err != nil {
+		return err
+	}
+	if d.WithReferrers {
+		if err := d.r.deleteReferrersOf(d.image, digest); err != nil {
+			return err
+		}
+	}
+	if err := d.r.DeleteManifest(d.image, digest); err != nil {
+		return err
+	}
+	d.deleted[digest] = tag
+	fmt.Printf("%s:%s has been successfully deleted\n", d.image, tag)
+	for _, other := range shared {
+		fmt.Printf("%s:%s has been deleted along with it\n", d.image, other)
+	}
+	return nil
+}
+
+func (d *TagDeleter) deleteDigest(digest string) error {
+	d.attempt = nil
+	if by, ok := d.deleted[digest]; ok {
+		if by != "" && !d.DryRun {
+			fmt.Printf("%s@%s has been deleted along with %s:%s\n", d.image, digest, d.image, by)
+		}
+		return nil
+	}
+	var shared []string
+	tags := d.Tags(digest)
+	for _, tag := range tags {
+		if p, ok := d.locked[tag]; ok {
+			return &ProtectedError{Protection: p, Along: digest}
+		}
+		if !d.selected[tag] {
+			shared = append(shared, tag)
+		}
+	}
+	if len(shared) > 0 && !d.Force {
+		return &SharedDigestError{Image: d.image, Digest: digest, Tags: shared}
+	}
+	if d.Quarantine != nil && len(tags) == 0 {
+		return errors.New(fmt.Sprintf("%s@%s has no tag, only tags can be quarantined. Delete it without a quarantine", d.image, digest))
+	}
+
+	if d.DryRun {
+		d.deleted[digest] = ""
+		return nil
+	}
+	if err := d.preDelete("", digest, "delete", tags); err != nil {
+		return err
+	}
+	if err := d.quarantine(digest, tags); err != nil {
+		return err
+	}
+	if d.WithReferrers {
+		if err := d.r.deleteReferrersOf(d.image, digest); err != nil {
+			return err
+		}
+	}
+	if err := d.r.DeleteManifest(d.image, digest); err != nil {
+		return err
+	}
+	// deleted by digest, not with a tag
+	d.deleted[digest] = ""
+	fmt.Printf("%s@%s has been successfully deleted\n", d.image, digest)
+	for _, tag := range tags {
+		fmt.Printf("%s:%s has been deleted along with it\n", d.image, tag)
+	}
+	return nil
+}
+
+// preDelete starts the attempt to delete a tag, running the pre-delete hook
+func (d *TagDeleter) preDelete(tag string, digest string, action string, along []string) error {
+	d.attempt = &DeleteEvent{Host: d.r.Host, Repository: d.r.Repository, Image: d.image, Tag: tag, Digest: digest, Action: action, Along: along}
+	if d.Quarantine != nil {
+		d.attempt.Quarantine = d.Quarantine.Repository
+	}
+	return d.r.preDelete(d.attempt)
+}
+
+// quarantine copies the tags about to be deleted to the quarantine, if there is one
+func (d *TagDeleter) quarantine(digest string, tags []string) error {
+	if d.Quarantine == nil {
+		return nil
+	}
+	return d.Quarantine.QuarantineTags(d.r, d.image, digest, tags)
+}
+
+// UntagImage deletes only the tag, through the components API. Nexus keeps a component per tag, deleting it leaves
+// the manifest and the other tags pointing to it in place
+func (r Registry) UntagImage(image string, tag string) error {
+	components, err := r.SearchComponents(url.Values{"format": {"docker"}, "name": {image}, "version": {tag}})
+	if err != nil {
+		return err
+	}
+	for _, component := range components {
+		if component.Name == image && component.Version == tag {
+			return r.DeleteComponent(component.ID)
+		}
+	}
+	return errors.New(fmt.Sprintf("There is no component for %s:%s in %s, the search index may not be up to date yet", image, tag, r.Repository))
+}
@@ -0,0 +1,276 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/utils"
+)
+
+// Throttle paces the blobs copied into a registry, for mirroring across slow links during business hours: Rate caps
+// the bytes per second, Windows restrict copying to times of the week, each may have a rate of its own. Registries
+// sharing a Throttle share its rate. The methods of a nil *Throttle limit nothing
+type Throttle struct {
+	// Rate is the bytes per second, 0 for no limit
+	Rate int64
+	// Windows are when blobs may be copied, any time without one
+	Windows []Window
+	// Stop fails copies outside of the windows with an *OutsideWindowError instead of waiting for the next window
+	Stop bool
+
+	mu sync.Mutex
+	// due is when the bytes read so far are sent at the rate
+	due time.Time
+}
+
+// Window is a span of the week, e.g. Mon-Fri 19:00-07:00. A span ending before it starts goes past midnight and
+// belongs to the day it starts on, one ending when it starts lasts a whole day
+type Window struct {
+	// Days are the weekdays the window opens on
+	Days [7]bool
+	// From and To are the times of the day
+	From time.Duration
+	To   time.Duration
+	// Rate replaces the rate of the throttle while the window is open, 0 keeps it
+	Rate int64
+}
+
+// OutsideWindowError is returned by copies outside of the windows of a Throttle with Stop
+type OutsideWindowError struct {
+	// Next is when the next window opens
+	Next time.Time
+}
+
+func (e *OutsideWindowError) Error() string {
+	return fmt.Sprintf("Outside of the transfer windows, the next one opens %s", e.Next.Format("Mon 15:04"))
+}
+
+// IsOutsideWindow tells if err is an OutsideWindowError
+func IsOutsideWindow(err error) bool {
+	_, ok := err.(*OutsideWindowError)
+	return ok
+}
+
+// WithThrottle paces the blobs copied into the registry, see Throttle
+func WithThrottle(t *Throttle) Option {
+	return func(r *Registry) {
+		r.throttle = t
+	}
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseWindow parses a window like 22:00-06:00, Mon-Fri 19:00-07:00 or Sat,Sun 00:00-00:00 50MiB: days (every day
+// if not given) as names or ranges of names, the times and a rate in bytes per second
+func ParseWindow(value string) (Window, error) {
+	var w Window
+	fields := strings.Fields(value)
+	if len(fields) > 0 && (fields[0][0] < '0' || fields[0][0] > '9') {
+		if err := w.parseDays(fields[0]); err != nil {
+			return w, errors.New(fmt.Sprintf("Invalid window %q: %s", value, err))
+		}
+		fields = fields[1:]
+	} else {
+		for i := range w.Days {
+			w.Days[i] = true
+		}
+	}
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, errors.New(fmt.Sprintf("Invalid window %q, expected [days] HH:MM-HH:MM [rate], e.g. Mon-Fri 19:00-07:00", value))
+	}
+	times := strings.Split(fields[0], "-")
+	if len(times) != 2 {
+		return w, errors.New(fmt.Sprintf("Invalid window %q, the times are not in the form HH:MM-HH:MM", value))
+	}
+	var err error
+	if w.From, err = parseTimeOfDay(times[0]); err == nil {
+		w.To, err = parseTimeOfDay(times[1])
+	}
+	if err != nil {
+		return w, errors.New(fmt.Sprintf("Invalid window %q: %s", value, err))
+	}
+	if len(fields) == 2 {
+		if w.Rate, err = utils.ParseBytes(fields[1]); err != nil {
+			return w, errors.New(fmt.Sprintf("Invalid window %q: %s", value, err))
+		}
+	}
+	return w, nil
+}
+
+func (w *Window) parseDays(value string) error {
+	for _, part := range strings.Split(strings.ToLower(value), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		from, ok := weekday(bounds[0])
+		if !ok {
+			return errors.New(fmt.Sprintf("unknown day %q", bounds[0]))
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekday(bounds[1]); !ok {
+				return errors.New(fmt.Sprintf("unknown day %q", bounds[1]))
+			}
+		}
+		// ranges may wrap around the week, e.g. Fri-Mon
+		for d := from; ; d = (d + 1) % 7 {
+			w.Days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func weekday(name string) (int, bool) {
+	if len(name) < 3 {
+		return 0, false
+	}
+	for i, day := range weekdays {
+		if strings.HasPrefix(name, day) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, errors.New(fmt.Sprintf("invalid time %q, expected HH:MM", value))
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, errors.New(fmt.Sprintf("invalid time %q, expected HH:MM", value))
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 || hours == 24 && minutes > 0 {
+		return 0, errors.New(fmt.Sprintf("invalid time %q, expected HH:MM", value))
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// contains tells if the window is open at t
+func (w Window) contains(t time.Time) bool {
+	day := int(t.Weekday())
+	since := t.Sub(midnight(t, 0))
+	if w.From < w.To {
+		return w.Days[day] && since >= w.From && since < w.To
+	}
+	return w.Days[day] && since >= w.From || w.Days[(day+6)%7] && since < w.To
+}
+
+// midnight is the start of the day days after the one of t
+func midnight(t time.Time, days int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+days, 0, 0, 0, 0, t.Location())
+}
+
+// window returns the window open at t, ok is true as well if there are no windows
+func (t *Throttle) window(at time.Time) (Window, bool) {
+	if len(t.Windows) == 0 {
+		return Window{}, true
+	}
+	for _, w := range t.Windows {
+		if w.contains(at) {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
+
+// next returns when the next window opens after at
+func (t *Throttle) next(at time.Time) time.Time {
+	var next time.Time
+	for days := 0; days <= 7; days++ {
+		start := midnight(at, days)
+		for _, w := range t.Windows {
+			opens := start.Add(w.From)
+			if w.Days[int(start.Weekday())] && opens.After(at) && (next.IsZero() || opens.Before(next)) {
+				next = opens
+			}
+		}
+	}
+	return next
+}
+
+// Open tells if blobs may be copied now
+func (t *Throttle) Open() bool {
+	if t == nil {
+		return true
+	}
+	_, ok := t.window(time.Now())
+	return ok
+}
+
+// admit returns once blobs may be copied: at once within a window, else it waits for the next one or, with Stop,
+// returns an *OutsideWindowError
+func (t *Throttle) admit() error {
+	if t == nil {
+		return nil
+	}
+	for !t.Open() {
+		next := t.next(time.Now())
+		if t.Stop {
+			return &OutsideWindowError{Next: next}
+		}
+		fmt.Fprintf(os.Stderr, "Outside of the transfer windows, waiting until %s\n", next.Format("Mon 15:04"))
+		time.Sleep(time.Until(next))
+	}
+	return nil
+}
+
+// rate returns the bytes per second at the moment, 0 for no limit
+func (t *Throttle) rate() int64 {
+	if w, _ := t.window(time.Now()); w.Rate > 0 {
+		return w.Rate
+	}
+	return t.Rate
+}
+
+// wait blocks until n more bytes are within the rate
+func (t *Throttle) wait(n int) {
+	rate := t.rate()
+	if rate <= 0 || n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	now := time.Now()
+	// at most a tenth of a second of idle time is saved up, it makes up for sleeping longer than asked
+	if floor := now.Add(-time.Second / 10); t.due.Before(floor) {
+		t.due = floor
+	}
+	t.due = t.due.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	delay := t.due.Sub(now)
+	t.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// reader paces reading content at the rate of the throttle
+func (t *Throttle) reader(content io.Reader) io.Reader {
+	if t == nil {
+		return content
+	}
+	return &throttledReader{content: content, throttle: t}
+}
+
+type throttledReader struct {
+	content  io.Reader
+	throttle *Throttle
+}
+
+// throttleChunk is the most read at once, small enough for an even pace at slow rates
+const throttleChunk = 32 * 1024
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := r.content.Read(p)
+	r.throttle.wait(n)
+	return n, err
+}
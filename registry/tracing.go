@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/eugenmayer/nexus-cli/tracing"
+)
+
+// tracingTransport records a span per request, named after what it does with Nexus: the operation of the registry
+// API (catalog, tags, manifest, blob, upload, referrers) or the REST API, with the image and the tag or digest
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	operation, attributes := describeRequest(req)
+	span := tracing.StartSpan(req.Method+" "+operation, tracing.KindClient)
+	span.SetAttribute("nexus.operation", operation)
+	for key, value := range attributes {
+		span.SetAttribute(key, value)
+	}
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("url.full", req.URL.String())
+	span.SetAttribute("server.address", req.URL.Hostname())
+	if port, err := strconv.Atoi(req.URL.Port()); err == nil {
+		span.SetAttribute("server.port", port)
+	}
+	if req.ContentLength > 0 {
+		span.SetAttribute("http.request.body.size", req.ContentLength)
+	}
+
+	// a RoundTripper must not change the request it is given
+	traced := new(http.Request)
+	*traced = *req
+	traced.Header = make(http.Header, len(req.Header)+1)
+	for name, values := range req.Header {
+		traced.Header[name] = values
+	}
+	span.Inject(traced.Header)
+
+	resp, err := t.next.RoundTrip(traced)
+	if err != nil {
+		span.End(err)
+		return resp, err
+	}
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		span.Fail(resp.Status)
+	}
+	// answers without a body (HEAD, 204, 304) are complete, callers need not close them for the span to end
+	if req.Method == "HEAD" || resp.Body == nil || resp.Body == http.NoBody || resp.ContentLength == 0 {
+		span.End(nil)
+		return resp, nil
+	}
+	// the span lasts until the answer is read
+	resp.Body = &tracedBody{body: resp.Body, span: span}
+	return resp, nil
+}
+
+// describeRequest tells the operation of a request and the attributes naming what it works on
+func describeRequest(req *http.Request) (string, map[string]interface{}) {
+	attributes := map[string]interface{}{}
+	p := req.URL.Path
+	if i := strings.Index(p, "/service/rest/"); i >= 0 {
+		// e.g. v1/components, v1/security/users
+		parts := strings.Split(strings.Trim(p[i+len("/service/rest/"):], "/"), "/")
+		if len(parts) > 1 {
+			parts = parts[1:]
+		}
+		if repository := req.URL.Query().Get("repository"); repository != "" {
+			attributes["nexus.repository"] = repository
+		}
+		return "rest " + parts[0], attributes
+	}
+	i := strings.Index(p, "/repository/")
+	if i < 0 {
+		return "request", attributes
+	}
+	rest := p[i+len("/repository/"):]
+	parts := strings.SplitN(rest, "/", 2)
+	attributes["nexus.repository"] = parts[0]
+	if len(parts) < 2 || !strings.HasPrefix(parts[1], "v2/") {
+		return "download", attributes
+	}
+	api := strings.TrimPrefix(parts[1], "v2/")
+	if api == "_catalog" || api == "" {
+		return "catalog", attributes
+	}
+	operations := []struct{ path, name string }{
+		{"/tags/list", "tags"}, {"/manifests/", "manifest"}, {"/blobs/uploads/", "upload"}, {"/blobs/", "blob"}, {"/referrers/", "referrers"},
+	}
+	for _, operation := range operations {
+		j := strings.LastIndex(api, operation.path)
+		if j < 0 {
+			continue
+		}
+		attributes["nexus.image"] = api[:j]
+		reference := strings.SplitN(api[j+len(operation.path):], "/", 2)[0]
+		switch {
+		case operation.name == "upload" || operation.name == "tags":
+			// the rest is the ID of an upload or list, no version of the image
+		case strings.Contains(reference, ":"):
+			attributes["nexus.digest"] = reference
+		case reference != "":
+			attributes["nexus.tag"] = reference
+		}
+		return operation.name, attributes
+	}
+	return "registry", attributes
+}
+
+// tracedBody ends the span of a request once the answer is read or closed, with the number of bytes read
+type tracedBody struct {
+	body  io.ReadCloser
+	span  *tracing.Span
+	read  int64
+	ended bool
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.read += int64(n)
+	switch {
+	case err == io.EOF:
+		b.end()
+	case err != nil:
+		b.span.Fail(err.Error())
+		b.end()
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	b.end()
+	return b.body.Close()
+}
+
+func (b *tracedBody) end() {
+	if b.ended {
+		return
+	}
+	b.ended = true
+	b.span.SetAttribute("http.response.body.size", b.read)
+	b.span.End(nil)
+}
+
+// traced returns a copy of client recording spans of its requests
+func traced(client *http.Client) *http.Client {
+	copied := *client
+	next := copied.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	copied.Transport = tracingTransport{next: next}
+	return &copied
+}
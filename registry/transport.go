@@ -0,0 +1,238 @@
+package registry
+
+import (
+	"compress/gzip"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Protocols of the http_protocol setting
+const (
+	// ProtocolAuto speaks HTTP/2 with servers offering it over TLS and HTTP/1.1 with the others
+	ProtocolAuto = "auto"
+	// ProtocolHTTP1 always speaks HTTP/1.1, for proxies and load balancers breaking HTTP/2 connections (GOAWAY)
+	ProtocolHTTP1 = "http1"
+)
+
+var (
+	// protocol is the --http-protocol flag, overriding the http_protocol of the profiles
+	protocol string
+	// verbose logs every request to stderr
+	verbose bool
+
+	clientsMu sync.Mutex
+	clients   = map[string]*http.Client{}
+)
+
+// ConfigureProtocol applies the --http-protocol flag, empty leaves the choice to the profile
+func ConfigureProtocol(p string) {
+	protocol = p
+}
+
+// ConfigureVerbose applies the --verbose flag: every request is logged to stderr with its answer, the protocol
+// negotiated and the time it took
+func ConfigureVerbose(enabled bool) {
+	verbose = enabled
+}
+
+// ValidProtocol tells if p is a value of http_protocol, empty being ProtocolAuto
+func ValidProtocol(p string) bool {
+	return p == "" || p == ProtocolAuto || p == ProtocolHTTP1
+}
+
+// Protocol returns the protocol requests to the registry are sent with
+func (r Registry) Protocol() string {
+	p := r.HTTPProtocol
+	if protocol != "" {
+		p = protocol
+	}
+	if p == "" {
+		return ProtocolAuto
+	}
+	return p
+}
+
+// sharedClient returns the client of the protocol. All registries of the process share it and its connections
+func sharedClient(p string) *http.Client {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if client, ok := clients[p]; ok {
+		return client
+	}
+	client := &http.Client{Transport: compressionTransport{next: newTransport(p)}}
+	clients[p] = client
+	return client
+}
+
+// newTransport creates a transport like http.DefaultTransport, keeping enough idle connections per host for the
+// parallel requests of crawls
+func newTransport(p string) *http.Transport {
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2: true,
+		// compressionTransport decides what is worth compressing
+		DisableCompression:    true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   crawlWorkers,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	if p == ProtocolHTTP1 {
+		// an empty, non nil map keeps the transport from offering h2 in the TLS handshake
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+// loggingTransport logs the requests it sends for --verbose
+type loggingTransport struct {
+	next http.RoundTripper
+}
+
+func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	took := time.Since(started).Round(time.Millisecond)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s (%s)\n", req.Method, req.URL, err, took)
+		return resp, err
+	}
+	encoding := ""
+	if decoded := decodedEncoding(resp.Body); decoded != "" {
+		encoding = ", " + decoded
+	}
+	fmt.Fprintf(os.Stderr, "%s %s: %s %s (%s%s)\n", req.Method, req.URL, resp.Proto, resp.Status, took, encoding)
+	return resp, nil
+}
+
+// compressionTransport asks for zstd or gzip where it pays: the JSON of the REST API (searches of thousands of
+// components), catalogs and tag lists. Nexus answers gzip, zstd is taken where a proxy in front of it offers it. Layers
+// are compressed already and compressing them again only costs Nexus CPU, blobs and manifests are fetched as they are.
+// Answers are decoded transparently, like http.Transport does
+type compressionTransport struct {
+	next http.RoundTripper
+}
+
+func (t compressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !compressible(req) {
+		return t.next.RoundTrip(req)
+	}
+	// a RoundTripper must not change the request it is given
+	compressed := new(http.Request)
+	*compressed = *req
+	compressed.Header = make(http.Header, len(req.Header)+1)
+	for name, values := range req.Header {
+		compressed.Header[name] = values
+	}
+	compressed.Header.Set("Accept-Encoding", "zstd, gzip")
+
+	resp, err := t.next.RoundTrip(compressed)
+	if err != nil {
+		return resp, err
+	}
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip":
+		resp.Body = &gzipBody{body: resp.Body}
+	case "zstd":
+		resp.Body = &zstdBody{body: resp.Body}
+	default:
+		return resp, nil
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+func compressible(req *http.Request) bool {
+	if req.Method != "GET" || req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" {
+		return false
+	}
+	p := req.URL.Path
+	return strings.Contains(p, "/service/rest/") || strings.HasSuffix(p, "/v2/_catalog") || strings.HasSuffix(p, "/tags/list")
+}
+
+// gzipBody decodes a gzip compressed body, starting with the first read so errors of the header surface there
+type gzipBody struct {
+	body io.ReadCloser
+	zr   *gzip.Reader
+	err  error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.zr == nil && b.err == nil {
+		b.zr, b.err = gzip.NewReader(b.body)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.zr.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	return b.body.Close()
+}
+
+// zstdBody decodes a zstd compressed body like gzipBody does. Closing it releases the goroutines of the decoder
+type zstdBody struct {
+	body io.ReadCloser
+	zr   *zstd.Decoder
+	err  error
+}
+
+func (b *zstdBody) Read(p []byte) (int, error) {
+	if b.zr == nil && b.err == nil {
+		b.zr, b.err = zstd.NewReader(b.body, zstd.WithDecoderConcurrency(1))
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.zr.Read(p)
+}
+
+func (b *zstdBody) Close() error {
+	if b.zr != nil {
+		b.zr.Close()
+	}
+	return b.body.Close()
+}
+
+// decodedEncoding tells the encoding compressionTransport decoded body from, empty if it came as it is
+func decodedEncoding(body io.ReadCloser) string {
+	switch b := body.(type) {
+	case *gzipBody:
+		return "gzip"
+	case *zstdBody:
+		return "zstd"
+	case *tracedBody:
+		return decodedEncoding(b.body)
+	}
+	return ""
+}
+
+// logged returns a copy of client logging its requests
+func logged(client *http.Client) *http.Client {
+	copied := *client
+	next := copied.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	copied.Transport = loggingTransport{next: next}
+	return &copied
+}
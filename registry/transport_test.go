@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// TestCompressionTransport checks tag lists are asked compressed and decoded from what the server chose, and
+// manifests are fetched as they are
+func TestCompressionTransport(t *testing.T) {
+	const tags = `{"name": "team/app", "tags": ["1.0", "1.1"]}`
+	var encoding string
+	var accepted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		accepted = append(accepted, req.Header.Get("Accept-Encoding"))
+		if !strings.HasSuffix(req.URL.Path, "/tags/list") {
+			w.Write([]byte(tags))
+			return
+		}
+		var body bytes.Buffer
+		switch encoding {
+		case "gzip":
+			zw := gzip.NewWriter(&body)
+			zw.Write([]byte(tags))
+			zw.Close()
+		case "zstd":
+			zw, err := zstd.NewWriter(&body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zw.Write([]byte(tags))
+			zw.Close()
+		default:
+			body.WriteString(tags)
+		}
+		if encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		w.Write(body.Bytes())
+	}))
+	defer srv.Close()
+	r := New(srv.URL, WithRepository("docker-hosted"))
+
+	for _, encoding = range []string{"zstd", "gzip", ""} {
+		accepted = nil
+		got, err := r.ListTagsByImage("team/app")
+		if err != nil {
+			t.Fatalf("tags encoded %q: %s", encoding, err)
+		}
+		if want := []string{"1.0", "1.1"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("tags encoded %q: %q, want %q", encoding, got, want)
+		}
+		if want := []string{"zstd, gzip"}; !reflect.DeepEqual(accepted, want) {
+			t.Errorf("tags encoded %q: Accept-Encoding %q, want %q", encoding, accepted, want)
+		}
+	}
+
+	accepted = nil
+	req, err := http.NewRequest("GET", srv.URL+"/repository/docker-hosted/v2/team/app/manifests/1.0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(accepted) != 1 || accepted[0] != "" {
+		t.Errorf("manifest asked with Accept-Encoding %q, want none", accepted)
+	}
+}
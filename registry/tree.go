@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"sort"
+	"strings"
+)
+
+// NamespaceNode is a namespace of the catalog, an image or both: team/app is an image and the namespace of
+// team/app/debug. Images, Tags and Bytes add up the images at and below the node
+type NamespaceNode struct {
+	// Name is the last component of Path, both are empty for the root
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Image bool   `json:"image,omitempty"`
+	// Images, Tags and Bytes are those of the node and everything below it. Bytes is the size of the distinct blobs
+	// they reference, layers shared between images count once
+	Images   int              `json:"images"`
+	Tags     int              `json:"tags"`
+	Bytes    int64            `json:"bytes"`
+	Children []*NamespaceNode `json:"children,omitempty"`
+}
+
+// NamespaceTree groups the images of the inventory by namespace, the children of each node sorted by name. blobs
+// are the blobs of the images with their sizes as ImageBlobs returns them, nil leaves the sizes 0
+func NamespaceTree(inventory Inventory, blobs map[string]map[string]int64) *NamespaceNode {
+	root := &NamespaceNode{}
+	nodes := map[string]*NamespaceNode{"": root}
+	sizes := map[*NamespaceNode]map[string]int64{}
+	images := make([]string, 0, len(inventory))
+	for image := range inventory {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+
+	for _, image := range images {
+		path := []*NamespaceNode{root}
+		parent := root
+		components := strings.Split(image, "/")
+		for i, component := range components {
+			p := strings.Join(components[:i+1], "/")
+			node, ok := nodes[p]
+			if !ok {
+				node = &NamespaceNode{Name: component, Path: p}
+				nodes[p] = node
+				parent.Children = append(parent.Children, node)
+			}
+			path = append(path, node)
+			parent = node
+		}
+		parent.Image = true
+		for _, node := range path {
+			node.Images++
+			node.Tags += len(inventory[image])
+			if sizes[node] == nil {
+				sizes[node] = map[string]int64{}
+			}
+			for blob, size := range blobs[image] {
+				sizes[node][blob] = size
+			}
+		}
+	}
+
+	for node, blobs := range sizes {
+		for _, size := range blobs {
+			node.Bytes += size
+		}
+	}
+	for _, node := range nodes {
+		sort.Slice(node.Children, func(i, j int) bool { return node.Children[i].Name < node.Children[j].Name })
+	}
+	return root
+}
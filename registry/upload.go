@@ -0,0 +1,353 @@
+package registry
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// uploadAttribute is a coordinate of a component or an asset taken by the components API upload. Its form field is
+// <format>.<name> for the component, <format>.asset.<name> (or .assetN.) for an asset
+type uploadAttribute struct {
+	name     string
+	required bool
+	// local attributes are checked against the files by check instead of being sent
+	local bool
+	// derive defaults the attribute of an asset from its file, nil if it has no default
+	derive func(path string, attributes map[string]string) string
+}
+
+// uploadFormat is what the upload form of a format takes
+type uploadFormat struct {
+	// multiple is set for formats taking several assets as one component, sent as <format>.asset1, .asset2 ...
+	multiple  bool
+	component []uploadAttribute
+	asset     []uploadAttribute
+	// check validates the attributes against a file, e.g. that an npm package has the scope given
+	check func(path string, attributes map[string]string) error
+}
+
+var uploadFormats = map[string]uploadFormat{
+	"maven2": {
+		multiple: true,
+		component: []uploadAttribute{
+			{name: "groupId"}, {name: "artifactId"}, {name: "version"}, {name: "packaging"}, {name: "generate-pom"},
+		},
+		asset: []uploadAttribute{
+			{name: "extension", required: true, derive: mavenExtension},
+			{name: "classifier", derive: mavenClassifier},
+		},
+	},
+	"raw": {
+		multiple:  true,
+		component: []uploadAttribute{{name: "directory", required: true}},
+		asset:     []uploadAttribute{{name: "filename", required: true, derive: baseName}},
+	},
+	"yum": {
+		component: []uploadAttribute{{name: "directory"}},
+		asset:     []uploadAttribute{{name: "filename", required: true, derive: baseName}},
+	},
+	"r": {
+		asset: []uploadAttribute{{name: "pathId", required: true}},
+	},
+	"npm": {
+		component: []uploadAttribute{{name: "scope", local: true}},
+		check:     npmScope,
+	},
+	"apt":      {},
+	"pypi":     {},
+	"nuget":    {},
+	"helm":     {},
+	"rubygems": {},
+}
+
+// UploadAsset is a file of an upload and the form field it is sent as
+type UploadAsset struct {
+	Field string
+	Path  string
+}
+
+// Upload is one request to the components API: the assets of a component and the form fields of its coordinates
+type Upload struct {
+	Assets []UploadAsset
+	Fields map[string]string
+}
+
+// PrepareUploads checks the attributes given for uploading files to a repository of the format and turns them into
+// the uploads: raw and maven2 upload all files as the assets of one component, the other formats one component per
+// file. Attributes of the assets not given are derived from their files, e.g. the extension and classifier of maven
+// artifacts from app-1.0-sources.jar, the filename of raw assets
+func PrepareUploads(format string, paths []string, attributes map[string]string) ([]Upload, error) {
+	spec, ok := uploadFormats[format]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Uploading %s components is not supported", format))
+	}
+	known := map[string]bool{}
+	for _, a := range append(append([]uploadAttribute{}, spec.component...), spec.asset...) {
+		known[a.name] = true
+	}
+	var names []string
+	for name := range attributes {
+		if !known[name] {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		return nil, errors.New(fmt.Sprintf("%s components have no attribute %s, they take: %s", format, strings.Join(names, ", "), spec.attributeNames()))
+	}
+	// attributes of the assets would be the same for all of them
+	if spec.multiple && len(paths) > 1 {
+		for _, a := range spec.asset {
+			if _, ok := attributes[a.name]; ok {
+				return nil, errors.New(fmt.Sprintf("%s is set per file, upload the files one by one to give it", a.name))
+			}
+		}
+	}
+
+	fields := map[string]string{}
+	for _, a := range spec.component {
+		value, ok := attributes[a.name]
+		if !ok && a.required {
+			return nil, errors.New(fmt.Sprintf("%s components need --attr %s=...", format, a.name))
+		}
+		if ok && !a.local {
+			fields[format+"."+a.name] = value
+		}
+	}
+	if format == "maven2" {
+		if err := mavenCoordinates(paths, attributes); err != nil {
+			return nil, err
+		}
+	}
+
+	var uploads []Upload
+	for i, path := range paths {
+		if spec.check != nil {
+			if err := spec.check(path, attributes); err != nil {
+				return nil, err
+			}
+		}
+		field := format + ".asset"
+		if spec.multiple {
+			field += strconv.Itoa(i + 1)
+		}
+		if i == 0 || !spec.multiple {
+			copied := map[string]string{}
+			for name, value := range fields {
+				copied[name] = value
+			}
+			uploads = append(uploads, Upload{Fields: copied})
+		}
+		upload := &uploads[len(uploads)-1]
+		upload.Assets = append(upload.Assets, UploadAsset{Field: field, Path: path})
+		for _, a := range spec.asset {
+			value, ok := attributes[a.name]
+			if !ok && a.derive != nil {
+				value = a.derive(path, attributes)
+			}
+			if value == "" && a.required {
+				return nil, errors.New(fmt.Sprintf("%s needs --attr %s=...", filepath.Base(path), a.name))
+			}
+			if value != "" {
+				upload.Fields[field+"."+a.name] = value
+			}
+		}
+	}
+	return uploads, nil
+}
+
+func (f uploadFormat) attributeNames() string {
+	var names []string
+	for _, a := range append(append([]uploadAttribute{}, f.component...), f.asset...) {
+		names = append(names, a.name)
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+func baseName(path string, _ map[string]string) string {
+	return filepath.Base(path)
+}
+
+// mavenCoordinates requires groupId, artifactId and version, unless a pom is uploaded which has them
+func mavenCoordinates(paths []string, attributes map[string]string) error {
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".pom") {
+			return nil
+		}
+	}
+	for _, name := range []string{"groupId", "artifactId", "version"} {
+		if attributes[name] == "" {
+			return errors.New(fmt.Sprintf("maven2 components need --attr %s=... unless a .pom is uploaded along", name))
+		}
+	}
+	return nil
+}
+
+// mavenExtension is what the file name ends with after the artifact and version, jar of app-1.0.jar and tar.gz of
+// app-1.0-dist.tar.gz
+func mavenExtension(path string, attributes map[string]string) string {
+	name := filepath.Base(path)
+	if prefix := attributes["artifactId"] + "-" + attributes["version"]; attributes["artifactId"] != "" && strings.HasPrefix(name, prefix) {
+		name = strings.TrimPrefix(name, prefix)
+		if i := strings.Index(name, "."); i >= 0 {
+			return name[i+1:]
+		}
+		return ""
+	}
+	if strings.HasSuffix(name, ".tar.gz") {
+		return "tar.gz"
+	}
+	return strings.TrimPrefix(filepath.Ext(name), ".")
+}
+
+// mavenClassifier is the part between version and extension of the file name, sources of app-1.0-sources.jar
+func mavenClassifier(path string, attributes map[string]string) string {
+	prefix := attributes["artifactId"] + "-" + attributes["version"] + "-"
+	name := filepath.Base(path)
+	if attributes["artifactId"] == "" || !strings.HasPrefix(name, prefix) {
+		return ""
+	}
+	name = strings.TrimPrefix(name, prefix)
+	if i := strings.Index(name, "."); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// npmScope checks that the package has the scope given with the scope attribute, Nexus takes the name (and scope)
+// from the package.json of the tarball
+func npmScope(path string, attributes map[string]string) error {
+	scope, ok := attributes["scope"]
+	if !ok {
+		return nil
+	}
+	name, err := npmPackageName(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s is no npm package: %s", path, err))
+	}
+	scope = "@" + strings.TrimPrefix(scope, "@")
+	if !strings.HasPrefix(name, scope+"/") {
+		return errors.New(fmt.Sprintf("%s is the package %s, not of the scope %s. Set the scope in the name of its package.json", path, name, scope))
+	}
+	return nil
+}
+
+// npmPackageName reads the name of the package.json of an npm tarball
+func npmPackageName(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return "", errors.New("it has no package/package.json")
+		}
+		if err != nil {
+			return "", err
+		}
+		if header.Name != "package/package.json" {
+			continue
+		}
+		var manifest struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+			return "", err
+		}
+		return manifest.Name, nil
+	}
+}
+
+// Upload sends an upload prepared by PrepareUploads to the components API of the repository
+func (r Registry) Upload(upload Upload) error {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, asset := range upload.Assets {
+		f, err := os.Open(asset.Path)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+
+	// stream the form instead of buffering packages in memory
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		err := writeComponentForm(form, upload, files)
+		writer.CloseWithError(err)
+	}()
+
+	uploadURL := fmt.Sprintf("%s/service/rest/v1/components?repository=%s", r.Host, url.QueryEscape(r.Repository))
+	resp, err := r.do("POST", uploadURL, "application/json", form.FormDataContentType(), body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 204 && resp.StatusCode != 200 {
+		return r.newError(resp)
+	}
+	return nil
+}
+
+func writeComponentForm(form *multipart.Writer, upload Upload, files []*os.File) error {
+	for name, value := range upload.Fields {
+		if err := form.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+	for i, asset := range upload.Assets {
+		part, err := form.CreateFormFile(asset.Field, filepath.Base(asset.Path))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, files[i]); err != nil {
+			return err
+		}
+	}
+	return form.Close()
+}
+
+// RepositoryFormat returns the format of a hosted repository, the components API only uploads to those
+func (r Registry) RepositoryFormat(repository string) (string, error) {
+	repositories, err := r.Repositories()
+	if err != nil {
+		return "", err
+	}
+	for _, info := range repositories {
+		if info.Name != repository {
+			continue
+		}
+		if info.Type != "hosted" {
+			return "", errors.New(fmt.Sprintf("%s is a %s repository, components are uploaded to hosted repositories", repository, info.Type))
+		}
+		return info.Format, nil
+	}
+	return "", errors.New(fmt.Sprintf("repository %s does not exist on %s", repository, r.Host))
+}
@@ -0,0 +1,39 @@
+package registry
+
+import "sync"
+
+// ImageBlobs returns the blobs the tags of each image of the inventory reference, with their sizes. Manifests count
+// as blobs, a blob referenced by several tags of an image is there once. Tags pointing to the same manifest are read
+// once, all through the crawler of the registry
+func (r Registry) ImageBlobs(inventory Inventory) (map[string]map[string]int64, error) {
+	type ref struct{ image, digest string }
+	var refs []ref
+	for image, tags := range inventory {
+		seen := map[string]bool{}
+		for _, digest := range tags {
+			if !seen[digest] {
+				seen[digest] = true
+				refs = append(refs, ref{image, digest})
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	usage := make(map[string]map[string]int64, len(inventory))
+	for image := range inventory {
+		usage[image] = map[string]int64{}
+	}
+	err := r.Crawler().RunLabeled(len(refs), func(i int) string { return refs[i].image + "@" + refs[i].digest }, func(i int) error {
+		_, blobs, err := r.manifestBlobs(refs[i].image, refs[i].digest)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for blob, size := range blobs {
+			usage[refs[i].image][blob] = size
+		}
+		return nil
+	})
+	return usage, err
+}
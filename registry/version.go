@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// Version is the version of a Nexus server
+type Version struct {
+	Major, Minor, Patch int
+	// Edition is OSS or PRO, if the server tells
+	Edition string
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast tells if v is the same as or newer than other
+func (v Version) AtLeast(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor > other.Minor
+	}
+	return v.Patch >= other.Patch
+}
+
+// Feature is a part of the Nexus API nexus-cli relies on which not all Nexus 3 releases provide
+type Feature struct {
+	Name  string
+	Since Version
+}
+
+var (
+	// FeatureSearchAPI is the search API used to find assets, it left beta with 3.9
+	FeatureSearchAPI = Feature{Name: "search API", Since: Version{Major: 3, Minor: 9}}
+	// FeatureAssetTimestamps are the lastModified / lastDownloaded fields of assets, needed by last-download rules
+	FeatureAssetTimestamps = Feature{Name: "asset download times", Since: Version{Major: 3, Minor: 28}}
+	// FeatureScriptAPI is the script API at /service/rest/v1/script, before 3.8 it was served below /service/siesta
+	FeatureScriptAPI = Feature{Name: "script API", Since: Version{Major: 3, Minor: 8}}
+	// FeatureInvalidateCache is the invalidate-cache endpoint of the repositories API
+	FeatureInvalidateCache = Feature{Name: "invalidate cache", Since: Version{Major: 3, Minor: 20}}
+	// FeatureRepositorySettings reads and writes the settings of a repository, e.g. its cleanup policies
+	FeatureRepositorySettings = Feature{Name: "repository settings API", Since: Version{Major: 3, Minor: 29}}
+	// FeatureCleanupPolicies is the REST API managing cleanup policies, before only the UI could
+	FeatureCleanupPolicies = Feature{Name: "cleanup policies API", Since: Version{Major: 3, Minor: 49}}
+)
+
+// Features lists all features which are checked against the server version
+var Features = []Feature{FeatureSearchAPI, FeatureAssetTimestamps, FeatureScriptAPI, FeatureInvalidateCache, FeatureRepositorySettings, FeatureCleanupPolicies}
+
+// Nexus answers with "Server: Nexus/3.38.1-01 (OSS)" unless the header is disabled
+var serverHeader = regexp.MustCompile(`^Nexus/(\d+)\.(\d+)\.(\d+)\S*(?: \((\w+)\))?`)
+
+// ParseVersion parses the Server header of a Nexus response
+func ParseVersion(header string) (Version, error) {
+	m := serverHeader.FindStringSubmatch(header)
+	if m == nil {
+		return Version{}, errors.New(fmt.Sprintf("not a Nexus server header: %q", header))
+	}
+	var v Version
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	v.Patch, _ = strconv.Atoi(m[3])
+	v.Edition = m[4]
+	return v, nil
+}
+
+type detectedVersion struct {
+	version Version
+	known   bool
+}
+
+var (
+	versionsLock sync.Mutex
+	versions     = map[string]detectedVersion{}
+)
+
+// ServerVersion asks the server for its version on first use and caches the answer per host. known is false if the
+// server hides its version (e.g. behind a proxy rewriting the Server header)
+func (r Registry) ServerVersion() (version Version, known bool, err error) {
+	versionsLock.Lock()
+	defer versionsLock.Unlock()
+	if detected, ok := versions[r.Host]; ok {
+		return detected.version, detected.known, nil
+	}
+
+	resp, err := r.do("GET", fmt.Sprintf("%s/service/rest/v1/status", r.Host), "", "", nil)
+	if err != nil {
+		return Version{}, false, err
+	}
+	resp.Body.Close()
+
+	var detected detectedVersion
+	if v, err := ParseVersion(resp.Header.Get("Server")); err == nil {
+		detected = detectedVersion{version: v, known: true}
+	}
+	versions[r.Host] = detected
+	return detected.version, detected.known, nil
+}
+
+// Supports tells if the server provides the feature. Servers hiding their version are assumed to support everything
+func (r Registry) Supports(feature Feature) (bool, error) {
+	version, known, err := r.ServerVersion()
+	if err != nil || !known {
+		return true, err
+	}
+	return version.AtLeast(feature.Since), nil
+}
+
+// Require returns an error naming the required release if the server does not provide the feature
+func (r Registry) Require(feature Feature) error {
+	version, known, err := r.ServerVersion()
+	if err != nil {
+		return err
+	}
+	if known && !version.AtLeast(feature.Since) {
+		return errors.New(fmt.Sprintf("%s requires Nexus >= %s, %s runs %s", feature.Name, feature.Since, r.Host, version))
+	}
+	return nil
+}
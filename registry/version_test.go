@@ -0,0 +1,60 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRequire checks the APIs missing in a release are refused naming the release needed, without calling them
+func TestRequire(t *testing.T) {
+	var called []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server", "Nexus/3.25.0-03 (OSS)")
+		if req.URL.Path != "/service/rest/v1/status" {
+			called = append(called, req.Method+" "+req.URL.Path)
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	r := New(srv.URL, WithRepository("go-proxy"))
+
+	tests := []struct {
+		call func() error
+		want string
+	}{
+		{func() error { _, err := r.CleanupPolicies(); return err }, "cleanup policies API requires Nexus >= 3.49.0"},
+		{func() error { _, err := r.CleanupPolicy("weekly"); return err }, "cleanup policies API requires Nexus >= 3.49.0"},
+		{func() error { return r.CreateCleanupPolicy(CleanupPolicy{Name: "weekly"}) }, "cleanup policies API requires Nexus >= 3.49.0"},
+		{func() error { return r.UpdateCleanupPolicy(CleanupPolicy{Name: "weekly"}) }, "cleanup policies API requires Nexus >= 3.49.0"},
+		{func() error { _, err := r.SetCleanupPolicies("go-proxy", []string{"weekly"}, true); return err }, "repository settings API requires Nexus >= 3.29.0"},
+		{func() error { return r.InvalidateCache() }, ""},
+		{func() error { return r.DeleteScript("cleanup") }, ""},
+	}
+	for i, test := range tests {
+		err := test.call()
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%d: %s, want the API of 3.25 to be called", i, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%d: %v, want %q", i, err, test.want)
+		}
+	}
+	if want := []string{"POST /service/rest/v1/repositories/go-proxy/invalidate-cache", "DELETE /service/rest/v1/script/cleanup"}; strings.Join(called, ", ") != strings.Join(want, ", ") {
+		t.Errorf("called %q, want only %q", called, want)
+	}
+
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server", "Nexus/3.7.1-02 (OSS)")
+	}))
+	defer old.Close()
+	r = New(old.URL, WithRepository("go-proxy"))
+	if _, err := r.RunScript("cleanup", ""); err == nil || !strings.Contains(err.Error(), "script API requires Nexus >= 3.8.0") {
+		t.Errorf("RunScript on 3.7.1: %v", err)
+	}
+	if err := r.InvalidateCache(); err == nil || !strings.Contains(err.Error(), "invalidate cache requires Nexus >= 3.20.0") {
+		t.Errorf("InvalidateCache on 3.7.1: %v", err)
+	}
+}
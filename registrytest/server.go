@@ -0,0 +1,493 @@
+// Package registrytest serves docker repositories of Nexus from memory, so programs using the registry package (and
+// nexus-cli itself) are tested end to end without a Nexus instance:
+//
+//	srv := registrytest.NewServer()
+//	defer srv.Close()
+//	srv.PushImage("docker-hosted", "team/app", "1.0.0", registrytest.Image{})
+//	r := srv.Registry("docker-hosted")
+//	tags, err := r.ListTagsByImage("team/app")
+//
+// It implements what nexus-cli uses of the registry API, the way Nexus does: the catalog and tag lists page by page,
+// manifests by tag and digest, blobs and their uploads, and deletes of manifests by digest, which delete the tags
+// pointing to them as well. Of the REST API it serves the repositories list and the status the version of Nexus is
+// read from
+package registrytest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/registry"
+)
+
+// Server is an in-memory Nexus. Its fields are set before the first request
+type Server struct {
+	*httptest.Server
+	// Username and Password are required as basic auth if Username is set
+	Username string
+	Password string
+	// Version is the version of Nexus the Server header tells, e.g. 3.68.1-02
+	Version string
+	// PageSize caps the entries of a catalog or tag list page, 0 sends all at once unless the client asks for fewer
+	PageSize int
+
+	mu           sync.Mutex
+	repositories map[string]*repository
+	uploads      map[string]*upload
+	requests     []string
+	nextUpload   int
+}
+
+// Image is what PushImage builds an image of. Layers are the content of its layers, one small layer by default
+type Image struct {
+	Created time.Time
+	Labels  map[string]string
+	Layers  [][]byte
+}
+
+type repository struct {
+	info      registry.RepositoryInfo
+	blobs     map[string][]byte
+	manifests map[string]map[string]manifest
+	tags      map[string]map[string]string
+}
+
+type manifest struct {
+	mediaType string
+	content   []byte
+}
+
+type upload struct {
+	repository string
+	content    bytes.Buffer
+}
+
+// NewServer starts a server with hosted docker repositories of the names given, docker-hosted if none is
+func NewServer(repositories ...string) *Server {
+	s := &Server{Version: "3.68.1-02", repositories: map[string]*repository{}, uploads: map[string]*upload{}}
+	if len(repositories) == 0 {
+		repositories = []string{"docker-hosted"}
+	}
+	for _, name := range repositories {
+		s.AddRepository(name, "docker", "hosted")
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// AddRepository adds a repository of the format and type (hosted, proxy or group), only hosted docker ones take
+// pushes and deletes
+func (s *Server) AddRepository(name string, format string, kind string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repositories[name] = &repository{
+		info:      registry.RepositoryInfo{Name: name, Format: format, Type: kind},
+		blobs:     map[string][]byte{},
+		manifests: map[string]map[string]manifest{},
+		tags:      map[string]map[string]string{},
+	}
+}
+
+// Registry returns a client of a repository of the server
+func (s *Server) Registry(repository string) registry.Registry {
+	return registry.New(s.URL, registry.WithBasicAuth(s.Username, s.Password), registry.WithRepository(repository))
+}
+
+// PushImage stores an image as docker manifest with its config and layers and tags it, returning the digest of the
+// manifest
+func (s *Server) PushImage(repository string, image string, tag string, img Image) string {
+	created := img.Created
+	if created.IsZero() {
+		created = time.Now().UTC()
+	}
+	layers := img.Layers
+	if len(layers) == 0 {
+		layers = [][]byte{[]byte(image + ":" + tag)}
+	}
+	config := map[string]interface{}{
+		"created": created, "architecture": "amd64", "os": "linux",
+		"config": map[string]interface{}{"Labels": img.Labels},
+		"rootfs": map[string]interface{}{"type": "layers", "diff_ids": []string{}},
+	}
+	configContent, _ := json.Marshal(config)
+	m := registry.ImageManifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeDockerManifest,
+		Config:        s.blob(repository, "application/vnd.docker.container.image.v1+json", configContent),
+	}
+	for _, layer := range layers {
+		m.Layers = append(m.Layers, s.blob(repository, "application/vnd.docker.image.rootfs.diff.tar.gzip", layer))
+	}
+	content, _ := json.Marshal(m)
+	return s.PutManifest(repository, image, tag, registry.MediaTypeDockerManifest, content)
+}
+
+func (s *Server) blob(repository string, mediaType string, content []byte) registry.LayerInfo {
+	return registry.LayerInfo{MediaType: mediaType, Size: int64(len(content)), Digest: s.PutBlob(repository, content)}
+}
+
+// PutBlob stores a blob in the repository, returning its digest
+func (s *Server) PutBlob(repository string, content []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := digest(content)
+	s.repository(repository).blobs[d] = content
+	return d
+}
+
+// PutManifest stores a manifest (or index) of image, reference is a tag or empty to leave it untagged. Returns the
+// digest of the manifest
+func (s *Server) PutManifest(repository string, image string, reference string, mediaType string, content []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.repository(repository).put(image, reference, mediaType, content)
+}
+
+// Images returns the images of a repository which have manifests, sorted
+func (s *Server) Images(repository string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.repository(repository).images()
+}
+
+// Tags returns the tags of an image, sorted
+func (s *Server) Tags(repository string, image string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tags []string
+	for tag := range s.repository(repository).tags[image] {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Requests returns the requests served so far, as "METHOD path?query"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// repository returns a repository the test set up, which has to exist
+func (s *Server) repository(name string) *repository {
+	repo, ok := s.repositories[name]
+	if !ok {
+		panic("registrytest: there is no repository " + name)
+	}
+	return repo
+}
+
+func (repo *repository) put(image string, reference string, mediaType string, content []byte) string {
+	d := digest(content)
+	if repo.manifests[image] == nil {
+		repo.manifests[image] = map[string]manifest{}
+		repo.tags[image] = map[string]string{}
+	}
+	repo.manifests[image][d] = manifest{mediaType: mediaType, content: content}
+	if reference != "" && !strings.HasPrefix(reference, "sha256:") {
+		repo.tags[image][reference] = d
+	}
+	return d
+}
+
+func (repo *repository) images() []string {
+	var images []string
+	for image, manifests := range repo.manifests {
+		if len(manifests) > 0 {
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+	return images
+}
+
+func digest(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, strings.TrimSuffix(req.Method+" "+req.URL.RequestURI(), "?"))
+	w.Header().Set("Server", "Nexus/"+s.Version+" (OSS)")
+	if s.Username != "" {
+		if username, password, ok := req.BasicAuth(); !ok || username != s.Username || password != s.Password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Sonatype Nexus Repository Manager"`)
+			registryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "access to the requested resource is not authorized")
+			return
+		}
+	}
+	path := req.URL.Path
+	switch {
+	case path == "/service/rest/v1/status":
+		w.WriteHeader(http.StatusOK)
+	case path == "/service/rest/v1/repositories":
+		var infos []registry.RepositoryInfo
+		for _, repo := range s.repositories {
+			info := repo.info
+			info.URL = s.URL + "/repository/" + info.Name
+			infos = append(infos, info)
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+		writeJSON(w, infos)
+	case strings.HasPrefix(path, "/repository/"):
+		parts := strings.SplitN(strings.TrimPrefix(path, "/repository/"), "/", 2)
+		repo, ok := s.repositories[parts[0]]
+		if !ok || len(parts) < 2 || !strings.HasPrefix(parts[1], "v2/") || repo.info.Format != "docker" {
+			http.NotFound(w, req)
+			return
+		}
+		s.serveRegistry(w, req, repo, strings.TrimPrefix(parts[1], "v2/"))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// serveRegistry serves the registry API of a repository, path is the part after /v2/
+func (s *Server) serveRegistry(w http.ResponseWriter, req *http.Request, repo *repository, path string) {
+	writes := req.Method != "GET" && req.Method != "HEAD"
+	if writes && repo.info.Type != "hosted" {
+		registryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "repository does not allow updating assets: "+repo.info.Name)
+		return
+	}
+	if path == "" {
+		writeJSON(w, map[string]string{})
+		return
+	}
+	if path == "_catalog" {
+		s.page(w, req, "/v2/_catalog", "repositories", repo.images())
+		return
+	}
+	for _, marker := range []string{"/tags/list", "/manifests/", "/blobs/uploads/", "/blobs/"} {
+		i := strings.LastIndex(path, marker)
+		if i <= 0 {
+			continue
+		}
+		image, rest := path[:i], path[i+len(marker):]
+		switch marker {
+		case "/tags/list":
+			s.tagList(w, req, repo, image)
+		case "/manifests/":
+			s.serveManifest(w, req, repo, image, rest)
+		case "/blobs/uploads/":
+			s.serveUpload(w, req, repo, image, rest)
+		default:
+			s.serveBlob(w, req, repo, rest)
+		}
+		return
+	}
+	http.NotFound(w, req)
+}
+
+func (s *Server) tagList(w http.ResponseWriter, req *http.Request, repo *repository, image string) {
+	if len(repo.manifests[image]) == 0 {
+		registryError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+	tags := []string{}
+	for tag := range repo.tags[image] {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	w.Header().Set("X-Image-Name", image)
+	s.page(w, req, "/v2/"+image+"/tags/list", "tags", tags)
+}
+
+// page sends the entries after the last query parameter, at most n of them, with a Link header to the next page
+func (s *Server) page(w http.ResponseWriter, req *http.Request, path string, field string, entries []string) {
+	query := req.URL.Query()
+	if last := query.Get("last"); last != "" {
+		i := sort.SearchStrings(entries, last)
+		if i < len(entries) && entries[i] == last {
+			i++
+		}
+		entries = entries[i:]
+	}
+	n, _ := strconv.Atoi(query.Get("n"))
+	if s.PageSize > 0 && (n <= 0 || n > s.PageSize) {
+		n = s.PageSize
+	}
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+		w.Header().Set("Link", fmt.Sprintf(`<%s?last=%s&n=%d>; rel="next"`, path, url.QueryEscape(entries[n-1]), n))
+	}
+	body := map[string]interface{}{field: entries}
+	if field == "tags" {
+		body["name"] = strings.TrimSuffix(strings.TrimPrefix(path, "/v2/"), "/tags/list")
+	}
+	writeJSON(w, body)
+}
+
+func (s *Server) serveManifest(w http.ResponseWriter, req *http.Request, repo *repository, image string, reference string) {
+	switch req.Method {
+	case "GET", "HEAD":
+		d := reference
+		if !strings.HasPrefix(reference, "sha256:") {
+			d = repo.tags[image][reference]
+		}
+		m, ok := repo.manifests[image][d]
+		if !ok {
+			registryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", d)
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.content)))
+		if req.Method == "GET" {
+			w.Write(m.content)
+		}
+	case "PUT":
+		content, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			registryError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		var parsed registry.ImageManifest
+		if err := json.Unmarshal(content, &parsed); err != nil {
+			registryError(w, http.StatusBadRequest, "MANIFEST_INVALID", "manifest invalid")
+			return
+		}
+		// Nexus refuses manifests whose blobs were not uploaded
+		if parsed.Config.Digest != "" {
+			for _, blob := range append([]registry.LayerInfo{parsed.Config}, parsed.Layers...) {
+				if _, ok := repo.blobs[blob.Digest]; !ok {
+					registryError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", "blob unknown to registry: "+blob.Digest)
+					return
+				}
+			}
+		}
+		mediaType := req.Header.Get("Content-Type")
+		if mediaType == "" {
+			mediaType = parsed.MediaType
+		}
+		d := repo.put(image, reference, mediaType, content)
+		w.Header().Set("Docker-Content-Digest", d)
+		w.Header().Set("Location", "/v2/"+image+"/manifests/"+d)
+		w.WriteHeader(http.StatusCreated)
+	case "DELETE":
+		if !strings.HasPrefix(reference, "sha256:") {
+			registryError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+			return
+		}
+		if _, ok := repo.manifests[image][reference]; !ok {
+			registryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		delete(repo.manifests[image], reference)
+		for tag, d := range repo.tags[image] {
+			if d == reference {
+				delete(repo.tags[image], tag)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveBlob(w http.ResponseWriter, req *http.Request, repo *repository, d string) {
+	content, ok := repo.blobs[d]
+	if !ok {
+		registryError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+	switch req.Method {
+	case "GET", "HEAD":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", d)
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if req.Method == "GET" {
+			w.Write(content)
+		}
+	default:
+		// blobs go when the blob store is compacted, not by the registry API
+		registryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "blob deletes are not supported")
+	}
+}
+
+// serveUpload takes monolithic and chunked uploads and mounts of blobs, id is the upload session
+func (s *Server) serveUpload(w http.ResponseWriter, req *http.Request, repo *repository, image string, id string) {
+	query := req.URL.Query()
+	switch {
+	case req.Method == "POST" && id == "":
+		if from := query.Get("from"); query.Get("mount") != "" {
+			if source, ok := s.repositories[from]; ok && source.blobs[query.Get("mount")] != nil {
+				repo.blobs[query.Get("mount")] = source.blobs[query.Get("mount")]
+				w.Header().Set("Docker-Content-Digest", query.Get("mount"))
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		s.nextUpload++
+		id = strconv.Itoa(s.nextUpload)
+		u := &upload{repository: repo.info.Name}
+		s.uploads[id] = u
+		if d := query.Get("digest"); d != "" {
+			s.finishUpload(w, req, repo, image, id, d)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/repository/%s/v2/%s/blobs/uploads/%s", repo.info.Name, image, id))
+		w.Header().Set("Docker-Upload-UUID", id)
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == "PATCH" && s.uploads[id] != nil:
+		u := s.uploads[id]
+		if _, err := u.content.ReadFrom(req.Body); err != nil {
+			registryError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/repository/%s/v2/%s/blobs/uploads/%s", repo.info.Name, image, id))
+		w.Header().Set("Range", fmt.Sprintf("0-%d", u.content.Len()-1))
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == "PUT" && s.uploads[id] != nil:
+		s.finishUpload(w, req, repo, image, id, query.Get("digest"))
+	case req.Method == "DELETE" && s.uploads[id] != nil:
+		delete(s.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		registryError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry")
+	}
+}
+
+// finishUpload adds the body of the request to the upload and stores the blob if it matches the digest
+func (s *Server) finishUpload(w http.ResponseWriter, req *http.Request, repo *repository, image string, id string, d string) {
+	u := s.uploads[id]
+	delete(s.uploads, id)
+	if _, err := u.content.ReadFrom(req.Body); err != nil {
+		registryError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+		return
+	}
+	if digest(u.content.Bytes()) != d {
+		registryError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+		return
+	}
+	repo.blobs[d] = u.content.Bytes()
+	w.Header().Set("Docker-Content-Digest", d)
+	w.Header().Set("Location", fmt.Sprintf("/repository/%s/v2/%s/blobs/%s", repo.info.Name, image, d))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func registryError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
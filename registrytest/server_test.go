@@ -0,0 +1,330 @@
+package registrytest_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/cleanup"
+	"github.com/eugenmayer/nexus-cli/policy"
+	"github.com/eugenmayer/nexus-cli/registry"
+	"github.com/eugenmayer/nexus-cli/registrytest"
+)
+
+// newServer starts a server requiring basic auth, so the tests cover the client authenticating as well
+func newServer() (*registrytest.Server, registry.Registry) {
+	srv := registrytest.NewServer()
+	srv.Username, srv.Password = "ci", "secret"
+	return srv, registry.New(srv.URL, registry.WithBasicAuth("ci", "secret"), registry.WithRepository("docker-hosted"))
+}
+
+// countRequests counts the requests served whose path starts with prefix
+func countRequests(srv *registrytest.Server, prefix string) int {
+	n := 0
+	for _, request := range srv.Requests() {
+		if strings.HasPrefix(request, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestUnauthorized(t *testing.T) {
+	srv, _ := newServer()
+	defer srv.Close()
+	r := registry.New(srv.URL, registry.WithBasicAuth("ci", "wrong"), registry.WithRepository("docker-hosted"))
+	if _, err := r.ListImages(); err == nil {
+		t.Fatal("ListImages with the wrong password succeeded")
+	}
+}
+
+func TestCatalogPagination(t *testing.T) {
+	srv, r := newServer()
+	defer srv.Close()
+	srv.PageSize = 2
+	want := []string{"a", "b", "team/c", "team/d", "team/e/f"}
+	for _, image := range want {
+		srv.PushImage("docker-hosted", image, "1.0", registrytest.Image{})
+	}
+
+	var got []string
+	it := r.ImagesIter(context.Background())
+	for it.Next() {
+		got = append(got, it.Value())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ImagesIter lists %q, want %q", got, want)
+	}
+	if n := countRequests(srv, "GET /repository/docker-hosted/v2/_catalog"); n != 3 {
+		t.Errorf("ImagesIter took %d pages, want 3", n)
+	}
+
+	var pages [][]string
+	err := r.EachImagePage(context.Background(), func(images []string) error {
+		pages = append(pages, images)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantPages := [][]string{{"a", "b"}, {"team/c", "team/d"}, {"team/e/f"}}; !reflect.DeepEqual(pages, wantPages) {
+		t.Errorf("EachImagePage pages %q, want %q", pages, wantPages)
+	}
+}
+
+func TestTagsPagination(t *testing.T) {
+	srv, r := newServer()
+	defer srv.Close()
+	srv.PageSize = 2
+	want := []string{"1.0", "1.1", "1.2", "2.0"}
+	for _, tag := range want {
+		srv.PushImage("docker-hosted", "team/app", tag, registrytest.Image{})
+	}
+
+	var got []string
+	it := r.TagsIter(context.Background(), "team/app")
+	for it.Next() {
+		got = append(got, it.Value())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TagsIter lists %q, want %q", got, want)
+	}
+	if n := countRequests(srv, "GET /repository/docker-hosted/v2/team/app/tags/list"); n != 2 {
+		t.Errorf("TagsIter took %d pages, want 2", n)
+	}
+
+	if _, err := r.ListTagsByImage("team/missing"); !registry.IsNotFound(err) {
+		t.Errorf("ListTagsByImage of a missing image: %v, want a not found error", err)
+	}
+}
+
+func TestManifests(t *testing.T) {
+	srv, r := newServer()
+	defer srv.Close()
+	pushed := srv.PushImage("docker-hosted", "team/app", "1.0", registrytest.Image{Layers: [][]byte{[]byte("one"), []byte("two")}})
+
+	// HEAD
+	d, err := r.ImageDigest("team/app", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != pushed {
+		t.Errorf("ImageDigest = %s, want %s", d, pushed)
+	}
+	if n := countRequests(srv, "HEAD /repository/docker-hosted/v2/team/app/manifests/1.0"); n != 1 {
+		t.Errorf("ImageDigest sent %d HEAD requests, want 1", n)
+	}
+
+	// GET
+	manifest, err := r.ImageManifest("team/app", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.MediaType != registry.MediaTypeDockerManifest || len(manifest.Layers) != 2 {
+		t.Errorf("ImageManifest = %s with %d layers, want %s with 2", manifest.MediaType, len(manifest.Layers), registry.MediaTypeDockerManifest)
+	}
+	_, _, d, err = r.RawManifest("team/app", pushed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != pushed {
+		t.Errorf("RawManifest by digest = %s, want %s", d, pushed)
+	}
+
+	// DELETE takes the tag along
+	if err := r.DeleteManifest("team/app", pushed); err != nil {
+		t.Fatal(err)
+	}
+	if tags := srv.Tags("docker-hosted", "team/app"); len(tags) != 0 {
+		t.Errorf("tags %q are left after deleting their manifest", tags)
+	}
+	if _, err := r.ImageManifest("team/app", "1.0"); !registry.IsNotFound(err) {
+		t.Errorf("ImageManifest of a deleted tag: %v, want a not found error", err)
+	}
+	if err := r.DeleteManifest("team/app", pushed); !registry.IsNotFound(err) {
+		t.Errorf("deleting a deleted manifest: %v, want a not found error", err)
+	}
+}
+
+func TestSharedDigestGuard(t *testing.T) {
+	srv, r := newServer()
+	defer srv.Close()
+	img := registrytest.Image{Created: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Layers: [][]byte{[]byte("shared")}}
+	srv.PushImage("docker-hosted", "team/app", "1.0", img)
+	srv.PushImage("docker-hosted", "team/app", "latest", img)
+	srv.PushImage("docker-hosted", "team/app", "2.0", registrytest.Image{})
+
+	// a dry run refuses what a deletion would, without deleting
+	dry, err := r.NewTagDeleter("team/app", []string{"1.0", "2.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dry.DryRun = true
+	if err := dry.Delete("1.0"); !registry.IsSharedDigest(err) {
+		t.Errorf("dry run of deleting a tag sharing its manifest: %v, want a shared digest error", err)
+	}
+	if err := dry.Delete("2.0"); err != nil {
+		t.Errorf("dry run of deleting a tag: %s", err)
+	}
+
+	err = r.DeleteImageByTag("team/app", "1.0")
+	if !registry.IsSharedDigest(err) {
+		t.Fatalf("deleting a tag sharing its manifest: %v, want a shared digest error", err)
+	}
+	if tags, want := srv.Tags("docker-hosted", "team/app"), []string{"1.0", "2.0", "latest"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags %q after the refused delete, want %q", tags, want)
+	}
+
+	// deleting all tags of the manifest is not refused
+	d, err := r.NewTagDeleter("team/app", []string{"1.0", "latest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("latest"); err != nil {
+		t.Fatal(err)
+	}
+	if tags, want := srv.Tags("docker-hosted", "team/app"), []string{"2.0"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags %q after deleting the shared manifest, want %q", tags, want)
+	}
+}
+
+func TestReferrerTree(t *testing.T) {
+	srv, r := newServer()
+	defer srv.Close()
+	subject := srv.PushImage("docker-hosted", "team/app", "1.0", registrytest.Image{})
+	signature := srv.PushImage("docker-hosted", "team/app", strings.Replace(subject, ":", "-", 1)+".sig", registrytest.Image{})
+	attestation := srv.PushImage("docker-hosted", "team/app", strings.Replace(signature, ":", "-", 1)+".att", registrytest.Image{})
+
+	tree, err := r.ReferrerTree("team/app", subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, referrer := range tree {
+		got = append(got, referrer.Digest)
+	}
+	// what is attached to the signature goes first, it is deleted before the signature
+	if want := []string{attestation, signature}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReferrerTree = %q, want %q", got, want)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	srv, r := newServer()
+	defer srv.Close()
+	for _, tag := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+		srv.PushImage("docker-hosted", "team/app", tag, registrytest.Image{})
+	}
+	srv.PushImage("docker-hosted", "team/web", "1.0.0", registrytest.Image{})
+	p, err := policy.Parse([]byte("rules:\n  - images: '^team/app$'\n    keep: 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := cleanup.Run(context.Background(), r, p, cleanup.Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Deleted) != 2 {
+		t.Errorf("the dry run selects %d tags, want 2", len(rep.Deleted))
+	}
+	if tags := srv.Tags("docker-hosted", "team/app"); len(tags) != 3 {
+		t.Errorf("the dry run left %q", tags)
+	}
+
+	rep, err = cleanup.Run(context.Background(), r, p, cleanup.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deleted []string
+	for _, tag := range rep.Deleted {
+		deleted = append(deleted, tag.Image+":"+tag.Tag)
+	}
+	if want := []string{"team/app:1.0.0", "team/app:1.1.0"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("cleanup deleted %q, want %q", deleted, want)
+	}
+	if tags, want := srv.Tags("docker-hosted", "team/app"), []string{"1.2.0"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("cleanup left %q of team/app, want %q", tags, want)
+	}
+	if tags, want := srv.Tags("docker-hosted", "team/web"), []string{"1.0.0"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("cleanup left %q of team/web, want %q", tags, want)
+	}
+}
+
+// layer builds an uncompressed layer of the entries given, directories end with a slash and symbolic links are
+// written as "name -> target"
+func layer(t *testing.T, entries ...string) []byte {
+	var content bytes.Buffer
+	tw := tar.NewWriter(&content)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry))}
+		if strings.HasSuffix(entry, "/") {
+			header.Mode, header.Typeflag, header.Size = 0755, tar.TypeDir, 0
+		} else if link := strings.Split(entry, " -> "); len(link) == 2 {
+			header.Name, header.Linkname, header.Typeflag, header.Size = link[0], link[1], tar.TypeSymlink, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			tw.Write([]byte(entry))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return content.Bytes()
+}
+
+// TestReplacedDirectory checks a file or link of an upper layer replaces a directory of a lower one with its content
+func TestReplacedDirectory(t *testing.T) {
+	srv, r := newServer()
+	defer srv.Close()
+	srv.PushImage("docker-hosted", "team/app", "1.0", registrytest.Image{Layers: [][]byte{
+		layer(t, "app/", "app/config/", "app/config/app.conf", "app/lib/", "app/lib/libapp.so", "etc/"),
+		layer(t, "app/config -> /etc/app", "app/lib", "etc/app/"),
+	}})
+
+	fs, _, err := r.ImageFilesystem("team/app", "1.0", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fs.Paths(), []string{"app", "app/config", "app/lib", "etc", "etc/app"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("paths %q, want %q", got, want)
+	}
+
+	dir, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stats, err := r.ExtractFiles("team/app", "1.0", "", "/app", dir)
+	if err != nil {
+		t.Fatalf("extracting /app: %s", err)
+	}
+	if want := (registry.ExtractStats{Files: 1, Directories: 1, Links: 1}); stats != want {
+		t.Errorf("extracted %+v, want %+v", stats, want)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "config")); err != nil || target != "/etc/app" {
+		t.Errorf("config links to %q, %v, want /etc/app", target, err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "lib")); err != nil || string(content) != "app/lib" {
+		t.Errorf("lib holds %q, %v, want the file of the upper layer", content, err)
+	}
+}
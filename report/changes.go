@@ -0,0 +1,119 @@
+package report
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// Run is the result set of a cleanup run, kept to tell the next run what changed since, see Compare
+type Run struct {
+	Started time.Time `json:"started"`
+	DryRun  bool      `json:"dry_run"`
+	// Policy identifies the policy the run applied, the changes may come from editing it
+	Policy string `json:"policy,omitempty"`
+	// Eligible are the tags the policy selected, Failed and Protected those of them which were not deleted
+	Eligible  []Tag `json:"eligible"`
+	Failed    []Tag `json:"failed,omitempty"`
+	Protected []Tag `json:"protected,omitempty"`
+}
+
+// Changes is what a run selected differently than the previous one
+type Changes struct {
+	Previous      time.Time
+	PolicyChanged bool
+	// NewlyEligible are selected now and were not left over by the previous run, NoLongerEligible the other way
+	// round. Left over are the tags a dry run selected and those a run did not delete, the others are gone.
+	// Protected tags are reported as such, not as eligible
+	NewlyEligible    []Tag
+	NoLongerEligible []Tag
+	// NewlyProtected escaped the deletion because of a lock this time but not the previous one
+	NewlyProtected    []Tag
+	NoLongerProtected []Tag
+}
+
+// Empty tells if nothing changed
+func (c Changes) Empty() bool {
+	return len(c.NewlyEligible)+len(c.NoLongerEligible)+len(c.NewlyProtected)+len(c.NoLongerProtected) == 0
+}
+
+// Run returns the result set of the report of a cleanup
+func (r Report) Run(policy string) Run {
+	run := Run{Started: r.Started, DryRun: r.DryRun, Policy: policy, Eligible: []Tag{}}
+	for _, t := range r.Deleted {
+		run.Eligible = append(run.Eligible, Tag{Image: t.Image, Tag: t.Tag})
+	}
+	for _, f := range r.Failures {
+		// failures of whole images have no tag
+		if f.Tag != "" {
+			run.Eligible = append(run.Eligible, Tag{Image: f.Image, Tag: f.Tag})
+			run.Failed = append(run.Failed, Tag{Image: f.Image, Tag: f.Tag, Reason: f.Error})
+		}
+	}
+	for _, t := range r.Skipped {
+		run.Eligible = append(run.Eligible, Tag{Image: t.Image, Tag: t.Tag})
+		run.Protected = append(run.Protected, Tag{Image: t.Image, Tag: t.Tag, Reason: t.Reason})
+	}
+	return run
+}
+
+// LoadRun reads the result set a run saved, nil if there is none yet
+func LoadRun(path string) (*Run, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var run Run
+	if err := json.Unmarshal(content, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Save writes the result set for the next run
+func (run Run) Save(path string) error {
+	content, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// Compare tells what current selected differently than previous, the tags sorted by image and tag
+func Compare(previous Run, current Run) Changes {
+	leftOver := append(append([]Tag{}, previous.Failed...), previous.Protected...)
+	if previous.DryRun {
+		leftOver = previous.Eligible
+	}
+	c := Changes{Previous: previous.Started, PolicyChanged: previous.Policy != current.Policy}
+	c.NewlyEligible = missing(missing(current.Eligible, leftOver), current.Protected)
+	c.NoLongerEligible = missing(leftOver, current.Eligible)
+	c.NewlyProtected = missing(current.Protected, previous.Protected)
+	c.NoLongerProtected = missing(previous.Protected, current.Protected)
+	return c
+}
+
+// missing returns the tags of tags which are not in of
+func missing(tags []Tag, of []Tag) []Tag {
+	in := map[string]bool{}
+	for _, t := range of {
+		in[t.Image+":"+t.Tag] = true
+	}
+	var result []Tag
+	for _, t := range tags {
+		if !in[t.Image+":"+t.Tag] {
+			result = append(result, t)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Image != result[j].Image {
+			return result[i].Image < result[j].Image
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	return result
+}
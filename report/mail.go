@@ -0,0 +1,91 @@
+package report
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP mails reports, authenticating with PLAIN if a username is given. The server has to offer STARTTLS for that,
+// net/smtp refuses to send credentials in the clear to anything but localhost
+type SMTP struct {
+	// Addr is host:port of the server, e.g. smtp.example.com:587
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// Send mails the report as HTML with the Markdown rendering as plain text alternative
+func (s SMTP) Send(r Report) error {
+	if s.From == "" || len(s.To) == 0 {
+		return errors.New("mailing a report needs a sender and at least one recipient")
+	}
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid SMTP server %s, expected host:port: %s", s.Addr, err))
+	}
+	text, err := r.Markdown()
+	if err != nil {
+		return err
+	}
+	html, err := r.HTML()
+	if err != nil {
+		return err
+	}
+	message, err := s.message(r, text, html)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	if err := smtp.SendMail(s.Addr, auth, s.From, s.To, message); err != nil {
+		return errors.New(fmt.Sprintf("mailing the report through %s failed: %s", s.Addr, err))
+	}
+	return nil
+}
+
+func (s SMTP) message(r Report, text []byte, html []byte) ([]byte, error) {
+	var random [12]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return nil, err
+	}
+	boundary := fmt.Sprintf("nexus-cli-%x", random)
+	subject := r.Title
+	if r.DryRun {
+		subject += " (Dry Run)"
+	}
+	if len(r.Failures) > 0 {
+		subject += fmt.Sprintf(", %d failed", len(r.Failures))
+	}
+
+	var m bytes.Buffer
+	fmt.Fprintf(&m, "From: %s\r\n", s.From)
+	fmt.Fprintf(&m, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&m, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&m, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&m, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&m, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	for _, part := range []struct {
+		contentType string
+		body        []byte
+	}{{"text/plain", text}, {"text/html", html}} {
+		fmt.Fprintf(&m, "--%s\r\n", boundary)
+		fmt.Fprintf(&m, "Content-Type: %s; charset=utf-8\r\n", part.contentType)
+		fmt.Fprintf(&m, "Content-Transfer-Encoding: 8bit\r\n\r\n")
+		m.Write(bytes.Replace(part.body, []byte("\n"), []byte("\r\n"), -1))
+		m.WriteString("\r\n")
+	}
+	fmt.Fprintf(&m, "--%s--\r\n", boundary)
+	return m.Bytes(), nil
+}
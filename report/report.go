@@ -0,0 +1,251 @@
+// Package report renders the outcome of cleanup and inventory runs as HTML or Markdown, and mails it
+package report
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/utils"
+)
+
+// TopImages is how many images the report lists in its top images section
+const TopImages = 10
+
+// Report is the outcome of a run
+type Report struct {
+	// Title names the run, e.g. "Cleanup of docker-hosted"
+	Title    string
+	Host     string
+	Started  time.Time
+	Finished time.Time
+	DryRun   bool
+	// Deleted are the tags deleted, or which would be deleted in a dry run
+	Deleted []Tag
+	// Skipped are the tags left alone on purpose, e.g. locked ones
+	Skipped  []Tag
+	Failures []Failure
+	// Images is the inventory of an inventory run
+	Images []Image
+	// Changes tells what the cleanup selected differently than the previous run, if it was asked for
+	Changes *Changes
+}
+
+// Tag is a tag the run touched. Size is its stored size, layers shared with other tags count for all of them
+type Tag struct {
+	Image  string `json:"image"`
+	Tag    string `json:"tag"`
+	Size   int64  `json:"size,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Failure is a tag which could not be deleted
+type Failure struct {
+	Image string
+	Tag   string
+	Error string
+}
+
+// Image summarizes an image of the inventory
+type Image struct {
+	Name string
+	Tags int
+	Size int64
+}
+
+// imageTotal sums what the run did to an image
+type imageTotal struct {
+	Name string
+	Tags int
+	Size int64
+}
+
+// view is what the templates render
+type view struct {
+	Report
+	Duration    time.Duration
+	DeletedSize int64
+	TotalSize   int64
+	TotalTags   int
+	Top         []imageTotal
+}
+
+func (r Report) view() view {
+	v := view{Report: r, Duration: r.Finished.Sub(r.Started).Round(time.Second)}
+	totals := map[string]*imageTotal{}
+	add := func(name string, tags int, size int64) {
+		if totals[name] == nil {
+			totals[name] = &imageTotal{Name: name}
+		}
+		totals[name].Tags += tags
+		totals[name].Size += size
+	}
+	for _, t := range r.Deleted {
+		v.DeletedSize += t.Size
+		add(t.Image, 1, t.Size)
+	}
+	for _, image := range r.Images {
+		v.TotalSize += image.Size
+		v.TotalTags += image.Tags
+		add(image.Name, image.Tags, image.Size)
+	}
+	for _, total := range totals {
+		v.Top = append(v.Top, *total)
+	}
+	sort.Slice(v.Top, func(i, j int) bool {
+		if v.Top[i].Size != v.Top[j].Size {
+			return v.Top[i].Size > v.Top[j].Size
+		}
+		return v.Top[i].Name < v.Top[j].Name
+	})
+	if len(v.Top) > TopImages {
+		v.Top = v.Top[:TopImages]
+	}
+	return v
+}
+
+var functions = map[string]interface{}{
+	"bytes": utils.HumanBytes,
+	"time":  func(t time.Time) string { return t.Format("2006-01-02 15:04:05 MST") },
+	// cell keeps Markdown tables intact
+	"cell": func(s string) string {
+		return strings.Replace(strings.Replace(s, "|", "\\|", -1), "\n", " ", -1)
+	},
+}
+
+var markdown = template.Must(template.New("markdown").Funcs(functions).Parse(`# {{.Title}}{{if .DryRun}} (Dry Run){{end}}
+
+{{.Host}}, {{time .Started}}, took {{.Duration}}
+{{if or .Deleted (not .Images)}}
+{{len .Deleted}} tags {{if .DryRun}}would be {{end}}deleted ({{bytes .DeletedSize}}), {{len .Skipped}} skipped, {{len .Failures}} failed
+{{end}}{{if .Images}}
+{{len .Images}} images with {{.TotalTags}} tags ({{bytes .TotalSize}})
+{{end}}{{with .Changes}}
+## Changes since {{time .Previous}}
+{{if .PolicyChanged}}
+The policy changed since.
+{{end}}{{if .Empty}}
+The same tags were selected and protected.
+{{else}}
+| Change | Image | Tag | Reason |
+|---|---|---|---|
+{{range .NewlyEligible}}| newly eligible | {{cell .Image}} | {{cell .Tag}} | |
+{{end}}{{range .NoLongerEligible}}| no longer eligible | {{cell .Image}} | {{cell .Tag}} | |
+{{end}}{{range .NewlyProtected}}| newly protected | {{cell .Image}} | {{cell .Tag}} | {{cell .Reason}} |
+{{end}}{{range .NoLongerProtected}}| no longer protected | {{cell .Image}} | {{cell .Tag}} | |
+{{end}}{{end}}{{end}}{{if .Top}}
+## Top images
+
+| Image | Tags | Size |
+|---|---:|---:|
+{{range .Top}}| {{cell .Name}} | {{.Tags}} | {{bytes .Size}} |
+{{end}}{{end}}{{if .Failures}}
+## Failures
+
+| Image | Tag | Error |
+|---|---|---|
+{{range .Failures}}| {{cell .Image}} | {{cell .Tag}} | {{cell .Error}} |
+{{end}}{{end}}{{if .Deleted}}
+## {{if .DryRun}}Would be deleted{{else}}Deleted{{end}}
+
+| Image | Tag | Size |
+|---|---|---:|
+{{range .Deleted}}| {{cell .Image}} | {{cell .Tag}} | {{bytes .Size}} |
+{{end}}{{end}}{{if .Skipped}}
+## Skipped
+
+| Image | Tag | Reason |
+|---|---|---|
+{{range .Skipped}}| {{cell .Image}} | {{cell .Tag}} | {{cell .Reason}} |
+{{end}}{{end}}`))
+
+var html = htmltemplate.Must(htmltemplate.New("html").Funcs(functions).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+td.size { text-align: right; }
+.failed { color: #b00; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}{{if .DryRun}} (Dry Run){{end}}</h1>
+<p>{{.Host}}, {{time .Started}}, took {{.Duration}}</p>
+{{if or .Deleted (not .Images)}}<p>{{len .Deleted}} tags {{if .DryRun}}would be {{end}}deleted ({{bytes .DeletedSize}}), {{len .Skipped}} skipped, <span{{if .Failures}} class="failed"{{end}}>{{len .Failures}} failed</span></p>
+{{end}}{{if .Images}}<p>{{len .Images}} images with {{.TotalTags}} tags ({{bytes .TotalSize}})</p>
+{{end}}{{with .Changes}}<h2>Changes since {{time .Previous}}</h2>
+{{if .PolicyChanged}}<p>The policy changed since.</p>
+{{end}}{{if .Empty}}<p>The same tags were selected and protected.</p>
+{{else}}<table>
+<tr><th>Change</th><th>Image</th><th>Tag</th><th>Reason</th></tr>
+{{range .NewlyEligible}}<tr><td>newly eligible</td><td>{{.Image}}</td><td>{{.Tag}}</td><td></td></tr>
+{{end}}{{range .NoLongerEligible}}<tr><td>no longer eligible</td><td>{{.Image}}</td><td>{{.Tag}}</td><td></td></tr>
+{{end}}{{range .NewlyProtected}}<tr><td>newly protected</td><td>{{.Image}}</td><td>{{.Tag}}</td><td>{{.Reason}}</td></tr>
+{{end}}{{range .NoLongerProtected}}<tr><td>no longer protected</td><td>{{.Image}}</td><td>{{.Tag}}</td><td></td></tr>
+{{end}}</table>
+{{end}}{{end}}{{if .Top}}<h2>Top images</h2>
+<table>
+<tr><th>Image</th><th>Tags</th><th>Size</th></tr>
+{{range .Top}}<tr><td>{{.Name}}</td><td class="size">{{.Tags}}</td><td class="size">{{bytes .Size}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Failures}}<h2 class="failed">Failures</h2>
+<table>
+<tr><th>Image</th><th>Tag</th><th>Error</th></tr>
+{{range .Failures}}<tr><td>{{.Image}}</td><td>{{.Tag}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Deleted}}<h2>{{if .DryRun}}Would be deleted{{else}}Deleted{{end}}</h2>
+<table>
+<tr><th>Image</th><th>Tag</th><th>Size</th></tr>
+{{range .Deleted}}<tr><td>{{.Image}}</td><td>{{.Tag}}</td><td class="size">{{bytes .Size}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Skipped}}<h2>Skipped</h2>
+<table>
+<tr><th>Image</th><th>Tag</th><th>Reason</th></tr>
+{{range .Skipped}}<tr><td>{{.Image}}</td><td>{{.Tag}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// Markdown renders the report as Markdown
+func (r Report) Markdown() ([]byte, error) {
+	var out bytes.Buffer
+	err := markdown.Execute(&out, r.view())
+	return out.Bytes(), err
+}
+
+// HTML renders the report as a standalone HTML page
+func (r Report) HTML() ([]byte, error) {
+	var out bytes.Buffer
+	err := html.Execute(&out, r.view())
+	return out.Bytes(), err
+}
+
+// Write renders the report to a file, as HTML if its name ends with .html or .htm and as Markdown otherwise
+func (r Report) Write(path string) error {
+	var content []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		content, err = r.HTML()
+	case ".md", ".markdown":
+		content, err = r.Markdown()
+	default:
+		return errors.New(fmt.Sprintf("Cannot tell the format of the report %s, name it .html or .md", path))
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
@@ -0,0 +1,94 @@
+// Package retry keeps what a batch operation failed on in a file, so a partially failed job can be completed later by
+// retrying only those images and tags instead of working out the whole work set again
+package retry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// File lists the failures of a run of Command, e.g. cleanup, in a repository
+type File struct {
+	Command    string    `json:"command"`
+	Host       string    `json:"host"`
+	Repository string    `json:"repository"`
+	Created    time.Time `json:"created"`
+	// Job identifies the work beyond the command, e.g. the digest of the cleanup policy. Failures are only retried
+	// by the same job, a changed policy may not select them anymore
+	Job      string    `json:"job,omitempty"`
+	Failures []Failure `json:"failures"`
+}
+
+// Failure is a tag which failed, or a whole image if Tag is empty
+type Failure struct {
+	Image string `json:"image"`
+	Tag   string `json:"tag,omitempty"`
+	Error string `json:"error"`
+}
+
+// Save writes the failures as indented JSON
+func (f File) Save(path string) error {
+	if f.Failures == nil {
+		f.Failures = []Failure{}
+	}
+	content, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// Load reads the failures Save wrote
+func Load(path string) (File, error) {
+	var f File
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal(content, &f); err != nil {
+		return f, errors.New(fmt.Sprintf("%s is no failures file: %s", path, err))
+	}
+	return f, nil
+}
+
+// Check refuses retrying the failures by another job than the one which wrote them
+func (f File) Check(command string, host string, repository string, job string) error {
+	if f.Command != command {
+		return errors.New(fmt.Sprintf("The failures are of '%s', not of '%s'", f.Command, command))
+	}
+	if f.Host != host || f.Repository != repository {
+		return errors.New(fmt.Sprintf("The failures are of %s on %s, the profile uses %s on %s", f.Repository, f.Host, repository, host))
+	}
+	if f.Job != job {
+		return errors.New(fmt.Sprintf("The failures are of another %s (%s), run it again instead of retrying them", command, f.Job))
+	}
+	return nil
+}
+
+// Images returns the images which failed sorted by name, with the tags which failed. Images failing as a whole have
+// no tags, the whole image is to be retried
+func (f File) Images() ([]string, map[string][]string) {
+	tags := map[string][]string{}
+	whole := map[string]bool{}
+	var images []string
+	for _, failure := range f.Failures {
+		if _, ok := tags[failure.Image]; !ok {
+			images = append(images, failure.Image)
+			tags[failure.Image] = nil
+		}
+		if failure.Tag == "" {
+			whole[failure.Image] = true
+		} else {
+			tags[failure.Image] = append(tags[failure.Image], failure.Tag)
+		}
+	}
+	for image := range whole {
+		tags[image] = nil
+	}
+	sort.Strings(images)
+	return images, tags
+}
@@ -0,0 +1,90 @@
+package sbom
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// bom is the part of a CycloneDX document merged into the consolidated one
+type bom struct {
+	BOMFormat  string            `json:"bomFormat"`
+	Components []json.RawMessage `json:"components"`
+}
+
+type component struct {
+	Type       string            `json:"type"`
+	BOMRef     string            `json:"bom-ref,omitempty"`
+	Name       string            `json:"name"`
+	Version    string            `json:"version,omitempty"`
+	Hashes     []hash            `json:"hashes,omitempty"`
+	Components []json.RawMessage `json:"components,omitempty"`
+}
+
+type hash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+// Consolidate merges the SBOMs of several images into one CycloneDX document describing the repository. Every image
+// becomes a container component holding the components of its SBOM. Dependency graphs are not carried over, bom-refs
+// are only unique within the document they come from
+func Consolidate(name string, sboms []SBOM) ([]byte, error) {
+	var containers []component
+	for _, s := range sboms {
+		var document bom
+		if err := json.Unmarshal(s.BOM, &document); err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid SBOM of %s:%s: %s", s.Image, s.Tag, err))
+		}
+		if document.BOMFormat != "CycloneDX" {
+			return nil, errors.New(fmt.Sprintf("the SBOM of %s:%s is no CycloneDX document", s.Image, s.Tag))
+		}
+		container := component{
+			Type:       "container",
+			BOMRef:     s.Image + ":" + s.Tag,
+			Name:       s.Image,
+			Version:    s.Tag,
+			Components: document.Components,
+		}
+		if strings.HasPrefix(s.Digest, "sha256:") {
+			container.Hashes = []hash{{"SHA-256", strings.TrimPrefix(s.Digest, "sha256:")}}
+		}
+		containers = append(containers, container)
+	}
+
+	serial, err := uuid()
+	if err != nil {
+		return nil, err
+	}
+	consolidated := struct {
+		BOMFormat    string `json:"bomFormat"`
+		SpecVersion  string `json:"specVersion"`
+		SerialNumber string `json:"serialNumber"`
+		Version      int    `json:"version"`
+		Metadata     struct {
+			Timestamp string    `json:"timestamp"`
+			Component component `json:"component"`
+		} `json:"metadata"`
+		Components []component `json:"components"`
+	}{BOMFormat: "CycloneDX", SpecVersion: "1.4", SerialNumber: "urn:uuid:" + serial, Version: 1, Components: containers}
+	consolidated.Metadata.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	consolidated.Metadata.Component = component{Type: "application", Name: name}
+	if consolidated.Components == nil {
+		consolidated.Components = []component{}
+	}
+	return json.MarshalIndent(consolidated, "", "  ")
+}
+
+// uuid generates a random (version 4) UUID
+func uuid() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
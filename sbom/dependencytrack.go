@@ -0,0 +1,67 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DependencyTrack uploads SBOMs to a Dependency-Track server, one project per image and version per tag
+type DependencyTrack struct {
+	URL    string
+	APIKey string
+	// ProjectPrefix is put in front of the image name to build the project name
+	ProjectPrefix string
+	// Client sends the requests, nil for one giving up after a minute
+	Client *http.Client
+}
+
+// Upload sends the SBOM to the BOM endpoint, creating the project if needed. Returns the token of the processing
+// task Dependency-Track queued
+func (d DependencyTrack) Upload(s SBOM) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"projectName":    d.ProjectPrefix + s.Image,
+		"projectVersion": s.Tag,
+		"autoCreate":     true,
+		"bom":            base64.StdEncoding.EncodeToString(s.BOM),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	url := strings.TrimRight(d.URL, "/") + "/api/v1/bom"
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", d.APIKey)
+
+	resp, err := httpClient(d.Client).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+	case 401, 403:
+		return "", errors.New(fmt.Sprintf("PUT %s: HTTP %d, the API key needs the BOM_UPLOAD and PROJECT_CREATION_UPLOAD permissions", url, resp.StatusCode))
+	default:
+		message, _ := ioutil.ReadAll(resp.Body)
+		return "", errors.New(fmt.Sprintf("PUT %s: HTTP %d %s", url, resp.StatusCode, strings.TrimSpace(string(message))))
+	}
+
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.Token, nil
+}
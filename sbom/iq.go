@@ -0,0 +1,169 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// IQ evaluates SBOMs against the policies of a Sonatype IQ (Lifecycle) server, with its third-party scan API
+type IQ struct {
+	URL      string
+	Username string
+	Password string
+	// Timeout is how long to wait for the evaluation, a minute if zero
+	Timeout time.Duration
+	// Client sends the requests, nil for one giving up on a request after a minute
+	Client *http.Client
+}
+
+// Violations counts policy violations by threat level
+type Violations struct {
+	Critical int `json:"critical"`
+	Severe   int `json:"severe"`
+	Moderate int `json:"moderate"`
+}
+
+// Evaluation is the outcome of a policy evaluation
+type Evaluation struct {
+	// PolicyAction is the strongest action of the violated policies: None, Warning or Failure
+	PolicyAction                  string     `json:"policyAction"`
+	ReportHTMLURL                 string     `json:"reportHtmlUrl"`
+	IsError                       bool       `json:"isError"`
+	ErrorMessage                  string     `json:"errorMessage"`
+	ComponentsAffected            Violations `json:"componentsAffected"`
+	OpenPolicyViolations          Violations `json:"openPolicyViolations"`
+	GrandfatheredPolicyViolations int        `json:"grandfatheredPolicyViolations"`
+}
+
+// Failed tells if a violated policy fails the stage
+func (e Evaluation) Failed() bool {
+	return strings.EqualFold(e.PolicyAction, "Failure")
+}
+
+// iqSource names nexus-cli as the source of third-party scans
+const iqSource = "nexus-cli"
+
+// Evaluate submits the SBOM for evaluation of the application with the given public id at stage, e.g. build or
+// release, and waits for the result
+func (q IQ) Evaluate(s SBOM, application string, stage string) (*Evaluation, error) {
+	id, err := q.applicationID(application)
+	if err != nil {
+		return nil, err
+	}
+
+	scan := fmt.Sprintf("%s/api/v2/scan/applications/%s/sources/%s?stageId=%s", q.base(), url.PathEscape(id), iqSource, url.QueryEscape(stage))
+	resp, err := q.do("POST", scan, "application/json", s.BOM)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 && resp.StatusCode != 202 {
+		return nil, iqError("POST", scan, resp)
+	}
+	var submitted struct {
+		StatusURL string `json:"statusUrl"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&submitted); err != nil {
+		return nil, err
+	}
+	return q.wait(q.base() + "/" + strings.TrimLeft(submitted.StatusURL, "/"))
+}
+
+// wait polls the status of an evaluation, IQ answers 404 until it is done
+func (q IQ) wait(status string) (*Evaluation, error) {
+	timeout := q.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := q.do("GET", status, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.StatusCode {
+		case 200:
+			var evaluation Evaluation
+			err := json.NewDecoder(resp.Body).Decode(&evaluation)
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			if evaluation.IsError {
+				return nil, errors.New(fmt.Sprintf("the evaluation failed: %s", evaluation.ErrorMessage))
+			}
+			if evaluation.ReportHTMLURL != "" && !strings.Contains(evaluation.ReportHTMLURL, "://") {
+				evaluation.ReportHTMLURL = q.base() + "/" + strings.TrimLeft(evaluation.ReportHTMLURL, "/")
+			}
+			return &evaluation, nil
+		case 404:
+			resp.Body.Close()
+		default:
+			defer resp.Body.Close()
+			return nil, iqError("GET", status, resp)
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New(fmt.Sprintf("the evaluation was not done within %s, see %s", timeout, status))
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// applicationID looks up the internal id of an application by its public id
+func (q IQ) applicationID(application string) (string, error) {
+	lookup := fmt.Sprintf("%s/api/v2/applications?publicId=%s", q.base(), url.QueryEscape(application))
+	resp, err := q.do("GET", lookup, "", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", iqError("GET", lookup, resp)
+	}
+	var result struct {
+		Applications []struct {
+			ID       string `json:"id"`
+			PublicID string `json:"publicId"`
+		} `json:"applications"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	for _, app := range result.Applications {
+		if app.PublicID == application {
+			return app.ID, nil
+		}
+	}
+	return "", errors.New(fmt.Sprintf("There is no application %s in IQ server %s", application, q.URL))
+}
+
+func (q IQ) base() string {
+	return strings.TrimRight(q.URL, "/")
+}
+
+func (q IQ) do(method string, url string, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.SetBasicAuth(q.Username, q.Password)
+	return httpClient(q.Client).Do(req)
+}
+
+func iqError(method string, url string, resp *http.Response) error {
+	if resp.StatusCode == 401 || resp.StatusCode == 403 {
+		return errors.New(fmt.Sprintf("%s %s: HTTP %d, the user needs the Evaluate Applications permission", method, url, resp.StatusCode))
+	}
+	message, _ := ioutil.ReadAll(resp.Body)
+	return errors.New(fmt.Sprintf("%s %s: HTTP %d %s", method, url, resp.StatusCode, strings.TrimSpace(string(message))))
+}
@@ -0,0 +1,121 @@
+// Package sbom finds the CycloneDX SBOMs attached to images, as referrers or cosign attestations, and hands them to
+// the servers analyzing them: Dependency-Track and Sonatype IQ
+package sbom
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eugenmayer/nexus-cli/registry"
+)
+
+// defaultClient sends the requests of servers given no client, an unresponsive server must not hang an export
+var defaultClient = &http.Client{Timeout: time.Minute}
+
+// httpClient returns client, defaultClient if it is nil
+func httpClient(client *http.Client) *http.Client {
+	if client == nil {
+		return defaultClient
+	}
+	return client
+}
+
+// Media types of CycloneDX documents and of the DSSE envelopes in-toto attestations (cosign attest) are stored in
+const (
+	MediaTypeCycloneDX    = "application/vnd.cyclonedx+json"
+	MediaTypeDSSEEnvelope = "application/vnd.dsse.envelope.v1+json"
+)
+
+// SBOM is the CycloneDX document attached to a tag
+type SBOM struct {
+	Image  string
+	Tag    string
+	Digest string
+	// Referrer is the digest of the artifact holding the document
+	Referrer string
+	BOM      []byte
+}
+
+// Find returns the first CycloneDX JSON document attached to a tag, either as an artifact of its own (oras attach,
+// cosign attach sbom) or as predicate of an in-toto attestation (cosign attest --type cyclonedx). found is false if
+// the tag has none
+func Find(r registry.Registry, image string, tag string) (SBOM, bool, error) {
+	result := SBOM{Image: image, Tag: tag}
+	digest, err := r.ImageDigest(image, tag)
+	if err != nil {
+		return result, false, err
+	}
+	result.Digest = digest
+
+	referrers, err := r.Referrers(image, digest)
+	if err != nil {
+		return result, false, err
+	}
+	for _, referrer := range referrers {
+		manifest, err := r.ImageManifest(image, referrer.Digest)
+		if err != nil {
+			return result, false, err
+		}
+		for _, layer := range manifest.Layers {
+			mediaType := strings.TrimSpace(strings.SplitN(layer.MediaType, ";", 2)[0])
+			if mediaType != MediaTypeCycloneDX && mediaType != MediaTypeDSSEEnvelope {
+				continue
+			}
+			content, err := readBlob(r, image, layer.Digest)
+			if err != nil {
+				return result, false, err
+			}
+			if mediaType == MediaTypeDSSEEnvelope {
+				if content, err = cycloneDXPredicate(content); err != nil {
+					return result, false, errors.New(fmt.Sprintf("attestation %s of %s:%s: %s", referrer.Digest, image, tag, err))
+				} else if content == nil {
+					continue
+				}
+			}
+			result.Referrer, result.BOM = referrer.Digest, content
+			return result, true, nil
+		}
+	}
+	return result, false, nil
+}
+
+// cycloneDXPredicate unwraps the in-toto statement of a DSSE envelope. Returns nil if it is no CycloneDX attestation
+func cycloneDXPredicate(envelope []byte) ([]byte, error) {
+	var dsse struct {
+		PayloadType string `json:"payloadType"`
+		Payload     string `json:"payload"`
+	}
+	if err := json.Unmarshal(envelope, &dsse); err != nil {
+		return nil, err
+	}
+	payload, err := base64.StdEncoding.DecodeString(dsse.Payload)
+	if err != nil {
+		return nil, err
+	}
+	var statement struct {
+		PredicateType string          `json:"predicateType"`
+		Predicate     json.RawMessage `json:"predicate"`
+	}
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(statement.PredicateType, "https://cyclonedx.org/bom") {
+		return nil, nil
+	}
+	return statement.Predicate, nil
+}
+
+func readBlob(r registry.Registry, image string, digest string) ([]byte, error) {
+	content, _, err := r.GetBlob(image, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	return ioutil.ReadAll(content)
+}
@@ -0,0 +1,106 @@
+// Package scan runs vulnerability scanners on exported images
+package scan
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Severities are the severities Trivy reports, from the lowest to the highest
+var Severities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// Vulnerability is a finding of Trivy
+type Vulnerability struct {
+	VulnerabilityID  string `json:"VulnerabilityID"`
+	PkgName          string `json:"PkgName"`
+	InstalledVersion string `json:"InstalledVersion"`
+	FixedVersion     string `json:"FixedVersion"`
+	Severity         string `json:"Severity"`
+	Title            string `json:"Title"`
+}
+
+// Result is what Trivy found in a target of the image, e.g. the OS packages or a lock file
+type Result struct {
+	Target          string          `json:"Target"`
+	Class           string          `json:"Class"`
+	Type            string          `json:"Type"`
+	Vulnerabilities []Vulnerability `json:"Vulnerabilities"`
+}
+
+// Report is the JSON report of 'trivy image'
+type Report struct {
+	ArtifactName string   `json:"ArtifactName"`
+	Results      []Result `json:"Results"`
+}
+
+// Counts returns the number of vulnerabilities per severity
+func (r Report) Counts() map[string]int {
+	counts := map[string]int{}
+	for _, result := range r.Results {
+		for _, v := range result.Vulnerabilities {
+			counts[strings.ToUpper(v.Severity)]++
+		}
+	}
+	return counts
+}
+
+// AtLeast returns the number of vulnerabilities of the given severity or a higher one
+func (r Report) AtLeast(severity string) int {
+	rank := SeverityRank(severity)
+	n := 0
+	for s, count := range r.Counts() {
+		if SeverityRank(s) >= rank {
+			n += count
+		}
+	}
+	return n
+}
+
+// SeverityRank orders severities, -1 for one Trivy does not know
+func SeverityRank(severity string) int {
+	for i, s := range Severities {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+	return -1
+}
+
+// LookTrivy finds the trivy binary, by name in PATH or by path. Empty is trivy
+func LookTrivy(trivy string) (string, error) {
+	if trivy == "" {
+		trivy = "trivy"
+	}
+	path, err := exec.LookPath(trivy)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("scanning needs trivy (https://trivy.dev), %s was not found", trivy))
+	}
+	return path, nil
+}
+
+// Trivy scans an OCI image layout directory with the trivy binary found by LookTrivy. platform selects the image of
+// an index, e.g. linux/amd64. The progress and warnings of trivy are written to out
+func Trivy(path string, layout string, platform string, out io.Writer) (*Report, error) {
+	args := []string{"image", "--input", layout, "--format", "json", "--scanners", "vuln"}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return nil, errors.New(fmt.Sprintf("trivy image failed: %s", err))
+	}
+	var report Report
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		return nil, errors.New(fmt.Sprintf("trivy printed no JSON report: %s", err))
+	}
+	return &report, nil
+}
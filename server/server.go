@@ -0,0 +1,190 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/eugenmayer/nexus-cli/policy"
+	"github.com/eugenmayer/nexus-cli/registry"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Server exposes registry operations as a small REST API:
+//
+//	GET  /images                      all images of the repository
+//	GET  /images/<name>/tags          tags of an image
+//	GET  /images/<name>/tags/<tag>    manifest and digest of a tag
+//	POST /cleanup[?image=..][&apply=true]  apply the configured policy
+//
+// Image names may contain slashes. Cleanups are dry runs unless apply=true is given, and only delete if the server
+// requires a Token
+type Server struct {
+	Registry registry.Registry
+	// Policy is applied by POST /cleanup, which is disabled if it is nil
+	Policy *policy.Policy
+	// Token, if set, has to be sent as "Authorization: Bearer <token>" with every request
+	Token string
+
+	cleanup sync.Mutex
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type tagResponse struct {
+	Name     string                 `json:"name"`
+	Tag      string                 `json:"tag"`
+	Digest   string                 `json:"digest"`
+	Manifest registry.ImageManifest `json:"manifest"`
+}
+
+// Deletion is a tag removed (or on a dry run: selected for removal) by a cleanup
+type Deletion struct {
+	Image string `json:"image"`
+	Tag   string `json:"tag"`
+}
+
+type cleanupResponse struct {
+	DryRun  bool       `json:"dry_run"`
+	Deleted []Deletion `json:"deleted"`
+	// Skipped are selected tags which are locked or share their manifest with tags which are kept
+	Skipped []Deletion `json:"skipped"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if s.Token != "" {
+		expected := "Bearer " + s.Token
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(expected)) != 1 {
+			respond(w, http.StatusUnauthorized, errorResponse{"missing or invalid token"})
+			return
+		}
+	}
+
+	path := req.URL.Path
+	switch {
+	case path == "/images":
+		if req.Method != "GET" {
+			respond(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+			return
+		}
+		images, err := s.Registry.ListImages()
+		if err != nil {
+			respond(w, http.StatusBadGateway, errorResponse{err.Error()})
+			return
+		}
+		respond(w, http.StatusOK, registry.Repositories{Images: images})
+	case strings.HasPrefix(path, "/images/"):
+		if req.Method != "GET" {
+			respond(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+			return
+		}
+		s.image(w, strings.TrimPrefix(path, "/images/"))
+	case path == "/cleanup":
+		if req.Method != "POST" {
+			respond(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+			return
+		}
+		s.runCleanup(w, req)
+	default:
+		respond(w, http.StatusNotFound, errorResponse{"not found"})
+	}
+}
+
+// image serves <name>/tags and <name>/tags/<tag>
+func (s *Server) image(w http.ResponseWriter, path string) {
+	if strings.HasSuffix(path, "/tags") {
+		name := strings.TrimSuffix(path, "/tags")
+		tags, err := s.Registry.ListTagsByImage(name)
+		if err != nil {
+			respond(w, http.StatusBadGateway, errorResponse{err.Error()})
+			return
+		}
+		respond(w, http.StatusOK, registry.ImageTags{Name: name, Tags: tags})
+		return
+	}
+
+	i := strings.LastIndex(path, "/tags/")
+	if i <= 0 {
+		respond(w, http.StatusNotFound, errorResponse{"not found"})
+		return
+	}
+	name, tag := path[:i], path[i+len("/tags/"):]
+	manifest, err := s.Registry.ImageManifest(name, tag)
+	if err != nil {
+		respond(w, http.StatusBadGateway, errorResponse{err.Error()})
+		return
+	}
+	digest, err := s.Registry.ImageDigest(name, tag)
+	if err != nil {
+		respond(w, http.StatusBadGateway, errorResponse{err.Error()})
+		return
+	}
+	respond(w, http.StatusOK, tagResponse{Name: name, Tag: tag, Digest: digest, Manifest: manifest})
+}
+
+func (s *Server) runCleanup(w http.ResponseWriter, req *http.Request) {
+	if s.Policy == nil {
+		respond(w, http.StatusNotImplemented, errorResponse{"no policy configured, start the server with --policy"})
+		return
+	}
+	dryRun := req.URL.Query().Get("apply") != "true"
+	images := req.URL.Query()["image"]
+	if !dryRun && s.Token == "" {
+		respond(w, http.StatusForbidden, errorResponse{"cleanups only delete if the server requires a token, start it with --token"})
+		return
+	}
+
+	// overlapping runs would race on the same tags
+	s.cleanup.Lock()
+	defer s.cleanup.Unlock()
+
+	if len(images) == 0 {
+		var err error
+		if images, err = s.Registry.ListImages(); err != nil {
+			respond(w, http.StatusBadGateway, errorResponse{err.Error()})
+			return
+		}
+	}
+
+	response := cleanupResponse{DryRun: dryRun, Deleted: []Deletion{}, Skipped: []Deletion{}}
+	for _, image := range images {
+		tags, err := s.Policy.Evaluate(s.Registry, image)
+		if err != nil {
+			respond(w, http.StatusBadGateway, errorResponse{err.Error()})
+			return
+		}
+		if len(tags) == 0 {
+			continue
+		}
+		deleter, err := s.Registry.NewTagDeleter(image, tags)
+		if err != nil {
+			respond(w, http.StatusBadGateway, errorResponse{err.Error()})
+			return
+		}
+		// a dry run skips the tags a real run would
+		deleter.DryRun = dryRun
+		for _, tag := range tags {
+			if err := deleter.Delete(tag); registry.IsSharedDigest(err) || registry.IsProtected(err) {
+				response.Skipped = append(response.Skipped, Deletion{Image: image, Tag: tag})
+				continue
+			} else if err != nil {
+				respond(w, http.StatusBadGateway, errorResponse{err.Error()})
+				return
+			}
+			response.Deleted = append(response.Deleted, Deletion{Image: image, Tag: tag})
+		}
+	}
+	log.Printf("Cleanup from %s: %d tags, %d skipped (dry run: %t)", req.RemoteAddr, len(response.Deleted), len(response.Skipped), dryRun)
+	respond(w, http.StatusOK, response)
+}
+
+func respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Writing response failed: %s", err)
+	}
+}
@@ -0,0 +1,66 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+)
+
+// SignBlob signs content as 'cosign sign-blob' does: an ASN.1 ECDSA signature of its sha256, base64 encoded.
+// 'cosign verify-blob' verifies it and VerifyBlob verifies what cosign signed
+func SignBlob(content []byte, key crypto.Signer) (string, error) {
+	hash := sha256.Sum256(content)
+	signature, err := key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// VerifyBlob checks a signature made by SignBlob or 'cosign sign-blob'
+func VerifyBlob(content []byte, signature string, key *ecdsa.PublicKey) error {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid signature: %s", err))
+	}
+	var sig struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(raw, &sig); err != nil || len(rest) > 0 {
+		return errors.New("invalid signature, expected an ASN.1 encoded ECDSA signature")
+	}
+	hash := sha256.Sum256(content)
+	if !ecdsa.Verify(key, hash[:], sig.R, sig.S) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// LoadPublicKey reads an ECDSA public key in PEM, like the cosign.pub of 'cosign generate-key-pair'
+func LoadPublicKey(path string) (*ecdsa.PublicKey, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New(fmt.Sprintf("%s does not contain a PEM encoded public key", path))
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("%s is not an ECDSA key", path))
+	}
+	return ecKey, nil
+}
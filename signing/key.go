@@ -0,0 +1,106 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+)
+
+// pem block types used by cosign for its password protected keys
+const (
+	encryptedSigstoreKey = "ENCRYPTED SIGSTORE PRIVATE KEY"
+	encryptedCosignKey   = "ENCRYPTED COSIGN PRIVATE KEY"
+)
+
+// encryptedKey is the envelope cosign wraps its PKCS8 keys in (scrypt derived key, nacl secretbox)
+type encryptedKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// LoadPrivateKey reads an ECDSA private key, either a password protected cosign key (as created by
+// 'cosign generate-key-pair') or an unencrypted PEM key. passphrase is only called for encrypted keys
+func LoadPrivateKey(path string, passphrase func() ([]byte, error)) (crypto.Signer, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New(fmt.Sprintf("%s does not contain a PEM encoded key", path))
+	}
+
+	der := block.Bytes
+	switch block.Type {
+	case encryptedSigstoreKey, encryptedCosignKey:
+		password, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+		if der, err = decrypt(block.Bytes, password); err != nil {
+			return nil, err
+		}
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY":
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported key type %q in %s", block.Type, path))
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("%s is not an ECDSA key", path))
+	}
+	return ecKey, nil
+}
+
+func decrypt(envelope []byte, password []byte) ([]byte, error) {
+	var key encryptedKey
+	if err := json.Unmarshal(envelope, &key); err != nil {
+		return nil, err
+	}
+	if key.KDF.Name != "scrypt" || key.Cipher.Name != "nacl/secretbox" {
+		return nil, errors.New(fmt.Sprintf("unsupported key encryption %s / %s", key.KDF.Name, key.Cipher.Name))
+	}
+	if len(key.Cipher.Nonce) != 24 {
+		return nil, errors.New("invalid nonce in encrypted key")
+	}
+
+	secret, err := scrypt.Key(password, key.KDF.Salt, key.KDF.Params.N, key.KDF.Params.R, key.KDF.Params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	var boxKey [32]byte
+	copy(nonce[:], key.Cipher.Nonce)
+	copy(boxKey[:], secret)
+
+	der, ok := secretbox.Open(nil, key.Ciphertext, &nonce, &boxKey)
+	if !ok {
+		return nil, errors.New("decryption of the key failed, wrong password?")
+	}
+	return der, nil
+}
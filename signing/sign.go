@@ -0,0 +1,142 @@
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/eugenmayer/nexus-cli/registry"
+	"strings"
+)
+
+const (
+	// SimpleSigningMediaType is the layer media type cosign stores its signature payloads with
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// SignatureAnnotation holds the base64 encoded signature of the payload on the layer
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+	configMediaType = "application/vnd.oci.image.config.v1+json"
+	emptyTime       = "0001-01-01T00:00:00Z"
+)
+
+// payload is the "simple signing" document cosign signs, binding the docker reference to the manifest digest
+type payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]string `json:"optional"`
+}
+
+// SignImage signs the manifest a tag points to and pushes the signature as cosign does: as an OCI manifest tagged
+// sha256-<digest>.sig, with one simple signing layer per signature. Existing signatures are kept.
+// dockerReference is the image reference (without tag) verifiers will use to pull the image.
+// Returns the digest of the signed manifest
+func SignImage(r registry.Registry, image string, tag string, dockerReference string, key crypto.Signer) (string, error) {
+	digest, err := r.ImageDigest(image, tag)
+	if err != nil {
+		return "", err
+	}
+
+	var p payload
+	p.Critical.Identity.DockerReference = dockerReference
+	p.Critical.Image.DockerManifestDigest = digest
+	p.Critical.Type = "cosign container image signature"
+	body, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(body)
+	signature, err := key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+
+	payloadDigest := blobDigest(body)
+	if err := r.UploadBlob(image, payloadDigest, int64(len(body)), bytes.NewReader(body)); err != nil {
+		return "", err
+	}
+	layer := registry.LayerInfo{
+		MediaType: SimpleSigningMediaType,
+		Size:      int64(len(body)),
+		Digest:    payloadDigest,
+		Annotations: map[string]string{
+			SignatureAnnotation: base64.StdEncoding.EncodeToString(signature),
+		},
+	}
+
+	signatureTag := strings.Replace(digest, ":", "-", 1) + ".sig"
+	layers := []registry.LayerInfo{}
+	tags, err := r.ListTagsByImage(image)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tags {
+		if t != signatureTag {
+			continue
+		}
+		existing, err := r.ImageManifest(image, signatureTag)
+		if err != nil {
+			return "", err
+		}
+		layers = existing.Layers
+	}
+	layers = append(layers, layer)
+
+	manifest, err := signatureManifest(r, image, layers)
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.PutManifest(image, signatureTag, registry.MediaTypeOCIManifest, manifest); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// signatureManifest builds the manifest for the signature layers, uploading the config blob listing them
+func signatureManifest(r registry.Registry, image string, layers []registry.LayerInfo) ([]byte, error) {
+	diffIDs := make([]string, 0, len(layers))
+	for _, layer := range layers {
+		diffIDs = append(diffIDs, layer.Digest)
+	}
+	config, err := json.Marshal(map[string]interface{}{
+		"architecture": "",
+		"created":      emptyTime,
+		"history":      []map[string]string{{"created": emptyTime}},
+		"os":           "",
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+		"config":       map[string]interface{}{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	configDigest := blobDigest(config)
+	if err := r.UploadBlob(image, configDigest, int64(len(config)), bytes.NewReader(config)); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(registry.ImageManifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		Config: registry.LayerInfo{
+			MediaType: configMediaType,
+			Size:      int64(len(config)),
+			Digest:    configDigest,
+		},
+		Layers: layers,
+	})
+}
+
+func blobDigest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
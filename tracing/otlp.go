@@ -0,0 +1,295 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchSize is how many spans are exported at once, long jobs export while they run
+const batchSize = 512
+
+// otlpExporter sends the spans to an OTLP/HTTP endpoint. It stands in for the OTLP exporter and batch span
+// processor of the OpenTelemetry Go SDK, which need a far newer Go than this module is built with: spans are
+// encoded as OTLP/HTTP JSON by hand and sent in batches, configured by the standard environment variables. Unlike
+// the SDK it neither retries failed exports nor speaks protobuf or grpc, and nothing is sampled out
+type otlpExporter struct {
+	endpoint string
+	headers  http.Header
+	client   *http.Client
+	resource []attribute
+	version  string
+
+	mu      sync.Mutex
+	spans   []*Span
+	exports sync.WaitGroup
+	// reported is set once an export failed, the next failures are not reported again
+	reported bool
+}
+
+// newExporter creates the exporter the environment configures, nil if there is no endpoint
+func newExporter(version string) (*otlpExporter, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, errors.New(fmt.Sprintf("The OTLP endpoint %s is no http:// or https:// URL", endpoint))
+	}
+	if protocol := firstEnv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"); protocol == "grpc" {
+		return nil, errors.New("OTLP over grpc is not supported, use an http/protobuf or http/json endpoint (port 4318 of collectors)")
+	}
+	timeout := 10 * time.Second
+	if value := firstEnv("OTEL_EXPORTER_OTLP_TRACES_TIMEOUT", "OTEL_EXPORTER_OTLP_TIMEOUT"); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms <= 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid OTEL_EXPORTER_OTLP_TIMEOUT %q, expected milliseconds", value))
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	e := &otlpExporter{endpoint: endpoint, headers: http.Header{}, client: &http.Client{Timeout: timeout}, version: version}
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		pairs, err := keyValues(name)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range pairs {
+			e.headers.Set(key, value)
+		}
+	}
+	resource, err := keyValues("OTEL_RESOURCE_ATTRIBUTES")
+	if err != nil {
+		return nil, err
+	}
+	resource["service.name"] = "nexus-cli"
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		resource["service.name"] = name
+	}
+	resource["service.version"] = version
+	var keys []string
+	for key := range resource {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		e.resource = append(e.resource, newAttribute(key, resource[key]))
+	}
+	return e, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// keyValues parses the key1=value1,key2=value2 list of an environment variable, the values are URL encoded
+func keyValues(name string) (map[string]string, error) {
+	pairs := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv(name), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.New(fmt.Sprintf("Invalid %s, %q is not in the form key=value", name, pair))
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid %s, the value of %s: %s", name, parts[0], err))
+		}
+		pairs[strings.TrimSpace(parts[0])] = value
+	}
+	return pairs, nil
+}
+
+// add queues a span, exporting a batch once there are enough
+func (e *otlpExporter) add(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+	if len(e.spans) >= batchSize {
+		batch := e.spans
+		e.spans = nil
+		e.exports.Add(1)
+		go func() {
+			defer e.exports.Done()
+			e.export(batch)
+		}()
+	}
+}
+
+// flush exports the queued spans and waits for the exports running
+func (e *otlpExporter) flush() {
+	e.mu.Lock()
+	batch := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+	if len(batch) > 0 {
+		e.export(batch)
+	}
+	e.exports.Wait()
+}
+
+func (e *otlpExporter) export(batch []*Span) {
+	body, err := json.Marshal(e.request(batch))
+	if err == nil {
+		err = e.post(body)
+	}
+	if err != nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if !e.reported {
+			e.reported = true
+			fmt.Fprintf(os.Stderr, "Exporting traces to %s failed: %s\n", e.endpoint, err)
+		}
+	}
+}
+
+func (e *otlpExporter) post(body []byte) error {
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range e.headers {
+		req.Header[name] = values
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		answer, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.New(fmt.Sprintf("%s %s", resp.Status, strings.TrimSpace(string(answer))))
+	}
+	return nil
+}
+
+// The types below are the JSON encoding of an OTLP ExportTraceServiceRequest. IDs are hex, 64 bit integers strings
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type spanJSON struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes,omitempty"`
+	Status            status      `json:"status"`
+}
+
+type status struct {
+	// Code is 0 for unset, 2 for an error
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type attribute struct {
+	Key   string         `json:"key"`
+	Value attributeValue `json:"value"`
+}
+
+type attributeValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newAttribute(key string, value interface{}) attribute {
+	a := attribute{Key: key}
+	switch v := value.(type) {
+	case bool:
+		a.Value.BoolValue = &v
+	case int:
+		i := strconv.Itoa(v)
+		a.Value.IntValue = &i
+	case int64:
+		i := strconv.FormatInt(v, 10)
+		a.Value.IntValue = &i
+	case float64:
+		a.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		a.Value.StringValue = &s
+	}
+	return a
+}
+
+func (e *otlpExporter) request(batch []*Span) exportRequest {
+	spans := make([]spanJSON, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		j := spanJSON{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		var keys []string
+		for key := range s.attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			j.Attributes = append(j.Attributes, newAttribute(key, s.attributes[key]))
+		}
+		if s.failed != "" {
+			j.Status = status{Code: 2, Message: s.failed}
+		}
+		s.mu.Unlock()
+		spans = append(spans, j)
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: e.resource},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "github.com/eugenmayer/nexus-cli", Version: e.version}, Spans: spans}},
+	}}}
+}
@@ -0,0 +1,217 @@
+// Package tracing records OpenTelemetry spans of a run of nexus-cli and exports them with OTLP, so long cleanup and
+// mirror jobs can be followed in tracing backends (Jaeger, Tempo, Honeycomb...). Every command is a span, the
+// requests it sends to Nexus are its children. Nothing is recorded unless Configure finds an OTLP endpoint in the
+// standard environment variables:
+//
+//	OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_TRACES_ENDPOINT  where to send the spans to
+//	OTEL_EXPORTER_OTLP_HEADERS, OTEL_EXPORTER_OTLP_TRACES_HEADERS    headers of the export, e.g. api-key=...
+//	OTEL_EXPORTER_OTLP_TIMEOUT, OTEL_EXPORTER_OTLP_TRACES_TIMEOUT    timeout of an export in milliseconds
+//	OTEL_SERVICE_NAME, OTEL_RESOURCE_ATTRIBUTES                      the resource the spans belong to
+//	OTEL_TRACES_EXPORTER=none, OTEL_SDK_DISABLED=true                turn tracing off
+//	TRACEPARENT                                                      a W3C trace context the command span joins
+//
+// The spans are sent as OTLP/HTTP JSON, which collectors accept whatever OTEL_EXPORTER_OTLP_PROTOCOL says, but for
+// grpc which is not spoken. This package is a minimal stand-in for the OpenTelemetry SDK, which this module cannot
+// depend on, see otlpExporter. The functions may be called from several goroutines
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of spans
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// Span is an operation of the run. Its methods do nothing on a nil span, which StartSpan returns if tracing is off
+type Span struct {
+	traceID    string
+	spanID     string
+	parentID   string
+	name       string
+	kind       int
+	start      time.Time
+	mu         sync.Mutex
+	end        time.Time
+	attributes map[string]interface{}
+	failed     string
+	ended      bool
+}
+
+var (
+	mu       sync.Mutex
+	exporter *otlpExporter
+	// command is the span of the command running, parent of the others
+	command *Span
+	// remote is the span of TRACEPARENT, parent of the command
+	remoteTrace, remoteSpan string
+)
+
+// Configure turns tracing on if the environment has an OTLP endpoint, version is the one of nexus-cli
+func Configure(version string) error {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return nil
+	}
+	if e := os.Getenv("OTEL_TRACES_EXPORTER"); e != "" && e != "otlp" {
+		if e == "none" {
+			return nil
+		}
+		return errors.New(fmt.Sprintf("OTEL_TRACES_EXPORTER=%s is not supported, use otlp or none", e))
+	}
+	e, err := newExporter(version)
+	if err != nil || e == nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	exporter = e
+	remoteTrace, remoteSpan = parseTraceparent(os.Getenv("TRACEPARENT"))
+	return nil
+}
+
+// Enabled tells if spans are recorded, to skip the work of describing them if not
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return exporter != nil
+}
+
+// Start starts the span of a command, the spans started until Finish are its children
+func Start(name string) {
+	span := StartSpan("nexus-cli "+name, KindInternal)
+	span.SetAttribute("nexus_cli.command", name)
+	mu.Lock()
+	command = span
+	mu.Unlock()
+}
+
+// Finish ends the span of the command with its error and exit code and exports the spans left. Failing exports are
+// reported to stderr only, tracing must not fail the job it watched
+func Finish(err error, exitCode int) {
+	mu.Lock()
+	span, e := command, exporter
+	command = nil
+	mu.Unlock()
+	span.SetAttribute("process.exit.code", exitCode)
+	span.End(err)
+	if e != nil {
+		e.flush()
+	}
+}
+
+// StartSpan starts a span, a child of the command span
+func StartSpan(name string, kind int) *Span {
+	mu.Lock()
+	defer mu.Unlock()
+	if exporter == nil {
+		return nil
+	}
+	s := &Span{name: name, kind: kind, start: time.Now(), spanID: newID(8), attributes: map[string]interface{}{}}
+	switch {
+	case command != nil:
+		s.traceID, s.parentID = command.traceID, command.spanID
+	case remoteTrace != "":
+		s.traceID, s.parentID = remoteTrace, remoteSpan
+	default:
+		s.traceID = newID(16)
+	}
+	return s
+}
+
+// SetAttribute sets an attribute of the span, a string, bool, int, int64 or float64
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+// Fail marks the span as failed without ending it
+func (s *Span) Fail(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed == "" {
+		s.failed = message
+	}
+}
+
+// End ends the span, failed if err is not nil, and queues it for the export. Later calls do nothing
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	if err != nil && s.failed == "" {
+		s.failed = err.Error()
+	}
+	s.mu.Unlock()
+	mu.Lock()
+	e := exporter
+	mu.Unlock()
+	if e != nil {
+		e.add(s)
+	}
+}
+
+// Inject sets the traceparent header of a request to the span, so servers taking part in the trace continue it
+func (s *Span) Inject(header http.Header) {
+	if s == nil {
+		return
+	}
+	header.Set("Traceparent", "00-"+s.traceID+"-"+s.spanID+"-01")
+}
+
+// Environ returns the TRACEPARENT of the command span for the environment of processes nexus-cli starts, so their
+// spans join the trace. It is empty if tracing is off
+func Environ() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	if command == nil {
+		return nil
+	}
+	return []string{"TRACEPARENT=00-" + command.traceID + "-" + command.spanID + "-01"}
+}
+
+// parseTraceparent returns the trace and span ID of a W3C traceparent, empty if it is none
+func parseTraceparent(value string) (string, string) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || !validID(parts[1], 32) || !validID(parts[2], 16) {
+		return "", ""
+	}
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2])
+}
+
+func validID(id string, length int) bool {
+	if len(id) != length || strings.Trim(id, "0") == "" {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func newID(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,90 @@
+package tracing_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/eugenmayer/nexus-cli/registry"
+	"github.com/eugenmayer/nexus-cli/registrytest"
+	"github.com/eugenmayer/nexus-cli/tracing"
+)
+
+// exportedSpan is what the tests read of an exported span
+type exportedSpan struct {
+	Name   string `json:"name"`
+	Status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+// TestRequestSpans checks every request to Nexus ends its span: answers without a body, failed requests not
+// reaching Nexus, and listings read to the end
+func TestRequestSpans(t *testing.T) {
+	var mu sync.Mutex
+	spans := map[string]exportedSpan{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var export struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&export); err != nil {
+			t.Errorf("invalid export: %s", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, r := range export.ResourceSpans {
+			for _, s := range r.ScopeSpans {
+				for _, span := range s.Spans {
+					spans[span.Name] = span
+				}
+			}
+		}
+	}))
+	defer collector.Close()
+	os.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", collector.URL+"/v1/traces")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if err := tracing.Configure("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := registrytest.NewServer()
+	defer srv.Close()
+	srv.PushImage("docker-hosted", "team/app", "1.0", registrytest.Image{})
+	r := srv.Registry("docker-hosted")
+	stopped := httptest.NewServer(http.NotFoundHandler())
+	stopped.Close()
+
+	tracing.Start("test")
+	if _, err := r.ImageDigest("team/app", "1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ListTagsByImage("team/app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.New(stopped.URL, registry.WithRepository("docker-hosted")).ListImages(); err == nil {
+		t.Fatal("listing a stopped server succeeded")
+	}
+	tracing.Finish(nil, 0)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range []string{"nexus-cli test", "HEAD manifest", "GET tags"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no span %q was exported, got %v", name, spans)
+		} else if span.Status.Code != 0 {
+			t.Errorf("span %q failed: %s", name, span.Status.Message)
+		}
+	}
+	if span, ok := spans["GET catalog"]; !ok || span.Status.Code != 2 || span.Status.Message == "" {
+		t.Errorf("the span of the failed catalog request: %+v, want it exported as failed", span)
+	}
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"fmt"
+	"github.com/blang/semver"
 	"sort"
 )
 
@@ -26,3 +28,31 @@ func (s *strSorter) Len() int { return len(s.strs) }
 func (s *strSorter) Swap(i, j int) { s.strs[i], s.strs[j] = s.strs[j], s.strs[i] }
 
 func (s *strSorter) Less(i, j int) bool { return s.cmp(s.strs[i], s.strs[j]) }
+
+// GetSortComparisonStrategy returns the comparison for the given sort option, "default" and "semver" both sort by
+// semantic version with latest considered the most recent
+func GetSortComparisonStrategy(sort string) func(str1, str2 string) bool {
+	var compareStringNumber func(str1, str2 string) bool
+
+	if sort == "default" || sort == "semver" {
+		compareStringNumber = func(str1, str2 string) bool {
+			if str1 == "latest" {
+				return false
+			}
+			if str2 == "latest" {
+				return true
+			}
+			version1, err1 := semver.Make(str1)
+			if err1 != nil {
+				fmt.Printf("Error parsing version1: %q\n", err1)
+			}
+			version2, err2 := semver.Make(str2)
+			if err2 != nil {
+				fmt.Printf("Error parsing version2: %q\n", err2)
+			}
+			return version1.LT(version2)
+		}
+	}
+
+	return compareStringNumber
+}
@@ -1,9 +1,14 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"os/user"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func ExpandTildeInPath(path string) string {
@@ -20,3 +25,77 @@ func ExpandTildeInPath(path string) string {
 
 	return path
 }
+
+// HumanBytes formats a byte count with binary units, e.g. 1536 becomes "1.5 KiB"
+func HumanBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+var byteSize = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([KMGTP]?)(I?)B?$`)
+
+// ParseBytes parses sizes like 512MB, 1.5GiB or 2T. Both decimal (MB) and binary (MiB) units are accepted, a number
+// without unit is in bytes
+func ParseBytes(value string) (int64, error) {
+	match := byteSize.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(value)))
+	if match == nil {
+		return 0, errors.New(fmt.Sprintf("invalid size %q, expected e.g. 500MB or 2GiB", value))
+	}
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	unit := 1000.0
+	if match[3] != "" {
+		unit = 1024
+	}
+	for i := 0; i < strings.Index(" KMGTP", match[2]); i++ {
+		number *= unit
+	}
+	return int64(number), nil
+}
+
+var dayDuration = regexp.MustCompile(`^(\d+)([dw])$`)
+
+// ParseDuration parses durations like time.ParseDuration, additionally accepting days (30d) and weeks (2w)
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if match := dayDuration.FindStringSubmatch(value); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		days := time.Duration(n) * 24 * time.Hour
+		if match[2] == "w" {
+			days *= 7
+		}
+		return days, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// GlobToRegexp compiles a path pattern: ** matches anything, * anything but a slash and ? a single character
+func GlobToRegexp(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
@@ -0,0 +1,287 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// CompareVersions orders package versions the way dpkg and rpm do, without requiring semantic versions: an epoch
+// ("2:") outranks everything, then runs of digits are compared numerically and runs of letters alphabetically, with
+// digits sorting after letters. A tilde sorts before anything, even the end, so 1.0~rc1 comes before 1.0.
+// Returns -1, 0 or 1
+func CompareVersions(a string, b string) int {
+	epochA, restA := splitEpoch(a)
+	epochB, restB := splitEpoch(b)
+	if c := compareDigits(epochA, epochB); c != 0 {
+		return c
+	}
+	a, b = restA, restB
+
+	for a != "" || b != "" {
+		// separators carry no meaning beyond splitting runs, except the tilde
+		a = strings.TrimLeftFunc(a, isSeparator)
+		b = strings.TrimLeftFunc(b, isSeparator)
+		switch {
+		case strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~"):
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		case a == "" || b == "":
+			if a == "" && b == "" {
+				return 0
+			}
+			if a == "" {
+				return -1
+			}
+			return 1
+		}
+
+		runA, runB := leadingRun(a), leadingRun(b)
+		a, b = a[len(runA):], b[len(runB):]
+		digitsA, digitsB := isDigit(rune(runA[0])), isDigit(rune(runB[0]))
+		var c int
+		switch {
+		case digitsA && digitsB:
+			c = compareDigits(runA, runB)
+		case digitsA:
+			c = 1
+		case digitsB:
+			c = -1
+		default:
+			c = strings.Compare(runA, runB)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// pythonVersionPattern is the version scheme of PEP 440, with the spellings it normalizes (1.0-RC.1, 1.0.post-2)
+var pythonVersionPattern = regexp.MustCompile(`^v?` +
+	`(?:([0-9]+)!)?` +
+	`([0-9]+(?:\.[0-9]+)*)` +
+	`(?:[-_.]?(alpha|a|beta|b|preview|pre|rc|c)[-_.]?([0-9]*))?` +
+	`(?:-([0-9]+)|[-_.]?(post|rev|r)[-_.]?([0-9]*))?` +
+	`(?:[-_.]?(dev)[-_.]?([0-9]*))?` +
+	`(?:\+([a-z0-9]+(?:[-_.][a-z0-9]+)*))?$`)
+
+// pythonVersion is a version parsed as PEP 440 defines it, numbers are kept as digits so any length compares
+type pythonVersion struct {
+	epoch   string
+	release []string
+	// pre is 0, 1 or 2 for a, b and rc, -1 without a pre-release
+	pre    int
+	preNum string
+	post   string
+	dev    string
+	local  []string
+	// hasPost and hasDev tell a post or development release 0 from none
+	hasPost bool
+	hasDev  bool
+}
+
+func parsePythonVersion(version string) (pythonVersion, bool) {
+	m := pythonVersionPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(version)))
+	if m == nil {
+		return pythonVersion{}, false
+	}
+	v := pythonVersion{epoch: m[1], release: strings.Split(m[2], "."), pre: -1}
+	// trailing zeros carry no meaning, 1.0 is 1.0.0
+	for len(v.release) > 1 && strings.TrimLeft(v.release[len(v.release)-1], "0") == "" {
+		v.release = v.release[:len(v.release)-1]
+	}
+	switch m[3] {
+	case "":
+	case "alpha", "a":
+		v.pre, v.preNum = 0, m[4]
+	case "beta", "b":
+		v.pre, v.preNum = 1, m[4]
+	default:
+		v.pre, v.preNum = 2, m[4]
+	}
+	if m[5] != "" {
+		v.hasPost, v.post = true, m[5]
+	} else if m[6] != "" {
+		v.hasPost, v.post = true, m[7]
+	}
+	if m[8] != "" {
+		v.hasDev, v.dev = true, m[9]
+	}
+	if m[10] != "" {
+		v.local = strings.FieldsFunc(m[10], func(r rune) bool { return r == '-' || r == '_' || r == '.' })
+	}
+	return v, true
+}
+
+// ComparePythonVersions orders versions as PEP 440 does: by epoch (1!), then the release with trailing zeros
+// ignored, development releases before pre-releases (a, b, rc) before the final release before post releases, and
+// local versions (+ubuntu1) after the version they are local to. Versions not following PEP 440 sort before the
+// others and among themselves as CompareVersions does. Returns -1, 0 or 1
+func ComparePythonVersions(a string, b string) int {
+	va, okA := parsePythonVersion(a)
+	vb, okB := parsePythonVersion(b)
+	switch {
+	case !okA && !okB:
+		return CompareVersions(a, b)
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+
+	if c := compareDigits(va.epoch, vb.epoch); c != 0 {
+		return c
+	}
+	for i := 0; i < len(va.release) || i < len(vb.release); i++ {
+		if c := compareDigits(segment(va.release, i), segment(vb.release, i)); c != 0 {
+			return c
+		}
+	}
+	if c := compareInts(va.preRank(), vb.preRank()); c != 0 {
+		return c
+	}
+	if va.pre >= 0 && vb.pre >= 0 {
+		if c := compareDigits(va.preNum, vb.preNum); c != 0 {
+			return c
+		}
+	}
+	if c := compareOptional(va.hasPost, va.post, vb.hasPost, vb.post, -1); c != 0 {
+		return c
+	}
+	// a development release comes before the release it leads to
+	if c := compareOptional(va.hasDev, va.dev, vb.hasDev, vb.dev, 1); c != 0 {
+		return c
+	}
+	return compareLocal(va.local, vb.local)
+}
+
+// preRank places a version among the releases with its release segment: 0 for a development release of the final
+// release (1.0.dev1 comes before 1.0a1), 1 to 3 for a, b and rc, 4 for the final and post releases
+func (v pythonVersion) preRank() int {
+	switch {
+	case v.pre >= 0:
+		return v.pre + 1
+	case v.hasDev && !v.hasPost:
+		return 0
+	default:
+		return 4
+	}
+}
+
+// compareOptional compares two optional numbers, a missing one sorting as missing says relative to present ones
+func compareOptional(hasA bool, a string, hasB bool, b string, missing int) int {
+	switch {
+	case hasA && hasB:
+		return compareDigits(a, b)
+	case hasA:
+		return -missing
+	case hasB:
+		return missing
+	}
+	return 0
+}
+
+// compareLocal orders local version labels: none sorts first, numeric segments after alphanumeric ones, and a label
+// extending another after it
+func compareLocal(a []string, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		digitsA := strings.IndexFunc(a[i], func(r rune) bool { return !isDigit(r) }) < 0
+		digitsB := strings.IndexFunc(b[i], func(r rune) bool { return !isDigit(r) }) < 0
+		var c int
+		switch {
+		case digitsA && digitsB:
+			c = compareDigits(a[i], b[i])
+		case digitsA:
+			c = 1
+		case digitsB:
+			c = -1
+		default:
+			c = strings.Compare(a[i], b[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+func segment(release []string, i int) string {
+	if i < len(release) {
+		return release[i]
+	}
+	return "0"
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// CompareSemanticVersions orders semantic versions like NuGet and Go do: pre-releases (1.0.0-beta.1) before their
+// release, build metadata (+build.5) is ignored
+func CompareSemanticVersions(a string, b string) int {
+	return CompareVersions(semanticVersionKey(a), semanticVersionKey(b))
+}
+
+func semanticVersionKey(version string) string {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.Index(version, "+"); i >= 0 {
+		version = version[:i]
+	}
+	return strings.Replace(version, "-", "~", 1)
+}
+
+// NormalizePythonName normalizes a project name as PEP 503 does, so My_Package and my-package are the same project
+func NormalizePythonName(name string) string {
+	return pythonNameSeparators.ReplaceAllString(strings.ToLower(name), "-")
+}
+
+var pythonNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+func splitEpoch(version string) (string, string) {
+	if i := strings.Index(version, ":"); i > 0 && strings.IndexFunc(version[:i], func(r rune) bool { return !isDigit(r) }) < 0 {
+		return version[:i], version[i+1:]
+	}
+	return "0", version
+}
+
+// leadingRun returns the digits or letters the version starts with
+func leadingRun(version string) string {
+	digits := isDigit(rune(version[0]))
+	for i, r := range version {
+		if isSeparator(r) || r == '~' || isDigit(r) != digits {
+			return version[:i]
+		}
+	}
+	return version
+}
+
+func compareDigits(a string, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isSeparator(r rune) bool {
+	return !isDigit(r) && r != '~' && !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+}
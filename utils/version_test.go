@@ -0,0 +1,66 @@
+package utils
+
+import "testing"
+
+func TestComparePythonVersions(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		// release segments, trailing zeros are not significant
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.0", 0},
+		{"1.0.0.0", "1", 0},
+		{"1.1", "1.0.9", 1},
+		{"1.10", "1.9", 1},
+		{"v1.2", "1.2", 0},
+
+		// epochs outrank the release
+		{"1!1.0", "2.0", 1},
+		{"0!2.0", "2.0", 0},
+
+		// development releases, pre-releases, the release, post releases
+		{"1.0.dev0", "1.0a1", -1},
+		{"1.0a1", "1.0a2", -1},
+		{"1.0a2", "1.0b1", -1},
+		{"1.0b1", "1.0rc1", -1},
+		{"1.0rc1", "1.0", -1},
+		{"1.0", "1.0.post1", -1},
+		{"1.0a1.dev1", "1.0a1", -1},
+		{"1.0.post1.dev1", "1.0.post1", -1},
+		{"1.0.post1.dev1", "1.0", 1},
+		{"1.0.post1", "1.0.post2", -1},
+		{"1.0.dev1", "1.0.dev2", -1},
+
+		// alternative spellings normalize to the same version
+		{"1.0-RC.1", "1.0rc1", 0},
+		{"1.0c1", "1.0rc1", 0},
+		{"1.0alpha1", "1.0a1", 0},
+		{"1.0-1", "1.0.post1", 0},
+		{"1.0.rev2", "1.0.post2", 0},
+		{"1.0.post", "1.0.post0", 0},
+
+		// local versions sort after the version they are local to
+		{"1.0+cpu", "1.0", 1},
+		{"1.0+abc", "1.0", 1},
+		{"1.0+abc", "1.0.post1", -1},
+		{"1.0+abc", "1.0+abd", -1},
+		{"1.0+abc", "1.0+5", -1},
+		{"1.0+5", "1.0+10", -1},
+		{"1.0+ubuntu", "1.0+ubuntu.1", -1},
+		{"1.0+abc", "1.0+ABC", 0},
+
+		// versions not following PEP 440 sort before the others
+		{"latest", "0.1", -1},
+		{"nightly-b", "nightly-a", 1},
+	}
+	for _, test := range tests {
+		if got := ComparePythonVersions(test.a, test.b); got != test.want {
+			t.Errorf("ComparePythonVersions(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		if got := ComparePythonVersions(test.b, test.a); got != -test.want {
+			t.Errorf("ComparePythonVersions(%q, %q) = %d, want %d", test.b, test.a, got, -test.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Package vault reads the Nexus credentials of a profile from a KV version 2 secret of HashiCorp Vault, logging in
+// with a token or an AppRole. Tokens and secrets are only kept in memory, for the lifetime of the process
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eugenmayer/nexus-cli/utils"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ways to log in to Vault
+const (
+	// AuthToken uses VAULT_TOKEN, or the token 'vault login' stored in ~/.vault-token
+	AuthToken = "token"
+	// AuthAppRole logs in with the role id of the profile and the secret id of VAULT_SECRET_ID, for CI runners
+	AuthAppRole = "approle"
+)
+
+// Config tells where the secret of a profile is and how to log in to read it
+type Config struct {
+	// Address of Vault, VAULT_ADDR if empty
+	Address string
+	// Path of the secret, starting with the mount of the KV engine, e.g. secret/nexus/ci
+	Path string
+	// Auth is AuthToken (the default) or AuthAppRole
+	Auth   string
+	RoleID string
+}
+
+// ValidAuth tells if auth is a known way to log in, empty is AuthToken
+func ValidAuth(auth string) bool {
+	return auth == "" || auth == AuthToken || auth == AuthAppRole
+}
+
+var (
+	mu      sync.Mutex
+	tokens  = map[string]string{}
+	secrets = map[string]map[string]string{}
+	client  = &http.Client{Timeout: 30 * time.Second}
+)
+
+// Secret returns the fields of the latest version of the secret. Secrets and the tokens of AppRole logins are read
+// once per process, the profile loaded again gets the same answer
+func Secret(c Config) (map[string]string, error) {
+	address := c.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" {
+		return nil, errors.New("vault_address is not set and neither is VAULT_ADDR")
+	}
+	address = strings.TrimRight(address, "/")
+
+	mu.Lock()
+	defer mu.Unlock()
+	key := address + "/" + c.Path
+	if secret, ok := secrets[key]; ok {
+		return secret, nil
+	}
+	token, err := login(address, c)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := call(address, "GET", "/v1/"+dataPath(c.Path), token, nil, &response); err != nil {
+		return nil, errors.New(fmt.Sprintf("Reading %s from Vault failed: %s", c.Path, err))
+	}
+	secret := map[string]string{}
+	for field, value := range response.Data.Data {
+		if s, ok := value.(string); ok {
+			secret[field] = s
+		}
+	}
+	secrets[key] = secret
+	return secret, nil
+}
+
+// dataPath is the API path of a secret of a KV version 2 engine, the data of secret/nexus/ci is read from
+// secret/data/nexus/ci. Paths given that way already are kept
+func dataPath(path string) string {
+	path = strings.Trim(path, "/")
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) < 2 || len(parts) == 3 && parts[1] == "data" {
+		return path
+	}
+	return parts[0] + "/data/" + strings.Join(parts[1:], "/")
+}
+
+// login returns the token to read secrets with
+func login(address string, c Config) (string, error) {
+	switch c.Auth {
+	case "", AuthToken:
+		if token := os.Getenv("VAULT_TOKEN"); token != "" {
+			return token, nil
+		}
+		content, err := ioutil.ReadFile(utils.ExpandTildeInPath("~/.vault-token"))
+		if err != nil || strings.TrimSpace(string(content)) == "" {
+			return "", errors.New("There is no Vault token, set VAULT_TOKEN or run 'vault login'")
+		}
+		return strings.TrimSpace(string(content)), nil
+	case AuthAppRole:
+		secretID := os.Getenv("VAULT_SECRET_ID")
+		if c.RoleID == "" || secretID == "" {
+			return "", errors.New("Logging in to Vault with an AppRole needs vault_role_id and VAULT_SECRET_ID")
+		}
+		key := address + "|" + c.RoleID
+		if token, ok := tokens[key]; ok {
+			return token, nil
+		}
+		var response struct {
+			Auth struct {
+				ClientToken string `json:"client_token"`
+			} `json:"auth"`
+		}
+		body := map[string]string{"role_id": c.RoleID, "secret_id": secretID}
+		if err := call(address, "POST", "/v1/auth/approle/login", "", body, &response); err != nil {
+			return "", errors.New(fmt.Sprintf("Logging in to Vault with the AppRole %s failed: %s", c.RoleID, err))
+		}
+		tokens[key] = response.Auth.ClientToken
+		return response.Auth.ClientToken, nil
+	default:
+		return "", errors.New(fmt.Sprintf("Unknown vault_auth %s, expected %s or %s", c.Auth, AuthToken, AuthAppRole))
+	}
+}
+
+// call sends a request to the Vault API and decodes the answer into v. Vault tells what went wrong in errors
+func call(address string, method string, path string, token string, body interface{}, v interface{}) error {
+	var content []byte
+	if body != nil {
+		var err error
+		if content, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, address+path, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		if len(failure.Errors) > 0 {
+			return errors.New(fmt.Sprintf("%s: %s", resp.Status, strings.Join(failure.Errors, ", ")))
+		}
+		return errors.New(resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
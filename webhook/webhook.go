@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+const (
+	// SignatureHeader carries the hex encoded HMAC-SHA1 of the body, keyed with the secret configured on the webhook
+	SignatureHeader = "X-Nexus-Webhook-Signature"
+	// IDHeader names the kind of webhook delivery, only component events are handled
+	IDHeader = "X-Nexus-Webhook-Id"
+	// ComponentWebhook is the webhook id of repository component events
+	ComponentWebhook = "rm:repository:component"
+
+	maxPayloadSize = 1 << 20
+)
+
+// Event is the payload of a Nexus repository component webhook
+type Event struct {
+	Timestamp      string `json:"timestamp"`
+	NodeID         string `json:"nodeId"`
+	Initiator      string `json:"initiator"`
+	RepositoryName string `json:"repositoryName"`
+	// Action is CREATED, UPDATED or DELETED
+	Action    string    `json:"action"`
+	Component Component `json:"component"`
+}
+
+// Component is the affected component. For docker repositories, Name is the image name and Version the tag
+type Component struct {
+	ID          string `json:"id"`
+	ComponentID string `json:"componentId"`
+	Format      string `json:"format"`
+	Name        string `json:"name"`
+	Group       string `json:"group"`
+	Version     string `json:"version"`
+}
+
+// Handler receives webhook deliveries from Nexus, verifies their signature and queues component events on Events.
+// Deliveries are acknowledged right away, so slow actions do not make Nexus time out and retry
+type Handler struct {
+	// Secret is the secret key of the webhook capability, deliveries are not verified if it is empty
+	Secret []byte
+	Events chan<- Event
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxPayloadSize))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if len(h.Secret) > 0 && !h.verify(body, req.Header.Get(SignatureHeader)) {
+		log.Printf("Rejected webhook delivery from %s: invalid signature", req.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if id := req.Header.Get(IDHeader); id != ComponentWebhook {
+		log.Printf("Ignoring webhook delivery %q, only %s is handled", id, ComponentWebhook)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		log.Printf("Rejected webhook delivery from %s: %s", req.RemoteAddr, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case h.Events <- event:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		log.Printf("Dropped %s event for %s:%s, the queue is full", event.Action, event.Component.Name, event.Component.Version)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+func (h Handler) verify(body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, h.Secret)
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}